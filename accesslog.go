@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// accessLogEntry is one proxied request as seen by the access log.
+type accessLogEntry struct {
+	Time       time.Time     `json:"time"`
+	RemoteAddr string        `json:"remoteAddr"`
+	Method     string        `json:"method"`
+	Host       string        `json:"host"`
+	URI        string        `json:"uri"`
+	Proto      string        `json:"proto"`
+	Status     int           `json:"status"`
+	Bytes      int64         `json:"bytes"`
+	Duration   time.Duration `json:"-"`
+	DurationMs float64       `json:"durationMs"`
+	Referer    string        `json:"referer,omitempty"`
+	UserAgent  string        `json:"userAgent,omitempty"`
+	Subdomain  string        `json:"subdomain,omitempty"`
+	Target     string        `json:"target,omitempty"`
+	Event      string        `json:"event,omitempty"` // wsEventOpen or wsEventClose for WebSocket tunnels
+}
+
+// WebSocket tunnels are logged twice: once when the upgrade is handed off and
+// again when the tunnel closes, with its total duration and bytes.
+const (
+	wsEventOpen  = "websocket-open"
+	wsEventClose = "websocket-close"
+)
+
+// accessLogFormatters render an entry as a single line (without newline).
+var accessLogFormatters = map[string]func(accessLogEntry) []byte{
+	"json":     formatAccessJSON,
+	"combined": formatAccessCombined,
+}
+
+func formatAccessJSON(e accessLogEntry) []byte {
+	e.DurationMs = float64(e.Duration.Microseconds()) / 1000
+	data, _ := json.Marshal(e)
+	return data
+}
+
+// formatAccessCombined renders the Apache/NGINX "combined" format. The
+// routing target is appended as one extra quoted field, which combined-format
+// parsers ignore.
+func formatAccessCombined(e accessLogEntry) []byte {
+	dash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	size := "-"
+	if e.Bytes > 0 {
+		size = strconv.FormatInt(e.Bytes, 10)
+	}
+	route := "-"
+	if e.Subdomain != "" {
+		route = e.Subdomain + " " + e.Target
+	}
+	line := fmt.Appendf(nil, "%s - - [%s] %q %d %s %q %q %q",
+		dash(e.RemoteAddr),
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method+" "+e.URI+" "+e.Proto,
+		e.Status, size,
+		dash(e.Referer), dash(e.UserAgent), route)
+	if e.Event != "" {
+		line = fmt.Appendf(line, " %q", e.Event)
+	}
+	return line
+}
+
+// AccessLogger writes one line per proxied request in the chosen format.
+// Successful requests can be sampled; the sampling state is atomic so the
+// request path never takes a lock to decide.
+type AccessLogger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format func(accessLogEntry) []byte
+
+	sampleRate atomic.Int64  // log 1 in N successful requests; 0 or 1 logs all
+	slow       atomic.Int64  // requests at least this slow (ns) are always logged; 0 = off
+	seen       atomic.Uint64 // successful requests considered for sampling
+}
+
+// NewAccessLogger creates an access logger writing to dest ("-" for stdout,
+// otherwise a file opened for append) in the given format.
+func NewAccessLogger(dest, format string) (*AccessLogger, error) {
+	f, ok := accessLogFormatters[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown access log format %q (expected json or combined)", format)
+	}
+	var w io.Writer = os.Stdout
+	if dest != "-" {
+		file, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		w = file
+	}
+	return &AccessLogger{w: w, format: f}, nil
+}
+
+// Close closes the log file. Logging to stdout leaves stdout open.
+func (al *AccessLogger) Close() error {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if f, ok := al.w.(*os.File); ok && f != os.Stdout {
+		return f.Close()
+	}
+	return nil
+}
+
+// SetSampling logs only 1 in rate successful (2xx) requests. Other statuses,
+// WebSocket upgrades, and requests taking at least slow are always logged.
+// It is safe to call while requests are being logged.
+func (al *AccessLogger) SetSampling(rate int, slow time.Duration) {
+	al.sampleRate.Store(int64(rate))
+	al.slow.Store(int64(slow))
+}
+
+// sampled reports whether e should be written.
+func (al *AccessLogger) sampled(e accessLogEntry) bool {
+	rate := al.sampleRate.Load()
+	if rate <= 1 || e.Status < 200 || e.Status >= 300 {
+		return true
+	}
+	if slow := al.slow.Load(); slow > 0 && e.Duration >= time.Duration(slow) {
+		return true
+	}
+	return (al.seen.Add(1)-1)%uint64(rate) == 0
+}
+
+func (al *AccessLogger) log(e accessLogEntry) {
+	if !al.sampled(e) {
+		return
+	}
+	line := append(al.format(e), '\n')
+	al.mu.Lock()
+	al.w.Write(line)
+	al.mu.Unlock()
+}
+
+// AccessLogMiddleware records every request passing through next.
+func AccessLogMiddleware(al *AccessLogger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		entry := func() accessLogEntry {
+			return accessLogEntry{
+				Time:       start,
+				RemoteAddr: clientIP(r),
+				Method:     r.Method,
+				Host:       r.Host,
+				URI:        r.RequestURI,
+				Proto:      r.Proto,
+				Status:     rec.statusCode(),
+				Bytes:      rec.bytes,
+				Duration:   time.Since(start),
+				Referer:    r.Referer(),
+				UserAgent:  r.UserAgent(),
+				Subdomain:  rec.subdomain,
+				Target:     rec.target,
+			}
+		}
+		// A tunnel can close before the handler returns; its close entry
+		// still comes after the open one
+		opened := make(chan struct{})
+		defer close(opened)
+		rec.tunnelClosed = func(bytes int64) {
+			<-opened
+			e := entry()
+			e.Bytes, e.Event = bytes, wsEventClose
+			al.log(e)
+		}
+		next.ServeHTTP(rec, r)
+
+		e := entry()
+		if rec.hijacked {
+			e.Event = wsEventOpen
+		}
+		al.log(e)
+	})
+}
+
+// statusRecorder wraps a ResponseWriter to capture the status code and body
+// size. It passes through Flush and Hijack so streaming responses and
+// WebSocket upgrades keep working.
+type statusRecorder struct {
+	http.ResponseWriter
+	status   int
+	bytes    int64
+	hijacked bool
+
+	// Routing info filled in by the proxy via annotateRoute.
+	subdomain string
+	target    string
+
+	// tunnelClosed logs the end of a hijacked connection; see logTunnelClosed.
+	tunnelClosed func(bytes int64)
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	if sr.status == 0 {
+		sr.status = code
+	}
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	n, err := sr.ResponseWriter.Write(b)
+	sr.bytes += int64(n)
+	return n, err
+}
+
+func (sr *statusRecorder) Flush() {
+	if f, ok := sr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (sr *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := sr.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("underlying ResponseWriter does not support hijacking")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		sr.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+func (sr *statusRecorder) statusCode() int {
+	switch {
+	case sr.hijacked:
+		return http.StatusSwitchingProtocols
+	case sr.status == 0:
+		return http.StatusOK
+	}
+	return sr.status
+}
+
+// annotateRoute records which mapping served the request in every recorder
+// (access log, metrics) wrapping w.
+func annotateRoute(w http.ResponseWriter, subdomain, target string) {
+	eachStatusRecorder(w, func(sr *statusRecorder) {
+		sr.subdomain = subdomain
+		sr.target = target
+	})
+}
+
+// logTunnelClosed records that the tunnel hijacked from w has closed after
+// carrying bytes in total, if the request is being access-logged.
+func logTunnelClosed(w http.ResponseWriter, bytes int64) {
+	eachStatusRecorder(w, func(sr *statusRecorder) {
+		if sr.tunnelClosed != nil {
+			sr.tunnelClosed(bytes)
+		}
+	})
+}
+
+// eachStatusRecorder calls fn for every statusRecorder behind w, looking
+// through wrappers such as compression.
+func eachStatusRecorder(w http.ResponseWriter, fn func(*statusRecorder)) {
+	for {
+		if sr, ok := w.(*statusRecorder); ok {
+			fn(sr)
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return
+		}
+		w = u.Unwrap()
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessLogSampling(t *testing.T) {
+	var buf bytes.Buffer
+	al := &AccessLogger{w: &buf, format: func(e accessLogEntry) []byte { return []byte(e.URI) }}
+	al.SetSampling(3, 500*time.Millisecond)
+
+	entries := []accessLogEntry{
+		{URI: "/a1", Status: 200},
+		{URI: "/a2", Status: 200},
+		{URI: "/err", Status: 502},
+		{URI: "/a3", Status: 204},
+		{URI: "/ws", Status: 101},
+		{URI: "/a4", Status: 200},
+		{URI: "/slow", Status: 200, Duration: time.Second},
+		{URI: "/a5", Status: 200},
+		{URI: "/missing", Status: 404},
+		{URI: "/a6", Status: 200},
+		{URI: "/a7", Status: 200},
+	}
+	for _, e := range entries {
+		al.log(e)
+	}
+	got := strings.Fields(buf.String())
+	want := []string{"/a1", "/err", "/ws", "/a4", "/slow", "/missing", "/a7"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("logged %v, want %v", got, want)
+	}
+}
+
+func TestAccessLogWebSocket(t *testing.T) {
+	// A backend that accepts the upgrade, says hello and hangs up
+	const reply = "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\nhello"
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+			return
+		}
+		io.WriteString(conn, reply)
+	}()
+
+	cs := newTestConfigStore(t)
+	cs.cfg.Mappings = []DomainMapping{{Domain: "chat", TargetPort: backend.Addr().(*net.TCPAddr).Port}}
+	var buf bytes.Buffer
+	al := &AccessLogger{w: &buf, format: formatAccessJSON}
+	proxy := httptest.NewServer(AccessLogMiddleware(al, ProxyHandler(NewHub(cs), "127.0.0.1:1")))
+	defer proxy.Close()
+
+	client, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	io.WriteString(client, "GET /socket HTTP/1.1\r\nHost: chat.localhost\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	io.Copy(io.Discard, client)
+
+	var entries []accessLogEntry
+	for deadline := time.Now().Add(2 * time.Second); len(entries) < 2 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		al.mu.Lock()
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		al.mu.Unlock()
+		entries = entries[:0]
+		for _, l := range lines {
+			var e accessLogEntry
+			if json.Unmarshal([]byte(l), &e) == nil {
+				entries = append(entries, e)
+			}
+		}
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want open and close: %s", len(entries), buf.String())
+	}
+	if e := entries[0]; e.Event != wsEventOpen || e.Status != http.StatusSwitchingProtocols || e.Subdomain != "chat" {
+		t.Errorf("open entry = %+v", e)
+	}
+	if e := entries[1]; e.Event != wsEventClose || e.Subdomain != "chat" || e.Bytes != int64(len(reply)) {
+		t.Errorf("close entry = %+v", e)
+	}
+}
@@ -2,11 +2,14 @@ package main
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -14,6 +17,16 @@ import (
 
 const sessionCookieName = "portgate_session"
 
+// tokenCookieName holds the API token for browsers, which can't send an
+// Authorization header on page loads or WebSocket upgrades.
+const tokenCookieName = "portgate_token"
+
+// tokenEnvVar is where CLI commands read the API token from.
+const tokenEnvVar = "PORTGATE_TOKEN"
+
+// cliServerHost is the address CLI commands reach the running server at.
+const cliServerHost = "localhost:8080"
+
 // SessionStore manages auth sessions in memory.
 type SessionStore struct {
 	mu       sync.RWMutex
@@ -94,6 +107,161 @@ func isLocalRequest(r *http.Request) bool {
 	return ip.IsLoopback()
 }
 
+// trustedProxies holds the networks whose X-Forwarded-For headers clientIP
+// believes. Empty by default, meaning forwarded headers are ignored.
+var trustedProxies atomic.Pointer[[]*net.IPNet]
+
+// setTrustedProxies replaces the trusted proxy networks used by clientIP.
+func setTrustedProxies(nets []*net.IPNet) {
+	trustedProxies.Store(&nets)
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	nets := trustedProxies.Load()
+	if nets == nil || ip == nil {
+		return false
+	}
+	for _, n := range *nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the real client address for logging and rate limiting.
+// If the socket peer is a trusted proxy, X-Forwarded-For is walked from the
+// right, skipping trusted hops, and the first untrusted address wins; entries
+// further left were supplied by the client and can't be believed. Otherwise
+// the peer address is used as-is.
+func clientIP(r *http.Request) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if !isTrustedProxy(net.ParseIP(peer)) {
+		return peer
+	}
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		ip := net.ParseIP(hop)
+		if ip == nil {
+			break
+		}
+		client = ip.String()
+		if !isTrustedProxy(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// forwardedPrefix returns the path prefix an upstream proxy stripped before
+// forwarding r, from X-Forwarded-Prefix. The header is only believed from a
+// trusted proxy or from this host, which is how the proxy port reaches the
+// dashboard; otherwise, or if it isn't a clean path, "" is returned.
+func forwardedPrefix(r *http.Request) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if !isLocalRequest(r) && !isTrustedProxy(net.ParseIP(peer)) {
+		return ""
+	}
+	p, err := cleanBasePath(r.Header.Get("X-Forwarded-Prefix"))
+	if err != nil {
+		return ""
+	}
+	return p
+}
+
+// requestPrefix returns the path prefix the client sees the dashboard under:
+// the forwarded prefix if an upstream stripped one, else the basePath.
+func requestPrefix(r *http.Request, config *ConfigStore) string {
+	if p := forwardedPrefix(r); p != "" {
+		return p
+	}
+	p, _ := config.BasePath()
+	return p
+}
+
+// TokenMiddleware rejects requests that don't carry the configured API token
+// with 401. The token is accepted as "Authorization: Bearer <token>", as a
+// ?token= query parameter, or from the cookie set the first time the query
+// parameter is used, so opening the dashboard once with ?token= is enough
+// for the browser and its WebSocket. A page opened that way is redirected to
+// the same URL without the token, keeping it out of the address bar, history
+// and Referer headers. With no token configured every request passes.
+func TokenMiddleware(config *ConfigStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		want := config.APIToken()
+		if want == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && tokenMatches(bearer, want) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if cookie, err := r.Cookie(tokenCookieName); err == nil && tokenMatches(cookie.Value, want) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if query := r.URL.Query(); tokenMatches(query.Get("token"), want) {
+			http.SetCookie(w, &http.Cookie{
+				Name:     tokenCookieName,
+				Value:    want,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteLaxMode,
+			})
+			if (r.Method == http.MethodGet || r.Method == http.MethodHead) && !isMachinePath(r.URL.Path) {
+				query.Del("token")
+				target := url.URL{Path: requestPrefix(r, config) + r.URL.Path, RawQuery: query.Encode()}
+				http.Redirect(w, r, target.String(), http.StatusFound)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="portgate"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// isMachinePath reports whether path is served to API clients, WebSockets
+// or scrapers rather than opened as a page in a browser.
+func isMachinePath(path string) bool {
+	return path == "/ws" || path == "/metrics" || strings.HasPrefix(path, "/api")
+}
+
+// tokenMatches compares a presented token in constant time.
+func tokenMatches(got, want string) bool {
+	return got != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// tokenTransport adds the API token to CLI requests to the running server.
+// Other hosts, such as the update server, never see it.
+type tokenTransport struct {
+	token string
+	next  http.RoundTripper
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != cliServerHost || req.Header.Get("Authorization") != "" {
+		return t.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.next.RoundTrip(req)
+}
+
 // AuthMiddleware wraps a handler with authentication checks.
 func AuthMiddleware(config *ConfigStore, sessions *SessionStore, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -123,13 +291,13 @@ func AuthMiddleware(config *ConfigStore, sessions *SessionStore, next http.Handl
 		}
 
 		// Not authenticated
-		// API/WebSocket requests get 401
-		if r.URL.Path == "/ws" || strings.HasPrefix(r.URL.Path, "/api") {
+		// API/WebSocket requests and scrapers get 401
+		if isMachinePath(r.URL.Path) {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
 
 		// Browser requests redirect to login
-		http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
+		http.Redirect(w, r, requestPrefix(r, config)+"/login", http.StatusTemporaryRedirect)
 	})
 }
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// backendDialer connects to mapping backends over HTTP and WebSocket. Each
+// attempt is bounded by backendDialTimeout instead of a dialer timeout.
+var backendDialer = &net.Dialer{
+	KeepAlive: 30 * time.Second,
+}
+
+// defaultBackendDialTimeout bounds one backend connection attempt unless
+// proxyDialTimeoutMs says otherwise.
+const defaultBackendDialTimeout = 5 * time.Second
+
+// backendRetryDelay is the pause between connection attempts to a backend
+// that refused, so proxyRetries of 8 rides out a restart of about 2 seconds.
+const backendRetryDelay = 250 * time.Millisecond
+
+// The backend dial policy, set from proxyDialTimeoutMs and proxyRetries at
+// startup and on reload.
+var (
+	backendDialTimeout atomic.Int64 // time.Duration
+	backendRetries     atomic.Int64
+)
+
+// setBackendDialPolicy sets the per-attempt timeout and the number of extra
+// attempts dialBackend makes after a refused connection.
+func setBackendDialPolicy(timeout time.Duration, retries int) {
+	backendDialTimeout.Store(int64(timeout))
+	backendRetries.Store(int64(retries))
+}
+
+// backendNetwork is the network backends are dialed over ("tcp", "tcp4" or
+// "tcp6"), set from proxyDialNetwork at startup.
+var backendNetwork atomic.Pointer[string]
+
+// setBackendDialNetwork replaces the network used by dialBackend.
+func setBackendDialNetwork(network string) {
+	backendNetwork.Store(&network)
+}
+
+// dialBackend connects to a backend address over the configured network.
+// A refused connection is retried up to proxyRetries times, backendRetryDelay
+// apart, so a backend that is restarting is waited for rather than failed.
+// Timeouts aren't retried: the backend is there but not answering.
+func dialBackend(ctx context.Context, addr string) (net.Conn, error) {
+	network := "tcp"
+	if n := backendNetwork.Load(); n != nil {
+		network = *n
+	}
+	timeout := time.Duration(backendDialTimeout.Load())
+	if timeout <= 0 {
+		timeout = defaultBackendDialTimeout
+	}
+	retries := backendRetries.Load()
+	for attempt := int64(0); ; attempt++ {
+		dialCtx, cancel := context.WithTimeout(ctx, timeout)
+		conn, err := backendDialer.DialContext(dialCtx, network, addr)
+		cancel()
+		if err == nil || attempt >= retries || !isConnRefused(err) {
+			return conn, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backendRetryDelay):
+		}
+	}
+}
+
+// Defaults for the backend response header limits.
+const (
+	defaultBackendMaxHeaderBytes = 1 << 20
+	defaultBackendHeaderTimeout  = 60 * time.Second
+)
+
+// backendTransport is shared by every backend proxy so idle connections are
+// pooled and reused across requests instead of dialing afresh each time.
+// It is replaced, not mutated, when its limits change.
+var backendTransport atomic.Pointer[http.Transport]
+
+// backendH2CTransport is the counterpart of backendTransport for mappings
+// with backendHTTP2. It speaks HTTP/2 over cleartext with prior knowledge
+// (h2c), so backends must accept HTTP/2 without an upgrade.
+var backendH2CTransport atomic.Pointer[http.Transport]
+
+func init() {
+	setBackendLimits(defaultBackendMaxHeaderBytes, defaultBackendHeaderTimeout)
+}
+
+// setBackendLimits caps how large a backend's response headers may be and how
+// long to wait for them, so a misbehaving backend fails fast instead of
+// holding a goroutine. In-flight requests finish on the previous transport.
+func setBackendLimits(maxHeaderBytes int64, headerTimeout time.Duration) {
+	if old := backendTransport.Load(); old != nil &&
+		old.MaxResponseHeaderBytes == maxHeaderBytes && old.ResponseHeaderTimeout == headerTimeout {
+		return
+	}
+	t := &http.Transport{
+		DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dialBackend(ctx, addr)
+		},
+		MaxIdleConns:           256,
+		MaxIdleConnsPerHost:    32,
+		IdleConnTimeout:        90 * time.Second,
+		ExpectContinueTimeout:  1 * time.Second,
+		MaxResponseHeaderBytes: maxHeaderBytes,
+		ResponseHeaderTimeout:  headerTimeout,
+	}
+	h2c := t.Clone()
+	h2c.Protocols = new(http.Protocols)
+	h2c.Protocols.SetUnencryptedHTTP2(true)
+	if old := backendTransport.Swap(t); old != nil {
+		old.CloseIdleConnections()
+	}
+	if old := backendH2CTransport.Swap(h2c); old != nil {
+		old.CloseIdleConnections()
+	}
+}
+
+// backendRoundTripper sends requests through the current backendTransport,
+// or backendH2CTransport when the request's route asks for HTTP/2.
+type backendRoundTripper struct{}
+
+func (backendRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if backendRouteFrom(req.Context()).http2 {
+		return backendH2CTransport.Load().RoundTrip(req)
+	}
+	return backendTransport.Load().RoundTrip(req)
+}
+
+// backendErrorStatus maps a failed round trip to 504 when the backend timed
+// out and 502 for everything else (refused, reset, oversized headers).
+func backendErrorStatus(err error) int {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// wsaeconnrefused is the WSAECONNREFUSED errno connect returns on Windows,
+// which syscall.ECONNREFUSED doesn't match there.
+const wsaeconnrefused = syscall.Errno(10061)
+
+// isConnRefused reports whether err is the backend actively refusing the
+// connection, as opposed to the host being unreachable or unresolvable.
+func isConnRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, wsaeconnrefused)
+}
+
+// backendErrorCategory names what went wrong with a failed round trip for the
+// error page: "timeout", "connection refused", "unreachable" (any other dial
+// failure, such as no route or an unknown host), "connection reset" or
+// "error".
+func backendErrorCategory(err error) string {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return "timeout"
+	}
+	if isConnRefused(err) {
+		return "connection refused"
+	}
+	var oe *net.OpError
+	if errors.As(err, &oe) && oe.Op == "dial" {
+		return "unreachable"
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return "connection reset"
+	}
+	return "error"
+}
+
+// mappingTestTimeout bounds a mapping test from dial to response headers.
+const mappingTestTimeout = 10 * time.Second
+
+// testMapping sends GET / to m's backend the way the proxy would, over the
+// same dialer, network and header timeout, and reports the outcome.
+func testMapping(ctx context.Context, cs *ConfigStore, m DomainMapping) MappingTestResult {
+	target := cs.BackendAddr(m)
+	res := MappingTestResult{Domain: m.Domain, Target: target}
+	if m.Maintenance {
+		res.Status = http.StatusServiceUnavailable
+		res.Error = "mapping is in maintenance mode"
+		return res
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, mappingTestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+target+"/", nil)
+	if err != nil {
+		res.Status = http.StatusBadGateway
+		res.Error = err.Error()
+		return res
+	}
+	req.Host = strings.TrimPrefix(m.Domain, "*.") + "." + cs.DomainSuffix()
+	req = withBackendRoute(req, &backendRoute{name: m.Domain, http2: m.BackendHTTP2})
+	start := time.Now()
+	resp, err := backendRoundTripper{}.RoundTrip(req)
+	res.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		res.Status = backendErrorStatus(err)
+		res.Error = describeBackendError(err, target)
+		return res
+	}
+	resp.Body.Close()
+	res.Status = resp.StatusCode
+	res.OK = resp.StatusCode < 500
+	if !res.OK {
+		res.Error = "backend answered " + resp.Status
+	}
+	return res
+}
+
+// describeBackendError turns a failed round trip into a short diagnosis.
+func describeBackendError(err error, target string) string {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return "timed out waiting for " + target
+	}
+	var oe *net.OpError
+	if errors.As(err, &oe) && oe.Op == "dial" {
+		return "port closed: nothing accepted a connection on " + target
+	}
+	return err.Error()
+}
+
+// backendProxies holds one ReverseProxy per backend address.
+var backendProxies = &proxyCache{proxies: make(map[string]*httputil.ReverseProxy)}
+
+// proxyCache builds reverse proxies lazily and reuses them for later requests
+// to the same target.
+type proxyCache struct {
+	mu      sync.Mutex
+	proxies map[string]*httputil.ReverseProxy
+}
+
+func (pc *proxyCache) get(target string) *httputil.ReverseProxy {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	p, ok := pc.proxies[target]
+	if !ok {
+		p = newBackendProxy(target)
+		pc.proxies[target] = p
+	}
+	return p
+}
+
+// backendRoute carries the per-request mapping details a cached proxy needs.
+type backendRoute struct {
+	name           string
+	modify         func(*http.Response) error
+	http2          bool   // use h2c to the backend
+	dashboard      string // dashboard URL linked from the error page
+	strippedPrefix string // mapping prefix removed from the request path, restored in redirects
+}
+
+type backendRouteKey struct{}
+
+func withBackendRoute(r *http.Request, route *backendRoute) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), backendRouteKey{}, route))
+}
+
+func backendRouteFrom(ctx context.Context) *backendRoute {
+	if rt, ok := ctx.Value(backendRouteKey{}).(*backendRoute); ok {
+		return rt
+	}
+	return &backendRoute{}
+}
+
+// identifyProxy makes backend proxies announce themselves with Via and Server
+// headers, set from identifyProxy at startup and on reload.
+var identifyProxy atomic.Bool
+
+func setIdentifyProxy(on bool) {
+	identifyProxy.Store(on)
+}
+
+// viaValue is this proxy's entry in a Via header.
+func viaValue() string {
+	return "1.1 portgate/" + version
+}
+
+// appendVia adds this proxy to the end of h's Via chain.
+func appendVia(h http.Header) {
+	h.Set("Via", strings.Join(append(h.Values("Via"), viaValue()), ", "))
+}
+
+// newBackendProxy returns a reverse proxy to target. The inbound Host header
+// is kept; mapping-specific behavior comes from the request's backendRoute.
+// The Director only retargets the URL: ReverseProxy strips hop-by-hop headers
+// after it runs, and keeps Upgrade/Connection only for genuine upgrades.
+func newBackendProxy(target string) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "http"
+			req.URL.Host = target
+			if identifyProxy.Load() {
+				appendVia(req.Header)
+			}
+		},
+		Transport: backendRoundTripper{},
+		ModifyResponse: func(resp *http.Response) error {
+			if identifyProxy.Load() {
+				appendVia(resp.Header)
+				resp.Header.Set("Server", "portgate")
+			}
+			route := backendRouteFrom(resp.Request.Context())
+			if route.strippedPrefix != "" {
+				restoreLocationPrefix(resp.Header, route.strippedPrefix)
+			}
+			if route.modify != nil {
+				return route.modify(resp)
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			route := backendRouteFrom(r.Context())
+			logEvent(levelError, logFields{"subdomain": route.name, "target": target, "remote_addr": r.RemoteAddr, "path": r.URL.Path, "error": err.Error()},
+				"proxy error for %s: %v", route.name, err)
+			// With retries on, a backend still refusing after all of them is
+			// most likely restarting: show the page that reloads itself
+			if backendRetries.Load() > 0 && isConnRefused(err) {
+				serveStarting(w, r, route.name)
+				return
+			}
+			serveProxyError(w, r, errorPageData{
+				Status:    backendErrorStatus(err),
+				Category:  backendErrorCategory(err),
+				Domain:    route.name,
+				Target:    target,
+				Dashboard: route.dashboard,
+			})
+		},
+	}
+}
+
+// restoreLocationPrefix puts a stripped path prefix back on a redirect to a
+// path on the same host, so a backend redirecting to /login sends the browser
+// to /v1/login. Redirects to other hosts are left alone.
+func restoreLocationPrefix(h http.Header, prefix string) {
+	loc := h.Get("Location")
+	if strings.HasPrefix(loc, "/") && !strings.HasPrefix(loc, "//") {
+		h.Set("Location", prefix+loc)
+	}
+}
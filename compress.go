@@ -0,0 +1,166 @@
+package main
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// defaultCompressionExcludeTypes are media types that are already compressed,
+// so gzipping them costs CPU and can even make them bigger.
+var defaultCompressionExcludeTypes = []string{
+	"image/*",
+	"video/*",
+	"audio/*",
+	"font/woff",
+	"font/woff2",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zstd",
+	"application/x-bzip2",
+	"application/x-xz",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/pdf",
+	"application/octet-stream",
+}
+
+// minCompressSize is the smallest known body worth compressing; below it the
+// gzip framing outweighs the savings.
+const minCompressSize = 1024
+
+// compressionExcluded reports whether responses of contentType are left
+// alone. Patterns are globs matched against the bare media type, so
+// "image/*" covers every image type.
+func compressionExcluded(contentType string, patterns []string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToLower(p), mt); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// validateCompressionPatterns checks that every pattern is a valid glob.
+func validateCompressionPatterns(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// acceptsGzip reports whether the client's Accept-Encoding allows gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for _, t := range strings.Split(v, ",") {
+			name, params, _ := strings.Cut(t, ";")
+			if !strings.EqualFold(strings.TrimSpace(name), "gzip") {
+				continue
+			}
+			q, found := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q=")
+			if !found {
+				return true
+			}
+			weight, err := strconv.ParseFloat(q, 64)
+			return err == nil && weight > 0
+		}
+	}
+	return false
+}
+
+// CompressMiddleware gzips proxied responses for clients that accept it when
+// compression is enabled. Responses that are already encoded, small, or of
+// an excluded content type pass through untouched, as do WebSocket upgrades
+// and range requests.
+func CompressMiddleware(config *ConfigStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !config.Compression() || !acceptsGzip(r) ||
+			isWebSocketUpgrade(r) || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w, exclude: config.CompressionExcludeTypes()}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// gzipResponseWriter decides at WriteHeader whether to compress the body,
+// based on the response headers the handler set.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	exclude     []string
+	wroteHeader bool
+	gz          *gzip.Writer
+}
+
+func (gw *gzipResponseWriter) WriteHeader(code int) {
+	if gw.wroteHeader {
+		return
+	}
+	gw.wroteHeader = true
+	if gw.shouldCompress(code) {
+		h := gw.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		h.Add("Vary", "Accept-Encoding")
+		gw.gz = gzip.NewWriter(gw.ResponseWriter)
+	}
+	gw.ResponseWriter.WriteHeader(code)
+}
+
+func (gw *gzipResponseWriter) shouldCompress(code int) bool {
+	h := gw.Header()
+	if code < 200 || code == http.StatusNoContent || code == http.StatusNotModified {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Type") == "" {
+		return false
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < minCompressSize {
+		return false
+	}
+	return !compressionExcluded(h.Get("Content-Type"), gw.exclude)
+}
+
+func (gw *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !gw.wroteHeader {
+		gw.WriteHeader(http.StatusOK)
+	}
+	if gw.gz != nil {
+		return gw.gz.Write(b)
+	}
+	return gw.ResponseWriter.Write(b)
+}
+
+// Flush pushes out what has been compressed so far, so streamed responses
+// such as server-sent events keep flowing.
+func (gw *gzipResponseWriter) Flush() {
+	if gw.gz != nil {
+		gw.gz.Flush()
+	}
+	if f, ok := gw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (gw *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
+
+func (gw *gzipResponseWriter) close() {
+	if gw.gz != nil {
+		gw.gz.Close()
+	}
+}
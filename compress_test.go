@@ -0,0 +1,110 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompressMiddleware(t *testing.T) {
+	html := strings.Repeat("<p>hello</p>", 200)
+	png := "\x89PNG\r\n\x1a\n" + strings.Repeat("\x00", 2000)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			io.WriteString(w, html)
+		case "/logo.png":
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, png)
+		case "/encoded":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Encoding", "br")
+			io.WriteString(w, html)
+		case "/small":
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, "tiny")
+		}
+	}))
+	defer backend.Close()
+
+	cs := newTestConfigStore(t)
+	cs.cfg.Compression = true
+	cs.cfg.Mappings = []DomainMapping{{Domain: "app", TargetPort: listenerPort(t, backend)}}
+	h := CompressMiddleware(cs, ProxyHandler(NewHub(cs), "127.0.0.1:1"))
+
+	tests := []struct {
+		path     string
+		accept   string
+		encoding string
+		body     string
+	}{
+		{"/page", "gzip, deflate, br", "gzip", html},
+		{"/page", "", "", html},
+		{"/page", "gzip;q=0", "", html},
+		{"/logo.png", "gzip", "", png},
+		{"/encoded", "gzip", "br", html},
+		{"/small", "gzip", "", "tiny"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Host = "app.localhost"
+		if tt.accept != "" {
+			req.Header.Set("Accept-Encoding", tt.accept)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if got := rec.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("%s (%q): Content-Encoding = %q, want %q", tt.path, tt.accept, got, tt.encoding)
+			continue
+		}
+		body := rec.Body.String()
+		if tt.encoding == "gzip" {
+			zr, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.path, err)
+			}
+			raw, _ := io.ReadAll(zr)
+			body = string(raw)
+		}
+		if body != tt.body {
+			t.Errorf("%s (%q): body differs from the backend's (%d bytes, want %d)", tt.path, tt.accept, len(body), len(tt.body))
+		}
+	}
+
+	// Off by default
+	cs.cfg.Compression = false
+	req := httptest.NewRequest(http.MethodGet, "/page", nil)
+	req.Host = "app.localhost"
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("compression off: Content-Encoding = %q", got)
+	}
+}
+
+func TestCompressionExcluded(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{"image/png", true},
+		{"IMAGE/WEBP", true},
+		{"video/mp4", true},
+		{"font/woff2", true},
+		{"application/zip", true},
+		{"text/html; charset=utf-8", false},
+		{"application/json", false},
+		{"not a type", true},
+	}
+	for _, tt := range tests {
+		if got := compressionExcluded(tt.contentType, defaultCompressionExcludeTypes); got != tt.want {
+			t.Errorf("compressionExcluded(%q) = %v, want %v", tt.contentType, got, tt.want)
+		}
+	}
+}
@@ -2,8 +2,18 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"net"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -13,6 +23,11 @@ type ConfigStore struct {
 	mu   sync.RWMutex
 	path string
 	cfg  Config
+
+	forceReadOnly bool                 // set by --read-only for this process only
+	allOrigins    bool                 // set by --unsafe-allow-all-origins for this process only
+	scanInterval  time.Duration        // set by --scan-interval for this process only; 0 uses the config
+	firstSeen     map[string]time.Time // when this process first saw each mapping, for startup grace periods
 }
 
 // DefaultScanRanges are used when no custom ranges are configured.
@@ -23,29 +38,254 @@ var DefaultScanRanges = []ScanRange{
 	{Start: 8000, End: 8999},
 }
 
+// configEnvVar overrides the default config location for every command.
+const configEnvVar = "PORTGATE_CONFIG"
+
+// resolveConfigPath picks the config file location: an explicit path (the
+// --config flag) wins, then $PORTGATE_CONFIG, then the platform default.
+func resolveConfigPath(path string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+	if env := os.Getenv(configEnvVar); env != "" {
+		return env, nil
+	}
+	return defaultConfigPath()
+}
+
+// errInvalidConfig wraps validation failures of a config file.
+var errInvalidConfig = errors.New("invalid config")
+
 // NewConfigStore creates a ConfigStore using the given path.
-// If path is empty, uses a platform-appropriate default location.
+// If path is empty, uses $PORTGATE_CONFIG or a platform-appropriate default.
+// A file that parses but fails validation is an errInvalidConfig error; the
+// store is still returned with its contents so config reset can replace
+// them.
 func NewConfigStore(path string) (*ConfigStore, error) {
-	if path == "" {
-		var err error
-		path, err = defaultConfigPath()
-		if err != nil {
-			return nil, err
-		}
+	path, err := resolveConfigPath(path)
+	if err != nil {
+		return nil, err
+	}
+	cs := &ConfigStore{
+		path:      path,
+		cfg:       Config{ConfigVersion: currentConfigVersion, ScanIntervalSec: defaultScanIntervalSec},
+		firstSeen: make(map[string]time.Time),
 	}
-	cs := &ConfigStore{path: path, cfg: Config{ScanIntervalSec: 10}}
-	if err := cs.load(); err != nil && !os.IsNotExist(err) {
+	err = cs.load()
+	if err != nil && !os.IsNotExist(err) && !errors.Is(err, errInvalidConfig) {
 		return nil, err
 	}
+	now := time.Now()
+	for _, m := range cs.cfg.Mappings {
+		cs.firstSeen[m.Domain] = now
+	}
+	if errors.Is(err, errInvalidConfig) {
+		return cs, err
+	}
 	return cs, nil
 }
 
+// defaultScanIntervalSec is the scan interval for new and legacy configs.
+const defaultScanIntervalSec = 10
+
+// configMigrations upgrade a config one schema version at a time: entry i
+// takes a version-i config to version i+1. Append a step (and so bump
+// currentConfigVersion) whenever a field is renamed or its meaning changes.
+var configMigrations = []func(*Config){
+	// 0 → 1: files written before configVersion existed could carry
+	// scanIntervalSec 0, which would make the scanner spin.
+	func(c *Config) {
+		if c.ScanIntervalSec <= 0 {
+			c.ScanIntervalSec = defaultScanIntervalSec
+		}
+	},
+}
+
+// currentConfigVersion is the schema version this build writes.
+var currentConfigVersion = len(configMigrations)
+
 func (cs *ConfigStore) load() error {
 	data, err := os.ReadFile(cs.path)
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(data, &cs.cfg)
+	// A file without configVersion predates versioning
+	cs.cfg.ConfigVersion = 0
+	if err := json.Unmarshal(data, &cs.cfg); err != nil {
+		return err
+	}
+	migrated := migrateConfig(&cs.cfg)
+	normalizeConfig(&cs.cfg)
+	if err := validateConfig(&cs.cfg); err != nil {
+		return fmt.Errorf("%w %s: %w", errInvalidConfig, cs.path, err)
+	}
+	if !migrated {
+		return nil
+	}
+	// Write the upgraded shape back once so later loads skip the migration
+	if err := cs.Save(); err != nil {
+		return fmt.Errorf("saving migrated config: %w", err)
+	}
+	return nil
+}
+
+// Reload re-reads the config file and swaps it in if it is valid. On any
+// error the current config is kept. Runtime-only state (--read-only, mapping
+// first-seen times) survives the reload.
+func (cs *ConfigStore) Reload() error {
+	data, err := os.ReadFile(cs.path)
+	if err != nil {
+		return err
+	}
+	next := Config{ScanIntervalSec: defaultScanIntervalSec}
+	if err := json.Unmarshal(data, &next); err != nil {
+		return fmt.Errorf("parsing %s: %w", cs.path, err)
+	}
+	migrateConfig(&next)
+	normalizeConfig(&next)
+
+	if err := validateConfig(&next); err != nil {
+		return err
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.cfg = next
+	now := time.Now()
+	for _, m := range next.Mappings {
+		if _, ok := cs.firstSeen[m.Domain]; !ok {
+			cs.firstSeen[m.Domain] = now
+		}
+	}
+	return nil
+}
+
+// normalizeConfig canonicalizes hand-edited values the API would have
+// normalized on the way in, so they compare the same way.
+func normalizeConfig(c *Config) {
+	for i := range c.Mappings {
+		c.Mappings[i].Group = normalizeGroup(c.Mappings[i].Group)
+	}
+}
+
+// validateConfig checks every setting that is read from the file as-is. load,
+// Reload and applyRuntimeConfig all run it, so a config is judged the same
+// way at startup, on reload and when applied.
+func validateConfig(c *Config) error {
+	// A scratch store lets the accessors see the values
+	check := &ConfigStore{cfg: *c}
+	if err := validateDomainSuffix(check.DomainSuffix()); err != nil {
+		return err
+	}
+	if _, err := check.TrustedProxyNets(); err != nil {
+		return err
+	}
+	if _, _, err := check.ProxyBackend(); err != nil {
+		return err
+	}
+	if _, err := check.ScanMode(); err != nil {
+		return err
+	}
+	if _, err := loadErrorPage(check.ErrorPageTemplate()); err != nil {
+		return err
+	}
+	if _, err := loadNotFoundPage(check.NotFoundPageTemplate()); err != nil {
+		return err
+	}
+	if _, err := check.BasePath(); err != nil {
+		return err
+	}
+	if err := validateAllowedOrigins(c.AllowedOrigins); err != nil {
+		return err
+	}
+	if err := validateCompressionPatterns(check.CompressionExcludeTypes()); err != nil {
+		return fmt.Errorf("compressionExcludeTypes: %w", err)
+	}
+	if _, err := check.TrailingSlashMode(); err != nil {
+		return err
+	}
+	if _, err := check.HealthyStatusCodes(); err != nil {
+		return err
+	}
+	if err := validateUpdateSource(c.UpdateRepo, c.UpdateAPIBase); err != nil {
+		return err
+	}
+	if err := validateProbePaths(c.ProbePaths); err != nil {
+		return err
+	}
+	for name, ranges := range c.ScanProfiles {
+		if err := validateScanProfile(name, ranges); err != nil {
+			return err
+		}
+	}
+	for _, r := range c.ScanRanges {
+		if err := validateScanRange(r); err != nil {
+			return fmt.Errorf("scanRanges: %w", err)
+		}
+	}
+	if _, ok := c.ScanProfiles[c.ActiveScanProfile]; !ok && c.ActiveScanProfile != "" {
+		return fmt.Errorf("activeScanProfile: %w %q", errUnknownScanProfile, c.ActiveScanProfile)
+	}
+	for _, m := range c.Mappings {
+		if err := validateMappingMode(m.Mode); err != nil {
+			return fmt.Errorf("mapping %s: %w", m.Domain, err)
+		}
+		if err := validateStripPrefix(m.StripPrefix); err != nil {
+			return fmt.Errorf("mapping %s: %w", m.Domain, err)
+		}
+		if m.TargetHost != "" {
+			if _, err := normalizeTargetHost(m.TargetHost); err != nil {
+				return fmt.Errorf("mapping %s: %w", m.Domain, err)
+			}
+		}
+	}
+	for _, mp := range c.ManualPorts {
+		if err := validateProbePaths(mp.ProbePaths); err != nil {
+			return fmt.Errorf("manual port %d: %w", mp.Port, err)
+		}
+		if err := validateHealthPath(mp.HealthPath); err != nil {
+			return fmt.Errorf("manual port %d: %w", mp.Port, err)
+		}
+	}
+	for _, p := range c.ExcludeProcesses {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid exclude pattern %q: %w", p, err)
+		}
+	}
+	if len(c.ExcludeProcesses) > 0 && !check.ResolveExe() {
+		return errExcludeWithoutResolve
+	}
+	return nil
+}
+
+// errExcludeWithoutResolve is returned for process exclusions that can't
+// apply because owning processes aren't looked up.
+var errExcludeWithoutResolve = errors.New("excludeProcesses needs resolveExe; processes aren't looked up while it is false")
+
+// Validate runs validateConfig on the current config.
+func (cs *ConfigStore) Validate() error {
+	cs.mu.RLock()
+	c := cs.cfg
+	cs.mu.RUnlock()
+	return validateConfig(&c)
+}
+
+// migrateConfig applies any pending migrations and reports whether c changed.
+// Configs from a newer build are left untouched.
+func migrateConfig(c *Config) bool {
+	if c.ConfigVersion >= currentConfigVersion {
+		return false
+	}
+	for _, migrate := range configMigrations[c.ConfigVersion:] {
+		migrate(c)
+	}
+	c.ConfigVersion = currentConfigVersion
+	return true
+}
+
+// Path returns the file the config is loaded from and saved to.
+func (cs *ConfigStore) Path() string {
+	return cs.path
 }
 
 // Save writes the config atomically (write tmp + rename).
@@ -67,6 +307,41 @@ func (cs *ConfigStore) Save() error {
 	return os.Rename(tmp, cs.path)
 }
 
+// Reset backs up the config file to a timestamped copy next to it and
+// replaces the config with defaults. System mappings, the master password and
+// the API token are kept so a reset never leaves the dashboard unprotected;
+// user mappings are kept only with keepMappings. It returns the backup path,
+// or "" if there was no file to back up.
+func (cs *ConfigStore) Reset(keepMappings bool) (string, error) {
+	backup := ""
+	data, err := os.ReadFile(cs.path)
+	switch {
+	case err == nil:
+		backup = cs.path + "." + time.Now().Format("20060102-150405") + ".bak"
+		if err := os.WriteFile(backup, data, 0644); err != nil {
+			return "", fmt.Errorf("backing up config: %w", err)
+		}
+	case !os.IsNotExist(err):
+		return "", err
+	}
+
+	cs.mu.Lock()
+	next := Config{
+		ConfigVersion:      currentConfigVersion,
+		ScanIntervalSec:    defaultScanIntervalSec,
+		MasterPasswordHash: cs.cfg.MasterPasswordHash,
+		APIToken:           cs.cfg.APIToken,
+	}
+	for _, m := range cs.cfg.Mappings {
+		if m.System || keepMappings {
+			next.Mappings = append(next.Mappings, m)
+		}
+	}
+	cs.cfg = next
+	cs.mu.Unlock()
+	return backup, cs.Save()
+}
+
 // Mappings returns a copy of the current domain mappings.
 func (cs *ConfigStore) Mappings() []DomainMapping {
 	cs.mu.RLock()
@@ -79,14 +354,23 @@ func (cs *ConfigStore) Mappings() []DomainMapping {
 // AddMapping adds a domain mapping and persists.
 func (cs *ConfigStore) AddMapping(m DomainMapping) error {
 	cs.mu.Lock()
-	// Remove existing mapping for same domain
-	filtered := cs.cfg.Mappings[:0]
-	for _, existing := range cs.cfg.Mappings {
-		if existing.Domain != m.Domain {
-			filtered = append(filtered, existing)
+	// Replace an existing mapping for the same domain in place, keeping its
+	// position and creation time, otherwise append
+	replaced := false
+	for i, existing := range cs.cfg.Mappings {
+		if existing.Domain == m.Domain {
+			if !existing.CreatedAt.IsZero() {
+				m.CreatedAt = existing.CreatedAt
+			}
+			cs.cfg.Mappings[i] = m
+			replaced = true
+			break
 		}
 	}
-	cs.cfg.Mappings = append(filtered, m)
+	if !replaced {
+		cs.cfg.Mappings = append(cs.cfg.Mappings, m)
+	}
+	cs.firstSeen[m.Domain] = time.Now()
 	cs.mu.Unlock()
 	return cs.Save()
 }
@@ -106,21 +390,398 @@ func (cs *ConfigStore) RemoveMapping(domain string) error {
 }
 
 // LookupPort returns the target port for a domain, or 0 if not found.
+// Wildcard mappings are considered, see ResolveMapping.
 func (cs *ConfigStore) LookupPort(domain string) int {
+	if m, ok := cs.ResolveMapping(domain); ok {
+		return m.TargetPort
+	}
+	return 0
+}
+
+// ResolveMapping finds the mapping that routes a subdomain. An exact match
+// always wins; otherwise the most specific wildcard mapping matching the name
+// is used (the one with the most literal characters, so "*.eu.app" beats
+// "*.app"), ties going to the earliest defined. See wildcardMatch for the
+// pattern syntax.
+func (cs *ConfigStore) ResolveMapping(name string) (DomainMapping, bool) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	var best DomainMapping
+	found := false
+	for _, m := range cs.cfg.Mappings {
+		if m.Domain == name {
+			return m, true
+		}
+		if wildcardMatch(m.Domain, name) && (!found || wildcardSpecificity(m.Domain) > wildcardSpecificity(best.Domain)) {
+			best, found = m, true
+		}
+	}
+	return best, found
+}
+
+// isWildcardDomain reports whether domain is a wildcard pattern such as
+// "*.app" or "api-*".
+func isWildcardDomain(domain string) bool {
+	return strings.Contains(domain, "*")
+}
+
+// wildcardSpecificity ranks overlapping wildcards by their literal
+// characters.
+func wildcardSpecificity(pattern string) int {
+	return len(pattern) - strings.Count(pattern, "*")
+}
+
+// wildcardMatch reports whether name falls under the wildcard pattern. A
+// leading "*." label covers one or more labels: "*.app" matches "t1.app" and
+// "a.t1.app" but not "app" itself. Any other "*" stands for any run of
+// characters within its label, so "api-*" matches "api-v2" but not
+// "api-v2.eu", and "pr-*.preview" matches "pr-12.preview".
+func wildcardMatch(pattern, name string) bool {
+	if !isWildcardDomain(pattern) {
+		return false
+	}
+	rest, deep := strings.CutPrefix(pattern, "*.")
+	if !deep {
+		return labelsMatch(pattern, name)
+	}
+	for i := 1; i < len(name); i++ {
+		if name[i] == '.' && labelsMatch(rest, name[i+1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// labelsMatch reports whether name has as many labels as pattern and each
+// matches its pattern label, where "*" stands for any run of characters.
+func labelsMatch(pattern, name string) bool {
+	if strings.Count(pattern, ".") != strings.Count(name, ".") {
+		return false
+	}
+	for {
+		p, pRest, more := strings.Cut(pattern, ".")
+		n, nRest, _ := strings.Cut(name, ".")
+		if !globMatch(p, n) {
+			return false
+		}
+		if !more {
+			return true
+		}
+		pattern, name = pRest, nRest
+	}
+}
+
+// globMatch reports whether s matches pattern, where "*" stands for any run
+// of characters, including none. s is the client's Host header, so this is
+// the greedy linear-time match: on a mismatch only the most recent star is
+// extended, never earlier ones.
+func globMatch(pattern, s string) bool {
+	p, i := 0, 0
+	star, mark := -1, 0 // last star in pattern and where its run in s ends
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, i
+			p++
+		case p < len(pattern) && pattern[p] == s[i]:
+			p++
+			i++
+		case star >= 0:
+			mark++
+			p, i = star+1, mark
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// validateWildcardDomain checks a wildcard pattern: non-empty labels, no
+// "**", and at least one label that isn't all wildcard, so a pattern can't
+// swallow every name.
+func validateWildcardDomain(domain string) error {
+	if strings.Contains(domain, "**") {
+		return errors.New(`wildcard pattern must not contain "**"`)
+	}
+	literal := false
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" {
+			return errors.New("wildcard pattern has an empty label")
+		}
+		if label != "*" {
+			literal = true
+		}
+	}
+	if !literal {
+		return errors.New(`wildcard pattern needs a label with literal text, e.g. *.app or api-*`)
+	}
+	return nil
+}
+
+// MappedHost returns the proxy hostname of the first exact mapping targeting
+// local port (e.g. "myapp.localhost"), or "" if the port is unmapped.
+// Mappings with their own target host point elsewhere and are skipped.
+func (cs *ConfigStore) MappedHost(port int) string {
+	suffix := cs.DomainSuffix()
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	for _, m := range cs.cfg.Mappings {
+		if m.TargetPort == port && m.TargetHost == "" && !m.System && !isWildcardDomain(m.Domain) {
+			return m.Domain + "." + suffix
+		}
+	}
+	return ""
+}
+
+// StartupDeadline returns when m's startup grace period ends: its
+// StartupGracePeriodSec after this process first saw the mapping (at load or
+// when it was added). It is zero if the mapping has no grace period.
+func (cs *ConfigStore) StartupDeadline(m DomainMapping) time.Time {
+	if m.StartupGracePeriodSec <= 0 {
+		return time.Time{}
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	seen, ok := cs.firstSeen[m.Domain]
+	if !ok {
+		seen = time.Now()
+		cs.firstSeen[m.Domain] = seen
+	}
+	return seen.Add(time.Duration(m.StartupGracePeriodSec) * time.Second)
+}
+
+// defaultWebSocketIdleTimeout closes proxied WebSockets that carry no traffic
+// in either direction for this long, so tunnels to crashed backends don't
+// linger forever.
+const defaultWebSocketIdleTimeout = 5 * time.Minute
+
+// WebSocketIdleTimeout returns how long a WebSocket proxied for m may go
+// without traffic before it is closed. The mapping's setting wins over the
+// global one; zero means no timeout.
+func (cs *ConfigStore) WebSocketIdleTimeout(m DomainMapping) time.Duration {
+	if m.WebSocketIdleTimeoutSec != nil {
+		return time.Duration(max(*m.WebSocketIdleTimeoutSec, 0)) * time.Second
+	}
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	if cs.cfg.WebSocketIdleTimeoutSec == nil {
+		return defaultWebSocketIdleTimeout
+	}
+	return time.Duration(max(*cs.cfg.WebSocketIdleTimeoutSec, 0)) * time.Second
+}
+
+// normalizeGroup trims and lowercases a mapping group so "Frontend " and
+// "frontend" are the same group.
+func normalizeGroup(group string) string {
+	return strings.ToLower(strings.TrimSpace(group))
+}
+
+// Mapping modes restrict which kinds of request a mapping proxies.
+const (
+	mappingModeBoth     = "both"
+	mappingModeHTTPOnly = "http-only"
+	mappingModeWSOnly   = "ws-only"
+)
+
+// validateMappingMode checks a mapping's mode; empty means both.
+func validateMappingMode(mode string) error {
+	switch mode {
+	case "", mappingModeBoth, mappingModeHTTPOnly, mappingModeWSOnly:
+		return nil
+	}
+	return fmt.Errorf("mode %q must be %s, %s or %s", mode, mappingModeBoth, mappingModeHTTPOnly, mappingModeWSOnly)
+}
+
+// validateMapping checks a user mapping's domain and settings. The domain
+// must already be lowercased and stripped of the suffix.
+func validateMapping(m DomainMapping) error {
+	domain := m.Domain
+	if isReservedDomain(domain) || domain == "" {
+		return errors.New("reserved domain")
+	}
+	if isWildcardDomain(domain) {
+		if err := validateWildcardDomain(domain); err != nil {
+			return err
+		}
+	}
+	if m.TargetPort < 1 || m.TargetPort > 65535 {
+		return errors.New("port must be 1-65535")
+	}
+	if m.StartupGracePeriodSec < 0 {
+		return errors.New("startupGracePeriodSec must not be negative")
+	}
+	if m.WebSocketIdleTimeoutSec != nil && *m.WebSocketIdleTimeoutSec < 0 {
+		return errors.New("webSocketIdleTimeoutSec must not be negative")
+	}
+	if err := validateMappingMode(m.Mode); err != nil {
+		return err
+	}
+	if err := validateStripPrefix(m.StripPrefix); err != nil {
+		return err
+	}
+	for _, rule := range m.ResponseRewrite {
+		if rule.From == "" {
+			return errors.New("rewrite rule needs a non-empty from")
+		}
+	}
+	return nil
+}
+
+// cleanStripPrefix normalizes a mapping's stripPrefix as entered, dropping a
+// trailing slash so "/v1/" strips the same as "/v1".
+func cleanStripPrefix(p string) (string, error) {
+	p = strings.TrimSpace(p)
+	if p == "" {
+		return "", nil
+	}
+	if trimmed := strings.TrimRight(p, "/"); trimmed != "" {
+		p = trimmed
+	}
+	return p, validateStripPrefix(p)
+}
+
+// validateStripPrefix checks a stored stripPrefix: empty, or a path starting
+// with "/" and without a trailing slash.
+func validateStripPrefix(p string) error {
+	if p != "" && (!strings.HasPrefix(p, "/") || strings.HasSuffix(p, "/")) {
+		return fmt.Errorf("stripPrefix %q must start with / and name a path, e.g. /v1", p)
+	}
+	return nil
+}
+
+// parseTarget parses a mapping target: a bare port ("3000"), host and port
+// ("devbox.lan:3000", "10.0.0.5:3000"), or a bracketed IPv6 literal and port
+// ("[::1]:3000"). The host is empty for a bare port.
+func parseTarget(s string) (host string, port int, err error) {
+	s = strings.TrimSpace(s)
+	portStr := s
+	if _, err := strconv.Atoi(s); err != nil {
+		if strings.Count(s, ":") > 1 && !strings.HasPrefix(s, "[") {
+			return "", 0, fmt.Errorf("target %q: IPv6 hosts must be bracketed, e.g. [::1]:3000", s)
+		}
+		if host, portStr, err = net.SplitHostPort(s); err != nil {
+			return "", 0, fmt.Errorf("target %q: %w", s, err)
+		}
+		if host, err = normalizeTargetHost(host); err != nil {
+			return "", 0, err
+		}
+	}
+	port, err = strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("target %q: port must be 1-65535", s)
+	}
+	return host, port, nil
+}
+
+// normalizeTargetHost validates a mapping's target host, returning IP
+// literals in canonical form and hostnames lowercased.
+func normalizeTargetHost(host string) (string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+	name := strings.ToLower(host)
+	if !validHostname(name) {
+		return "", fmt.Errorf("target host %q is not an IP address or hostname", host)
+	}
+	return name, nil
+}
+
+// mappingTarget formats m's backend for display: ":3000" for the default
+// host, otherwise host and port with IPv6 literals bracketed.
+func mappingTarget(m DomainMapping) string {
+	if m.TargetHost == "" {
+		return ":" + strconv.Itoa(m.TargetPort)
+	}
+	return net.JoinHostPort(m.TargetHost, strconv.Itoa(m.TargetPort))
+}
+
+// MappingCounts returns how many mappings there are, how many are in
+// maintenance mode, and how many are system mappings.
+func (cs *ConfigStore) MappingCounts() (total, maintenance, system int) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	for _, m := range cs.cfg.Mappings {
+		if m.Maintenance {
+			maintenance++
+		}
+		if m.System {
+			system++
+		}
+	}
+	return len(cs.cfg.Mappings), maintenance, system
+}
+
+// LookupMapping returns the mapping for a domain and whether it exists.
+func (cs *ConfigStore) LookupMapping(domain string) (DomainMapping, bool) {
 	cs.mu.RLock()
 	defer cs.mu.RUnlock()
 	for _, m := range cs.cfg.Mappings {
 		if m.Domain == domain {
-			return m.TargetPort
+			return m, true
 		}
 	}
-	return 0
+	return DomainMapping{}, false
+}
+
+// PortNotes returns the user's port annotations keyed by port number.
+func (cs *ConfigStore) PortNotes() map[int]string {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return maps.Clone(cs.cfg.PortNotes)
+}
+
+// SetPortNote sets the note for a port and persists. An empty note removes it.
+func (cs *ConfigStore) SetPortNote(port int, note string) error {
+	cs.mu.Lock()
+	if note == "" {
+		delete(cs.cfg.PortNotes, port)
+	} else {
+		if cs.cfg.PortNotes == nil {
+			cs.cfg.PortNotes = make(map[int]string)
+		}
+		cs.cfg.PortNotes[port] = note
+	}
+	cs.mu.Unlock()
+	return cs.Save()
+}
+
+// SetMaintenance toggles maintenance mode on a mapping and persists.
+func (cs *ConfigStore) SetMaintenance(domain string, enabled bool) error {
+	cs.mu.Lock()
+	found := false
+	for i := range cs.cfg.Mappings {
+		if cs.cfg.Mappings[i].Domain == domain {
+			cs.cfg.Mappings[i].Maintenance = enabled
+			found = true
+			break
+		}
+	}
+	cs.mu.Unlock()
+	if !found {
+		return fmt.Errorf("no mapping for %q", domain)
+	}
+	return cs.Save()
 }
 
-// ScanRanges returns the configured scan ranges, or defaults if none set.
+// MaintenanceRetryAfter returns the Retry-After value sent with maintenance
+// pages, or 0 if the header should be omitted.
+func (cs *ConfigStore) MaintenanceRetryAfter() time.Duration {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return time.Duration(cs.cfg.MaintenanceRetryAfterSec) * time.Second
+}
+
+// ScanRanges returns the ranges to scan: the active profile's if one is
+// selected, otherwise the configured scan ranges, or defaults if none set.
 func (cs *ConfigStore) ScanRanges() []ScanRange {
 	cs.mu.RLock()
 	defer cs.mu.RUnlock()
+	if ranges, ok := cs.cfg.ScanProfiles[cs.cfg.ActiveScanProfile]; ok && cs.cfg.ActiveScanProfile != "" {
+		return slices.Clone(ranges)
+	}
 	if len(cs.cfg.ScanRanges) == 0 {
 		return DefaultScanRanges
 	}
@@ -129,9 +790,21 @@ func (cs *ConfigStore) ScanRanges() []ScanRange {
 	return out
 }
 
-// AddScanRange adds a scan range and persists.
+// AddScanRange adds a scan range and persists. With a scan profile active,
+// the range is added to that profile.
 func (cs *ConfigStore) AddScanRange(sr ScanRange) error {
 	cs.mu.Lock()
+	if name := cs.cfg.ActiveScanProfile; name != "" {
+		if _, ok := cs.cfg.ScanProfiles[name]; !ok {
+			cs.mu.Unlock()
+			return fmt.Errorf("activeScanProfile: %w %q", errUnknownScanProfile, name)
+		}
+		if !slices.Contains(cs.cfg.ScanProfiles[name], sr) {
+			cs.cfg.ScanProfiles[name] = append(cs.cfg.ScanProfiles[name], sr)
+		}
+		cs.mu.Unlock()
+		return cs.Save()
+	}
 	// Initialize from defaults if empty
 	if len(cs.cfg.ScanRanges) == 0 {
 		cs.cfg.ScanRanges = make([]ScanRange, len(DefaultScanRanges))
@@ -139,7 +812,7 @@ func (cs *ConfigStore) AddScanRange(sr ScanRange) error {
 	}
 	// Avoid duplicates
 	for _, existing := range cs.cfg.ScanRanges {
-		if existing.Start == sr.Start && existing.End == sr.End {
+		if existing == sr {
 			cs.mu.Unlock()
 			return nil
 		}
@@ -149,9 +822,19 @@ func (cs *ConfigStore) AddScanRange(sr ScanRange) error {
 	return cs.Save()
 }
 
-// RemoveScanRange removes a scan range and persists.
+// RemoveScanRange removes a scan range and persists. With a scan profile
+// active, the range is removed from that profile.
 func (cs *ConfigStore) RemoveScanRange(sr ScanRange) error {
 	cs.mu.Lock()
+	if name := cs.cfg.ActiveScanProfile; name != "" {
+		if _, ok := cs.cfg.ScanProfiles[name]; !ok {
+			cs.mu.Unlock()
+			return fmt.Errorf("activeScanProfile: %w %q", errUnknownScanProfile, name)
+		}
+		cs.cfg.ScanProfiles[name] = slices.DeleteFunc(cs.cfg.ScanProfiles[name], func(r ScanRange) bool { return r == sr })
+		cs.mu.Unlock()
+		return cs.Save()
+	}
 	// Initialize from defaults if empty
 	if len(cs.cfg.ScanRanges) == 0 {
 		cs.cfg.ScanRanges = make([]ScanRange, len(DefaultScanRanges))
@@ -159,7 +842,7 @@ func (cs *ConfigStore) RemoveScanRange(sr ScanRange) error {
 	}
 	filtered := cs.cfg.ScanRanges[:0]
 	for _, existing := range cs.cfg.ScanRanges {
-		if existing.Start != sr.Start || existing.End != sr.End {
+		if existing != sr {
 			filtered = append(filtered, existing)
 		}
 	}
@@ -168,11 +851,131 @@ func (cs *ConfigStore) RemoveScanRange(sr ScanRange) error {
 	return cs.Save()
 }
 
-// ManualPorts returns a copy of the manual ports.
-func (cs *ConfigStore) ManualPorts() []ManualPort {
+// errUnknownScanProfile is returned when a named scan profile doesn't exist.
+var errUnknownScanProfile = errors.New("unknown scan profile")
+
+// ScanProfiles returns the named scan range sets and the active one's name,
+// empty when the plain scan ranges are in use.
+func (cs *ConfigStore) ScanProfiles() (profiles map[string][]ScanRange, active string) {
 	cs.mu.RLock()
 	defer cs.mu.RUnlock()
-	out := make([]ManualPort, len(cs.cfg.ManualPorts))
+	profiles = make(map[string][]ScanRange, len(cs.cfg.ScanProfiles))
+	for name, ranges := range cs.cfg.ScanProfiles {
+		profiles[name] = slices.Clone(ranges)
+	}
+	return profiles, cs.cfg.ActiveScanProfile
+}
+
+// SetScanProfile creates or replaces a named scan profile and persists.
+func (cs *ConfigStore) SetScanProfile(name string, ranges []ScanRange) error {
+	name = normalizeGroup(name)
+	if err := validateScanProfile(name, ranges); err != nil {
+		return err
+	}
+	cs.mu.Lock()
+	if cs.cfg.ScanProfiles == nil {
+		cs.cfg.ScanProfiles = make(map[string][]ScanRange)
+	}
+	cs.cfg.ScanProfiles[name] = slices.Clone(ranges)
+	cs.mu.Unlock()
+	return cs.Save()
+}
+
+// RemoveScanProfile deletes a scan profile and persists. Removing the active
+// profile switches back to the plain scan ranges.
+func (cs *ConfigStore) RemoveScanProfile(name string) error {
+	name = normalizeGroup(name)
+	cs.mu.Lock()
+	if _, ok := cs.cfg.ScanProfiles[name]; !ok {
+		cs.mu.Unlock()
+		return fmt.Errorf("%w %q", errUnknownScanProfile, name)
+	}
+	delete(cs.cfg.ScanProfiles, name)
+	if cs.cfg.ActiveScanProfile == name {
+		cs.cfg.ActiveScanProfile = ""
+	}
+	cs.mu.Unlock()
+	return cs.Save()
+}
+
+// UseScanProfile makes the named profile's ranges the ones scanned and
+// persists. An empty name switches back to the plain scan ranges.
+func (cs *ConfigStore) UseScanProfile(name string) error {
+	name = normalizeGroup(name)
+	cs.mu.Lock()
+	if _, ok := cs.cfg.ScanProfiles[name]; !ok && name != "" {
+		cs.mu.Unlock()
+		return fmt.Errorf("%w %q", errUnknownScanProfile, name)
+	}
+	cs.cfg.ActiveScanProfile = name
+	cs.mu.Unlock()
+	return cs.Save()
+}
+
+// Scan range protocols. TCP is stored as "" so existing ranges compare equal.
+const (
+	scanProtoTCP  = "tcp"
+	scanProtoUDP  = "udp"
+	scanProtoBoth = "both"
+)
+
+// normalizeScanProtocol validates a scan range protocol and returns its
+// stored form.
+func normalizeScanProtocol(p string) (string, error) {
+	switch p = strings.ToLower(strings.TrimSpace(p)); p {
+	case "", scanProtoTCP:
+		return "", nil
+	case scanProtoUDP, scanProtoBoth:
+		return p, nil
+	}
+	return "", fmt.Errorf("scan range protocol %q must be %s, %s or %s", p, scanProtoTCP, scanProtoUDP, scanProtoBoth)
+}
+
+// validateScanRange checks a stored scan range.
+func validateScanRange(r ScanRange) error {
+	if r.Start < 1 || r.End > 65535 || r.Start > r.End {
+		return fmt.Errorf("invalid range %d-%d", r.Start, r.End)
+	}
+	if _, err := normalizeScanProtocol(r.Protocol); err != nil {
+		return fmt.Errorf("range %d-%d: %w", r.Start, r.End, err)
+	}
+	return nil
+}
+
+// scansTCP and scansUDP report which protocols a range covers.
+func (r ScanRange) scansTCP() bool { return r.Protocol != scanProtoUDP }
+func (r ScanRange) scansUDP() bool { return r.Protocol == scanProtoUDP || r.Protocol == scanProtoBoth }
+
+// String formats the range as the CLI accepts it, e.g. "5000-5010/udp".
+func (r ScanRange) String() string {
+	s := fmt.Sprintf("%d-%d", r.Start, r.End)
+	if r.Protocol != "" {
+		s += "/" + r.Protocol
+	}
+	return s
+}
+
+// validateScanProfile checks a profile's name (lowercase letters, digits,
+// '-' and '_') and its ranges.
+func validateScanProfile(name string, ranges []ScanRange) error {
+	if name == "" || strings.TrimFunc(name, func(r rune) bool {
+		return r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_'
+	}) != "" {
+		return fmt.Errorf("scan profile name %q must be lowercase letters, digits, '-' or '_'", name)
+	}
+	for _, r := range ranges {
+		if err := validateScanRange(r); err != nil {
+			return fmt.Errorf("scan profile %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ManualPorts returns a copy of the manual ports.
+func (cs *ConfigStore) ManualPorts() []ManualPort {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	out := make([]ManualPort, len(cs.cfg.ManualPorts))
 	copy(out, cs.cfg.ManualPorts)
 	return out
 }
@@ -222,20 +1025,662 @@ func (cs *ConfigStore) EnsureDefaultMapping(dashPort int) error {
 func (cs *ConfigStore) DomainSuffix() string {
 	cs.mu.RLock()
 	defer cs.mu.RUnlock()
-	if cs.cfg.DomainSuffix == "" {
-		return "localhost"
+	if suffix := normalizeDomainSuffix(cs.cfg.DomainSuffix); suffix != "" {
+		return suffix
 	}
-	return cs.cfg.DomainSuffix
+	return "localhost"
 }
 
-// SetDomainSuffix updates the domain suffix and persists.
+// reservedDomains are subdomains the proxy always routes to the dashboard.
+var reservedDomains = map[string]bool{"portgate": true}
+
+// isReservedDomain reports whether name is a reserved subdomain.
+func isReservedDomain(name string) bool {
+	return reservedDomains[strings.ToLower(name)]
+}
+
+// normalizeDomainSuffix lowercases the suffix and strips surrounding dots.
+func normalizeDomainSuffix(suffix string) string {
+	return strings.Trim(strings.ToLower(strings.TrimSpace(suffix)), ".")
+}
+
+// validateDomainSuffix checks that a normalized suffix is a plain hostname
+// that doesn't collide with a reserved subdomain. A suffix of "portgate"
+// would make "portgate.portgate" ambiguous between the dashboard and a
+// mapping, so it's rejected outright.
+func validateDomainSuffix(suffix string) error {
+	if suffix == "" {
+		return fmt.Errorf("domain suffix cannot be empty")
+	}
+	if !validHostname(suffix) {
+		return fmt.Errorf("invalid domain suffix %q", suffix)
+	}
+	if first, _, _ := strings.Cut(suffix, "."); isReservedDomain(first) {
+		return fmt.Errorf("domain suffix %q collides with the reserved %q subdomain", suffix, first)
+	}
+	return nil
+}
+
+// validHostname reports whether name is a lowercase dotted hostname made of
+// 1-63 character labels of letters, digits and hyphens.
+func validHostname(name string) bool {
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ProxyBackend returns the host and dial network used to reach mapping
+// backends. An empty proxyBackendHost means loopback (::1 when dialing tcp6).
+// An error is returned if the host or network is invalid or they disagree on
+// the address family.
+func (cs *ConfigStore) ProxyBackend() (host, network string, err error) {
+	cs.mu.RLock()
+	host, network = cs.cfg.ProxyBackendHost, cs.cfg.ProxyDialNetwork
+	cs.mu.RUnlock()
+
+	switch network {
+	case "":
+		network = "tcp"
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return "", "", fmt.Errorf("proxyDialNetwork %q must be tcp, tcp4 or tcp6", network)
+	}
+	if host == "" {
+		if network == "tcp6" {
+			return "::1", network, nil
+		}
+		return "127.0.0.1", network, nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if network == "tcp4" && ip.To4() == nil || network == "tcp6" && ip.To4() != nil {
+			return "", "", fmt.Errorf("proxyBackendHost %s can't be dialed over %s", host, network)
+		}
+		return host, network, nil
+	}
+	if !validHostname(strings.ToLower(host)) {
+		return "", "", fmt.Errorf("proxyBackendHost %q is not an IP address or hostname", host)
+	}
+	return host, network, nil
+}
+
+// BackendAddr returns the host:port the proxy dials for m: its own target
+// host if set, otherwise the configured backend host, falling back to
+// loopback if that is invalid.
+func (cs *ConfigStore) BackendAddr(m DomainMapping) string {
+	if m.TargetHost != "" {
+		return net.JoinHostPort(m.TargetHost, strconv.Itoa(m.TargetPort))
+	}
+	host, _, err := cs.ProxyBackend()
+	if err != nil {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(m.TargetPort))
+}
+
+// SetDomainSuffix normalizes and validates the domain suffix, then persists.
 func (cs *ConfigStore) SetDomainSuffix(suffix string) error {
+	suffix = normalizeDomainSuffix(suffix)
+	if err := validateDomainSuffix(suffix); err != nil {
+		return err
+	}
 	cs.mu.Lock()
 	cs.cfg.DomainSuffix = suffix
 	cs.mu.Unlock()
 	return cs.Save()
 }
 
+// ExcludeProcesses returns the process name globs excluded from discovery.
+func (cs *ConfigStore) ExcludeProcesses() []string {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	out := make([]string, len(cs.cfg.ExcludeProcesses))
+	copy(out, cs.cfg.ExcludeProcesses)
+	return out
+}
+
+// SetExcludeProcesses validates and stores the process exclusion globs and persists.
+func (cs *ConfigStore) SetExcludeProcesses(patterns []string) error {
+	var clean []string
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "" {
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+		clean = append(clean, p)
+	}
+	cs.mu.Lock()
+	if len(clean) > 0 && cs.cfg.ResolveExe != nil && !*cs.cfg.ResolveExe {
+		cs.mu.Unlock()
+		return errExcludeWithoutResolve
+	}
+	cs.cfg.ExcludeProcesses = clean
+	cs.mu.Unlock()
+	return cs.Save()
+}
+
+// ResolveExe returns whether the scanner should resolve the process owning
+// each discovered port. Defaults to true.
+func (cs *ConfigStore) ResolveExe() bool {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.cfg.ResolveExe == nil || *cs.cfg.ResolveExe
+}
+
+// ScanningEnabled reports whether the scan ranges are scanned. When it is
+// off only manual ports are health-checked.
+func (cs *ConfigStore) ScanningEnabled() bool {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.cfg.ScanningEnabled == nil || *cs.cfg.ScanningEnabled
+}
+
+// SetScanningEnabled turns range scanning on or off and persists.
+func (cs *ConfigStore) SetScanningEnabled(enabled bool) error {
+	cs.mu.Lock()
+	if enabled {
+		cs.cfg.ScanningEnabled = nil
+	} else {
+		cs.cfg.ScanningEnabled = &enabled
+	}
+	cs.mu.Unlock()
+	return cs.Save()
+}
+
+// Scan modes: dial connects to every port in the ranges; kernel reads the
+// listening sockets from the OS and only probes those.
+const (
+	scanModeDial   = "dial"
+	scanModeKernel = "kernel"
+)
+
+// ScanMode returns how the scanner finds open ports, defaulting to dial.
+func (cs *ConfigStore) ScanMode() (string, error) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	switch cs.cfg.ScanMode {
+	case "", scanModeDial:
+		return scanModeDial, nil
+	case scanModeKernel:
+		return scanModeKernel, nil
+	}
+	return scanModeDial, fmt.Errorf("scanMode %q must be %s or %s", cs.cfg.ScanMode, scanModeDial, scanModeKernel)
+}
+
+// IdentifyProxy reports whether proxied traffic should name portgate in Via
+// and Server headers.
+func (cs *ConfigStore) IdentifyProxy() bool {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.cfg.IdentifyProxy
+}
+
+// ErrorPageTemplate returns the path of the custom proxy error page template,
+// or "" to use the embedded one.
+func (cs *ConfigStore) ErrorPageTemplate() string {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.cfg.ErrorPageTemplate
+}
+
+// NotFoundPageTemplate returns the path of the custom unknown-domain page
+// template, or "" to use the embedded one.
+func (cs *ConfigStore) NotFoundPageTemplate() string {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.cfg.NotFoundPageTemplate
+}
+
+// BasePath returns the path prefix the dashboard is reached under through an
+// upstream proxy, such as "/portgate", or "" when it is served at the root.
+func (cs *ConfigStore) BasePath() (string, error) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	p, err := cleanBasePath(cs.cfg.BasePath)
+	if err != nil {
+		return "", fmt.Errorf("basePath: %w", err)
+	}
+	return p, nil
+}
+
+// cleanBasePath checks a URL path prefix such as "/portgate" and returns it
+// without a trailing slash. "" and "/" mean no prefix.
+func cleanBasePath(p string) (string, error) {
+	p = strings.TrimSuffix(p, "/")
+	if p == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(p, "/") || path.Clean(p) != p || strings.ContainsAny(p, "?#\"'<> \\") {
+		return "", fmt.Errorf("%q must be an absolute path like /portgate", p)
+	}
+	return p, nil
+}
+
+// defaultPollInterval is how often the dashboard polls when it can't open a
+// WebSocket.
+const defaultPollInterval = 5 * time.Second
+
+// PollInterval returns the dashboard's polling fallback interval.
+func (cs *ConfigStore) PollInterval() time.Duration {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	if cs.cfg.PollIntervalSec > 0 {
+		return time.Duration(cs.cfg.PollIntervalSec) * time.Second
+	}
+	return defaultPollInterval
+}
+
+// Compression reports whether proxied responses are gzipped.
+func (cs *ConfigStore) Compression() bool {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.cfg.Compression
+}
+
+// CompressionExcludeTypes returns the content-type globs that are never
+// compressed.
+func (cs *ConfigStore) CompressionExcludeTypes() []string {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	if len(cs.cfg.CompressionExcludeTypes) == 0 {
+		return defaultCompressionExcludeTypes
+	}
+	return slices.Clone(cs.cfg.CompressionExcludeTypes)
+}
+
+// defaultHealthInterval is how often known ports are re-checked between full
+// scans.
+const defaultHealthInterval = 3 * time.Second
+
+// HealthInterval returns how often the known ports are re-checked between
+// full scans.
+func (cs *ConfigStore) HealthInterval() time.Duration {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	if cs.cfg.HealthIntervalSec > 0 {
+		return time.Duration(cs.cfg.HealthIntervalSec) * time.Second
+	}
+	return defaultHealthInterval
+}
+
+// defaultMaxTitleLength caps probed service titles, in characters.
+const defaultMaxTitleLength = 120
+
+// MaxTitleLength returns how many characters of a probed title are kept.
+func (cs *ConfigStore) MaxTitleLength() int {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	if cs.cfg.MaxTitleLength > 0 {
+		return cs.cfg.MaxTitleLength
+	}
+	return defaultMaxTitleLength
+}
+
+// Trailing-slash normalization directions for path-routed mount points.
+const (
+	trailingSlashAdd    = "add"
+	trailingSlashRemove = "remove"
+)
+
+// TrailingSlashMode returns the trailing-slash normalization direction, or ""
+// when normalization is off.
+func (cs *ConfigStore) TrailingSlashMode() (string, error) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	switch cs.cfg.NormalizeTrailingSlash {
+	case "", "off":
+		return "", nil
+	case trailingSlashAdd, trailingSlashRemove:
+		return cs.cfg.NormalizeTrailingSlash, nil
+	}
+	return "", fmt.Errorf("normalizeTrailingSlash %q must be %s, %s or off", cs.cfg.NormalizeTrailingSlash, trailingSlashAdd, trailingSlashRemove)
+}
+
+// HealthyStatusCodes returns the probe statuses a port must answer with to
+// count as healthy, or nil when any open port is healthy.
+func (cs *ConfigStore) HealthyStatusCodes() (statusCodeSet, error) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	set, err := parseStatusCodes(cs.cfg.HealthyStatusCodes)
+	if err != nil {
+		return nil, fmt.Errorf("healthyStatusCodes: %w", err)
+	}
+	return set, nil
+}
+
+// Probe defaults: fetch the root page and ask for HTML, which is where a
+// title is most likely to be found.
+var defaultProbePaths = []string{"/"}
+
+const defaultProbeAccept = "text/html"
+
+// ProbeDefaults returns the paths tried in order when probing a service and
+// the Accept header sent with each request.
+func (cs *ConfigStore) ProbeDefaults() (paths []string, accept string) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	paths, accept = defaultProbePaths, defaultProbeAccept
+	if len(cs.cfg.ProbePaths) > 0 {
+		paths = slices.Clone(cs.cfg.ProbePaths)
+	}
+	if cs.cfg.ProbeAccept != "" {
+		accept = cs.cfg.ProbeAccept
+	}
+	return paths, accept
+}
+
+// validateProbePaths checks that every probe path is an absolute URL path.
+func validateProbePaths(paths []string) error {
+	for _, p := range paths {
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("probe path %q must start with /", p)
+		}
+	}
+	return nil
+}
+
+// validateHealthPath checks a manual port's health check path. Empty means
+// no health check.
+func validateHealthPath(p string) error {
+	if p != "" && !strings.HasPrefix(p, "/") {
+		return fmt.Errorf("health path %q must start with /", p)
+	}
+	return nil
+}
+
+// defaultAccessLogSlow is the duration past which sampled-out requests are
+// logged anyway.
+const defaultAccessLogSlow = time.Second
+
+// AccessLogSampling returns the access log sample rate and slow-request
+// threshold.
+func (cs *ConfigStore) AccessLogSampling() (rate int, slow time.Duration) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	slow = defaultAccessLogSlow
+	if cs.cfg.AccessLogSlowMs > 0 {
+		slow = time.Duration(cs.cfg.AccessLogSlowMs) * time.Millisecond
+	}
+	return cs.cfg.AccessLogSampleRate, slow
+}
+
+// UpdateSource returns the configured release repo and API base; empty
+// values mean the defaults.
+func (cs *ConfigStore) UpdateSource() (repo, apiBase string) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.cfg.UpdateRepo, cs.cfg.UpdateAPIBase
+}
+
+// validateScanInterval rejects intervals short enough to make the scanner
+// spin.
+func validateScanInterval(d time.Duration) error {
+	if d < time.Second {
+		return fmt.Errorf("scan interval must be at least 1s, got %s", d)
+	}
+	return nil
+}
+
+// ScanInterval returns the time between scan cycles: the --scan-interval
+// override if one is set, else scanIntervalSec.
+func (cs *ConfigStore) ScanInterval() time.Duration {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	if cs.scanInterval > 0 {
+		return cs.scanInterval
+	}
+	if cs.cfg.ScanIntervalSec <= 0 {
+		return defaultScanIntervalSec * time.Second
+	}
+	return time.Duration(cs.cfg.ScanIntervalSec) * time.Second
+}
+
+// ForceScanInterval overrides scanIntervalSec for the lifetime of this
+// process without persisting it.
+func (cs *ConfigStore) ForceScanInterval(d time.Duration) error {
+	if err := validateScanInterval(d); err != nil {
+		return err
+	}
+	cs.mu.Lock()
+	cs.scanInterval = d
+	cs.mu.Unlock()
+	return nil
+}
+
+// SetScanInterval saves a new scanIntervalSec. It replaces any
+// --scan-interval override, since it is the more recent choice.
+func (cs *ConfigStore) SetScanInterval(sec int) error {
+	if err := validateScanInterval(time.Duration(sec) * time.Second); err != nil {
+		return err
+	}
+	cs.mu.Lock()
+	cs.cfg.ScanIntervalSec = sec
+	cs.scanInterval = 0
+	cs.mu.Unlock()
+	return cs.Save()
+}
+
+// PortGracePeriod returns how long a port that a scan no longer finds stays
+// listed as stale. Zero drops it straight away.
+func (cs *ConfigStore) PortGracePeriod() time.Duration {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return time.Duration(max(cs.cfg.PortGraceSec, 0)) * time.Second
+}
+
+// Scan concurrency bounds. The upper one keeps a scan well inside the usual
+// open file limit.
+const (
+	defaultScanConcurrency = 128
+	maxScanConcurrency     = 1024
+)
+
+// ScanConcurrency returns how many ports the scanner checks at once.
+func (cs *ConfigStore) ScanConcurrency() int {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	if cs.cfg.ScanConcurrency <= 0 {
+		return defaultScanConcurrency
+	}
+	return min(cs.cfg.ScanConcurrency, maxScanConcurrency)
+}
+
+// Jitter bounds. Scan jitter is also kept below the scan interval.
+const (
+	maxScanJitter            = time.Minute
+	defaultUpdateCheckJitter = 30 * time.Second
+	maxUpdateCheckJitter     = 10 * time.Minute
+)
+
+// ScanJitter returns the most the scanner's start may be randomly delayed,
+// so instances started together don't scan in lockstep.
+func (cs *ConfigStore) ScanJitter() time.Duration {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return min(time.Duration(max(cs.cfg.ScanJitterMs, 0))*time.Millisecond, maxScanJitter)
+}
+
+// UpdateCheckJitter returns the most the startup update check may be
+// randomly delayed, so a fleet started together doesn't hit GitHub at once.
+func (cs *ConfigStore) UpdateCheckJitter() time.Duration {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	if cs.cfg.UpdateCheckJitterSec == nil {
+		return defaultUpdateCheckJitter
+	}
+	return min(time.Duration(max(*cs.cfg.UpdateCheckJitterSec, 0))*time.Second, maxUpdateCheckJitter)
+}
+
+// randomDelay returns a random duration in [0, limit), or 0 if limit is not
+// positive. The generator is seeded per process.
+func randomDelay(limit time.Duration) time.Duration {
+	if limit <= 0 {
+		return 0
+	}
+	return rand.N(limit)
+}
+
+// DeferInitialScan reports whether the scanner should wait one interval
+// before its first scan instead of scanning at startup.
+func (cs *ConfigStore) DeferInitialScan() bool {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.cfg.DeferInitialScan
+}
+
+// ExternalAccess reports whether access from other machines is enabled.
+func (cs *ConfigStore) ExternalAccess() bool {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.cfg.ExternalAccess
+}
+
+// ReadOnly returns whether mutating API requests are rejected.
+func (cs *ConfigStore) ReadOnly() bool {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.cfg.ReadOnly || cs.forceReadOnly
+}
+
+// ForceReadOnly enables read-only mode for the lifetime of this process
+// without persisting it, so the setting can't lock itself in.
+func (cs *ConfigStore) ForceReadOnly() {
+	cs.mu.Lock()
+	cs.forceReadOnly = true
+	cs.mu.Unlock()
+}
+
+// AllowAllOrigins turns off the WebSocket origin check for the lifetime of
+// this process, restoring the old accept-anything behavior.
+func (cs *ConfigStore) AllowAllOrigins() {
+	cs.mu.Lock()
+	cs.allOrigins = true
+	cs.mu.Unlock()
+}
+
+// AllowedOrigins returns the configured extra WebSocket origins, and whether
+// every origin is allowed.
+func (cs *ConfigStore) AllowedOrigins() ([]string, bool) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return slices.Clone(cs.cfg.AllowedOrigins), cs.allOrigins
+}
+
+// validateAllowedOrigins checks allowedOrigins entries: a full origin such
+// as https://dash.example.com, or a hostname, optionally "*." for any
+// subdomain.
+func validateAllowedOrigins(entries []string) error {
+	for _, e := range entries {
+		if strings.Contains(e, "://") {
+			if u, err := url.Parse(e); err != nil || u.Host == "" || u.Path != "" && u.Path != "/" {
+				return fmt.Errorf("allowedOrigins: %q is not an origin like https://host[:port]", e)
+			}
+			continue
+		}
+		if host := strings.TrimPrefix(e, "*."); host == "" || strings.ContainsAny(host, "*/:") {
+			return fmt.Errorf("allowedOrigins: %q is not a hostname", e)
+		}
+	}
+	return nil
+}
+
+// TrustedProxyNets parses the trustedProxies CIDRs. A bare IP is treated as
+// a single-host network.
+func (cs *ConfigStore) TrustedProxyNets() ([]*net.IPNet, error) {
+	cs.mu.RLock()
+	entries := make([]string, len(cs.cfg.TrustedProxies))
+	copy(entries, cs.cfg.TrustedProxies)
+	cs.mu.RUnlock()
+
+	var nets []*net.IPNet
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		if !strings.Contains(e, "/") {
+			ip := net.ParseIP(e)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", e)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(e)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", e, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// BackendLimits returns the response header size cap and header timeout for
+// backend requests, with defaults for unset or non-positive values.
+func (cs *ConfigStore) BackendLimits() (maxHeaderBytes int64, headerTimeout time.Duration) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	maxHeaderBytes, headerTimeout = defaultBackendMaxHeaderBytes, defaultBackendHeaderTimeout
+	if cs.cfg.BackendMaxHeaderBytes > 0 {
+		maxHeaderBytes = int64(cs.cfg.BackendMaxHeaderBytes)
+	}
+	if cs.cfg.BackendHeaderTimeoutSec > 0 {
+		headerTimeout = time.Duration(cs.cfg.BackendHeaderTimeoutSec) * time.Second
+	}
+	return maxHeaderBytes, headerTimeout
+}
+
+// maxProxyRetries bounds proxyRetries so a typo can't hold requests for
+// minutes.
+const maxProxyRetries = 20
+
+// BackendDialPolicy returns how long one backend connection attempt may take
+// and how many more attempts follow a refused connection, with defaults for
+// unset or out-of-range values.
+func (cs *ConfigStore) BackendDialPolicy() (timeout time.Duration, retries int) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	timeout = defaultBackendDialTimeout
+	if cs.cfg.ProxyDialTimeoutMs > 0 {
+		timeout = time.Duration(cs.cfg.ProxyDialTimeoutMs) * time.Millisecond
+	}
+	return timeout, min(max(cs.cfg.ProxyRetries, 0), maxProxyRetries)
+}
+
+// defaultDashboardMaxHeaderBytes caps the request headers the dashboard
+// reads. Its requests carry a session cookie and little else.
+const defaultDashboardMaxHeaderBytes = 64 << 10
+
+// DashboardMaxHeaderBytes returns the request header size cap for the
+// dashboard server.
+func (cs *ConfigStore) DashboardMaxHeaderBytes() int {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	if cs.cfg.DashboardMaxHeaderBytes > 0 {
+		return cs.cfg.DashboardMaxHeaderBytes
+	}
+	return defaultDashboardMaxHeaderBytes
+}
+
+// MaxConnsPerIP returns the concurrent proxied connection limit per client IP,
+// or 0 for no limit.
+func (cs *ConfigStore) MaxConnsPerIP() int {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.cfg.MaxConnsPerIP
+}
+
 // RemoveManualPort removes a manual port and persists.
 func (cs *ConfigStore) RemoveManualPort(port int) error {
 	cs.mu.Lock()
@@ -250,6 +1695,41 @@ func (cs *ConfigStore) RemoveManualPort(port int) error {
 	return cs.Save()
 }
 
+// MappingsToPort returns the domains of the user mappings routed to the local
+// port, which is what ties them to a manual port registered there.
+func (cs *ConfigStore) MappingsToPort(port int) []string {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	var domains []string
+	for _, m := range cs.cfg.Mappings {
+		if m.TargetPort == port && m.TargetHost == "" && !m.System {
+			domains = append(domains, m.Domain)
+		}
+	}
+	return domains
+}
+
+// RemoveMappingsToPort removes the user mappings routed to the local port,
+// persists, and returns their domains.
+func (cs *ConfigStore) RemoveMappingsToPort(port int) ([]string, error) {
+	cs.mu.Lock()
+	var removed []string
+	filtered := cs.cfg.Mappings[:0]
+	for _, m := range cs.cfg.Mappings {
+		if m.TargetPort == port && m.TargetHost == "" && !m.System {
+			removed = append(removed, m.Domain)
+			continue
+		}
+		filtered = append(filtered, m)
+	}
+	cs.cfg.Mappings = filtered
+	cs.mu.Unlock()
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	return removed, cs.Save()
+}
+
 // MasterPasswordHash returns the stored bcrypt hash, or "" if not set.
 func (cs *ConfigStore) MasterPasswordHash() string {
 	cs.mu.RLock()
@@ -282,7 +1762,21 @@ func (cs *ConfigStore) BypassAuthForLocalhost() bool {
 	return cs.cfg.BypassAuthForLocalhost
 }
 
+// APIToken returns the token every dashboard request must carry, or "" if
+// token auth is off.
+func (cs *ConfigStore) APIToken() string {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.cfg.APIToken
+}
+
 // AuthEnabled returns true if a master password is configured.
 func (cs *ConfigStore) AuthEnabled() bool {
 	return cs.MasterPasswordHash() != ""
 }
+
+// DashboardProtected reports whether remote dashboard requests must
+// authenticate, with the master password or the API token.
+func (cs *ConfigStore) DashboardProtected() bool {
+	return cs.AuthEnabled() || cs.APIToken() != ""
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigMigration(t *testing.T) {
+	fixture, err := os.ReadFile(filepath.Join("testdata", "config-v0.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, fixture, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cs, err := NewConfigStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.cfg.ScanIntervalSec != defaultScanIntervalSec {
+		t.Errorf("ScanIntervalSec = %d, want %d", cs.cfg.ScanIntervalSec, defaultScanIntervalSec)
+	}
+	if cs.cfg.ConfigVersion != currentConfigVersion {
+		t.Errorf("ConfigVersion = %d, want %d", cs.cfg.ConfigVersion, currentConfigVersion)
+	}
+	if ms := cs.Mappings(); len(ms) != 1 || ms[0].Domain != "myapp" || ms[0].TargetPort != 3000 {
+		t.Errorf("mappings not preserved: %+v", ms)
+	}
+
+	// The upgrade is written back so the next load has nothing to do
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved Config
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.ConfigVersion != currentConfigVersion || saved.ScanIntervalSec != defaultScanIntervalSec {
+		t.Errorf("saved config not migrated: version %d, scanIntervalSec %d", saved.ConfigVersion, saved.ScanIntervalSec)
+	}
+	if migrateConfig(&saved) {
+		t.Errorf("migrateConfig changed an up-to-date config")
+	}
+
+	// A config from a newer build is left alone
+	future := Config{ConfigVersion: currentConfigVersion + 1}
+	if migrateConfig(&future) || future.ScanIntervalSec != 0 {
+		t.Errorf("migrateConfig touched a newer config: %+v", future)
+	}
+}
+
+func TestProxyBackend(t *testing.T) {
+	tests := []struct {
+		host, network string
+		wantAddr      string
+		ok            bool
+	}{
+		{"", "", "127.0.0.1:3000", true},
+		{"", "tcp6", "[::1]:3000", true},
+		{"10.0.0.5", "tcp4", "10.0.0.5:3000", true},
+		{"fd00::5", "", "[fd00::5]:3000", true},
+		{"devbox.lan", "tcp", "devbox.lan:3000", true},
+		{"10.0.0.5", "tcp6", "", false},
+		{"::1", "tcp4", "", false},
+		{"", "udp", "", false},
+		{"bad host", "", "", false},
+		{"host:80", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host+"/"+tt.network, func(t *testing.T) {
+			cs := newTestConfigStore(t)
+			cs.cfg.ProxyBackendHost = tt.host
+			cs.cfg.ProxyDialNetwork = tt.network
+			_, _, err := cs.ProxyBackend()
+			if (err == nil) != tt.ok {
+				t.Fatalf("ProxyBackend() error = %v, want ok=%v", err, tt.ok)
+			}
+			if tt.ok {
+				if got := cs.BackendAddr(DomainMapping{TargetPort: 3000}); got != tt.wantAddr {
+					t.Errorf("BackendAddr = %q, want %q", got, tt.wantAddr)
+				}
+			}
+		})
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		target   string
+		host     string
+		port     int
+		ok       bool
+		wantAddr string // BackendAddr of the resulting mapping
+	}{
+		{"3000", "", 3000, true, "127.0.0.1:3000"},
+		{" 3000 ", "", 3000, true, "127.0.0.1:3000"},
+		{"[::1]:3000", "::1", 3000, true, "[::1]:3000"},
+		{"[0:0::1]:3000", "::1", 3000, true, "[::1]:3000"},
+		{"[fd00::5]:8080", "fd00::5", 8080, true, "[fd00::5]:8080"},
+		{"10.0.0.5:3000", "10.0.0.5", 3000, true, "10.0.0.5:3000"},
+		{"DevBox.lan:3000", "devbox.lan", 3000, true, "devbox.lan:3000"},
+		{"localhost:3000", "localhost", 3000, true, "localhost:3000"},
+		{"::1:3000", "", 0, false, ""},
+		{"[::1]", "", 0, false, ""},
+		{"[::1]:0", "", 0, false, ""},
+		{"host:70000", "", 0, false, ""},
+		{"bad host:3000", "", 0, false, ""},
+		{"0", "", 0, false, ""},
+		{"app", "", 0, false, ""},
+	}
+	cs := newTestConfigStore(t)
+	for _, tt := range tests {
+		host, port, err := parseTarget(tt.target)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseTarget(%q) error = %v, want ok=%v", tt.target, err, tt.ok)
+			continue
+		}
+		if host != tt.host || port != tt.port {
+			t.Errorf("parseTarget(%q) = %q, %d, want %q, %d", tt.target, host, port, tt.host, tt.port)
+		}
+		if tt.ok {
+			if got := cs.BackendAddr(DomainMapping{TargetHost: host, TargetPort: port}); got != tt.wantAddr {
+				t.Errorf("%q: BackendAddr = %q, want %q", tt.target, got, tt.wantAddr)
+			}
+		}
+	}
+}
+
+func TestConfigReload(t *testing.T) {
+	cs := newTestConfigStore(t)
+	if err := cs.AddMapping(DomainMapping{Domain: "old", TargetPort: 3000}); err != nil {
+		t.Fatal(err)
+	}
+	write := func(cfg string) {
+		t.Helper()
+		if err := os.WriteFile(cs.Path(), []byte(cfg), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"configVersion": 1, "mappings": [{"domain": "new", "targetPort": 4000}], "scanIntervalSec": 10}`)
+	if err := cs.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if _, ok := cs.LookupMapping("new"); !ok {
+		t.Errorf("reloaded mapping missing: %+v", cs.Mappings())
+	}
+
+	// Invalid files are rejected and the current config stays
+	for _, bad := range []string{
+		`{"mappings": [`,
+		`{"domainSuffix": "portgate"}`,
+		`{"trustedProxies": ["not-an-ip"]}`,
+		`{"proxyDialNetwork": "udp"}`,
+		`{"activeScanProfile": "missing"}`,
+		`{"scanProfiles": {"Bad Name": []}}`,
+		`{"scanProfiles": {"web": [{"start": 5000, "end": 4000}]}}`,
+		`{"basePath": "portgate"}`,
+		`{"compressionExcludeTypes": ["image/["]}`,
+		`{"errorPageTemplate": "/nonexistent/error.html"}`,
+		`{"notFoundPageTemplate": "/nonexistent/notfound.html"}`,
+		`{"allowedOrigins": ["https://"]}`,
+		`{"allowedOrigins": ["evil.com/path"]}`,
+		`{"resolveExe": false, "excludeProcesses": ["node"]}`,
+	} {
+		write(bad)
+		if err := cs.Reload(); err == nil {
+			t.Errorf("Reload accepted %s", bad)
+		}
+		if _, ok := cs.LookupMapping("new"); !ok {
+			t.Errorf("failed reload of %s dropped the current config", bad)
+		}
+		// Loading at startup applies the same checks
+		if _, err := NewConfigStore(cs.Path()); err == nil {
+			t.Errorf("NewConfigStore accepted %s", bad)
+		}
+	}
+
+	// An invalid file still opens for a reset
+	loaded, err := NewConfigStore(cs.Path())
+	if !errors.Is(err, errInvalidConfig) || loaded == nil {
+		t.Fatalf("NewConfigStore(invalid) = %v, %v; want the store and errInvalidConfig", loaded, err)
+	}
+	if _, err := loaded.Reset(false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewConfigStore(cs.Path()); err != nil {
+		t.Errorf("config after reset: %v", err)
+	}
+}
+
+func TestScanProfiles(t *testing.T) {
+	cs := newTestConfigStore(t)
+	cs.cfg.ScanRanges = []ScanRange{{Start: 9000, End: 9099}}
+	plain := cs.ScanRanges()
+
+	if err := cs.SetScanProfile("Frontend", []ScanRange{{Start: 3000, End: 3999}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.SetScanProfile("backend", []ScanRange{{Start: 8000, End: 8999}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.SetScanProfile("bad name", nil); err == nil {
+		t.Error("SetScanProfile accepted an invalid name")
+	}
+	if err := cs.UseScanProfile("nope"); !errors.Is(err, errUnknownScanProfile) {
+		t.Errorf("UseScanProfile(unknown) = %v", err)
+	}
+	if got := cs.ScanRanges(); !reflect.DeepEqual(got, plain) {
+		t.Errorf("ranges with no profile = %v, want %v", got, plain)
+	}
+
+	if err := cs.UseScanProfile("frontend"); err != nil {
+		t.Fatal(err)
+	}
+	// Range edits go to the active profile
+	if err := cs.AddScanRange(ScanRange{Start: 5173, End: 5179}); err != nil {
+		t.Fatal(err)
+	}
+	want := []ScanRange{{Start: 3000, End: 3999}, {Start: 5173, End: 5179}}
+	if got := cs.ScanRanges(); !reflect.DeepEqual(got, want) {
+		t.Errorf("frontend ranges = %v, want %v", got, want)
+	}
+	if err := cs.RemoveScanRange(ScanRange{Start: 3000, End: 3999}); err != nil {
+		t.Fatal(err)
+	}
+	if got := cs.ScanRanges(); !reflect.DeepEqual(got, want[1:]) {
+		t.Errorf("after remove = %v, want %v", got, want[1:])
+	}
+	if !reflect.DeepEqual(cs.cfg.ScanRanges, plain) {
+		t.Errorf("plain ranges changed to %v", cs.cfg.ScanRanges)
+	}
+
+	// The active profile survives a restart
+	reloaded, err := NewConfigStore(cs.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, active := reloaded.ScanProfiles(); active != "frontend" {
+		t.Errorf("reloaded active profile = %q", active)
+	}
+
+	// Removing the active profile falls back to the plain ranges
+	if err := cs.RemoveScanProfile("frontend"); err != nil {
+		t.Fatal(err)
+	}
+	profiles, active := cs.ScanProfiles()
+	if active != "" || len(profiles) != 1 {
+		t.Errorf("after removing active: active %q, profiles %v", active, profiles)
+	}
+	if got := cs.ScanRanges(); !reflect.DeepEqual(got, plain) {
+		t.Errorf("ranges after removing active = %v, want %v", got, plain)
+	}
+
+	// An active profile that doesn't exist is refused rather than written
+	// into a missing map
+	dangling := newTestConfigStore(t)
+	dangling.cfg.ActiveScanProfile = "gone"
+	if err := dangling.AddScanRange(ScanRange{Start: 1, End: 2}); !errors.Is(err, errUnknownScanProfile) {
+		t.Errorf("AddScanRange with a dangling profile = %v", err)
+	}
+	if err := dangling.RemoveScanRange(ScanRange{Start: 1, End: 2}); !errors.Is(err, errUnknownScanProfile) {
+		t.Errorf("RemoveScanRange with a dangling profile = %v", err)
+	}
+}
+
+func TestConfigReset(t *testing.T) {
+	for _, keep := range []bool{false, true} {
+		cs := newTestConfigStore(t)
+		cs.cfg.ScanIntervalSec = 30
+		cs.cfg.MasterPasswordHash = "hash"
+		cs.cfg.Mappings = []DomainMapping{
+			{Domain: "portgate", TargetPort: 8080, System: true},
+			{Domain: "app", TargetPort: 3000},
+		}
+		if err := cs.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		backup, err := cs.Reset(keep)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(backup)
+		if err != nil {
+			t.Fatalf("backup not written: %v", err)
+		}
+		var old Config
+		if err := json.Unmarshal(data, &old); err != nil || old.ScanIntervalSec != 30 {
+			t.Errorf("backup does not hold the previous config: %s", data)
+		}
+
+		reloaded, err := NewConfigStore(cs.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reloaded.cfg.ScanIntervalSec != defaultScanIntervalSec {
+			t.Errorf("ScanIntervalSec = %d, want default", reloaded.cfg.ScanIntervalSec)
+		}
+		if reloaded.MasterPasswordHash() != "hash" {
+			t.Errorf("reset dropped the master password")
+		}
+		want := 1
+		if keep {
+			want = 2
+		}
+		if ms := reloaded.Mappings(); len(ms) != want || ms[0].Domain != "portgate" {
+			t.Errorf("keepMappings=%v: mappings = %+v", keep, ms)
+		}
+	}
+}
+
+func TestCleanStripPrefix(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"", "", true},
+		{"/v1", "/v1", true},
+		{"/v1/", "/v1", true},
+		{" /api/v2 ", "/api/v2", true},
+		{"v1", "", false},
+		{"/", "", false},
+	}
+	for _, tt := range tests {
+		got, err := cleanStripPrefix(tt.in)
+		if (err == nil) != tt.ok || tt.ok && got != tt.want {
+			t.Errorf("cleanStripPrefix(%q) = %q, %v; want %q, ok=%v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestNormalizeScanProtocol(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"", "", true},
+		{"tcp", "", true},
+		{"UDP", "udp", true},
+		{" both ", "both", true},
+		{"sctp", "", false},
+	}
+	for _, tt := range tests {
+		got, err := normalizeScanProtocol(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("normalizeScanProtocol(%q) = %q, %v; want %q, ok=%v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+	if err := validateScanRange(ScanRange{Start: 1, End: 2, Protocol: "quic"}); err == nil {
+		t.Error("validateScanRange accepted an unknown protocol")
+	}
+	if got := (ScanRange{Start: 5000, End: 5010, Protocol: scanProtoUDP}).String(); got != "5000-5010/udp" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	cs := newTestConfigStore(t)
+	if got := cs.ScanJitter(); got != 0 {
+		t.Errorf("default ScanJitter = %v, want 0", got)
+	}
+	if got := cs.UpdateCheckJitter(); got != defaultUpdateCheckJitter {
+		t.Errorf("default UpdateCheckJitter = %v, want %v", got, defaultUpdateCheckJitter)
+	}
+	cs.cfg.ScanJitterMs = 500
+	zero, huge := 0, 1_000_000
+	cs.cfg.UpdateCheckJitterSec = &zero
+	if cs.ScanJitter() != 500*time.Millisecond || cs.UpdateCheckJitter() != 0 {
+		t.Errorf("ScanJitter %v, UpdateCheckJitter %v", cs.ScanJitter(), cs.UpdateCheckJitter())
+	}
+	cs.cfg.ScanJitterMs = huge
+	cs.cfg.UpdateCheckJitterSec = &huge
+	if cs.ScanJitter() != maxScanJitter || cs.UpdateCheckJitter() != maxUpdateCheckJitter {
+		t.Errorf("unbounded: ScanJitter %v, UpdateCheckJitter %v", cs.ScanJitter(), cs.UpdateCheckJitter())
+	}
+
+	if randomDelay(0) != 0 || randomDelay(-time.Second) != 0 {
+		t.Error("randomDelay with no limit should be 0")
+	}
+	for range 100 {
+		if d := randomDelay(time.Second); d < 0 || d >= time.Second {
+			t.Fatalf("randomDelay(1s) = %v", d)
+		}
+	}
+}
+
+func TestValidateWildcardMapping(t *testing.T) {
+	for domain, ok := range map[string]bool{
+		"*.app":        true,
+		"api-*":        true,
+		"pr-*.preview": true,
+		"*.*.app":      true,
+		"*":            false,
+		"*.*":          false,
+		"api-**":       false,
+		"*..app":       false,
+		"*.":           false,
+	} {
+		err := validateMapping(DomainMapping{Domain: domain, TargetPort: 3000})
+		if (err == nil) != ok {
+			t.Errorf("validateMapping(%q) = %v, want ok=%t", domain, err, ok)
+		}
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"api-*", "api-v2", true},
+		{"api-*", "api-", true},
+		{"api-*", "api", false},
+		{"*-api", "eu-api", true},
+		{"pr-*-*", "pr-12-fix", true},
+		{"pr-*-*", "pr-12", false},
+		{"*a*b", "xaxxb", true},
+		{"*a*b", "xaxxbc", false},
+		{"a*b*c", "abcbc", true},
+		{"*", "", true},
+		{"exact", "exact", true},
+		{"exact", "exac", false},
+		// Backtracking into earlier stars would take exponential time here
+		{strings.Repeat("a*", 20) + "b", strings.Repeat("a", 5000), false},
+	}
+	for _, tt := range tests {
+		if got := globMatch(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("globMatch(%q, %.20q) = %t, want %t", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
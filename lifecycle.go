@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Lifecycle collects the shutdown hooks of long-running components so
+// cmdStart can stop them in a defined order. Components register as they
+// are started; Shutdown runs the hooks in registration order.
+type Lifecycle struct {
+	mu    sync.Mutex
+	hooks []shutdownHook
+}
+
+type shutdownHook struct {
+	name string
+	fn   func(context.Context) error
+}
+
+// OnShutdown registers fn to run at shutdown under name, which is used in
+// log messages.
+func (lc *Lifecycle) OnShutdown(name string, fn func(context.Context) error) {
+	lc.mu.Lock()
+	lc.hooks = append(lc.hooks, shutdownHook{name: name, fn: fn})
+	lc.mu.Unlock()
+}
+
+// Shutdown runs every registered hook in order, each with ctx. A hook that
+// fails or is still running when ctx expires is logged and the next one is
+// started anyway, so one stuck component can't keep the rest running. The
+// returned error joins the failures.
+func (lc *Lifecycle) Shutdown(ctx context.Context) error {
+	lc.mu.Lock()
+	hooks := lc.hooks
+	lc.hooks = nil
+	lc.mu.Unlock()
+
+	var errs []error
+	for _, h := range hooks {
+		done := make(chan error, 1)
+		go func() { done <- h.fn(ctx) }()
+
+		var err error
+		select {
+		case err = <-done:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if err != nil {
+			log.Printf("shutdown: %s: %v", h.name, err)
+			errs = append(errs, fmt.Errorf("%s: %w", h.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
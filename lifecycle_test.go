@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLifecycleShutdown(t *testing.T) {
+	var lc Lifecycle
+	var order []string
+	record := func(name string, err error) func(context.Context) error {
+		return func(context.Context) error {
+			order = append(order, name)
+			return err
+		}
+	}
+	lc.OnShutdown("scanner", record("scanner", nil))
+	lc.OnShutdown("hub", record("hub", errors.New("boom")))
+	lc.OnShutdown("dashboard", record("dashboard", nil))
+
+	err := lc.Shutdown(context.Background())
+	if got := len(order); got != 3 || order[0] != "scanner" || order[1] != "hub" || order[2] != "dashboard" {
+		t.Errorf("hooks ran as %v, want [scanner hub dashboard]", order)
+	}
+	if err == nil || err.Error() != "hub: boom" {
+		t.Errorf("Shutdown error = %v, want hub: boom", err)
+	}
+
+	// Hooks run once
+	order = nil
+	if err := lc.Shutdown(context.Background()); err != nil || len(order) != 0 {
+		t.Errorf("second Shutdown: err %v, ran %v", err, order)
+	}
+}
+
+func TestLifecycleShutdownTimeout(t *testing.T) {
+	var lc Lifecycle
+	release := make(chan struct{})
+	defer close(release)
+	ranAfter := make(chan struct{})
+	lc.OnShutdown("stuck", func(context.Context) error {
+		<-release // ignores ctx
+		return nil
+	})
+	lc.OnShutdown("after", func(context.Context) error {
+		close(ranAfter)
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := lc.Shutdown(ctx)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Shutdown took %v despite the deadline", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown error = %v, want deadline exceeded", err)
+	}
+	select {
+	case <-ranAfter:
+	case <-time.After(time.Second):
+		t.Error("hook after the stuck one did not run")
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLogEvent(t *testing.T) {
+	defer setLogFormat(logFormatText)
+
+	// Text mode writes exactly what log.Printf would
+	var want, got bytes.Buffer
+	log.SetOutput(&want)
+	log.SetFlags(0)
+	log.Printf("proxy error for %s: %v", "app", "refused")
+	log.SetOutput(&got)
+	logEvent(levelError, logFields{"subdomain": "app"}, "proxy error for %s: %v", "app", "refused")
+	if got.String() != want.String() {
+		t.Errorf("text = %q, want %q", got.String(), want.String())
+	}
+
+	// JSON mode carries the fields, and wraps plain log lines too
+	var buf bytes.Buffer
+	jw := &jsonLogWriter{w: &buf}
+	jsonLog.Store(jw)
+	log.SetOutput(jw)
+	logEvent(levelError, logFields{"subdomain": "app", "port": 3000}, "proxy error for %s: %v", "app", "refused")
+	log.Printf("warning: could not register default mapping")
+	log.Println("Portgate started")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines: %s", len(lines), buf.String())
+	}
+	var entries []logEntry
+	for _, l := range lines {
+		var e logEntry
+		if err := json.Unmarshal([]byte(l), &e); err != nil {
+			t.Fatalf("%q: %v", l, err)
+		}
+		if e.TS.IsZero() {
+			t.Errorf("%q: no timestamp", l)
+		}
+		entries = append(entries, e)
+	}
+	if e := entries[0]; e.Level != levelError || e.Msg != "proxy error for app: refused" ||
+		e.Fields["subdomain"] != "app" || e.Fields["port"] != float64(3000) {
+		t.Errorf("event = %+v", e)
+	}
+	if e := entries[1]; e.Level != levelWarn || e.Msg != "warning: could not register default mapping" || e.Fields != nil {
+		t.Errorf("plain warning = %+v", e)
+	}
+	if e := entries[2]; e.Level != levelInfo || e.Msg != "Portgate started" {
+		t.Errorf("plain line = %+v", e)
+	}
+
+	if err := setLogFormat("xml"); err == nil {
+		t.Error("setLogFormat accepted an unknown format")
+	}
+}
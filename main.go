@@ -2,21 +2,35 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"cmp"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"maps"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 )
 
-var version = "dev"
+// Build metadata, injected via -ldflags "-X main.version=... -X main.commit=...".
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
 
 func main() {
 	if len(os.Args) < 2 {
@@ -24,15 +38,20 @@ func main() {
 		return
 	}
 
+	// Commands talking to a token-protected server send $PORTGATE_TOKEN
+	if token := os.Getenv(tokenEnvVar); token != "" {
+		http.DefaultTransport = &tokenTransport{token: token, next: http.DefaultTransport}
+	}
+
 	switch os.Args[1] {
 	case "start":
 		cmdStart()
 	case "add":
 		if len(os.Args) < 4 {
-			fmt.Fprintln(os.Stderr, "usage: portgate add <domain> <port>")
+			fmt.Fprintln(os.Stderr, "usage: portgate add <domain> <[host:]port> [--group NAME] [--mode both|http-only|ws-only] [--http2]")
 			os.Exit(1)
 		}
-		cmdAdd(os.Args[2], os.Args[3])
+		cmdAdd(os.Args[2], os.Args[3], os.Args[4:])
 	case "remove":
 		if len(os.Args) < 3 {
 			fmt.Fprintln(os.Stderr, "usage: portgate remove <domain>")
@@ -40,31 +59,82 @@ func main() {
 		}
 		cmdRemove(os.Args[2])
 	case "list":
-		cmdList()
+		cmdList(os.Args[2:])
+	case "maintenance":
+		if len(os.Args) < 4 || (os.Args[2] != "on" && os.Args[2] != "off") {
+			fmt.Fprintln(os.Stderr, "usage: portgate maintenance <on|off> <domain>")
+			os.Exit(1)
+		}
+		cmdMaintenance(os.Args[2] == "on", os.Args[3])
 	case "status":
-		cmdStatus()
+		cmdStatus(os.Args[2:])
+	case "test":
+		if len(os.Args) < 3 {
+			fmt.Fprintln(os.Stderr, "usage: portgate test <domain>")
+			os.Exit(1)
+		}
+		cmdTest(os.Args[2])
+	case "note":
+		if len(os.Args) < 3 {
+			fmt.Fprintln(os.Stderr, "usage: portgate note <port> [text]")
+			os.Exit(1)
+		}
+		cmdNote(os.Args[2], strings.Join(os.Args[3:], " "))
+	case "info":
+		if len(os.Args) < 3 {
+			fmt.Fprintln(os.Stderr, "usage: portgate info <port> [--json]")
+			os.Exit(1)
+		}
+		cmdInfo(os.Args[2], os.Args[3:])
+	case "scan":
+		cmdScan(os.Args[2:])
+	case "scan-now":
+		cmdScanNow()
 	case "scan-range":
 		if len(os.Args) < 3 {
 			fmt.Fprintln(os.Stderr, "usage: portgate scan-range <add|remove|list> [start-end]")
 			os.Exit(1)
 		}
 		cmdScanRange(os.Args[2:])
+	case "scan-profile":
+		if len(os.Args) < 3 {
+			fmt.Fprintln(os.Stderr, "usage: portgate scan-profile <list|use|clear|add|remove> [name] [start-end...]")
+			os.Exit(1)
+		}
+		cmdScanProfile(os.Args[2:])
+	case "snapshot":
+		cmdSnapshot(os.Args[2:])
 	case "add-port":
 		cmdAddPort(os.Args[2:])
 	case "remove-port":
 		if len(os.Args) < 3 {
-			fmt.Fprintln(os.Stderr, "usage: portgate remove-port <port>")
+			fmt.Fprintln(os.Stderr, "usage: portgate remove-port <port>[,<port>|<start-end>...] [--remove-mappings]")
+			os.Exit(1)
+		}
+		cmdRemovePort(os.Args[2], os.Args[3:])
+	case "export":
+		cmdExport(os.Args[2:])
+	case "import":
+		if len(os.Args) < 3 {
+			fmt.Fprintln(os.Stderr, "usage: portgate import <file> [--merge] [--config FILE]")
 			os.Exit(1)
 		}
-		cmdRemovePort(os.Args[2])
+		cmdImport(os.Args[2], os.Args[3:])
+	case "config":
+		cmdConfig(os.Args[2:])
+	case "reload":
+		cmdReload()
 	case "set-password":
 		cmdSetPassword()
 	case "version", "--version", "-v":
-		cmdVersion()
+		cmdVersion(os.Args[2:])
 	case "update":
-		cmdUpdate()
+		cmdUpdate(os.Args[2:])
 	case "help", "--help", "-h":
 		cmdHelp()
+	case "__list":
+		// Internal: plain lists for completion scripts, not shown in help
+		cmdInternalList(os.Args[2:])
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", os.Args[1])
 		cmdHelp()
@@ -78,17 +148,32 @@ func cmdHelp() {
 Usage: portgate <command> [options]
 
 Commands:
-  start [--domain-suffix HOST]  Start the proxy and dashboard server
-  add <domain> <port>          Map a subdomain to a port
+  start [--domain-suffix HOST] [--tls]  Start the proxy and dashboard server
+  add <domain> <[host:]port>   Map a subdomain to a port or host:port (--group NAME, --mode http-only|ws-only, --strip-prefix /PATH)
   remove <domain>              Remove a domain mapping
-  list                         List current domain mappings
-  status                       Show running status and discovered ports
-  add-port <port> [options]    Manually register a port
-  remove-port <port>           Remove a manually registered port
+  list [options]               List domain mappings (--group NAME, --sort domain|created|port)
+  maintenance <on|off> <domain> Toggle the maintenance page for a mapping
+  status [--summary]           Show running status and discovered ports, or just counts
+  test <domain>                Send a request through a mapping and report the result
+  note <port> [text]           Attach a note to a port; omit the text to remove it
+  info <port> [--json]         Show everything known about one port
+  add-port <ports> [options]   Manually register ports (e.g. 3000,3005-3010)
+  remove-port <ports>          Remove manually registered ports (--remove-mappings to drop their mappings too)
+  scan [--stream]              Scan once, print ports as JSON, and exit
+  scan <pause|resume>          Pause or resume range scanning on the running server
+  scan-now                     Make the running server scan now and print the ports it finds
   scan-range <add|remove|list> Manage port scan ranges
+  scan-profile <cmd> [name]    Switch between named scan range sets (list, use, clear, add, remove)
+  snapshot export <file>       Save config (secrets redacted), ports and build info for a bug report
+  snapshot import <file>       Merge a snapshot's mappings, ranges and manual ports (--dry-run, --yes)
+  export [file]                Write mappings, scan ranges, manual ports and domain suffix as JSON
+  import <file> [--merge]      Replace the config with an export, or add to it with --merge
+  config path [--config FILE]  Print the config file location
+  config reset [--keep-mappings] Back up the config and restore defaults
+  reload                       Make the running server re-read its config file
   set-password                 Set or update the master password for auth
-  update                       Check for and apply updates
-  version                      Show current version
+  update [--tag TAG [--force]] Check for and apply updates, or install a given release
+  version [--json]             Show current version
   help                         Show this help message
 `, version)
 }
@@ -96,11 +181,43 @@ Commands:
 func cmdStart() {
 	startFlags := flag.NewFlagSet("start", flag.ExitOnError)
 	dashPort := startFlags.Int("dashboard-port", 8080, "dashboard listen port")
-	proxyPort := startFlags.Int("proxy-port", 80, "reverse proxy listen port")
+	proxyPortList := startFlags.String("proxy-port", "80", "reverse proxy listen port, or a comma-separated list such as 80,8080")
 	domainSuffix := startFlags.String("domain-suffix", "", "domain suffix (default: localhost)")
+	excludeProcess := startFlags.String("exclude-process", "", "comma-separated process name globs to hide from discovery")
+	readOnly := startFlags.Bool("read-only", false, "reject mutating API requests (view-only dashboard)")
+	allOrigins := startFlags.Bool("unsafe-allow-all-origins", false, "let pages on any origin open the dashboard WebSocket")
+	accessLog := startFlags.String("access-log", "", "write proxy access log to this file (\"-\" for stdout)")
+	accessLogFormat := startFlags.String("access-log-format", "json", "access log format: json or combined")
+	configPath := startFlags.String("config", "", "config file path (default: $PORTGATE_CONFIG or the platform default)")
+	quiet := startFlags.Bool("quiet", false, "don't print the startup summary")
+	verbose := startFlags.Bool("verbose", false, "log every port the scanner finds, re-probes, marks healthy/unhealthy or drops")
+	useTLS := startFlags.Bool("tls", false, "serve the proxy over HTTPS with a self-signed certificate, redirecting the proxy ports to it")
+	tlsPort := startFlags.Int("tls-port", 443, "HTTPS listen port when --tls is set")
+	logFormat := startFlags.String("log-format", logFormatText, "log output format: text or json")
+	scanInterval := startFlags.Duration("scan-interval", 0, "time between scans, e.g. 30s (default: scanIntervalSec from the config)")
+	bind := startFlags.String("bind", "", "address to listen on, e.g. 127.0.0.1 (default: all interfaces)")
+	dashBind := startFlags.String("dashboard-bind", "", "address the dashboard listens on (default: --bind)")
+	proxyBind := startFlags.String("proxy-bind", "", "address the proxy listens on (default: --bind)")
 	startFlags.Parse(os.Args[2:])
 
-	cs, err := NewConfigStore("")
+	if err := setLogFormat(*logFormat); err != nil {
+		log.Fatalf("log-format: %v", err)
+	}
+
+	proxyPorts, err := parsePorts(*proxyPortList, false)
+	if err != nil {
+		log.Fatalf("proxy-port: %v (expected a port or a comma-separated list of ports)", err)
+	}
+	dashHost, err := parseBindHost(cmp.Or(*dashBind, *bind))
+	if err != nil {
+		log.Fatalf("dashboard-bind: %v", err)
+	}
+	proxyHost, err := parseBindHost(cmp.Or(*proxyBind, *bind))
+	if err != nil {
+		log.Fatalf("proxy-bind: %v", err)
+	}
+
+	cs, err := NewConfigStore(*configPath)
 	if err != nil {
 		log.Fatalf("config: %v", err)
 	}
@@ -112,22 +229,69 @@ func cmdStart() {
 		}
 	}
 
+	// Apply process exclusions from CLI flag if provided
+	if *excludeProcess != "" {
+		if err := cs.SetExcludeProcesses(strings.Split(*excludeProcess, ",")); err != nil {
+			log.Fatalf("exclude-process: %v", err)
+		}
+	}
+
+	if *readOnly {
+		cs.ForceReadOnly()
+	}
+	if *allOrigins {
+		log.Printf("warning: --unsafe-allow-all-origins: any web page can open the dashboard WebSocket")
+		cs.AllowAllOrigins()
+	}
+	startFlags.Visit(func(f *flag.Flag) {
+		if f.Name != "scan-interval" {
+			return
+		}
+		if err := cs.ForceScanInterval(*scanInterval); err != nil {
+			log.Fatalf("scan-interval: %v", err)
+		}
+	})
+
+	if err := applyRuntimeConfig(cs); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+
 	// Ensure portgate.localhost system mapping exists for the dashboard
 	if err := cs.EnsureDefaultMapping(*dashPort); err != nil {
 		log.Printf("warning: could not register default mapping: %v", err)
 	}
 
 	hub := NewHub(cs)
+	// Show the ports known at the last shutdown until the first scan
+	if err := hub.loadState(); err != nil {
+		log.Printf("warning: could not restore saved ports: %v", err)
+	}
 	go hub.Run()
 
-	scanner := NewScanner(10*time.Second, cs, func(ports []DiscoveredPort) {
+	scanner := NewScanner(cs.ScanInterval(), cs, func(ports []DiscoveredPort) {
 		hub.SetPorts(ports)
 	})
+	scanner.SetVerbose(*verbose)
+	hub.SetScanner(scanner)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Components register their shutdown as they start; on a shutdown
+	// signal the hooks run in this order within shutdownTimeout.
+	var lc Lifecycle
+	lc.OnShutdown("scanner", func(context.Context) error {
+		cancel()
+		return nil
+	})
+	lc.OnShutdown("hub", func(ctx context.Context) error {
+		hub.Shutdown(ctx)
+		return nil
+	})
+	lc.OnShutdown("port state", func(context.Context) error { return hub.saveState() })
+
 	go scanner.Run(ctx)
+	go hub.RunHealthChecks(ctx)
 
 	sessions := NewSessionStore()
 
@@ -139,59 +303,312 @@ func cmdStart() {
 		}
 	}()
 
-	dashAddr := fmt.Sprintf(":%d", *dashPort)
-	proxyAddr := fmt.Sprintf(":%d", *proxyPort)
+	dashAddr := net.JoinHostPort(dashHost, strconv.Itoa(*dashPort))
 
 	// Dashboard (with auth middleware)
-	dashboardHandler := AuthMiddleware(cs, sessions, DashboardHandler(hub, sessions))
-	dashSrv := &http.Server{Addr: dashAddr, Handler: dashboardHandler}
+	dashboardHandler := stripBasePath(cs, TokenMiddleware(cs, AuthMiddleware(cs, sessions, DashboardHandler(hub, sessions))))
+	dashSrv := &http.Server{Addr: dashAddr, Handler: dashboardHandler, MaxHeaderBytes: cs.DashboardMaxHeaderBytes()}
 
 	// Reverse proxy — no auth wrapping. Proxied services handle their own
 	// auth. Dashboard-bound requests are proxied to port 8080, which has
 	// its own AuthMiddleware.
-	proxyHandler := ProxyHandler(hub, fmt.Sprintf("127.0.0.1:%d", *dashPort))
-	proxySrv := &http.Server{Addr: proxyAddr, Handler: proxyHandler}
+	proxyHandler := MetricsMiddleware(CompressMiddleware(cs, ProxyHandler(hub, localDialAddr(dashHost, *dashPort))))
+	var al *AccessLogger
+	if *accessLog != "" {
+		al, err = NewAccessLogger(*accessLog, *accessLogFormat)
+		if err != nil {
+			log.Fatalf("access log: %v", err)
+		}
+		al.SetSampling(cs.AccessLogSampling())
+		proxyHandler = AccessLogMiddleware(al, proxyHandler)
+	}
 
+	// Bind the dashboard before the proxy starts so requests forwarded to
+	// it don't race its startup. All ports are bound up front so a port
+	// already in use is reported, with its owner, before anything is served.
+	dashLn, err := net.Listen("tcp", dashAddr)
+	if err != nil {
+		log.Fatalf("dashboard: %v", bindError(*dashPort, err))
+	}
+	proxyLns, err := listenProxyPorts(proxyHost, proxyPorts)
+	if err != nil {
+		log.Fatalf("proxy: %v", err)
+	}
+	// With TLS the plain proxy ports only redirect to the HTTPS port
+	var tlsLn net.Listener
+	var certs *selfSignedCerts
+	if *useTLS {
+		certs = newSelfSignedCerts(filepath.Dir(cs.Path()), cs)
+		if _, err := certs.GetCertificate(nil); err != nil {
+			log.Fatalf("tls: %v", err)
+		}
+		tlsLn, err = net.Listen("tcp", net.JoinHostPort(proxyHost, strconv.Itoa(*tlsPort)))
+		if err != nil {
+			log.Fatalf("tls: %v", bindError(*tlsPort, err))
+		}
+		hub.SetProxyEndpoint("https", *tlsPort)
+	} else {
+		// Mapping URLs use the first port that could be bound
+		hub.SetProxyEndpoint("http", proxyLns[0].Addr().(*net.TCPAddr).Port)
+	}
+	lc.OnShutdown("dashboard", dashSrv.Shutdown)
 	go func() {
 		log.Printf("Dashboard listening on %s", dashAddr)
-		if err := dashSrv.ListenAndServe(); err != http.ErrServerClosed {
+		if err := dashSrv.Serve(dashLn); err != http.ErrServerClosed {
 			log.Fatalf("dashboard: %v", err)
 		}
 	}()
 
-	go func() {
-		log.Printf("Proxy listening on %s", proxyAddr)
-		if err := proxySrv.ListenAndServe(); err != http.ErrServerClosed {
-			log.Fatalf("proxy: %v", err)
+	plainHandler := proxyHandler
+	if *useTLS {
+		plainHandler = httpsRedirect(*tlsPort)
+	}
+	var proxyAddrs []string
+	for _, ln := range proxyLns {
+		srv := &http.Server{Addr: net.JoinHostPort(proxyHost, strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)), Handler: plainHandler}
+		proxyAddrs = append(proxyAddrs, srv.Addr)
+		lc.OnShutdown("proxy "+srv.Addr, srv.Shutdown)
+		go func() {
+			log.Printf("Proxy listening on %s", srv.Addr)
+			if err := srv.Serve(ln); err != http.ErrServerClosed {
+				log.Fatalf("proxy: %v", err)
+			}
+		}()
+	}
+	proxyAddr := strings.Join(proxyAddrs, ",")
+	tlsAddr := ""
+	if tlsLn != nil {
+		srv := &http.Server{
+			Addr:      net.JoinHostPort(proxyHost, strconv.Itoa(*tlsPort)),
+			Handler:   proxyHandler,
+			TLSConfig: &tls.Config{GetCertificate: certs.GetCertificate},
 		}
-	}()
+		tlsAddr = srv.Addr
+		lc.OnShutdown("proxy "+srv.Addr+" (tls)", srv.Shutdown)
+		go func() {
+			log.Printf("Proxy listening on %s (TLS)", srv.Addr)
+			if err := srv.ServeTLS(tlsLn, "", ""); err != http.ErrServerClosed {
+				log.Fatalf("proxy: %v", err)
+			}
+		}()
+	}
+	if al != nil {
+		// Last, so requests drained by the proxy servers are still logged
+		lc.OnShutdown("access log", func(context.Context) error { return al.Close() })
+	}
 
-	go backgroundUpdateCheck()
+	go backgroundUpdateCheck(cs)
 
+	if !*quiet {
+		printStartupSummary(os.Stdout, cs, dashAddr, proxyAddr, tlsAddr, cs.ScanInterval())
+	}
 	log.Println("Portgate started")
 
+	hub.SetReloader(func() error {
+		if err := reloadConfig(cs, hub, *dashPort); err != nil {
+			return err
+		}
+		if al != nil {
+			al.SetSampling(cs.AccessLogSampling())
+		}
+		return nil
+	})
+	if len(reloadSignals) > 0 {
+		reload := make(chan os.Signal, 1)
+		signal.Notify(reload, reloadSignals...)
+		go func() {
+			for range reload {
+				hub.Reload()
+			}
+		}()
+	}
+
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, shutdownSignals...)
 	<-sig
 
 	log.Println("Shutting down...")
-	cancel()
-
-	shutCtx, shutCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	shutCtx, shutCancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer shutCancel()
-	dashSrv.Shutdown(shutCtx)
-	proxySrv.Shutdown(shutCtx)
+	lc.Shutdown(shutCtx)
 }
 
-func cmdAdd(domain, portStr string) {
-	var port int
-	if _, err := fmt.Sscanf(portStr, "%d", &port); err != nil {
-		fmt.Fprintf(os.Stderr, "invalid port: %s\n", portStr)
+// shutdownTimeout bounds how long all shutdown hooks may take together.
+const shutdownTimeout = 5 * time.Second
+
+// parseBindHost checks a --bind style listen address: an IP address (IPv6
+// with or without brackets) or a hostname. Empty means all interfaces.
+func parseBindHost(host string) (string, error) {
+	host = strings.TrimSpace(host)
+	if host == "" {
+		return "", nil
+	}
+	if h, ok := strings.CutPrefix(host, "["); ok {
+		if host, ok = strings.CutSuffix(h, "]"); !ok {
+			return "", fmt.Errorf("invalid address %q", "["+h)
+		}
+	}
+	if _, _, err := net.SplitHostPort(net.JoinHostPort(host, "0")); err != nil {
+		return "", fmt.Errorf("invalid address %q: %v", host, err)
+	}
+	if net.ParseIP(host) == nil && !validHostname(strings.ToLower(host)) {
+		return "", fmt.Errorf("invalid address %q: expected an IP address or hostname", host)
+	}
+	return host, nil
+}
+
+// localDialAddr returns the address this process reaches its own server on
+// port at, given the host it is bound to: loopback when it listens on every
+// interface, the bound host otherwise.
+func localDialAddr(bindHost string, port int) string {
+	if bindHost == "" || net.ParseIP(bindHost).IsUnspecified() {
+		bindHost = "127.0.0.1"
+	}
+	return net.JoinHostPort(bindHost, strconv.Itoa(port))
+}
+
+// listenProxyPorts binds the proxy to each port on host (all interfaces if
+// empty). A port that can't be bound is logged and skipped, so a list like
+// 80,8080 still starts without the privileges 80 needs; it is an error only
+// if none could be bound.
+func listenProxyPorts(host string, ports []int) ([]net.Listener, error) {
+	var lns []net.Listener
+	var errs []error
+	for _, port := range ports {
+		ln, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			err = bindError(port, err)
+			if len(ports) > 1 {
+				log.Printf("warning: proxy: %v; skipping port %d", err, port)
+			}
+			errs = append(errs, err)
+			continue
+		}
+		lns = append(lns, ln)
+	}
+	if len(lns) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	return lns, nil
+}
+
+// applyRuntimeConfig pushes config settings that live outside the
+// ConfigStore (trusted proxies, backend dial network and limits, the proxy
+// error page) into effect.
+func applyRuntimeConfig(cs *ConfigStore) error {
+	if err := cs.Validate(); err != nil {
+		return err
+	}
+	nets, err := cs.TrustedProxyNets()
+	if err != nil {
+		return err
+	}
+	_, network, err := cs.ProxyBackend()
+	if err != nil {
+		return err
+	}
+	errorPage, err := loadErrorPage(cs.ErrorPageTemplate())
+	if err != nil {
+		return err
+	}
+	notFoundPage, err := loadNotFoundPage(cs.NotFoundPageTemplate())
+	if err != nil {
+		return err
+	}
+	setTrustedProxies(nets)
+	setBackendDialNetwork(network)
+	setBackendLimits(cs.BackendLimits())
+	setBackendDialPolicy(cs.BackendDialPolicy())
+	setIdentifyProxy(cs.IdentifyProxy())
+	setErrorPage(errorPage)
+	setNotFoundPage(notFoundPage)
+	return nil
+}
+
+// reloadConfig re-reads the config file into the running server. A bad file
+// is logged and returned, and the current config stays in effect.
+func reloadConfig(cs *ConfigStore, hub *Hub, dashPort int) error {
+	if err := cs.Reload(); err != nil {
+		log.Printf("config reload failed, keeping current config: %v", err)
+		return err
+	}
+	if err := cs.EnsureDefaultMapping(dashPort); err != nil {
+		log.Printf("warning: could not register default mapping: %v", err)
+	}
+	if err := applyRuntimeConfig(cs); err != nil {
+		log.Printf("config reload: %v", err)
+	}
+	hub.syncScanning()
+	hub.syncScanInterval()
+	hub.broadcastUpdate()
+	log.Printf("Config reloaded from %s (%d mappings)", cs.Path(), len(cs.Mappings()))
+	return nil
+}
+
+// printStartupSummary writes the effective configuration as one
+// "key: value" line per setting so it is easy to read and to grep.
+func printStartupSummary(w io.Writer, cs *ConfigStore, dashAddr, proxyAddr, tlsAddr string, scanInterval time.Duration) {
+	onOff := func(b bool) string {
+		if b {
+			return "on"
+		}
+		return "off"
+	}
+	var ranges []string
+	for _, r := range cs.ScanRanges() {
+		ranges = append(ranges, r.String())
+	}
+	fmt.Fprintf(w, "version: %s\n", version)
+	fmt.Fprintf(w, "config: %s\n", cs.Path())
+	fmt.Fprintf(w, "dashboard: %s\n", dashAddr)
+	fmt.Fprintf(w, "proxy: %s\n", proxyAddr)
+	fmt.Fprintf(w, "domain-suffix: %s\n", cs.DomainSuffix())
+	fmt.Fprintf(w, "mappings: %d\n", len(cs.Mappings()))
+	fmt.Fprintf(w, "scan-ranges: %s\n", strings.Join(ranges, ","))
+	if _, profile := cs.ScanProfiles(); profile != "" {
+		fmt.Fprintf(w, "scan-profile: %s\n", profile)
+	}
+	fmt.Fprintf(w, "scan-interval: %s\n", scanInterval)
+	mode, _ := cs.ScanMode()
+	fmt.Fprintf(w, "scan-mode: %s\n", mode)
+	fmt.Fprintf(w, "external-access: %s\n", onOff(cs.ExternalAccess()))
+	if tlsAddr != "" {
+		fmt.Fprintf(w, "tls: %s (self-signed for *.%s)\n", tlsAddr, cs.DomainSuffix())
+	} else {
+		fmt.Fprintln(w, "tls: off")
+	}
+	fmt.Fprintf(w, "auth: %s\n", onOff(cs.AuthEnabled()))
+	fmt.Fprintf(w, "read-only: %s\n", onOff(cs.ReadOnly()))
+}
+
+func cmdAdd(domain, target string, args []string) {
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	group := fs.String("group", "", "group the mapping belongs to")
+	mode := fs.String("mode", "", "both (default), http-only or ws-only")
+	http2 := fs.Bool("http2", false, "speak HTTP/2 cleartext (h2c) to the backend")
+	stripPrefix := fs.String("strip-prefix", "", "path prefix removed before proxying, e.g. /v1")
+	hostFlag := fs.String("host", "", "backend host or IP, e.g. 192.168.1.40 (default: proxyBackendHost)")
+	fs.Parse(args)
+
+	host, port, err := parseTarget(target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid target: %v\n", err)
 		os.Exit(1)
 	}
-	body := fmt.Sprintf(`{"domain":"%s","port":%d}`, domain, port)
+	if *hostFlag != "" {
+		if host != "" {
+			fmt.Fprintln(os.Stderr, "invalid target: give the host in either --host or the target, not both")
+			os.Exit(1)
+		}
+		if host, err = normalizeTargetHost(*hostFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid target: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	req := MappingRequest{Domain: domain, Port: port, Host: host, Group: *group, Mode: *mode, BackendHTTP2: *http2, StripPrefix: *stripPrefix}
+	body, _ := json.Marshal(req)
 	resp, err := http.Post("http://localhost:8080/api/mappings", "application/json",
-		strings.NewReader(body))
+		bytes.NewReader(body))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v (is portgate running?)\n", err)
 		os.Exit(1)
@@ -207,7 +624,12 @@ func cmdAdd(domain, portStr string) {
 				suffix = s.Suffix
 			}
 		}
-		fmt.Printf("Mapped %s.%s → :%d\n", domain, suffix, port)
+		fmt.Printf("Mapped %s.%s → %s\n", domain, suffix, mappingTarget(DomainMapping{TargetHost: host, TargetPort: port}))
+		var created struct{ Warnings []string }
+		json.NewDecoder(resp.Body).Decode(&created)
+		for _, w := range created.Warnings {
+			fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+		}
 	} else {
 		io.Copy(os.Stderr, resp.Body)
 		os.Exit(1)
@@ -231,13 +653,284 @@ func cmdRemove(domain string) {
 	}
 }
 
-func cmdList() {
-	resp, err := http.Get("http://localhost:8080/api/mappings")
+func cmdMaintenance(enabled bool, domain string) {
+	body, _ := json.Marshal(MaintenanceRequest{Domain: domain, Enabled: enabled})
+	req, _ := http.NewRequest(http.MethodPut, "http://localhost:8080/api/maintenance",
+		bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v (is portgate running?)\n", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(os.Stderr, resp.Body)
+		os.Exit(1)
+	}
+	if enabled {
+		fmt.Printf("Maintenance enabled for %s\n", domain)
+	} else {
+		fmt.Printf("Maintenance disabled for %s\n", domain)
+	}
+}
+
+// cmdNote sets the note shown for a port. An empty text removes it.
+func cmdNote(portStr, text string) {
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid port: %s\n", portStr)
+		os.Exit(1)
+	}
+	body, _ := json.Marshal(PortNoteRequest{Note: text})
+	req, _ := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:8080/api/ports/%d/note", port),
+		bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v (is portgate running?)\n", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(os.Stderr, resp.Body)
+		os.Exit(1)
+	}
+	if strings.TrimSpace(text) == "" {
+		fmt.Printf("Note removed from port %d\n", port)
+	} else {
+		fmt.Printf("Note set on port %d\n", port)
+	}
+}
+
+// portInfo is the single-port report printed by `portgate info`.
+type portInfo struct {
+	DiscoveredPort
+	Manual     *ManualPort `json:"manual,omitempty"`     // the port's manual registration
+	Mappings   []string    `json:"mappings,omitempty"`   // hostnames routed to the port
+	PID        int         `json:"pid,omitempty"`        // owning process, looked up by this command
+	Cmdline    string      `json:"cmdline,omitempty"`    // the owner's command line, where readable
+	Started    *time.Time  `json:"started,omitempty"`    // when the owner started, where readable
+	Live       bool        `json:"live"`                 // probed by this command, not read from a running server
+	LiveReason string      `json:"liveReason,omitempty"` // why the running server's view wasn't used
+}
+
+// cmdInfo prints everything known about one port. The running server's view
+// is used when there is one; otherwise the port is probed once, here.
+func cmdInfo(portStr string, args []string) {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	fs.Parse(args)
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		fmt.Fprintf(os.Stderr, "invalid port: %s\n", portStr)
+		os.Exit(1)
+	}
+	cs, err := NewConfigStore("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		os.Exit(1)
+	}
+
+	var ports []DiscoveredPort
+	mappings := cs.Mappings()
+	suffix := cs.DomainSuffix()
+	liveReason := ""
+	if err := getServerJSON("/api/ports", &ports); err == nil {
+		var ms []DomainMapping
+		if getServerJSON("/api/mappings", &ms) == nil {
+			mappings = ms
+		}
+		var s struct{ Suffix string }
+		if getServerJSON("/api/domain-suffix", &s) == nil && s.Suffix != "" {
+			suffix = s.Suffix
+		}
+	} else {
+		// A server that refuses the request (e.g. it needs a token) must not
+		// make the port look closed; probe it here instead and say why
+		liveReason = "portgate is not running"
+		if !errors.Is(err, errNotRunning) {
+			liveReason = err.Error()
+			fmt.Fprintf(os.Stderr, "warning: %v; probing the port here instead\n", err)
+		}
+		dp := NewScanner(0, cs, nil).CheckPort(port)
+		dp.Note = cs.PortNotes()[port]
+		ports = []DiscoveredPort{dp}
+	}
+
+	info := buildPortInfo(port, ports, mappings, cs.ManualPorts(), suffix)
+	info.Live, info.LiveReason = liveReason != "", liveReason
+	if _, pid, found := findPortOwner(port); found && pid != 0 {
+		info.PID = pid
+		cmdline, started := processDetails(pid)
+		info.Cmdline = cmdline
+		if !started.IsZero() {
+			info.Started = &started
+		}
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(info)
+		return
+	}
+	printPortInfo(os.Stdout, info)
+}
+
+// buildPortInfo assembles the report for port from the discovered ports,
+// mappings and manual registrations. A port that isn't among the discovered
+// ones is reported closed.
+func buildPortInfo(port int, ports []DiscoveredPort, mappings []DomainMapping, manual []ManualPort, suffix string) portInfo {
+	info := portInfo{DiscoveredPort: DiscoveredPort{Port: port, Protocol: "tcp", HealthReason: healthTCPClosed}}
+	for _, p := range ports {
+		if p.Port == port {
+			info.DiscoveredPort = p
+		}
+	}
+	for _, mp := range manual {
+		if mp.Port == port {
+			info.Manual = &mp
+		}
+	}
+	for _, m := range mappings {
+		if m.TargetPort == port && m.TargetHost == "" {
+			info.Mappings = append(info.Mappings, m.Domain+"."+suffix)
+		}
+	}
+	return info
+}
+
+// printPortInfo writes info as an aligned "label: value" report, leaving out
+// whatever is unknown.
+func printPortInfo(w io.Writer, info portInfo) {
+	state := "closed"
+	if info.Healthy {
+		state = "open"
+	}
+	if label := failureLabel(info.DiscoveredPort); label != "" && label != healthTCPClosed {
+		state += " (" + label + ")"
+	}
+	fmt.Fprintf(w, "Port %d — %s\n", info.Port, state)
+	line := func(label, value string) {
+		if value != "" {
+			fmt.Fprintf(w, "  %-10s %s\n", label+":", value)
+		}
+	}
+	service := info.ServiceName
+	if label := portLabel(info.DiscoveredPort); label != "" {
+		service += " — " + label
+	}
+	line("service", strings.TrimPrefix(service, " — "))
+	if info.ProbeStatus != 0 {
+		line("probe", strings.TrimSpace(fmt.Sprintf("GET %s → %d %s", info.ProbePath, info.ProbeStatus, info.ContentType)))
+	}
+	if info.AuthScheme != "" {
+		line("auth", strings.TrimSpace(info.AuthScheme+" "+info.AuthRealm))
+	}
+	if info.TLSSubject != "" {
+		tlsLine := info.TLSSubject
+		if info.TLSExpiry != nil {
+			tlsLine += ", expires " + info.TLSExpiry.Local().Format(time.DateOnly)
+		}
+		line("tls", tlsLine)
+	}
+	// The process name is only worth showing when the path doesn't already
+	// end in it, e.g. a script run by an interpreter
+	process := info.ExePath
+	if name := info.ProcessName; name != "" && name != exeProcessName(process) {
+		process = name
+		if info.ExePath != "" {
+			process += " (" + info.ExePath + ")"
+		}
+	}
+	line("process", process)
+	if info.PID != 0 {
+		line("pid", strconv.Itoa(info.PID))
+	}
+	line("cmdline", info.Cmdline)
+	if info.Started != nil {
+		line("started", info.Started.Local().Format(time.DateTime))
+	}
+	line("listening", strings.Join(info.ListenAddrs, ", "))
+	if info.Manual != nil {
+		manual := "yes"
+		if info.Manual.Name != "" {
+			manual += fmt.Sprintf(" (%s)", info.Manual.Name)
+		}
+		line("manual", manual)
+	}
+	line("mapped", strings.Join(info.Mappings, ", "))
+	line("note", info.Note)
+	if !info.LastSeen.IsZero() {
+		line("seen", info.LastSeen.Local().Format(time.DateTime))
+	}
+	if info.Live {
+		line("source", "live probe ("+info.LiveReason+")")
+	}
+}
+
+// errNotRunning wraps the transport error when no server answers.
+var errNotRunning = errors.New("portgate is not running")
+
+// getServerJSON decodes the running server's 200 answer for path into v. Any
+// other status is an error naming it, so a refused request is never read as
+// an empty result.
+func getServerJSON(path string, v any) error {
+	resp, err := http.Get("http://localhost:8080" + path)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errNotRunning, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// cmdTest asks the running server to send a request through a mapping and
+// reports the result. It exits non-zero when the mapping is not working.
+func cmdTest(domain string) {
+	resp, err := http.Post("http://localhost:8080/api/mappings/"+url.PathEscape(domain)+"/test", "application/json", nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v (is portgate running?)\n", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(os.Stderr, resp.Body)
+		os.Exit(1)
+	}
+	var res MappingTestResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	if !res.OK {
+		fmt.Printf("FAIL %s -> %s: %d %s (%s)\n", res.Domain, res.Target, res.Status, http.StatusText(res.Status), res.Error)
+		os.Exit(1)
+	}
+	fmt.Printf("OK   %s -> %s: %d %s in %dms\n", res.Domain, res.Target, res.Status, http.StatusText(res.Status), res.LatencyMs)
+}
+
+func cmdList(args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	group := fs.String("group", "", "only show mappings in this group")
+	sortBy := fs.String("sort", "", "order by domain, created or port (default: config order)")
+	fs.Parse(args)
+
+	q := url.Values{}
+	q.Set("group", *group)
+	q.Set("sort", *sortBy)
+	resp, err := http.Get("http://localhost:8080/api/mappings?" + q.Encode())
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v (is portgate running?)\n", err)
 		os.Exit(1)
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(os.Stderr, resp.Body)
+		os.Exit(1)
+	}
 	var mappings []DomainMapping
 	json.NewDecoder(resp.Body).Decode(&mappings)
 	if len(mappings) == 0 {
@@ -254,11 +947,26 @@ func cmdList() {
 		}
 	}
 	for _, m := range mappings {
-		fmt.Printf("  %s.%s → :%d\n", m.Domain, suffix, m.TargetPort)
+		note := ""
+		if m.Group != "" {
+			note += " (" + m.Group + ")"
+		}
+		if m.Maintenance {
+			note += " [maintenance]"
+		}
+		fmt.Printf("  %s.%s → %s%s\n", m.Domain, suffix, mappingTarget(m), note)
 	}
 }
 
-func cmdStatus() {
+func cmdStatus(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	summary := fs.Bool("summary", false, "print aggregate counts instead of the port list")
+	fs.Parse(args)
+	if *summary {
+		cmdStatusSummary()
+		return
+	}
+
 	resp, err := http.Get("http://localhost:8080/api/ports")
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Portgate is not running\n")
@@ -277,6 +985,12 @@ func cmdStatus() {
 		}
 	}
 	fmt.Printf("Portgate is running — %d ports discovered (domain: .%s)\n", len(ports), suffix)
+	printPorts(ports)
+}
+
+// printPorts lists ports one per line with their health, label and origin,
+// as portgate status shows them.
+func printPorts(ports []DiscoveredPort) {
 	for _, p := range ports {
 		status := "●"
 		if !p.Healthy {
@@ -286,14 +1000,196 @@ func cmdStatus() {
 		if p.Source == "manual" {
 			source = " [manual]"
 		}
+		if p.Stale {
+			source += " [stale]"
+		}
 		detail := p.ServiceName
-		if p.Title != "" {
-			detail += " — " + p.Title
+		if label := portLabel(p); label != "" {
+			detail += " — " + label
 		}
-		fmt.Printf("  %s :%d  %s%s\n", status, p.Port, detail, source)
+		if label := failureLabel(p); label != "" {
+			detail += " (" + label + ")"
+		}
+		fmt.Printf("  %s :%d/%s  %s%s\n", status, p.Port, cmp.Or(p.Protocol, "tcp"), detail, source)
 		if p.ExePath != "" {
 			fmt.Printf("    %s\n", p.ExePath)
 		}
+		if len(p.ListenAddrs) > 1 {
+			fmt.Printf("    listening on %s\n", strings.Join(p.ListenAddrs, ", "))
+		}
+	}
+}
+
+// cmdScanNow makes the running server scan straight away and prints the
+// ports it found.
+func cmdScanNow() {
+	resp, err := http.Post("http://localhost:8080/api/scan", "application/json", nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v (is portgate running?)\n", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusAccepted:
+		fmt.Println("Scan started but is still running; the dashboard will show the results")
+		return
+	default:
+		io.Copy(os.Stderr, resp.Body)
+		os.Exit(1)
+	}
+	var ports []DiscoveredPort
+	if err := json.NewDecoder(resp.Body).Decode(&ports); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Scan finished — %d ports discovered\n", len(ports))
+	printPorts(ports)
+}
+
+// cmdStatusSummary prints the aggregate counts from /api/stats.
+func cmdStatusSummary() {
+	resp, err := http.Get("http://localhost:8080/api/stats")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Portgate is not running\n")
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(os.Stderr, resp.Body)
+		os.Exit(1)
+	}
+	var st hubStats
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Portgate %s — up %s\n", st.Version, time.Duration(st.UptimeSec)*time.Second)
+	fmt.Printf("  ports:     %d (%d healthy, %d unhealthy; %d scanned, %d manual)\n",
+		st.Ports.Total, st.Ports.Healthy, st.Ports.Unhealthy, st.Ports.Scan, st.Ports.Manual)
+	fmt.Printf("  mappings:  %d (%d active, %d in maintenance, %d system)\n",
+		st.Mappings.Total, st.Mappings.Active, st.Mappings.Maintenance, st.Mappings.System)
+	fmt.Printf("  ranges:    %d covering %d ports\n", st.ScanRanges.Count, st.ScanRanges.Ports)
+	if st.LastScan != nil {
+		fmt.Printf("  last scan: %s, took %dms\n", st.LastScan.Local().Format(time.TimeOnly), st.LastScanMs)
+	} else {
+		fmt.Println("  last scan: none yet")
+	}
+	fmt.Printf("  clients:   %d dashboard connections\n", st.Clients)
+}
+
+// cmdScan runs a single scan against the configured ranges and manual ports
+// and prints the result as JSON, without starting the servers.
+func cmdScan(args []string) {
+	if len(args) > 0 && (args[0] == "pause" || args[0] == "resume") {
+		cmdScanPause(args[0] == "pause")
+		return
+	}
+	fs := flag.NewFlagSet("scan", flag.ExitOnError)
+	stream := fs.Bool("stream", false, "print each port as one JSON object per line as soon as it is found")
+	fs.Parse(args)
+
+	cs, err := NewConfigStore("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		os.Exit(1)
+	}
+	if *stream {
+		// Stdout is unbuffered, so each line is written as soon as it's encoded
+		enc := json.NewEncoder(os.Stdout)
+		NewScanner(0, cs, nil).scanStream(func(dp DiscoveredPort) {
+			enc.Encode(dp)
+		})
+		return
+	}
+	ports := NewScanner(0, cs, nil).scan()
+	if ports == nil {
+		ports = []DiscoveredPort{}
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(ports)
+}
+
+// cmdScanPause pauses or resumes range scanning on the running server.
+func cmdScanPause(pause bool) {
+	action := "resume"
+	if pause {
+		action = "pause"
+	}
+	resp, err := http.Post("http://localhost:8080/api/scan/"+action, "application/json", nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v (is portgate running?)\n", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(os.Stderr, resp.Body)
+		os.Exit(1)
+	}
+	if pause {
+		fmt.Println("Scanning paused; only manual ports are health-checked")
+	} else {
+		fmt.Println("Scanning resumed")
+	}
+}
+
+// cmdReload asks the running server to re-read its config file, for edits
+// made by hand or by another tool. It works on every platform, unlike SIGHUP.
+func cmdReload() {
+	resp, err := http.Post("http://localhost:8080/api/reload", "application/json", nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v (is portgate running?)\n", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(os.Stderr, resp.Body)
+		os.Exit(1)
+	}
+	var result struct {
+		Path     string `json:"path"`
+		Mappings int    `json:"mappings"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	fmt.Printf("Reloaded %s (%d mappings)\n", result.Path, result.Mappings)
+}
+
+// cmdInternalList prints the running server's mapped domains or discovered
+// ports, one per line, for completion scripts and shell one-liners. Any
+// failure, including the server not running, produces empty output.
+func cmdInternalList(args []string) {
+	if len(args) != 1 {
+		return
+	}
+	client := &http.Client{Timeout: time.Second}
+	switch args[0] {
+	case "domains":
+		resp, err := client.Get("http://localhost:8080/api/mappings")
+		if err != nil {
+			return
+		}
+		defer resp.Body.Close()
+		var mappings []DomainMapping
+		if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&mappings) != nil {
+			return
+		}
+		for _, m := range mappings {
+			fmt.Println(m.Domain)
+		}
+	case "ports":
+		resp, err := client.Get("http://localhost:8080/api/ports")
+		if err != nil {
+			return
+		}
+		defer resp.Body.Close()
+		var ports []DiscoveredPort
+		if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&ports) != nil {
+			return
+		}
+		for _, p := range ports {
+			fmt.Println(p.Port)
+		}
 	}
 }
 
@@ -312,12 +1208,12 @@ func cmdScanRange(args []string) {
 		}
 		fmt.Println("Scan ranges:")
 		for _, r := range ranges {
-			fmt.Printf("  %d-%d\n", r.Start, r.End)
+			fmt.Printf("  %s\n", r)
 		}
 
 	case "add":
 		if len(args) < 2 {
-			fmt.Fprintln(os.Stderr, "usage: portgate scan-range add <start>-<end>")
+			fmt.Fprintln(os.Stderr, "usage: portgate scan-range add <start>-<end>[/tcp|/udp|/both]")
 			os.Exit(1)
 		}
 		sr := parseScanRange(args[1])
@@ -330,11 +1226,11 @@ func cmdScanRange(args []string) {
 			fmt.Fprintf(os.Stderr, "error: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Printf("Added scan range %d-%d\n", sr.Start, sr.End)
+		fmt.Printf("Added scan range %s\n", sr)
 
 	case "remove":
 		if len(args) < 2 {
-			fmt.Fprintln(os.Stderr, "usage: portgate scan-range remove <start>-<end>")
+			fmt.Fprintln(os.Stderr, "usage: portgate scan-range remove <start>-<end>[/tcp|/udp|/both]")
 			os.Exit(1)
 		}
 		sr := parseScanRange(args[1])
@@ -347,7 +1243,7 @@ func cmdScanRange(args []string) {
 			fmt.Fprintf(os.Stderr, "error: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Printf("Removed scan range %d-%d\n", sr.Start, sr.End)
+		fmt.Printf("Removed scan range %s\n", sr)
 
 	default:
 		fmt.Fprintf(os.Stderr, "unknown scan-range subcommand: %s\nsubcommands: add, remove, list\n", args[0])
@@ -355,30 +1251,162 @@ func cmdScanRange(args []string) {
 	}
 }
 
+// cmdScanProfile manages named scan range sets. Changes go to the running
+// server so a switch rescans at once; without one the config file is edited
+// and the change applies at the next start.
+func cmdScanProfile(args []string) {
+	const base = "http://localhost:8080/api/scan-profiles"
+	needName := func(usage string) string {
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "usage: portgate scan-profile "+usage)
+			os.Exit(1)
+		}
+		return normalizeGroup(args[1])
+	}
+
+	switch args[0] {
+	case "list":
+		cs, err := NewConfigStore("")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "config: %v\n", err)
+			os.Exit(1)
+		}
+		profiles, active := cs.ScanProfiles()
+		if len(profiles) == 0 {
+			fmt.Println("No scan profiles configured")
+			return
+		}
+		fmt.Println("Scan profiles (* = active):")
+		for _, name := range slices.Sorted(maps.Keys(profiles)) {
+			mark := " "
+			if name == active {
+				mark = "*"
+			}
+			var ranges []string
+			for _, r := range profiles[name] {
+				ranges = append(ranges, r.String())
+			}
+			fmt.Printf("  %s %s: %s\n", mark, name, strings.Join(ranges, ", "))
+		}
+
+	case "use", "clear":
+		name := ""
+		if args[0] == "use" {
+			name = needName("use <name>")
+		}
+		body, _ := json.Marshal(map[string]string{"name": name})
+		req, _ := http.NewRequest(http.MethodPut, base+"/active", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		sendOrApply(req, func(cs *ConfigStore) error { return cs.UseScanProfile(name) })
+		if name == "" {
+			fmt.Println("Scanning the plain scan ranges")
+		} else {
+			fmt.Printf("Scanning profile %s\n", name)
+		}
+
+	case "add":
+		name := needName("add <name> <start>-<end>...")
+		if len(args) < 3 {
+			fmt.Fprintln(os.Stderr, "usage: portgate scan-profile add <name> <start>-<end>...")
+			os.Exit(1)
+		}
+		var ranges []ScanRange
+		for _, a := range args[2:] {
+			ranges = append(ranges, parseScanRange(a))
+		}
+		if err := validateScanProfile(name, ranges); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		body, _ := json.Marshal(ScanProfileRequest{Name: name, Ranges: ranges})
+		req, _ := http.NewRequest(http.MethodPost, base, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		sendOrApply(req, func(cs *ConfigStore) error { return cs.SetScanProfile(name, ranges) })
+		fmt.Printf("Saved scan profile %s\n", name)
+
+	case "remove":
+		name := needName("remove <name>")
+		req, _ := http.NewRequest(http.MethodDelete, base+"?name="+url.QueryEscape(name), nil)
+		sendOrApply(req, func(cs *ConfigStore) error { return cs.RemoveScanProfile(name) })
+		fmt.Printf("Removed scan profile %s\n", name)
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown scan-profile subcommand: %s\nsubcommands: list, use, clear, add, remove\n", args[0])
+		os.Exit(1)
+	}
+}
+
+// sendOrApply sends req to the running server. If none is listening, the
+// same change is made to the config file by local instead.
+func sendOrApply(req *http.Request, local func(cs *ConfigStore) error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err == nil {
+		defer resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			io.Copy(os.Stderr, resp.Body)
+			os.Exit(1)
+		}
+		return
+	}
+	cs, err := NewConfigStore("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		os.Exit(1)
+	}
+	if err := local(cs); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("portgate is not running; the change applies at the next start.")
+}
+
 func parseScanRange(s string) ScanRange {
+	bounds, proto, _ := strings.Cut(s, "/")
 	var start, end int
-	n, err := fmt.Sscanf(s, "%d-%d", &start, &end)
+	n, err := fmt.Sscanf(bounds, "%d-%d", &start, &end)
 	if err != nil || n != 2 || start > end || start < 1 || end > 65535 {
-		fmt.Fprintf(os.Stderr, "invalid range: %s (expected start-end, e.g. 9000-9999)\n", s)
+		fmt.Fprintf(os.Stderr, "invalid range: %s (expected start-end, e.g. 9000-9999 or 5000-5010/udp)\n", s)
+		os.Exit(1)
+	}
+	proto, err = normalizeScanProtocol(proto)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid range: %v\n", err)
 		os.Exit(1)
 	}
-	return ScanRange{Start: start, End: end}
+	return ScanRange{Start: start, End: end, Protocol: proto}
 }
 
 func cmdAddPort(args []string) {
 	fs := flag.NewFlagSet("add-port", flag.ExitOnError)
 	name := fs.String("name", "", "optional name for the port")
 	path := fs.String("path", "", "optional install path of the application")
+	probeHost := fs.String("probe-host", "", "Host header to send when probing (for virtual-host-only services)")
+	probePaths := fs.String("probe-path", "", "comma-separated paths to probe in order, e.g. /health,/")
+	probeAccept := fs.String("probe-accept", "", "Accept header to send when probing, e.g. application/json")
+	healthPath := fs.String("health-path", "", "path that must answer 2xx/3xx for the port to count as healthy, e.g. /health")
 	fs.Parse(args)
 
 	if fs.NArg() < 1 {
-		fmt.Fprintln(os.Stderr, "usage: portgate add-port <port> [--name \"my-app\"] [--path /usr/bin/app]")
+		fmt.Fprintln(os.Stderr, "usage: portgate add-port <port>[,<port>|<start-end>...] [--name \"my-app\"] [--path /usr/bin/app] [--probe-host app.local] [--probe-path /health,/] [--probe-accept TYPE] [--health-path /health]")
+		os.Exit(1)
+	}
+
+	ports, err := parsePortList(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
 
-	var port int
-	if _, err := fmt.Sscanf(fs.Arg(0), "%d", &port); err != nil || port < 1 || port > 65535 {
-		fmt.Fprintf(os.Stderr, "invalid port: %s\n", fs.Arg(0))
+	var paths []string
+	if *probePaths != "" {
+		paths = strings.Split(*probePaths, ",")
+		if err := validateProbePaths(paths); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := validateHealthPath(*healthPath); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
 
@@ -387,34 +1415,287 @@ func cmdAddPort(args []string) {
 		fmt.Fprintf(os.Stderr, "config: %v\n", err)
 		os.Exit(1)
 	}
-	mp := ManualPort{Port: port, Name: *name, Path: *path}
-	if err := cs.AddManualPort(mp); err != nil {
+	failed := 0
+	for _, port := range ports {
+		mp := ManualPort{Port: port, Name: *name, Path: *path, ProbeHost: *probeHost, ProbePaths: paths, ProbeAccept: *probeAccept, HealthPath: *healthPath}
+		if err := cs.AddManualPort(mp); err != nil {
+			fmt.Fprintf(os.Stderr, "port %d: error: %v\n", port, err)
+			failed++
+			continue
+		}
+		if *name != "" {
+			fmt.Printf("Registered port %d (%s)\n", port, *name)
+		} else {
+			fmt.Printf("Registered port %d\n", port)
+		}
+		if domains := cs.MappingsToPort(port); len(domains) > 0 {
+			fmt.Printf("  used by mappings: %s\n", strings.Join(domains, ", "))
+		}
+	}
+	portListSummary("Registered", len(ports), failed)
+}
+
+func cmdRemovePort(portStr string, args []string) {
+	fs := flag.NewFlagSet("remove-port", flag.ExitOnError)
+	removeMappings := fs.Bool("remove-mappings", false, "also remove the mappings that route to the ports")
+	fs.Parse(args)
+	ports, err := parsePortList(portStr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	cs, err := NewConfigStore("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		os.Exit(1)
+	}
+	registered := make(map[int]bool)
+	for _, mp := range cs.ManualPorts() {
+		registered[mp.Port] = true
+	}
+	failed := 0
+	for _, port := range ports {
+		if !registered[port] {
+			fmt.Fprintf(os.Stderr, "port %d: not a manually registered port\n", port)
+			failed++
+			continue
+		}
+		if err := cs.RemoveManualPort(port); err != nil {
+			fmt.Fprintf(os.Stderr, "port %d: error: %v\n", port, err)
+			failed++
+			continue
+		}
+		fmt.Printf("Removed manual port %d\n", port)
+		if !*removeMappings {
+			for _, w := range danglingMappingWarnings(port, cs.MappingsToPort(port)) {
+				fmt.Fprintf(os.Stderr, "warning: %s (pass --remove-mappings to remove it too)\n", w)
+			}
+			continue
+		}
+		removed, err := cs.RemoveMappingsToPort(port)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "port %d: error removing mappings: %v\n", port, err)
+			failed++
+			continue
+		}
+		for _, d := range removed {
+			fmt.Printf("Removed mapping %s\n", d)
+		}
+	}
+	portListSummary("Removed", len(ports), failed)
+}
+
+// maxPortListSize caps how many ports a single add-port/remove-port may touch.
+const maxPortListSize = 1024
+
+// parsePortList parses a comma-separated list of ports and ranges, e.g.
+// "3000,3001,3005-3010", into individual ports in order without duplicates.
+func parsePortList(s string) ([]int, error) {
+	return parsePorts(s, true)
+}
+
+// parsePorts is parsePortList with ranges optional; without them an item
+// such as "3005-3010" is an error.
+func parsePorts(s string, allowRanges bool) ([]int, error) {
+	var ports []int
+	seen := make(map[int]bool)
+	add := func(p int) {
+		if !seen[p] {
+			seen[p] = true
+			ports = append(ports, p)
+		}
+	}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		startStr, endStr, isRange := strings.Cut(item, "-")
+		start, err := strconv.Atoi(startStr)
+		if err != nil || start < 1 || start > 65535 {
+			return nil, fmt.Errorf("invalid port: %s", item)
+		}
+		end := start
+		if isRange && !allowRanges {
+			return nil, fmt.Errorf("port ranges are not allowed here: %s", item)
+		}
+		if isRange {
+			end, err = strconv.Atoi(endStr)
+			if err != nil || end < start || end > 65535 {
+				return nil, fmt.Errorf("invalid port range: %s (expected start-end, e.g. 3005-3010)", item)
+			}
+		}
+		if len(ports)+end-start+1 > maxPortListSize {
+			return nil, fmt.Errorf("too many ports in %q (max %d)", s, maxPortListSize)
+		}
+		for p := start; p <= end; p++ {
+			add(p)
+		}
+	}
+	return ports, nil
+}
+
+// portListSummary prints a total for multi-port commands and exits non-zero
+// if any port failed.
+func portListSummary(verb string, total, failed int) {
+	if total > 1 {
+		fmt.Printf("%s %d of %d ports\n", verb, total-failed, total)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// cmdExport writes the portable part of the config file to a file, or to
+// stdout without one or with "-".
+func cmdExport(args []string) {
+	file := ""
+	if len(args) > 0 && (args[0] == "-" || !strings.HasPrefix(args[0], "-")) {
+		file, args = args[0], args[1:]
+	}
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	configPath := fs.String("config", "", "config file path")
+	fs.Parse(args)
+
+	cs, err := NewConfigStore(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		os.Exit(1)
+	}
+	if file == "" || file == "-" {
+		if err := cs.Export(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	var buf bytes.Buffer
+	if err := cs.Export(&buf); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(file, buf.Bytes(), 0644); err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
-	if *name != "" {
-		fmt.Printf("Registered port %d (%s)\n", port, *name)
+	fmt.Printf("Exported %s to %s\n", cs.Path(), file)
+}
+
+// cmdImport applies an export to the config file, replacing its mappings,
+// scan ranges, manual ports and domain suffix, or adding to them with
+// --merge. A running server using the default config is told to reload.
+func cmdImport(file string, args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	merge := fs.Bool("merge", false, "add to the existing config instead of replacing it")
+	configPath := fs.String("config", "", "config file path")
+	fs.Parse(args)
+
+	in := io.Reader(os.Stdin)
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		in = f
+	}
+	cs, err := NewConfigStore(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		os.Exit(1)
+	}
+	res, err := cs.Import(in, *merge)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	if *merge {
+		printSnapshotPlan(os.Stdout, res)
+		fmt.Printf("Merged %d new entries into %s\n", len(res.Added), cs.Path())
 	} else {
-		fmt.Printf("Registered port %d\n", port)
+		fmt.Printf("Replaced %s with %d entries\n", cs.Path(), len(res.Added))
+	}
+
+	if *configPath != "" {
+		fmt.Println("Restart or reload portgate if it is running with this file.")
+		return
+	}
+	// A running server holds its own copy of the config until it reloads
+	if resp, err := http.Post("http://localhost:8080/api/reload", "application/json", nil); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			fmt.Println("Reloaded the running server")
+		}
 	}
 }
 
-func cmdRemovePort(portStr string) {
-	var port int
-	if _, err := fmt.Sscanf(portStr, "%d", &port); err != nil {
-		fmt.Fprintf(os.Stderr, "invalid port: %s\n", portStr)
+func cmdConfig(args []string) {
+	if len(args) >= 1 && args[0] == "reset" {
+		cmdConfigReset(args[1:])
+		return
+	}
+	if len(args) < 1 || args[0] != "path" {
+		fmt.Fprintln(os.Stderr, "usage: portgate config <path|reset> [--config FILE]")
 		os.Exit(1)
 	}
-	cs, err := NewConfigStore("")
+	fs := flag.NewFlagSet("config path", flag.ExitOnError)
+	configPath := fs.String("config", "", "config file path")
+	fs.Parse(args[1:])
+
+	path, err := resolveConfigPath(*configPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "config: %v\n", err)
 		os.Exit(1)
 	}
-	if err := cs.RemoveManualPort(port); err != nil {
+	fmt.Println(path)
+}
+
+// cmdConfigReset resets the config to defaults. A running server does the
+// reset itself so it picks up the new config straight away; otherwise the
+// file is reset directly.
+func cmdConfigReset(args []string) {
+	fs := flag.NewFlagSet("config reset", flag.ExitOnError)
+	keepMappings := fs.Bool("keep-mappings", false, "keep domain mappings")
+	configPath := fs.String("config", "", "config file path (resets the file directly)")
+	fs.Parse(args)
+
+	if *configPath == "" {
+		resp, err := http.Post(fmt.Sprintf("http://localhost:8080/api/config/reset?keepMappings=%t", *keepMappings), "application/json", nil)
+		if err == nil {
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				io.Copy(os.Stderr, resp.Body)
+				os.Exit(1)
+			}
+			var out struct {
+				Backup string `json:"backup"`
+			}
+			json.NewDecoder(resp.Body).Decode(&out)
+			printResetResult(out.Backup)
+			return
+		}
+	}
+
+	// An invalid file can still be reset; that's what reset is for
+	cs, err := NewConfigStore(*configPath)
+	if err != nil && !errors.Is(err, errInvalidConfig) {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		os.Exit(1)
+	}
+	backup, err := cs.Reset(*keepMappings)
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
-	fmt.Printf("Removed manual port %d\n", port)
+	printResetResult(backup)
+	if *configPath != "" {
+		fmt.Println("Restart or reload portgate if it is running with this file.")
+	}
+}
+
+func printResetResult(backup string) {
+	fmt.Println("Config reset to defaults")
+	if backup != "" {
+		fmt.Printf("Previous config saved to %s\n", backup)
+	}
 }
 
 func cmdSetPassword() {
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePortList(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []int
+		ok   bool
+	}{
+		{"3000", []int{3000}, true},
+		{"3000,3001,3005-3008", []int{3000, 3001, 3005, 3006, 3007, 3008}, true},
+		{" 80 , 80,79-80 ", []int{80, 79}, true},
+		{"0", nil, false},
+		{"65536", nil, false},
+		{"3010-3005", nil, false},
+		{"3000-", nil, false},
+		{"abc", nil, false},
+		{"3000,,3001", nil, false},
+		{"1-65535", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parsePortList(tt.in)
+			if (err == nil) != tt.ok {
+				t.Fatalf("parsePortList(%q) error = %v, want ok=%v", tt.in, err, tt.ok)
+			}
+			if tt.ok && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePortList(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+
+	// --proxy-port takes single ports only
+	if got, err := parsePorts("80,8080", false); err != nil || !reflect.DeepEqual(got, []int{80, 8080}) {
+		t.Errorf("parsePorts(80,8080) = %v, %v", got, err)
+	}
+	if _, err := parsePorts("80,8080-8081", false); err == nil {
+		t.Errorf("parsePorts accepted a range without allowRanges")
+	}
+}
+
+func TestPortInfo(t *testing.T) {
+	cs := newTestConfigStore(t)
+	off := false
+	cs.cfg.ResolveExe = &off
+	cs.cfg.ManualPorts = []ManualPort{{Port: 9000, Name: "db"}}
+	cs.cfg.Mappings = []DomainMapping{
+		{Domain: "app", TargetPort: 3001},
+		{Domain: "remote", TargetPort: 3001, TargetHost: "10.0.0.5"},
+	}
+
+	s := NewScanner(time.Second, cs, nil)
+	s.prober = fakeProber{services: map[int]DiscoveredPort{
+		3001: {ServiceName: "http", Title: "Web"},
+		9000: {ServiceName: "tcp"},
+	}}
+
+	tests := []struct {
+		port int
+		want []string
+	}{
+		{3001, []string{"Port 3001 — open", "service:   http — Web", "mapped:    app.localhost"}},
+		{9000, []string{"Port 9000 — open", "manual:    yes (db)"}},
+		{9001, []string{"Port 9001 — closed"}},
+	}
+	for _, tt := range tests {
+		dp := s.CheckPort(tt.port)
+		info := buildPortInfo(tt.port, []DiscoveredPort{dp}, cs.Mappings(), cs.ManualPorts(), "localhost")
+		var b strings.Builder
+		printPortInfo(&b, info)
+		for _, want := range tt.want {
+			if !strings.Contains(b.String(), want) {
+				t.Errorf("info %d = %q, missing %q", tt.port, b.String(), want)
+			}
+		}
+		if strings.Contains(b.String(), "remote") {
+			t.Errorf("info %d lists a mapping to another host: %q", tt.port, b.String())
+		}
+	}
+}
+
+func TestListenProxyPorts(t *testing.T) {
+	busy, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	busyPort := busy.Addr().(*net.TCPAddr).Port
+	free, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	freePort := free.Addr().(*net.TCPAddr).Port
+	free.Close()
+
+	// One busy port in the list is skipped
+	lns, err := listenProxyPorts("", []int{busyPort, freePort})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lns) != 1 || lns[0].Addr().(*net.TCPAddr).Port != freePort {
+		t.Errorf("listeners = %v, want only :%d", lns, freePort)
+	}
+	for _, ln := range lns {
+		ln.Close()
+	}
+
+	// With nothing bound it fails
+	if _, err := listenProxyPorts("", []int{busyPort}); err == nil {
+		t.Error("listenProxyPorts succeeded with every port busy")
+	}
+
+	// A bind address restricts the interface
+	lns, err = listenProxyPorts("127.0.0.1", []int{freePort})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lns[0].Close()
+	if addr := lns[0].Addr().String(); addr != fmt.Sprintf("127.0.0.1:%d", freePort) {
+		t.Errorf("bound %s, want 127.0.0.1:%d", addr, freePort)
+	}
+}
+
+func TestParseBindHost(t *testing.T) {
+	tests := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"", "", true},
+		{"127.0.0.1", "127.0.0.1", true},
+		{" 0.0.0.0 ", "0.0.0.0", true},
+		{"::1", "::1", true},
+		{"[::1]", "::1", true},
+		{"localhost", "localhost", true},
+		{"127.0.0.1:8080", "", false},
+		{"[::1", "", false},
+		{"not a host", "", false},
+	}
+	for _, tt := range tests {
+		got, err := parseBindHost(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseBindHost(%q) = %q, %v; want %q, ok=%t", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+
+	for host, want := range map[string]string{
+		"":          "127.0.0.1:8080",
+		"0.0.0.0":   "127.0.0.1:8080",
+		"::":        "127.0.0.1:8080",
+		"127.0.0.1": "127.0.0.1:8080",
+		"::1":       "[::1]:8080",
+	} {
+		if got := localDialAddr(host, 8080); got != want {
+			t.Errorf("localDialAddr(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Histogram bucket bounds in seconds. Proxied requests use the Prometheus
+// client defaults; scans run longer, so their buckets reach further.
+var (
+	proxyDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	scanDurationBuckets  = []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+)
+
+// metrics holds the counters served at /metrics. Everything is updated with
+// atomics, so recording never takes a lock on the proxy or scanner path
+// beyond the first request for a new subdomain and status.
+var metrics = newMetricsRegistry()
+
+type metricsRegistry struct {
+	requests      sync.Map // requestKey → *atomic.Uint64
+	proxyDuration *histogram
+	scanDuration  *histogram
+}
+
+// requestKey labels portgate_proxy_requests_total.
+type requestKey struct {
+	subdomain string
+	status    int
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		proxyDuration: newHistogram(proxyDurationBuckets),
+		scanDuration:  newHistogram(scanDurationBuckets),
+	}
+}
+
+// observeProxyRequest counts one proxied request. subdomain is the mapping
+// that served it (the pattern, for wildcards), empty if none did.
+func (m *metricsRegistry) observeProxyRequest(subdomain string, status int, d time.Duration) {
+	key := requestKey{subdomain, status}
+	c, ok := m.requests.Load(key)
+	if !ok {
+		c, _ = m.requests.LoadOrStore(key, new(atomic.Uint64))
+	}
+	c.(*atomic.Uint64).Add(1)
+	m.proxyDuration.observe(d)
+}
+
+// observeScan records how long a full scan took.
+func (m *metricsRegistry) observeScan(d time.Duration) {
+	m.scanDuration.observe(d)
+}
+
+// histogram is a Prometheus histogram with fixed bucket bounds.
+type histogram struct {
+	bounds []float64
+	counts []atomic.Uint64 // per bucket, not cumulative; the last is +Inf
+	sumNs  atomic.Int64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]atomic.Uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i, _ := slices.BinarySearch(h.bounds, d.Seconds())
+	h.counts[i].Add(1)
+	h.sumNs.Add(int64(d))
+}
+
+// write renders the histogram in the text exposition format.
+func (h *histogram) write(w io.Writer, name, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	var total uint64
+	for i := range h.counts {
+		total += h.counts[i].Load()
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = formatMetricValue(h.bounds[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, le, total)
+	}
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatMetricValue(time.Duration(h.sumNs.Load()).Seconds()))
+	fmt.Fprintf(w, "%s_count %d\n", name, total)
+}
+
+// writeMetrics renders every metric in the Prometheus text exposition
+// format. Port and client gauges are read from the hub at scrape time.
+func (h *Hub) writeMetrics(w io.Writer) {
+	st := h.stats()
+	gauge := func(name, help string, v int64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, v)
+	}
+	gauge("portgate_discovered_ports", "Ports currently known, scanned and manual.", int64(st.Ports.Total))
+	gauge("portgate_healthy_ports", "Known ports that are healthy.", int64(st.Ports.Healthy))
+	gauge("portgate_ws_clients", "Connected dashboard WebSocket clients.", st.Clients)
+
+	var keys []requestKey
+	counts := make(map[requestKey]uint64)
+	metrics.requests.Range(func(k, v any) bool {
+		key := k.(requestKey)
+		keys = append(keys, key)
+		counts[key] = v.(*atomic.Uint64).Load()
+		return true
+	})
+	slices.SortFunc(keys, func(a, b requestKey) int {
+		return cmp.Or(strings.Compare(a.subdomain, b.subdomain), cmp.Compare(a.status, b.status))
+	})
+	fmt.Fprint(w, "# HELP portgate_proxy_requests_total Requests handled by the proxy, by mapping and status.\n# TYPE portgate_proxy_requests_total counter\n")
+	for _, k := range keys {
+		fmt.Fprintf(w, "portgate_proxy_requests_total{subdomain=\"%s\",status=\"%d\"} %d\n", escapeLabel(k.subdomain), k.status, counts[k])
+	}
+	metrics.proxyDuration.write(w, "portgate_proxy_request_duration_seconds", "Time to handle proxied requests.")
+	metrics.scanDuration.write(w, "portgate_scan_duration_seconds", "Time taken by full port scans.")
+}
+
+// MetricsMiddleware counts every request passing through next, by the
+// mapping that served it and the status it got.
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		metrics.observeProxyRequest(rec.subdomain, rec.statusCode(), time.Since(start))
+	})
+}
+
+// labelEscaper escapes label values as the exposition format requires.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatMetricValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics = newMetricsRegistry()
+	defer func() { metrics = newMetricsRegistry() }()
+
+	cs := newTestConfigStore(t)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer backend.Close()
+	cs.cfg.Mappings = []DomainMapping{{Domain: "app", TargetPort: listenerPort(t, backend)}}
+	hub := NewHub(cs)
+	hub.SetPorts([]DiscoveredPort{{Port: 3000, Healthy: true}, {Port: 3001}})
+
+	proxy := MetricsMiddleware(ProxyHandler(hub, "127.0.0.1:1"))
+	for _, path := range []string{"/", "/", "/missing"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = "app.localhost"
+		proxy.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	metrics.observeScan(300 * time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	DashboardHandler(hub, NewSessionStore()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"portgate_discovered_ports 2\n",
+		"portgate_healthy_ports 1\n",
+		"portgate_ws_clients 0\n",
+		`portgate_proxy_requests_total{subdomain="app",status="200"} 2` + "\n",
+		`portgate_proxy_requests_total{subdomain="app",status="404"} 1` + "\n",
+		`portgate_proxy_request_duration_seconds_bucket{le="+Inf"} 3` + "\n",
+		"portgate_proxy_request_duration_seconds_count 3\n",
+		`portgate_scan_duration_seconds_bucket{le="0.25"} 0` + "\n",
+		`portgate_scan_duration_seconds_bucket{le="0.5"} 1` + "\n",
+		"portgate_scan_duration_seconds_sum 0.3\n",
+		"# TYPE portgate_proxy_requests_total counter\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+}
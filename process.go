@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// processInfo identifies the process behind a listener.
+type processInfo struct {
+	Exe  string // executable path; empty if it couldn't be read
+	Name string // short name, e.g. from /proc/<pid>/comm or the exe basename
+}
+
+// exeProcessName derives a process name from an executable path: its
+// basename without a Windows .exe extension.
+func exeProcessName(exe string) string {
+	if exe == "" {
+		return ""
+	}
+	base := filepath.Base(exe)
+	if ext := filepath.Ext(base); strings.EqualFold(ext, ".exe") {
+		base = strings.TrimSuffix(base, ext)
+	}
+	return base
+}
+
+// listener is a LISTEN socket found in the kernel socket table. A dual-stack
+// service may show up twice for the same port, once per address family.
+type listener struct {
+	IP    net.IP
+	Port  int
+	Inode string // socket inode (Linux only)
+	PID   int    // owning process, if known from the socket table (Windows only)
+}
+
+// owner identifies the socket behind l: its inode on Linux, its owning PID on
+// Windows. A process that restarts on the same port gets a new one. It is
+// empty when the socket table didn't say.
+func (l listener) owner() string {
+	if l.Inode != "" {
+		return l.Inode
+	}
+	if l.PID != 0 {
+		return "pid " + strconv.Itoa(l.PID)
+	}
+	return ""
+}
+
+// Addr returns the listener's bound address as host:port.
+func (l listener) Addr() string {
+	return net.JoinHostPort(l.IP.String(), strconv.Itoa(l.Port))
+}
+
+// reachRank orders listeners by how directly the proxy reaches them. The
+// scanner and proxy dial 127.0.0.1, so IPv4 listeners win, then IPv6 sockets
+// bound to a v4-mapped address, then the IPv6 wildcard (which accepts v4
+// traffic unless the socket is v6-only), then everything else. IPv4 listeners
+// must carry 4-byte IPs so they can be told apart from v4-mapped ones.
+func reachRank(ip net.IP) int {
+	local := ip.IsLoopback() || ip.IsUnspecified()
+	switch {
+	case len(ip) == net.IPv4len && local:
+		return 0
+	case ip.To4() != nil && local:
+		return 1
+	case ip.Equal(net.IPv6unspecified):
+		return 2
+	}
+	return 3
+}
+
+// preferredListener picks the listener the proxy actually talks to when a
+// port is bound in several address families.
+func preferredListener(ls []listener) (listener, bool) {
+	if len(ls) == 0 {
+		return listener{}, false
+	}
+	best := ls[0]
+	for _, l := range ls[1:] {
+		if reachRank(l.IP) < reachRank(best.IP) {
+			best = l
+		}
+	}
+	return best, true
+}
+
+// listenAddrs returns the bound addresses of ls, preferred listener first.
+func listenAddrs(ls []listener) []string {
+	best, ok := preferredListener(ls)
+	if !ok {
+		return nil
+	}
+	out := []string{best.Addr()}
+	for _, l := range ls {
+		if a := l.Addr(); a != out[0] {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// bindError explains a failed listen on port by naming the process that
+// already owns it. It returns err unchanged if nothing is listening there.
+func bindError(port int, err error) error {
+	exe, pid, found := findPortOwner(port)
+	switch {
+	case !found:
+		return err
+	case exe == "" && pid == 0:
+		return fmt.Errorf("port %d is in use by another process (could not identify it; try running as root)", port)
+	case exe == "":
+		return fmt.Errorf("port %d is in use by pid %d", port, pid)
+	}
+	return fmt.Errorf("port %d is in use by %s (pid %d)", port, exe, pid)
+}
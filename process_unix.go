@@ -4,52 +4,180 @@ package main
 
 import (
 	"encoding/hex"
-	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
-// findExeByPort returns the executable path of the process listening on the given TCP port.
-// It reads /proc/net/tcp and /proc/net/tcp6 to find the socket inode, then walks
-// /proc/*/fd/ to find the owning PID, and resolves /proc/<pid>/exe.
-func findExeByPort(port int) string {
-	inode := findSocketInode(port)
-	if inode == "" {
-		return ""
+// processesForListeners resolves the process behind the preferred listener of
+// every port in one walk of /proc/*/fd/. The name comes from
+// /proc/<pid>/comm, which stays readable when the exe link isn't.
+func processesForListeners(byPort map[int][]listener) map[int]processInfo {
+	inodes := make(map[string]bool)
+	for _, ls := range byPort {
+		if l, ok := preferredListener(ls); ok {
+			inodes[l.Inode] = true
+		}
+	}
+	pids := findPIDsByInodes(inodes)
+
+	out := make(map[int]processInfo, len(byPort))
+	for port, ls := range byPort {
+		l, _ := preferredListener(ls)
+		pid := pids[l.Inode]
+		if pid == "" {
+			continue
+		}
+		var p processInfo
+		if exe, err := os.Readlink(filepath.Join("/proc", pid, "exe")); err == nil {
+			// Ignore deleted binaries marker
+			p.Exe = strings.TrimSuffix(exe, " (deleted)")
+		}
+		if comm, err := os.ReadFile(filepath.Join("/proc", pid, "comm")); err == nil {
+			p.Name = strings.TrimSpace(string(comm))
+		}
+		if p.Name == "" {
+			p.Name = exeProcessName(p.Exe)
+		}
+		if p.Exe != "" || p.Name != "" {
+			out[port] = p
+		}
 	}
-	pid := findPIDByInode(inode)
-	if pid == "" {
-		return ""
+	return out
+}
+
+// findPortOwner returns the executable and PID of the process listening on
+// port. found is false when nothing listens there; exe and pid may be empty
+// when the owner belongs to another user.
+func findPortOwner(port int) (exe string, pid int, found bool) {
+	l, ok := preferredListener(findListeners(port))
+	if !ok {
+		return "", 0, false
 	}
-	exe, err := os.Readlink(filepath.Join("/proc", pid, "exe"))
+	p := findPIDsByInodes(map[string]bool{l.Inode: true})[l.Inode]
+	if p == "" {
+		return "", 0, true
+	}
+	pid, _ = strconv.Atoi(p)
+	exe, _ = os.Readlink(filepath.Join("/proc", p, "exe"))
+	return strings.TrimSuffix(exe, " (deleted)"), pid, true
+}
+
+// clockTicks is USER_HZ, the unit of /proc/<pid>/stat times. Linux fixes it
+// at 100 on every architecture.
+const clockTicks = 100
+
+// processDetails returns the command line and start time of pid, each left
+// empty when it can't be read: another user's process, or no /proc.
+func processDetails(pid int) (cmdline string, started time.Time) {
+	dir := filepath.Join("/proc", strconv.Itoa(pid))
+	if data, err := os.ReadFile(filepath.Join(dir, "cmdline")); err == nil {
+		cmdline = strings.TrimSpace(strings.ReplaceAll(string(data), "\x00", " "))
+	}
+	stat, err := os.ReadFile(filepath.Join(dir, "stat"))
 	if err != nil {
-		return ""
+		return cmdline, time.Time{}
 	}
-	// Ignore deleted binaries marker
-	exe = strings.TrimSuffix(exe, " (deleted)")
-	return exe
+	return cmdline, procStartTime(string(stat), bootTime())
 }
 
-// findSocketInode searches /proc/net/tcp and /proc/net/tcp6 for a LISTEN socket
-// on the given port and returns its inode number as a string.
-func findSocketInode(port int) string {
-	for _, path := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
-		if inode := findInodeInFile(path, port); inode != "" {
-			return inode
-		}
+// procStartTime reads field 22 of a /proc/<pid>/stat line, the start time in
+// clock ticks after boot. Fields are counted from the closing parenthesis of
+// the command name, which may itself contain spaces.
+func procStartTime(stat string, boot time.Time) time.Time {
+	i := strings.LastIndexByte(stat, ')')
+	if i < 0 || boot.IsZero() {
+		return time.Time{}
+	}
+	fields := strings.Fields(stat[i+1:])
+	if len(fields) < 20 {
+		return time.Time{}
+	}
+	ticks, err := strconv.ParseInt(fields[19], 10, 64)
+	if err != nil {
+		return time.Time{}
 	}
-	return ""
+	return boot.Add(time.Duration(ticks) * time.Second / clockTicks)
 }
 
-func findInodeInFile(path string, port int) string {
-	data, err := os.ReadFile(path)
+// bootTime returns when the system booted, from the btime line of
+// /proc/stat, or the zero time if it can't be read.
+func bootTime() time.Time {
+	data, err := os.ReadFile("/proc/stat")
 	if err != nil {
-		return ""
+		return time.Time{}
 	}
-	lines := strings.Split(string(data), "\n")
-	for i, line := range lines {
+	for _, line := range strings.Split(string(data), "\n") {
+		if v, ok := strings.CutPrefix(line, "btime "); ok {
+			if sec, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+				return time.Unix(sec, 0)
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// findListeners returns every LISTEN socket on the given port from both
+// /proc/net/tcp and /proc/net/tcp6, so dual-stack services yield one entry
+// per address family.
+func findListeners(port int) []listener {
+	return findListenersByPorts([]int{port})[port]
+}
+
+// findListenersByPorts reads /proc/net/tcp and /proc/net/tcp6 once and
+// returns the LISTEN sockets of each requested port.
+func findListenersByPorts(ports []int) map[int][]listener {
+	want := make(map[int]bool, len(ports))
+	for _, p := range ports {
+		want[p] = true
+	}
+	out, _ := findListenersMatching(func(port int) bool { return want[port] })
+	return out
+}
+
+// findListenersMatching returns the LISTEN sockets of every port want accepts.
+// It fails if the socket table can't be read, e.g. on systems without /proc.
+func findListenersMatching(want func(port int) bool) (map[int][]listener, error) {
+	out := make(map[int][]listener)
+	var readErr error
+	read := 0
+	for _, path := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			readErr = err
+			continue
+		}
+		read++
+		for _, l := range parseProcNetListeners(string(data), want) {
+			out[l.Port] = append(out[l.Port], l)
+		}
+	}
+	if read == 0 {
+		return out, readErr
+	}
+	return out, nil
+}
+
+// parseProcNetTCP extracts LISTEN sockets on port from the contents of a
+// /proc/net/tcp or /proc/net/tcp6 file.
+func parseProcNetTCP(data string, port int) []listener {
+	return parseProcNetListeners(data, func(p int) bool { return p == port })
+}
+
+// parseProcNetListeners extracts LISTEN sockets on any port want accepts
+// from the contents of a /proc/net/tcp or /proc/net/tcp6 file.
+func parseProcNetListeners(data string, want func(port int) bool) []listener {
+	var out []listener
+	for i := 0; len(data) > 0; i++ {
+		line := data
+		if n := strings.IndexByte(data, '\n'); n >= 0 {
+			line, data = data[:n], data[n+1:]
+		} else {
+			data = ""
+		}
 		if i == 0 { // skip header
 			continue
 		}
@@ -66,25 +194,48 @@ func findInodeInFile(path string, port int) string {
 		if len(parts) != 2 {
 			continue
 		}
-		portHex := parts[1]
-		portBytes, err := hex.DecodeString(portHex)
+		portBytes, err := hex.DecodeString(parts[1])
 		if err != nil || len(portBytes) != 2 {
 			continue
 		}
 		localPort := int(portBytes[0])<<8 | int(portBytes[1])
-		if localPort == port {
-			return fields[9] // inode
+		if !want(localPort) {
+			continue
 		}
+		ip := parseProcNetIP(parts[0])
+		if ip == nil {
+			continue
+		}
+		out = append(out, listener{IP: ip, Port: localPort, Inode: fields[9]})
 	}
-	return ""
+	return out
 }
 
-// findPIDByInode walks /proc/*/fd/ looking for a symlink to socket:[inode].
-func findPIDByInode(inode string) string {
-	target := fmt.Sprintf("socket:[%s]", inode)
+// parseProcNetIP decodes the hex address column of /proc/net/tcp[6]. The
+// kernel prints each 32-bit word in host (little-endian) byte order, so IPv4
+// addresses are 8 hex digits reversed and IPv6 addresses are four such words.
+func parseProcNetIP(s string) net.IP {
+	b, err := hex.DecodeString(s)
+	if err != nil || (len(b) != net.IPv4len && len(b) != net.IPv6len) {
+		return nil
+	}
+	ip := make(net.IP, len(b))
+	for w := 0; w < len(b); w += 4 {
+		ip[w], ip[w+1], ip[w+2], ip[w+3] = b[w+3], b[w+2], b[w+1], b[w]
+	}
+	return ip
+}
+
+// findPIDsByInodes walks /proc/*/fd/ once and maps each wanted socket inode
+// to the PID holding it. The walk stops as soon as every inode is found.
+func findPIDsByInodes(inodes map[string]bool) map[string]string {
+	out := make(map[string]string, len(inodes))
+	if len(inodes) == 0 {
+		return out
+	}
 	entries, err := os.ReadDir("/proc")
 	if err != nil {
-		return ""
+		return out
 	}
 	for _, e := range entries {
 		if !e.IsDir() {
@@ -104,10 +255,18 @@ func findPIDByInode(inode string) string {
 			if err != nil {
 				continue
 			}
-			if link == target {
-				return e.Name()
+			inode, ok := strings.CutPrefix(link, "socket:[")
+			if !ok {
+				continue
+			}
+			inode = strings.TrimSuffix(inode, "]")
+			if inodes[inode] && out[inode] == "" {
+				out[inode] = e.Name()
+				if len(out) == len(inodes) {
+					return out
+				}
 			}
 		}
 	}
-	return ""
+	return out
 }
@@ -0,0 +1,285 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+const procNetHeader = "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+
+func TestParseProcNetTCP(t *testing.T) {
+	tcp := procNetHeader +
+		"   0: 00000000:0BB8 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 11111 1 0000000000000000 100 0 0 10 0\n" +
+		"   1: 0100007F:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 22222 1 0000000000000000 100 0 0 10 0\n" +
+		"   2: 0100007F:0BB8 0100007F:D431 01 00000000:00000000 00:00000000 00000000  1000        0 33333 1 0000000000000000 20 4 30 10 -1\n"
+	tcp6 := procNetHeader +
+		"   0: 00000000000000000000000000000000:0BB8 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 44444 1 0000000000000000 100 0 0 10 0\n" +
+		"   1: 00000000000000000000000001000000:1388 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 55555 1 0000000000000000 100 0 0 10 0\n" +
+		"   2: 0000000000000000FFFF00000100007F:1770 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 66666 1 0000000000000000 100 0 0 10 0\n"
+
+	tests := []struct {
+		name  string
+		data  string
+		port  int
+		addrs []string
+		inode []string
+	}{
+		{"v4 any", tcp, 3000, []string{"0.0.0.0:3000"}, []string{"11111"}},
+		{"v4 loopback", tcp, 8080, []string{"127.0.0.1:8080"}, []string{"22222"}},
+		{"v6 any", tcp6, 3000, []string{"[::]:3000"}, []string{"44444"}},
+		{"v6 loopback", tcp6, 5000, []string{"[::1]:5000"}, []string{"55555"}},
+		{"v4-mapped", tcp6, 6000, []string{"127.0.0.1:6000"}, []string{"66666"}},
+		{"no listener", tcp6, 9999, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ls := parseProcNetTCP(tt.data, tt.port)
+			var addrs, inodes []string
+			for _, l := range ls {
+				addrs = append(addrs, l.Addr())
+				inodes = append(inodes, l.Inode)
+			}
+			if !reflect.DeepEqual(addrs, tt.addrs) {
+				t.Errorf("addrs = %v, want %v", addrs, tt.addrs)
+			}
+			if !reflect.DeepEqual(inodes, tt.inode) {
+				t.Errorf("inodes = %v, want %v", inodes, tt.inode)
+			}
+		})
+	}
+}
+
+func TestPreferredListenerDualStack(t *testing.T) {
+	v4 := parseProcNetTCP(procNetHeader+
+		"   0: 00000000:0BB8 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 11111 1 0000000000000000 100 0 0 10 0\n", 3000)
+	v6 := parseProcNetTCP(procNetHeader+
+		"   0: 00000000000000000000000000000000:0BB8 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 44444 1 0000000000000000 100 0 0 10 0\n"+
+		"   1: 00000000000000000000000001000000:0BB8 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 55555 1 0000000000000000 100 0 0 10 0\n", 3000)
+
+	tests := []struct {
+		name  string
+		ls    []listener
+		inode string
+		addrs []string
+	}{
+		// tcp6 listed first must not win over the IPv4 socket the proxy dials
+		{"v6 before v4", append(append([]listener{}, v6...), v4...), "11111",
+			[]string{"0.0.0.0:3000", "[::]:3000", "[::1]:3000"}},
+		{"v6 only", v6, "44444", []string{"[::]:3000", "[::1]:3000"}},
+		{"v6 loopback only", v6[1:], "55555", []string{"[::1]:3000"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, ok := preferredListener(tt.ls)
+			if !ok || l.Inode != tt.inode {
+				t.Errorf("preferredListener inode = %q, want %q", l.Inode, tt.inode)
+			}
+			if got := listenAddrs(tt.ls); !reflect.DeepEqual(got, tt.addrs) {
+				t.Errorf("listenAddrs = %v, want %v", got, tt.addrs)
+			}
+		})
+	}
+}
+
+// listenN opens n loopback listeners and returns their ports.
+func listenN(tb testing.TB, n int) []int {
+	tb.Helper()
+	ports := make([]int, n)
+	for i := range ports {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			tb.Fatal(err)
+		}
+		tb.Cleanup(func() { ln.Close() })
+		ports[i] = ln.Addr().(*net.TCPAddr).Port
+	}
+	return ports
+}
+
+func TestProcessesForListeners(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("exe resolution test relies on /proc")
+	}
+	ports := listenN(t, 3)
+	want, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	procs := processesForListeners(findListenersByPorts(append(ports, 1))) // port 1 has no listener
+	for _, port := range ports {
+		single := processesForListeners(findListenersByPorts([]int{port}))[port].Exe
+		if procs[port].Exe != want || single != want {
+			t.Errorf("port %d: batch %q, single %q, want %q", port, procs[port].Exe, single, want)
+		}
+	}
+	if p, ok := procs[1]; ok {
+		t.Errorf("unbound port resolved to %+v", p)
+	}
+}
+
+func TestProcessNames(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("process name test relies on /proc")
+	}
+	port := listenN(t, 1)[0]
+	comm, err := os.ReadFile("/proc/self/comm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	procs := processesForListeners(findListenersByPorts([]int{port}))
+	if got, want := procs[port].Name, strings.TrimSpace(string(comm)); got != want {
+		t.Errorf("name = %q, want %q", got, want)
+	}
+
+	for exe, want := range map[string]string{
+		"/usr/bin/node":       "node",
+		"/opt/app/server.exe": "server",
+		"/opt/app/Server.EXE": "Server",
+		"/opt/app/manage.py":  "manage.py",
+		"":                    "",
+	} {
+		if got := exeProcessName(exe); got != want {
+			t.Errorf("exeProcessName(%q) = %q, want %q", exe, got, want)
+		}
+	}
+}
+
+func TestBindErrorNamesOwner(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("owner resolution test relies on /proc")
+	}
+	port := listenN(t, 1)[0]
+	_, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err == nil {
+		t.Fatal("second listen succeeded")
+	}
+	exe, _ := os.Executable()
+	want := fmt.Sprintf("port %d is in use by %s (pid %d)", port, exe, os.Getpid())
+	if got := bindError(port, err).Error(); got != want {
+		t.Errorf("bindError = %q, want %q", got, want)
+	}
+}
+
+// BenchmarkResolveProcesses compares resolving many ports one at a time,
+// which reads the socket table and walks every process per port, with the
+// batch lookup the scanner uses.
+func BenchmarkResolveProcesses(b *testing.B) {
+	if runtime.GOOS != "linux" {
+		b.Skip("exe resolution relies on /proc")
+	}
+	ports := listenN(b, 50)
+	b.Run("per-port", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, port := range ports {
+				processesForListeners(findListenersByPorts([]int{port}))
+			}
+		}
+	})
+	b.Run("batch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			processesForListeners(findListenersByPorts(ports))
+		}
+	})
+}
+
+func TestKernelScanMode(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("kernel scan mode test relies on /proc")
+	}
+	ports := listenN(t, 2)
+	cs := newTestConfigStore(t)
+	cs.cfg.ScanMode = scanModeKernel
+	cs.cfg.ScanRanges = []ScanRange{{Start: ports[0], End: ports[0]}}
+	cs.cfg.ManualPorts = []ManualPort{{Port: ports[1], Name: "manual"}}
+
+	s := NewScanner(time.Second, cs, nil)
+	// The fake prober reports every port closed, so only the kernel's
+	// socket table can find them
+	s.prober = fakeProber{}
+	got := s.scan()
+
+	exe, _ := os.Executable()
+	if len(got) != 2 {
+		t.Fatalf("scan found %+v, want ports %v", got, ports)
+	}
+	for i, dp := range got {
+		if dp.Port != ports[i] || !dp.Healthy || dp.ExePath != exe {
+			t.Errorf("port %d: %+v, want healthy and owned by %s", ports[i], dp, exe)
+		}
+	}
+}
+
+func TestResolveProcessesCache(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("exe resolution test relies on /proc")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	want, _ := os.Executable()
+	s := NewScanner(time.Second, newTestConfigStore(t), nil)
+	if got := s.resolveProcesses([]int{port}, nil)[port].exe; got != want {
+		t.Fatalf("exe = %q, want %q", got, want)
+	}
+
+	// The same socket is served from the cache, without walking /proc
+	s.exeMu.Lock()
+	e := s.exeCache[port]
+	e.exe = "cached"
+	s.exeCache[port] = e
+	s.exeMu.Unlock()
+	if got := s.resolveProcesses([]int{port}, nil)[port].exe; got != "cached" {
+		t.Errorf("same socket: exe = %q, want the cached entry", got)
+	}
+
+	// A new socket on the port is looked up again
+	ln.Close()
+	ln, err = net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Skipf("could not rebind port %d: %v", port, err)
+	}
+	defer ln.Close()
+	if got := s.resolveProcesses([]int{port}, nil)[port].exe; got != want {
+		t.Errorf("new socket: exe = %q, want %q", got, want)
+	}
+
+	// Closed ports are forgotten
+	s.forgetProcesses(nil)
+	if len(s.exeCache) != 0 {
+		t.Errorf("cache after forget = %v", s.exeCache)
+	}
+}
+
+func TestProcessDetails(t *testing.T) {
+	boot := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	stat := "4242 (my (odd) app) S 1 4242 4242 0 -1 4194560 100 0 0 0 5 3 0 0 20 0 1 0 12345 1000000 200"
+	if got, want := procStartTime(stat, boot), boot.Add(123450*time.Millisecond); !got.Equal(want) {
+		t.Errorf("procStartTime = %v, want %v", got, want)
+	}
+	if got := procStartTime("4242 (truncated", boot); !got.IsZero() {
+		t.Errorf("malformed stat: %v", got)
+	}
+
+	if runtime.GOOS != "linux" {
+		t.Skip("process details rely on /proc")
+	}
+	cmdline, started := processDetails(os.Getpid())
+	if !strings.Contains(cmdline, os.Args[0]) {
+		t.Errorf("cmdline = %q, want it to contain %q", cmdline, os.Args[0])
+	}
+	if started.IsZero() || started.After(time.Now()) || time.Since(started) > time.Hour {
+		t.Errorf("started = %v, want shortly before now", started)
+	}
+}
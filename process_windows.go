@@ -3,60 +3,131 @@
 package main
 
 import (
-	"fmt"
+	"net"
 	"os/exec"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
-// findExeByPort returns the executable path of the process listening on the given TCP port.
-// It uses netstat to find the PID and then queries the Windows API for the process path.
-func findExeByPort(port int) string {
-	pid := findPIDByPort(port)
-	if pid == 0 {
-		return ""
+// processesForListeners resolves the process behind the preferred listener
+// of every port, querying each owning PID once. The name is the image
+// basename.
+func processesForListeners(byPort map[int][]listener) map[int]processInfo {
+	exes := make(map[int]string)
+	out := make(map[int]processInfo, len(byPort))
+	for port, ls := range byPort {
+		l, ok := preferredListener(ls)
+		if !ok || l.PID == 0 {
+			continue
+		}
+		exe, seen := exes[l.PID]
+		if !seen {
+			exe = getProcessExePath(l.PID)
+			exes[l.PID] = exe
+		}
+		if exe != "" {
+			out[port] = processInfo{Exe: exe, Name: exeProcessName(exe)}
+		}
 	}
-	return getProcessExePath(pid)
+	return out
 }
 
-// findPIDByPort runs netstat -ano and finds the PID for a LISTENING socket on the given port.
-func findPIDByPort(port int) int {
+// findPortOwner returns the executable and PID of the process listening on
+// port. found is false when nothing listens there; exe may be empty when the
+// process can't be opened.
+func findPortOwner(port int) (exe string, pid int, found bool) {
+	l, ok := preferredListener(findListeners(port))
+	if !ok {
+		return "", 0, false
+	}
+	if l.PID == 0 {
+		return "", 0, true
+	}
+	return getProcessExePath(l.PID), l.PID, true
+}
+
+// findListeners runs netstat -ano and returns every LISTENING socket on the
+// given port, one per address family for dual-stack services.
+func findListeners(port int) []listener {
+	return findListenersByPorts([]int{port})[port]
+}
+
+// findListenersByPorts runs netstat -ano once and returns the LISTENING
+// sockets of each requested port.
+func findListenersByPorts(ports []int) map[int][]listener {
+	want := make(map[int]bool, len(ports))
+	for _, p := range ports {
+		want[p] = true
+	}
+	byPort, err := findListenersMatching(func(port int) bool { return want[port] })
+	if err != nil {
+		return nil
+	}
+	return byPort
+}
+
+// findListenersMatching runs netstat -ano once and returns the LISTENING
+// sockets of every port want accepts.
+func findListenersMatching(want func(port int) bool) (map[int][]listener, error) {
 	out, err := exec.Command("netstat", "-ano").Output()
 	if err != nil {
-		return 0
+		return nil, err
+	}
+	byPort := make(map[int][]listener)
+	for _, l := range parseNetstatListeners(string(out), want) {
+		byPort[l.Port] = append(byPort[l.Port], l)
 	}
-	needle := fmt.Sprintf(":%d ", port)
-	for _, line := range strings.Split(string(out), "\n") {
+	return byPort, nil
+}
+
+// parseNetstat extracts LISTENING TCP sockets on port from netstat -ano output.
+func parseNetstat(out string, port int) []listener {
+	return parseNetstatListeners(out, func(p int) bool { return p == port })
+}
+
+// parseNetstatListeners extracts LISTENING TCP sockets on any port want
+// accepts from netstat -ano output.
+func parseNetstatListeners(out string, want func(port int) bool) []listener {
+	var ls []listener
+	for _, line := range strings.Split(out, "\n") {
 		line = strings.TrimSpace(line)
 		if !strings.Contains(line, "LISTENING") {
 			continue
 		}
-		if !strings.Contains(line, needle) {
-			continue
-		}
 		fields := strings.Fields(line)
 		if len(fields) < 5 {
 			continue
 		}
 		// Verify the local address column matches our port exactly
-		addr := fields[1]
-		parts := strings.Split(addr, ":")
-		if len(parts) < 2 {
+		host, portStr, err := net.SplitHostPort(fields[1])
+		if err != nil {
 			continue
 		}
-		p, err := strconv.Atoi(parts[len(parts)-1])
-		if err != nil || p != port {
+		p, err := strconv.Atoi(portStr)
+		if err != nil || !want(p) {
 			continue
 		}
+		// Drop IPv6 zone ("fe80::1%4")
+		if i := strings.IndexByte(host, '%'); i != -1 {
+			host = host[:i]
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			continue
+		}
+		if ip4 := ip.To4(); ip4 != nil && !strings.Contains(host, ":") {
+			ip = ip4
+		}
 		pid, err := strconv.Atoi(fields[len(fields)-1])
 		if err != nil {
 			continue
 		}
-		return pid
+		ls = append(ls, listener{IP: ip, Port: p, PID: pid})
 	}
-	return 0
+	return ls
 }
 
 var (
@@ -64,6 +135,25 @@ var (
 	procQueryFullProcessName = modKernel32.NewProc("QueryFullProcessImageNameW")
 )
 
+// processDetails returns the start time of pid. The command line is left
+// empty: reading another process's means walking its PEB, which isn't worth
+// it for a diagnostic.
+func processDetails(pid int) (cmdline string, started time.Time) {
+	const PROCESS_QUERY_LIMITED_INFORMATION = 0x1000
+
+	handle, err := syscall.OpenProcess(PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return "", time.Time{}
+	}
+	defer syscall.CloseHandle(handle)
+
+	var creation, exit, kernel, user syscall.Filetime
+	if err := syscall.GetProcessTimes(handle, &creation, &exit, &kernel, &user); err != nil {
+		return "", time.Time{}
+	}
+	return "", time.Unix(0, creation.Nanoseconds())
+}
+
 // getProcessExePath returns the full image path for the given PID using the Windows API.
 func getProcessExePath(pid int) string {
 	const PROCESS_QUERY_LIMITED_INFORMATION = 0x1000
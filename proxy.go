@@ -1,45 +1,302 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"html/template"
 	"io"
 	"log"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// parsePage parses an embedded status page together with static/page.html,
+// which holds the stylesheet the pages share.
+func parsePage(name string) *template.Template {
+	return template.Must(template.ParseFS(staticFS, "static/"+name, "static/page.html"))
+}
+
+var maintenanceTmpl = parsePage("maintenance.html")
+
+// startingTmpl is served when a backend is still down after its startup grace period.
+var startingTmpl = parsePage("starting.html")
+
+// dashboardUnavailableTmpl is served when the dashboard server can't be reached.
+var dashboardUnavailableTmpl = parsePage("unavailable.html")
+
+// defaultErrorPageTmpl is served when a mapping's backend can't be reached and
+// no errorPageTemplate is configured.
+var defaultErrorPageTmpl = parsePage("error.html")
+
+// errorPageTmpl is the configured error page, set by applyRuntimeConfig.
+var errorPageTmpl atomic.Pointer[template.Template]
+
+// defaultNotFoundPageTmpl is served for a subdomain with no mapping when no
+// notFoundPageTemplate is configured.
+var defaultNotFoundPageTmpl = parsePage("notfound.html")
+
+// notFoundPageTmpl is the configured unknown-domain page, set by
+// applyRuntimeConfig.
+var notFoundPageTmpl atomic.Pointer[template.Template]
+
+// errorPageData is what the error page template is rendered with.
+type errorPageData struct {
+	Status     int    // 502 or 504
+	StatusText string // "Bad Gateway" or "Gateway Timeout"
+	Category   string // see backendErrorCategory
+	Domain     string // the mapping the request was routed by
+	Target     string // backend address, host:port
+	Port       string // backend port
+	Dashboard  string // dashboard URL
+}
+
+// notFoundPageData is what the unknown-domain page template is rendered with.
+type notFoundPageData struct {
+	Status      int           // always 404
+	StatusText  string        // "Not Found"
+	Domain      string        // the subdomain that has no mapping
+	Host        string        // the full requested host
+	Suggestions []mappingLink // mappings whose names are close to Domain
+	Mappings    []mappingLink // every mapping that can be linked to
+	Dashboard   string        // dashboard URL
+}
+
+// mappingLink is a mapping as listed on the unknown-domain page.
+type mappingLink struct {
+	Domain string
+	URL    string
+}
+
+// loadErrorPage parses the error page template at path, or returns the
+// embedded one if path is empty.
+func loadErrorPage(path string) (*template.Template, error) {
+	return loadPageTemplate("errorPageTemplate", path, defaultErrorPageTmpl)
+}
+
+// loadNotFoundPage parses the unknown-domain page template at path, or
+// returns the embedded one if path is empty.
+func loadNotFoundPage(path string) (*template.Template, error) {
+	return loadPageTemplate("notFoundPageTemplate", path, defaultNotFoundPageTmpl)
+}
+
+// loadPageTemplate parses the template file at path for the config field
+// named field, or returns def if path is empty.
+func loadPageTemplate(field, path string, def *template.Template) (*template.Template, error) {
+	if path == "" {
+		return def, nil
+	}
+	t, err := template.ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", field, err)
+	}
+	return t, nil
+}
+
+func setErrorPage(t *template.Template) {
+	errorPageTmpl.Store(t)
+}
+
+func setNotFoundPage(t *template.Template) {
+	notFoundPageTmpl.Store(t)
+}
+
+// serveProxyError responds to a failed backend round trip with the error
+// page. WebSocket upgrades get plain text since browsers won't render a body,
+// and a template that fails to render falls back to the embedded page.
+func serveProxyError(w http.ResponseWriter, r *http.Request, data errorPageData) {
+	data.StatusText = http.StatusText(data.Status)
+	if isWebSocketUpgrade(r) {
+		http.Error(w, fmt.Sprintf("%d %s", data.Status, data.StatusText), data.Status)
+		return
+	}
+	if _, port, err := net.SplitHostPort(data.Target); err == nil {
+		data.Port = port
+	}
+	errorPage(w, data.Status, errorPageTmpl.Load(), defaultErrorPageTmpl, data)
+}
+
+// serveUnknownDomain responds to a request for a subdomain with no mapping
+// with a 404 page naming it, suggesting mappings with similar names and
+// listing the rest.
+func serveUnknownDomain(w http.ResponseWriter, r *http.Request, cs *ConfigStore, subdomain, suffix string) {
+	if isWebSocketUpgrade(r) {
+		http.Error(w, "404 Not Found: no mapping for "+subdomain, http.StatusNotFound)
+		return
+	}
+	var domains []string
+	for _, m := range cs.Mappings() {
+		if !m.System && !isWildcardDomain(m.Domain) {
+			domains = append(domains, m.Domain)
+		}
+	}
+	slices.Sort(domains)
+	link := func(domain string) mappingLink {
+		return mappingLink{Domain: domain, URL: subdomainURL(r, domain, suffix)}
+	}
+	data := notFoundPageData{
+		Status:     http.StatusNotFound,
+		StatusText: http.StatusText(http.StatusNotFound),
+		Domain:     subdomain,
+		Host:       subdomain + "." + suffix,
+		Dashboard:  dashboardURL(r, suffix),
+	}
+	for _, d := range suggestMappings(subdomain, domains) {
+		data.Suggestions = append(data.Suggestions, link(d))
+	}
+	for _, d := range domains {
+		data.Mappings = append(data.Mappings, link(d))
+	}
+	errorPage(w, data.Status, notFoundPageTmpl.Load(), defaultNotFoundPageTmpl, data)
+}
+
+// errorPage renders t with data as an HTML response with the given status.
+// A nil t means def, and a template that fails to render falls back to def
+// so the client still gets a page.
+func errorPage(w http.ResponseWriter, status int, t, def *template.Template, data any) {
+	if t == nil {
+		t = def
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		log.Printf("error page: %v", err)
+		buf.Reset()
+		def.Execute(&buf, data)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+// suggestMappings returns up to three of domains that look like what name
+// was meant to be: a few typos away, or one containing the other. The
+// closest come first.
+func suggestMappings(name string, domains []string) []string {
+	type match struct {
+		domain string
+		dist   int
+	}
+	var matches []match
+	limit := max(1, len(name)/3)
+	for _, d := range domains {
+		dist := editDistance(name, d)
+		contains := min(len(name), len(d)) >= 3 && (strings.Contains(d, name) || strings.Contains(name, d))
+		if dist <= limit || contains {
+			matches = append(matches, match{d, dist})
+		}
+	}
+	slices.SortStableFunc(matches, func(a, b match) int { return a.dist - b.dist })
+	var out []string
+	for _, m := range matches[:min(len(matches), 3)] {
+		out = append(out, m.domain)
+	}
+	return out
+}
+
+// editDistance returns the number of single-character insertions,
+// deletions, substitutions and adjacent swaps that turn a into b.
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+// dashboardURL returns the dashboard's address as seen by the client that
+// sent r: the reserved portgate subdomain on the same scheme and port.
+func dashboardURL(r *http.Request, suffix string) string {
+	return subdomainURL(r, "portgate", suffix)
+}
+
+// subdomainURL returns the root URL of name under suffix as seen by the
+// client that sent r, on the same scheme and port.
+func subdomainURL(r *http.Request, name, suffix string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host := name + "." + suffix
+	if _, port, err := net.SplitHostPort(r.Host); err == nil {
+		host = net.JoinHostPort(host, port)
+	}
+	return scheme + "://" + host + "/"
+}
+
 // ProxyHandler returns an http.Handler that reverse-proxies based on Host header
 // (subdomain routing) and URL path (path-based routing for external access).
 // Reserved subdomains: "portgate" → dashboard, bare "localhost" → dashboard.
+// Wildcard mappings ("*.app") route any deeper subdomain, and the original
+// Host header is forwarded so the backend can read the tenant label.
 func ProxyHandler(hub *Hub, dashboardAddr string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if limit := hub.config.MaxConnsPerIP(); limit > 0 {
+			ip := clientIP(r)
+			if parsed := net.ParseIP(ip); parsed == nil || !parsed.IsLoopback() && !isTrustedProxy(parsed) {
+				if !hub.acquireConn(ip, limit) {
+					http.Error(w, "503 Service Unavailable: too many connections", http.StatusServiceUnavailable)
+					return
+				}
+				slot := &connSlot{release: func() { hub.releaseConn(ip) }}
+				defer slot.done()
+				r = r.WithContext(context.WithValue(r.Context(), connSlotKey{}, slot))
+			}
+		}
+
 		host := r.Host
 		// Strip port if present
 		if h, _, err := net.SplitHostPort(host); err == nil {
 			host = h
 		}
+		// Hostnames are case-insensitive and may carry a trailing FQDN dot
+		host = strings.TrimSuffix(strings.ToLower(host), ".")
 
 		suffix := hub.config.DomainSuffix()
 		subdomain := extractSubdomain(host, suffix)
 
 		// If subdomain routing matched, use it
-		if subdomain != "" && subdomain != "portgate" {
-			port := hub.config.LookupPort(subdomain)
-			if port != 0 {
-				proxyToPort(w, r, subdomain, port, "")
+		if subdomain != "" && !isReservedDomain(subdomain) {
+			if m, ok := hub.config.ResolveMapping(subdomain); ok {
+				proxyToMapping(w, r, hub, m, "")
 				return
 			}
 		}
 
 		// Try path-based routing: /{domain-name}/rest/of/path
 		if pathDomain, remaining := extractPathDomain(r.URL.Path); pathDomain != "" {
-			port := hub.config.LookupPort(pathDomain)
-			if port != 0 {
-				proxyToPort(w, r, pathDomain, port, remaining)
+			if m, ok := hub.config.LookupMapping(pathDomain); ok {
+				if redirectTrailingSlash(w, r, hub.config, "/"+pathDomain) {
+					return
+				}
+				// Tell the backend which prefix was stripped, after any an
+				// upstream proxy stripped before us
+				r.Header.Set("X-Forwarded-Prefix", forwardedPrefix(r)+"/"+pathDomain)
+				proxyToMapping(w, r, hub, m, remaining)
 				return
 			}
 		}
@@ -51,20 +308,86 @@ func ProxyHandler(hub *Hub, dashboardAddr string) http.Handler {
 		if referer := r.Header.Get("Referer"); referer != "" {
 			if refURL, err := url.Parse(referer); err == nil {
 				if refDomain, _ := extractPathDomain(refURL.Path); refDomain != "" {
-					port := hub.config.LookupPort(refDomain)
-					if port != 0 {
-						proxyToPort(w, r, refDomain, port, r.URL.Path)
+					if m, ok := hub.config.LookupMapping(refDomain); ok {
+						proxyToMapping(w, r, hub, m, r.URL.Path)
 						return
 					}
 				}
 			}
 		}
 
+		// A subdomain with no mapping gets a 404 saying so, not the dashboard
+		if subdomain != "" && !isReservedDomain(subdomain) {
+			serveUnknownDomain(w, r, hub.config, subdomain, suffix)
+			return
+		}
+
 		// Everything else → dashboard
 		proxyToDashboard(w, r, dashboardAddr)
 	})
 }
 
+// redirectTrailingSlash redirects a GET or HEAD for the bare mount point of a
+// path-routed mapping to its configured trailing-slash form, and reports
+// whether it did. Anything below the mount point is the backend's own URL
+// space and is passed on as is.
+func redirectTrailingSlash(w http.ResponseWriter, r *http.Request, cs *ConfigStore, mount string) bool {
+	mode, _ := cs.TrailingSlashMode()
+	if mode == "" || r.Method != http.MethodGet && r.Method != http.MethodHead || isWebSocketUpgrade(r) {
+		return false
+	}
+	target := trailingSlashTarget(r.URL.Path, mount, mode)
+	if target == "" {
+		return false
+	}
+	u := *r.URL
+	u.Path, u.RawPath = target, ""
+	http.Redirect(w, r, u.RequestURI(), http.StatusTemporaryRedirect)
+	return true
+}
+
+// trailingSlashTarget returns the mount point ("/app") with its trailing
+// slash added or removed per mode if path is the mount point in the other
+// form, or "" otherwise.
+func trailingSlashTarget(path, mount, mode string) string {
+	switch {
+	case mode == trailingSlashAdd && path == mount:
+		return mount + "/"
+	case mode == trailingSlashRemove && path == mount+"/":
+		return mount
+	}
+	return ""
+}
+
+// connSlot is a per-IP connection count held for the life of a proxied
+// request. Hijacked WebSocket connections take it over so the slot is held
+// until the tunnel closes rather than when the handler returns.
+type connSlot struct {
+	release   func()
+	handedOff bool
+}
+
+type connSlotKey struct{}
+
+// done releases the slot unless a WebSocket tunnel took it over.
+func (s *connSlot) done() {
+	if !s.handedOff {
+		s.release()
+	}
+}
+
+// takeConnSlot hands the request's connection slot to the caller, who must
+// call the returned func when the connection ends. Returns a no-op if the
+// request isn't being counted.
+func takeConnSlot(r *http.Request) func() {
+	s, ok := r.Context().Value(connSlotKey{}).(*connSlot)
+	if !ok {
+		return func() {}
+	}
+	s.handedOff = true
+	return s.release
+}
+
 // extractPathDomain extracts the first path segment as a potential domain name.
 // Returns the domain and the remaining path (with leading /).
 // e.g. "/myapp/api/data" → ("myapp", "/api/data")
@@ -85,40 +408,156 @@ func extractPathDomain(path string) (string, string) {
 	return domain, remaining
 }
 
-// proxyToPort reverse-proxies to the given port, optionally rewriting the path.
-// If pathPrefix is non-empty, the request URL path is set to that value
-// (stripping the domain-name prefix used in path-based routing).
-func proxyToPort(w http.ResponseWriter, r *http.Request, name string, port int, rewritePath string) {
-	target := fmt.Sprintf("127.0.0.1:%d", port)
+// proxyToMapping reverse-proxies to the mapping's target port, optionally
+// rewriting the path. If rewritePath is non-empty, the request URL path is set
+// to that value (stripping the domain-name prefix used in path-based routing).
+// The mapping's stripPrefix is then removed from the path when present.
+// Mappings in maintenance mode get a 503 instead of being proxied.
+func proxyToMapping(w http.ResponseWriter, r *http.Request, hub *Hub, m DomainMapping, rewritePath string) {
+	if m.Maintenance {
+		serveMaintenance(w, r, m.Domain, hub.config.MaintenanceRetryAfter())
+		return
+	}
+
+	if msg := modeRejection(m.Mode, isWebSocketUpgrade(r)); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	name := m.Domain
+	target := hub.config.BackendAddr(m)
+	annotateRoute(w, name, target)
+
+	// Within the startup grace period, wait for a booting backend to accept
+	// connections rather than failing straight away.
+	if deadline := hub.config.StartupDeadline(m); time.Now().Before(deadline) {
+		if !waitForBackend(r.Context(), target, deadline) {
+			serveStarting(w, r, name)
+			return
+		}
+	}
+
+	// Strip the mapping's prefix; a path without it is passed on unchanged
+	var stripped string
+	if m.StripPrefix != "" {
+		path := rewritePath
+		if path == "" {
+			path = r.URL.Path
+		}
+		if rest, ok := stripPathPrefix(path, m.StripPrefix); ok {
+			rewritePath, stripped = rest, m.StripPrefix
+		}
+	}
 
 	// WebSocket upgrade detection
 	if isWebSocketUpgrade(r) {
 		if rewritePath != "" {
 			r.URL.Path = rewritePath
 		}
-		handleWebSocket(w, r, target)
+		handleWebSocket(w, r, target, hub.config.WebSocketIdleTimeout(m))
 		return
 	}
 
-	// Regular HTTP reverse proxy
-	proxyURL, _ := url.Parse(fmt.Sprintf("http://%s", target))
-	proxy := &httputil.ReverseProxy{
-		Director: func(req *http.Request) {
-			req.URL.Scheme = proxyURL.Scheme
-			req.URL.Host = proxyURL.Host
-			req.Host = r.Host
-			if rewritePath != "" {
-				req.URL.Path = rewritePath
-				// Preserve query string
-				req.URL.RawQuery = r.URL.RawQuery
-			}
-		},
-		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
-			log.Printf("proxy error for %s: %v", name, err)
-			http.Error(w, "502 Bad Gateway", http.StatusBadGateway)
-		},
+	// Regular HTTP reverse proxy, shared per target so connections are reused
+	r = withBackendRoute(r, &backendRoute{
+		name:           name,
+		modify:         rewriteResponse(m.ResponseRewrite),
+		http2:          m.BackendHTTP2,
+		dashboard:      dashboardURL(r, hub.config.DomainSuffix()),
+		strippedPrefix: stripped,
+	})
+	if rewritePath != "" {
+		// r is now a shallow copy, so the caller's URL is left alone
+		u := *r.URL
+		u.Path = rewritePath
+		u.RawPath = ""
+		r.URL = &u
 	}
-	proxy.ServeHTTP(w, r)
+	backendProxies.get(target).ServeHTTP(w, r)
+}
+
+// stripPathPrefix removes prefix from path if path is the prefix or lies
+// beneath it, so "/v1" strips "/v1" and "/v1/users" but not "/v1x". The
+// result always starts with "/".
+func stripPathPrefix(path, prefix string) (string, bool) {
+	rest, ok := strings.CutPrefix(path, prefix)
+	if !ok || rest != "" && rest[0] != '/' {
+		return path, false
+	}
+	if rest == "" {
+		rest = "/"
+	}
+	return rest, true
+}
+
+// modeRejection returns why a mapping with mode refuses a request, or "" if
+// the request is allowed.
+func modeRejection(mode string, upgrade bool) string {
+	switch {
+	case mode == mappingModeHTTPOnly && upgrade:
+		return "400 Bad Request: this mapping does not accept WebSocket upgrades"
+	case mode == mappingModeWSOnly && !upgrade:
+		return "400 Bad Request: this mapping only accepts WebSocket upgrades"
+	}
+	return ""
+}
+
+// startupPollInterval is how often waitForBackend retries the backend.
+const startupPollInterval = 250 * time.Millisecond
+
+// waitForBackend dials target until it accepts a connection, the deadline
+// passes, or ctx is done. It reports whether the backend came up.
+func waitForBackend(ctx context.Context, target string, deadline time.Time) bool {
+	for {
+		dialCtx, cancel := context.WithTimeout(ctx, startupPollInterval)
+		conn, err := dialBackend(dialCtx, target)
+		cancel()
+		if err == nil {
+			conn.Close()
+			return true
+		}
+		wait := startupPollInterval
+		if left := time.Until(deadline); left < wait {
+			wait = left
+		}
+		if wait <= 0 {
+			return false
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(wait):
+		}
+	}
+}
+
+// serveStarting responds with 503 and a self-refreshing "starting up" page.
+func serveStarting(w http.ResponseWriter, r *http.Request, domain string) {
+	w.Header().Set("Retry-After", "2")
+	if isWebSocketUpgrade(r) {
+		http.Error(w, "503 Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	startingTmpl.Execute(w, struct{ Domain string }{domain})
+}
+
+// serveMaintenance responds with 503 and the embedded maintenance page.
+// WebSocket upgrades get a plain 503 since browsers won't render a body.
+func serveMaintenance(w http.ResponseWriter, r *http.Request, domain string, retryAfter time.Duration) {
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+	}
+	if isWebSocketUpgrade(r) {
+		http.Error(w, "503 Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	maintenanceTmpl.Execute(w, struct{ Domain string }{domain})
 }
 
 func extractSubdomain(host, suffix string) string {
@@ -134,14 +573,61 @@ func extractSubdomain(host, suffix string) string {
 	return sub
 }
 
+// isWebSocketUpgrade reports whether r asks to switch to WebSocket. The
+// Connection header is a token list, so "keep-alive, Upgrade" counts too.
 func isWebSocketUpgrade(r *http.Request) bool {
-	return strings.EqualFold(r.Header.Get("Connection"), "upgrade") &&
-		strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+	return headerHasToken(r.Header, "Connection", "upgrade") &&
+		headerHasToken(r.Header, "Upgrade", "websocket")
+}
+
+// hopHeaders are the hop-by-hop headers of RFC 9110 section 7.6.1. They
+// describe a single connection and are never forwarded as-is.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection", // non-standard, still sent by some clients
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopHeaders deletes the hop-by-hop headers from h, including any
+// extra ones the Connection header names.
+func removeHopHeaders(h http.Header) {
+	for _, v := range h["Connection"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// headerHasToken reports whether the comma-separated values of header name
+// include token, ignoring case.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
-func handleWebSocket(w http.ResponseWriter, r *http.Request, target string) {
+// handleWebSocket tunnels a WebSocket upgrade to target. If idle is non-zero,
+// the tunnel is torn down once no bytes have flowed in either direction for
+// that long.
+func handleWebSocket(w http.ResponseWriter, r *http.Request, target string, idle time.Duration) {
 	// Dial backend
-	backendConn, err := net.DialTimeout("tcp", target, 5*time.Second)
+	backendConn, err := dialBackend(r.Context(), target)
 	if err != nil {
 		http.Error(w, "502 Bad Gateway", http.StatusBadGateway)
 		return
@@ -161,8 +647,16 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request, target string) {
 		return
 	}
 
-	// Forward the original request to backend
-	if err := r.Write(backendConn); err != nil {
+	// Forward the request to the backend with the client's hop-by-hop
+	// headers replaced by the upgrade itself
+	out := r.Clone(r.Context())
+	removeHopHeaders(out.Header)
+	out.Header.Set("Connection", "Upgrade")
+	out.Header.Set("Upgrade", "websocket")
+	if identifyProxy.Load() {
+		appendVia(out.Header)
+	}
+	if err := out.Write(backendConn); err != nil {
 		clientConn.Close()
 		backendConn.Close()
 		return
@@ -175,23 +669,94 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request, target string) {
 		backendConn.Write(buffered)
 	}
 
-	// Bidirectional copy
+	// Bidirectional copy; the connection slot is held until both directions end
+	release := takeConnSlot(r)
+	activity := &idleDeadline{timeout: idle, conns: [2]net.Conn{clientConn, backendConn}}
+	activity.touch()
+	var wg sync.WaitGroup
+	var up, down int64
+	wg.Add(2)
 	go func() {
-		io.Copy(backendConn, clientConn)
+		defer wg.Done()
+		up, _ = io.Copy(backendConn, activity.reader(clientConn))
 		backendConn.Close()
 	}()
 	go func() {
-		io.Copy(clientConn, backendConn)
+		defer wg.Done()
+		down, _ = io.Copy(clientConn, activity.reader(backendConn))
 		clientConn.Close()
 	}()
+	go func() {
+		wg.Wait()
+		release()
+		logTunnelClosed(w, up+down)
+	}()
+}
+
+// idleDeadline keeps the read deadlines of both ends of a tunnel a timeout
+// ahead of the last activity in either direction, so a read times out only
+// when the whole tunnel has gone quiet.
+type idleDeadline struct {
+	timeout time.Duration
+	conns   [2]net.Conn
+}
+
+// touch pushes both read deadlines out by the timeout. It is a no-op when
+// the timeout is disabled.
+func (d *idleDeadline) touch() {
+	if d.timeout <= 0 {
+		return
+	}
+	deadline := time.Now().Add(d.timeout)
+	for _, c := range d.conns {
+		c.SetReadDeadline(deadline)
+	}
+}
+
+// reader wraps c so every successful read counts as activity.
+func (d *idleDeadline) reader(c net.Conn) io.Reader {
+	if d.timeout <= 0 {
+		return c
+	}
+	return readerFunc(func(p []byte) (int, error) {
+		n, err := c.Read(p)
+		if n > 0 {
+			d.touch()
+		}
+		return n, err
+	})
 }
 
+type readerFunc func([]byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
 func proxyToDashboard(w http.ResponseWriter, r *http.Request, dashboardAddr string) {
 	proxyURL, _ := url.Parse(fmt.Sprintf("http://%s", dashboardAddr))
 	proxy := &httputil.ReverseProxy{
 		Director: func(req *http.Request) {
 			req.URL.Scheme = proxyURL.Scheme
 			req.URL.Host = proxyURL.Host
+			// The dashboard believes X-Forwarded-Prefix from this host, so
+			// only pass on a prefix the proxy itself would believe
+			if p := forwardedPrefix(r); p != "" {
+				req.Header.Set("X-Forwarded-Prefix", p)
+			} else {
+				req.Header.Del("X-Forwarded-Prefix")
+			}
+		},
+		Transport: backendRoundTripper{},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			logEvent(levelError, logFields{"remote_addr": r.RemoteAddr, "path": r.URL.Path, "error": err.Error()}, "dashboard proxy error: %v", err)
+			w.Header().Set("Retry-After", "3")
+			if isWebSocketUpgrade(r) || strings.HasPrefix(r.URL.Path, "/api/") {
+				http.Error(w, "dashboard unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			dashboardUnavailableTmpl.Execute(w, nil)
 		},
 	}
 	proxy.ServeHTTP(w, r)
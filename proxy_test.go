@@ -0,0 +1,1048 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestValidateDomainSuffix(t *testing.T) {
+	tests := []struct {
+		suffix string
+		ok     bool
+	}{
+		{"localhost", true},
+		{"dev.test", true},
+		{"my-lab.internal", true},
+		{"", false},
+		{"portgate", false},
+		{"portgate.localhost", false},
+		{"bad suffix", false},
+		{"a..b", false},
+		{"localhost:80", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.suffix, func(t *testing.T) {
+			err := validateDomainSuffix(tt.suffix)
+			if (err == nil) != tt.ok {
+				t.Errorf("validateDomainSuffix(%q) = %v, want ok=%v", tt.suffix, err, tt.ok)
+			}
+		})
+	}
+
+	cs := newTestConfigStore(t)
+	if err := cs.SetDomainSuffix(" PortGate. "); err == nil {
+		t.Errorf("SetDomainSuffix accepted a suffix colliding with the reserved domain")
+	}
+	if err := cs.SetDomainSuffix(".Dev.Test."); err != nil || cs.DomainSuffix() != "dev.test" {
+		t.Errorf("SetDomainSuffix normalization: err=%v suffix=%q", err, cs.DomainSuffix())
+	}
+}
+
+func TestProxyReservedSubdomain(t *testing.T) {
+	serve := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name)
+		}))
+	}
+	dashboard := serve("dashboard")
+	defer dashboard.Close()
+	backend := serve("backend")
+	defer backend.Close()
+
+	cs := newTestConfigStore(t)
+	cs.cfg.DomainSuffix = "test"
+	// A legacy config with a user mapping squatting on the reserved name
+	cs.cfg.Mappings = []DomainMapping{
+		{Domain: "portgate", TargetPort: listenerPort(t, backend)},
+		{Domain: "app", TargetPort: listenerPort(t, backend)},
+	}
+	h := ProxyHandler(NewHub(cs), dashboard.Listener.Addr().String())
+
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"portgate.test", "dashboard"},
+		{"PORTGATE.test.", "dashboard"},
+		{"test", "dashboard"},
+		{"app.test", "backend"},
+		{"App.TEST.", "backend"},
+		{"app.localhost", "dashboard"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Host = tt.host
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if got := rec.Body.String(); got != tt.want {
+				t.Errorf("Host %q routed to %q, want %q", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveWildcardMapping(t *testing.T) {
+	cs := newTestConfigStore(t)
+	cs.cfg.Mappings = []DomainMapping{
+		{Domain: "*.app", TargetPort: 1},
+		{Domain: "admin.app", TargetPort: 2},
+		{Domain: "*.eu.app", TargetPort: 3},
+		{Domain: "*.eu.app", TargetPort: 4}, // shadowed by the earlier duplicate
+		{Domain: "api", TargetPort: 5},
+		{Domain: "api-*", TargetPort: 6},
+		{Domain: "pr-*.preview", TargetPort: 7},
+		{Domain: "api-v1", TargetPort: 8},
+		{Domain: "*.api-*", TargetPort: 9},
+	}
+
+	tests := []struct {
+		name string
+		port int
+	}{
+		{"t1.app", 1},
+		{"a.t1.app", 1},
+		{"admin.app", 2},   // exact beats wildcard
+		{"acme.eu.app", 3}, // longest base wins
+		{"eu.app", 1},      // "*.eu.app" needs a label before "eu"
+		{"app", 0},         // the base itself is not covered
+		{"myapp", 0},       // suffix must fall on a label boundary
+		{"t1.app.other", 0},
+		{"api", 5},
+		{"v2.api", 0},
+		{"api-v2", 6},
+		{"api-", 6},
+		{"api-v1", 8},    // exact beats a pattern defined earlier
+		{"api-v2.eu", 0}, // "*" inside a label doesn't cross dots
+		{"x.api-v2", 9},  // leading "*." label combined with an inner "*"
+		{"pr-12.preview", 7},
+		{"pr-12.other", 0},
+		{"a.pr-12.preview", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cs.LookupPort(tt.name); got != tt.port {
+				t.Errorf("LookupPort(%q) = %d, want %d", tt.name, got, tt.port)
+			}
+		})
+	}
+}
+
+func TestProxyWildcardPreservesHost(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Host)
+	}))
+	defer backend.Close()
+
+	cs := newTestConfigStore(t)
+	cs.cfg.Mappings = []DomainMapping{{Domain: "*.app", TargetPort: listenerPort(t, backend)}}
+	h := ProxyHandler(NewHub(cs), "127.0.0.1:1")
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Host = "tenant1.app.localhost"
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Body.String(); got != "tenant1.app.localhost" {
+		t.Errorf("backend saw Host %q, want tenant1.app.localhost", got)
+	}
+}
+
+func TestProxyStartupGracePeriod(t *testing.T) {
+	// Reserve a port, then free it so the backend can start there later
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	cs := newTestConfigStore(t)
+	cs.cfg.Mappings = []DomainMapping{
+		{Domain: "slow", TargetPort: port, StartupGracePeriodSec: 5},
+		{Domain: "plain", TargetPort: port},
+	}
+	h := ProxyHandler(NewHub(cs), "127.0.0.1:1")
+	get := func(host string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = host
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("plain.localhost"); rec.Code != http.StatusBadGateway {
+		t.Errorf("no grace period: status %d, want 502", rec.Code)
+	}
+
+	// The backend comes up while the request is waiting
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return
+		}
+		srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "up")
+		})}
+		t.Cleanup(func() { srv.Close() })
+		srv.Serve(ln)
+	}()
+	if rec := get("slow.localhost"); rec.Code != http.StatusOK || rec.Body.String() != "up" {
+		t.Errorf("within grace period: status %d body %q, want 200 up", rec.Code, rec.Body.String())
+	}
+
+	// Past the deadline a down backend gets the starting page
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+	cs.cfg.Mappings = []DomainMapping{{Domain: "gone", TargetPort: closedPort, StartupGracePeriodSec: 1}}
+	cs.firstSeen["gone"] = time.Now().Add(-900 * time.Millisecond)
+	if rec := get("gone.localhost"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("grace period exceeded: status %d, want 503", rec.Code)
+	}
+}
+
+// BenchmarkProxyToMapping measures proxied round trips to a keep-alive
+// backend; connections are pooled by the shared backend transport.
+func BenchmarkProxyToMapping(b *testing.B) {
+	var conns atomic.Int64
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	backend.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	backend.Start()
+	defer backend.Close()
+
+	cs, err := NewConfigStore(filepath.Join(b.TempDir(), "config.json"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	port := backend.Listener.Addr().(*net.TCPAddr).Port
+	cs.cfg.Mappings = []DomainMapping{{Domain: "app", TargetPort: port}}
+	h := ProxyHandler(NewHub(cs), "127.0.0.1:1")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = "app.localhost"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			b.Fatalf("status %d", rec.Code)
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(conns.Load()), "backend-conns")
+}
+
+func TestProxyBadBackendHeaders(t *testing.T) {
+	setBackendLimits(4<<10, 200*time.Millisecond)
+	t.Cleanup(func() { setBackendLimits(defaultBackendMaxHeaderBytes, defaultBackendHeaderTimeout) })
+
+	release := make(chan struct{})
+	defer close(release)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    int
+	}{
+		{"slow headers", func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}, http.StatusGatewayTimeout},
+		{"huge headers", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Junk", strings.Repeat("x", 64<<10))
+		}, http.StatusBadGateway},
+		{"normal", func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "ok")
+		}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := httptest.NewServer(tt.handler)
+			defer backend.Close()
+
+			cs := newTestConfigStore(t)
+			cs.cfg.Mappings = []DomainMapping{{Domain: "app", TargetPort: listenerPort(t, backend)}}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Host = "app.localhost"
+			rec := httptest.NewRecorder()
+			start := time.Now()
+			ProxyHandler(NewHub(cs), "127.0.0.1:1").ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if d := time.Since(start); d > 2*time.Second {
+				t.Errorf("proxy took %v to give up", d)
+			}
+		})
+	}
+}
+
+func TestWebSocketIdleTimeout(t *testing.T) {
+	// A backend that accepts the upgrade and then never sends anything
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	backendClosed := make(chan struct{})
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		req.Body.Close()
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		io.Copy(io.Discard, conn)
+		close(backendClosed)
+	}()
+
+	one := 1
+	cs := newTestConfigStore(t)
+	cs.cfg.Mappings = []DomainMapping{{
+		Domain:                  "stall",
+		TargetPort:              backend.Addr().(*net.TCPAddr).Port,
+		WebSocketIdleTimeoutSec: &one,
+	}}
+	proxy := httptest.NewServer(ProxyHandler(NewHub(cs), "127.0.0.1:1"))
+	defer proxy.Close()
+
+	client, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	io.WriteString(client, "GET /socket HTTP/1.1\r\nHost: stall.localhost\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+	br := bufio.NewReader(client)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("upgrade status %d, want 101", resp.StatusCode)
+	}
+
+	// Some traffic keeps the tunnel alive past one timeout period
+	start := time.Now()
+	time.Sleep(600 * time.Millisecond)
+	io.WriteString(client, "ping")
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Fatalf("read from idle tunnel: %v, want EOF", err)
+	}
+	if elapsed := time.Since(start); elapsed < 1500*time.Millisecond {
+		t.Errorf("tunnel closed after %v, want the activity to extend the 1s timeout", elapsed)
+	}
+	select {
+	case <-backendClosed:
+	case <-time.After(2 * time.Second):
+		t.Error("backend connection still open after idle timeout")
+	}
+}
+
+func TestProxyStripsHopHeaders(t *testing.T) {
+	var got http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Header().Set("Connection", "X-Backend-Hop")
+		w.Header().Set("X-Backend-Hop", "1")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("X-End-To-End", "1")
+	}))
+	defer backend.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Connection", "close, X-Client-Hop")
+	req.Header.Set("X-Client-Hop", "1")
+	req.Header.Set("Keep-Alive", "300")
+	req.Header.Set("Proxy-Authorization", "Basic Zm9vOmJhcg==")
+	req.Header.Set("Proxy-Connection", "keep-alive")
+	req.Header.Set("Te", "gzip")
+	req.Header.Set("Upgrade", "websocket") // no Connection: upgrade, so not a real upgrade
+	req.Header.Set("X-End-To-End", "1")
+	resp := proxyThrough(t, backend, DomainMapping{}, req)
+
+	for _, h := range []string{"X-Client-Hop", "Keep-Alive", "Proxy-Authorization", "Proxy-Connection", "Te", "Upgrade"} {
+		if v := got.Get(h); v != "" {
+			t.Errorf("backend received %s: %q", h, v)
+		}
+	}
+	if got.Get("X-End-To-End") == "" {
+		t.Errorf("end-to-end header was dropped")
+	}
+	for _, h := range []string{"X-Backend-Hop", "Keep-Alive"} {
+		if v := resp.Header.Get(h); v != "" {
+			t.Errorf("client received %s: %q", h, v)
+		}
+	}
+	if resp.Header.Get("X-End-To-End") == "" {
+		t.Errorf("end-to-end response header was dropped")
+	}
+}
+
+func TestWebSocketUpgradeHeaders(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	received := make(chan http.Header, 1)
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		received <- req.Header
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+	}()
+
+	cs := newTestConfigStore(t)
+	cs.cfg.Mappings = []DomainMapping{{Domain: "ws", TargetPort: backend.Addr().(*net.TCPAddr).Port}}
+	proxy := httptest.NewServer(ProxyHandler(NewHub(cs), "127.0.0.1:1"))
+	defer proxy.Close()
+
+	client, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	// Firefox sends "keep-alive, Upgrade"
+	io.WriteString(client, "GET /socket HTTP/1.1\r\nHost: ws.localhost\r\n"+
+		"Connection: keep-alive, Upgrade, X-Client-Hop\r\nUpgrade: websocket\r\n"+
+		"X-Client-Hop: 1\r\nKeep-Alive: 300\r\nProxy-Authorization: secret\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+
+	select {
+	case h := <-received:
+		if h.Get("Connection") != "Upgrade" || h.Get("Upgrade") != "websocket" {
+			t.Errorf("upgrade headers = Connection %q, Upgrade %q", h.Get("Connection"), h.Get("Upgrade"))
+		}
+		for _, name := range []string{"X-Client-Hop", "Keep-Alive", "Proxy-Authorization"} {
+			if v := h.Get(name); v != "" {
+				t.Errorf("backend received %s: %q", name, v)
+			}
+		}
+		if h.Get("Sec-Websocket-Key") == "" {
+			t.Errorf("Sec-WebSocket-Key was dropped")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("upgrade never reached the backend")
+	}
+}
+
+func TestProxyIPv6Target(t *testing.T) {
+	ln, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skip("no IPv6 loopback:", err)
+	}
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isWebSocketUpgrade(r) {
+			conn, buf, _ := http.NewResponseController(w).Hijack()
+			defer conn.Close()
+			buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+			buf.Flush()
+			return
+		}
+		io.WriteString(w, "v6 "+r.URL.Path)
+	}))
+	backend.Listener.Close()
+	backend.Listener = ln
+	backend.Start()
+	defer backend.Close()
+	port := listenerPort(t, backend)
+
+	cs := newTestConfigStore(t)
+	hub := NewHub(cs)
+	go hub.Run()
+	// Added through the API the way `portgate add app [::1]:<port>` does
+	rec := httptest.NewRecorder()
+	body := fmt.Sprintf(`{"domain": "app", "target": "[::1]:%d"}`, port)
+	DashboardHandler(hub, NewSessionStore()).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/mappings", strings.NewReader(body)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("add mapping: status %d: %s", rec.Code, rec.Body)
+	}
+	if m, _ := cs.LookupMapping("app"); m.TargetHost != "::1" || m.TargetPort != port {
+		t.Fatalf("stored mapping = %q, %d", m.TargetHost, m.TargetPort)
+	}
+
+	proxy := httptest.NewServer(ProxyHandler(hub, "127.0.0.1:1"))
+	defer proxy.Close()
+	req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/hello", nil)
+	req.Host = "app.localhost"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(got) != "v6 /hello" {
+		t.Errorf("HTTP via [::1] = %d %q", resp.StatusCode, got)
+	}
+
+	client, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	io.WriteString(client, "GET /socket HTTP/1.1\r\nHost: app.localhost\r\n"+
+		"Connection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	wsResp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wsResp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("WebSocket via [::1] = %d", wsResp.StatusCode)
+	}
+}
+
+func TestMappingTargetAPI(t *testing.T) {
+	cs := newTestConfigStore(t)
+	hub := NewHub(cs)
+	go hub.Run()
+	handler := DashboardHandler(hub, NewSessionStore())
+	tests := []struct {
+		body string
+		code int
+		host string
+	}{
+		{`{"domain": "a", "port": 3000}`, http.StatusCreated, ""},
+		{`{"domain": "b", "target": "3001"}`, http.StatusCreated, ""},
+		{`{"domain": "c", "target": "DevBox.lan:3000"}`, http.StatusCreated, "devbox.lan"},
+		{`{"domain": "d", "target": "[fd00::5]:3000"}`, http.StatusCreated, "fd00::5"},
+		{`{"domain": "e", "target": "fd00::5:3000"}`, http.StatusBadRequest, ""},
+		{`{"domain": "f", "target": "[::1]:3000", "port": 3000}`, http.StatusBadRequest, ""},
+		{`{"domain": "g", "port": 70000}`, http.StatusBadRequest, ""},
+		{`{"domain": "h", "port": 3000, "host": "192.168.1.40"}`, http.StatusCreated, "192.168.1.40"},
+		{`{"domain": "i", "port": 3000, "host": "VM.lan"}`, http.StatusCreated, "vm.lan"},
+		{`{"domain": "j", "port": 3000, "host": "not a host"}`, http.StatusBadRequest, ""},
+		{`{"domain": "k", "target": "vm.lan:3000", "host": "vm.lan"}`, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/mappings", strings.NewReader(tt.body)))
+		if rec.Code != tt.code {
+			t.Errorf("%s: status %d, want %d: %s", tt.body, rec.Code, tt.code, rec.Body)
+			continue
+		}
+		var m DomainMapping
+		if tt.code == http.StatusCreated {
+			json.NewDecoder(rec.Body).Decode(&m)
+			if m.TargetHost != tt.host || m.TargetPort == 0 {
+				t.Errorf("%s: mapping = %q, %d", tt.body, m.TargetHost, m.TargetPort)
+			}
+		}
+	}
+}
+
+func TestProxyBackendHTTP2(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	}))
+	backend.Config.Protocols = new(http.Protocols)
+	backend.Config.Protocols.SetHTTP1(true)
+	backend.Config.Protocols.SetUnencryptedHTTP2(true)
+	backend.Start()
+	defer backend.Close()
+
+	for _, tt := range []struct {
+		http2 bool
+		want  string
+	}{
+		{false, "HTTP/1.1"},
+		{true, "HTTP/2.0"},
+	} {
+		// Twice each, so the second request reuses the pooled connection
+		for range 2 {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			resp := proxyThrough(t, backend, DomainMapping{BackendHTTP2: tt.http2}, req)
+			got, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != http.StatusOK || string(got) != tt.want {
+				t.Errorf("backendHTTP2=%t: backend saw %d %q, want %q", tt.http2, resp.StatusCode, got, tt.want)
+			}
+			if resp.Proto != "HTTP/1.1" {
+				t.Errorf("client response proto = %s", resp.Proto)
+			}
+		}
+	}
+
+	// The mapping test goes over the same transport
+	cs := newTestConfigStore(t)
+	m := DomainMapping{Domain: "app", TargetPort: listenerPort(t, backend), BackendHTTP2: true}
+	if res := testMapping(context.Background(), cs, m); !res.OK {
+		t.Errorf("testMapping over h2c = %+v", res)
+	}
+}
+
+func TestTrailingSlashTarget(t *testing.T) {
+	tests := []struct {
+		path, mode, want string
+	}{
+		{"/app", trailingSlashAdd, "/app/"},
+		{"/app/", trailingSlashAdd, ""},
+		{"/app/docs", trailingSlashAdd, ""},
+		{"/app/api/users", trailingSlashAdd, ""},
+		{"/", trailingSlashAdd, ""},
+		{"/app/", trailingSlashRemove, "/app"},
+		{"/app", trailingSlashRemove, ""},
+		{"/app/docs/", trailingSlashRemove, ""},
+		{"/", trailingSlashRemove, ""},
+	}
+	for _, tt := range tests {
+		if got := trailingSlashTarget(tt.path, "/app", tt.mode); got != tt.want {
+			t.Errorf("trailingSlashTarget(%q, %s) = %q, want %q", tt.path, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestProxyTrailingSlashRedirect(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.Path)
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		mode, method, host, path string
+		wantCode                 int
+		wantLocation             string
+	}{
+		{trailingSlashAdd, http.MethodGet, "portgate.localhost", "/app?x=1", http.StatusTemporaryRedirect, "/app/?x=1"},
+		{trailingSlashAdd, http.MethodHead, "portgate.localhost", "/app", http.StatusTemporaryRedirect, "/app/"},
+		{trailingSlashAdd, http.MethodGet, "portgate.localhost", "/app/", http.StatusOK, ""},
+		{trailingSlashAdd, http.MethodPost, "portgate.localhost", "/app", http.StatusOK, ""},
+		{trailingSlashAdd, http.MethodGet, "portgate.localhost", "/app/api/users", http.StatusOK, ""},
+		{trailingSlashRemove, http.MethodGet, "portgate.localhost", "/app/", http.StatusTemporaryRedirect, "/app"},
+		{trailingSlashAdd, http.MethodGet, "app.localhost", "/docs", http.StatusOK, ""},
+		{trailingSlashRemove, http.MethodGet, "app.localhost", "/docs/", http.StatusOK, ""},
+		{"", http.MethodGet, "portgate.localhost", "/app", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		cs := newTestConfigStore(t)
+		cs.cfg.NormalizeTrailingSlash = tt.mode
+		cs.cfg.Mappings = []DomainMapping{{Domain: "app", TargetPort: listenerPort(t, backend)}}
+		handler := ProxyHandler(NewHub(cs), "127.0.0.1:1")
+
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.Host = tt.host
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.wantCode || rec.Header().Get("Location") != tt.wantLocation {
+			t.Errorf("%s %s %s%s: %d Location %q, want %d %q", tt.mode, tt.method, tt.host, tt.path,
+				rec.Code, rec.Header().Get("Location"), tt.wantCode, tt.wantLocation)
+		}
+	}
+}
+
+func TestProxyIdentifyHeaders(t *testing.T) {
+	var gotVia string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotVia = r.Header.Get("Via")
+		w.Header().Set("Via", "1.1 app-cache")
+		w.Header().Set("Server", "gunicorn")
+	}))
+	defer backend.Close()
+	t.Cleanup(func() { setIdentifyProxy(false) })
+
+	tests := []struct {
+		on                          bool
+		wantReqVia, wantRespVia, sv string
+	}{
+		{false, "1.0 corp-proxy", "1.1 app-cache", "gunicorn"},
+		{true, "1.0 corp-proxy, " + viaValue(), "1.1 app-cache, " + viaValue(), "portgate"},
+	}
+	for _, tt := range tests {
+		setIdentifyProxy(tt.on)
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Via", "1.0 corp-proxy")
+		resp := proxyThrough(t, backend, DomainMapping{}, req)
+		if gotVia != tt.wantReqVia {
+			t.Errorf("identify=%v: backend saw Via %q, want %q", tt.on, gotVia, tt.wantReqVia)
+		}
+		if v := resp.Header.Get("Via"); v != tt.wantRespVia {
+			t.Errorf("identify=%v: client saw Via %q, want %q", tt.on, v, tt.wantRespVia)
+		}
+		if v := resp.Header.Get("Server"); v != tt.sv {
+			t.Errorf("identify=%v: Server %q, want %q", tt.on, v, tt.sv)
+		}
+	}
+}
+
+func TestMappingMode(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	cs := newTestConfigStore(t)
+	port := listenerPort(t, backend)
+	cs.cfg.Mappings = []DomainMapping{
+		{Domain: "both", TargetPort: port},
+		{Domain: "rest", TargetPort: port, Mode: mappingModeHTTPOnly},
+		{Domain: "live", TargetPort: port, Mode: mappingModeWSOnly},
+	}
+	handler := ProxyHandler(NewHub(cs), "127.0.0.1:1")
+
+	tests := []struct {
+		domain   string
+		upgrade  bool
+		rejected bool
+	}{
+		{"both", false, false},
+		{"both", true, false},
+		{"rest", false, false},
+		{"rest", true, true},
+		{"live", false, true},
+		{"live", true, false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = tt.domain + ".localhost"
+		if tt.upgrade {
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Upgrade", "websocket")
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		// Allowed upgrades fail later, at the hijack the recorder can't do
+		if rejected := rec.Code == http.StatusBadRequest; rejected != tt.rejected {
+			t.Errorf("%s upgrade=%v: status %d, want rejected=%v", tt.domain, tt.upgrade, rec.Code, tt.rejected)
+		}
+	}
+
+	dash := DashboardHandler(NewHub(cs), NewSessionStore())
+	for body, want := range map[string]int{
+		`{"domain":"a","port":3000,"mode":"ws-only"}`: http.StatusCreated,
+		`{"domain":"b","port":3000,"mode":"both"}`:    http.StatusCreated,
+		`{"domain":"c","port":3000,"mode":"udp"}`:     http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		dash.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/mappings", strings.NewReader(body)))
+		if rec.Code != want {
+			t.Errorf("POST %s: status %d, want %d", body, rec.Code, want)
+		}
+	}
+	if m, _ := cs.LookupMapping("b"); m.Mode != "" {
+		t.Errorf("mode both stored as %q, want empty", m.Mode)
+	}
+}
+
+func TestProxyErrorPage(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	cs := newTestConfigStore(t)
+	cs.cfg.Mappings = []DomainMapping{{Domain: "app", TargetPort: port}}
+	h := ProxyHandler(NewHub(cs), "127.0.0.1:1")
+	get := func(upgrade bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = "app.localhost:8443"
+		if upgrade {
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Upgrade", "websocket")
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get(false)
+	body := rec.Body.String()
+	if rec.Code != http.StatusBadGateway || rec.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Errorf("status %d, Content-Type %q; want a 502 HTML page", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	for _, want := range []string{"502 Bad Gateway", "connection refused", fmt.Sprint(port), `href="http://portgate.localhost:8443/"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("error page missing %q:\n%s", want, body)
+		}
+	}
+
+	// A custom template replaces the page; a broken one is rejected on load
+	dir := t.TempDir()
+	custom := filepath.Join(dir, "custom.html")
+	os.WriteFile(custom, []byte("{{.Status}} {{.Domain}} {{.Category}} {{.Target}}"), 0o644)
+	tmpl, err := loadErrorPage(custom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setErrorPage(tmpl)
+	t.Cleanup(func() { setErrorPage(defaultErrorPageTmpl) })
+	if got, want := get(false).Body.String(), fmt.Sprintf("502 app connection refused 127.0.0.1:%d", port); got != want {
+		t.Errorf("custom page = %q, want %q", got, want)
+	}
+	broken := filepath.Join(dir, "broken.html")
+	os.WriteFile(broken, []byte("{{.Status"), 0o644)
+	if _, err := loadErrorPage(broken); err == nil {
+		t.Error("loadErrorPage accepted a malformed template")
+	}
+
+	if rec := get(true); rec.Code != http.StatusBadGateway || strings.Contains(rec.Body.String(), "<html") {
+		t.Errorf("WebSocket upgrade: status %d, body %q; want a plain 502", rec.Code, rec.Body.String())
+	}
+}
+
+func TestProxyUnknownDomain(t *testing.T) {
+	cs := newTestConfigStore(t)
+	cs.cfg.Mappings = []DomainMapping{
+		{Domain: "portgate", TargetPort: 8080, System: true},
+		{Domain: "frontend", TargetPort: 3000},
+		{Domain: "api", TargetPort: 4000},
+		{Domain: "*.preview", TargetPort: 5000},
+	}
+	h := ProxyHandler(NewHub(cs), "127.0.0.1:1")
+	get := func(host string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = host
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("fronted.localhost:8080")
+	body := rec.Body.String()
+	if rec.Code != http.StatusNotFound || rec.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Errorf("status %d, Content-Type %q; want a 404 HTML page", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	for _, want := range []string{"fronted.localhost", "Did you mean", `href="http://frontend.localhost:8080/"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page missing %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "api.localhost") {
+		t.Errorf("unrelated mapping suggested:\n%s", body)
+	}
+
+	// With nothing close, every linkable mapping is listed instead
+	body = get("zzz.localhost:8080").Body.String()
+	for _, want := range []string{`href="http://api.localhost:8080/"`, `href="http://frontend.localhost:8080/"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("listing missing %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "preview") || strings.Contains(body, ">portgate<") {
+		t.Errorf("wildcard or system mapping listed:\n%s", body)
+	}
+
+	// A custom template replaces the page
+	custom := filepath.Join(t.TempDir(), "notfound.html")
+	os.WriteFile(custom, []byte("{{.Domain}}:{{range .Suggestions}}{{.Domain}}{{end}}"), 0o644)
+	tmpl, err := loadNotFoundPage(custom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setNotFoundPage(tmpl)
+	t.Cleanup(func() { setNotFoundPage(defaultNotFoundPageTmpl) })
+	if got := get("apj.localhost").Body.String(); got != "apj:api" {
+		t.Errorf("custom page = %q, want %q", got, "apj:api")
+	}
+}
+
+func TestSuggestMappings(t *testing.T) {
+	domains := []string{"api", "app", "admin", "frontend", "frontend-v2", "docs"}
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"apo", []string{"api", "app"}},
+		{"fronted", []string{"frontend"}},
+		{"front", []string{"frontend", "frontend-v2"}},
+		{"dcos", []string{"docs"}},
+		{"zzz", nil},
+	}
+	for _, tt := range tests {
+		if got := suggestMappings(tt.name, domains); !slices.Equal(got, tt.want) {
+			t.Errorf("suggestMappings(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProxyForwardedPrefix(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r
+		conn, buf, _ := http.NewResponseController(w).Hijack()
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		buf.Flush()
+	}))
+	defer backend.Close()
+
+	cs := newTestConfigStore(t)
+	cs.cfg.Mappings = []DomainMapping{{Domain: "app", TargetPort: listenerPort(t, backend)}}
+	proxy := httptest.NewServer(ProxyHandler(NewHub(cs), "127.0.0.1:1"))
+	defer proxy.Close()
+
+	// Path-routed upgrade behind an upstream that already stripped /outer
+	client, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	io.WriteString(client, "GET /app/socket HTTP/1.1\r\nHost: localhost\r\nX-Forwarded-Prefix: /outer\r\n"+
+		"Connection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+	select {
+	case r := <-received:
+		if r.URL.Path != "/socket" || r.Header.Get("X-Forwarded-Prefix") != "/outer/app" {
+			t.Errorf("backend got %s with X-Forwarded-Prefix %q, want /socket with /outer/app", r.URL.Path, r.Header.Get("X-Forwarded-Prefix"))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("upgrade never reached the backend")
+	}
+}
+
+func TestStripPrefix(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusFound)
+			return
+		}
+		if r.URL.Path == "/away" {
+			http.Redirect(w, r, "http://elsewhere.example/", http.StatusFound)
+			return
+		}
+		io.WriteString(w, r.URL.RequestURI())
+	}))
+	defer backend.Close()
+
+	cs := newTestConfigStore(t)
+	port := listenerPort(t, backend)
+	cs.cfg.Mappings = []DomainMapping{{Domain: "api", TargetPort: port, StripPrefix: "/v1"}}
+	handler := ProxyHandler(NewHub(cs), "127.0.0.1:1")
+
+	tests := []struct {
+		host, path string
+		want       string // path the backend saw, or the Location header for redirects
+	}{
+		{"api.localhost", "/v1", "/"},
+		{"api.localhost", "/v1/", "/"},
+		{"api.localhost", "/v1/users?page=2", "/users?page=2"},
+		{"api.localhost", "/users", "/users"},
+		{"api.localhost", "/v1x/users", "/v1x/users"},
+		{"api.localhost", "/v1/old", "/v1/new"},
+		{"api.localhost", "/v1/away", "http://elsewhere.example/"},
+		{"api.localhost", "/old", "/new"},
+		// Path-based routing strips the mapping name first
+		{"localhost", "/api/v1/users", "/users"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Host = tt.host
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		got := rec.Body.String()
+		if rec.Code == http.StatusFound {
+			got = rec.Header().Get("Location")
+		}
+		if got != tt.want {
+			t.Errorf("%s%s: got %q, want %q", tt.host, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestProxyDialRetries(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	setBackendDialPolicy(time.Second, 4)
+	t.Cleanup(func() { setBackendDialPolicy(defaultBackendDialTimeout, 0) })
+	cs := newTestConfigStore(t)
+	cs.cfg.Mappings = []DomainMapping{{Domain: "app", TargetPort: port}}
+	h := ProxyHandler(NewHub(cs), "127.0.0.1:1")
+	get := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = "app.localhost"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// A backend still refusing after every retry gets the self-refreshing
+	// starting page instead of a 502
+	start := time.Now()
+	rec := get()
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), `http-equiv="refresh"`) {
+		t.Errorf("status %d; want 503 with the starting page:\n%s", rec.Code, rec.Body)
+	}
+	if d := time.Since(start); d < 4*backendRetryDelay {
+		t.Errorf("gave up after %v, before the retries ran", d)
+	}
+
+	// A backend coming back within the retries is reached
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "up") })}
+	defer srv.Close()
+	go func() {
+		time.Sleep(2 * backendRetryDelay)
+		ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err != nil {
+			return
+		}
+		srv.Serve(ln)
+	}()
+	if rec := get(); rec.Code != http.StatusOK || rec.Body.String() != "up" {
+		t.Errorf("restarting backend: status %d body %q, want 200 up", rec.Code, rec.Body)
+	}
+}
+
+func TestBackendErrorCategory(t *testing.T) {
+	dialErr := func(err error) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", err)}
+	}
+	tests := []struct {
+		err     error
+		want    string
+		refused bool
+	}{
+		{dialErr(syscall.ECONNREFUSED), "connection refused", true},
+		{dialErr(wsaeconnrefused), "connection refused", true},
+		{dialErr(syscall.EHOSTUNREACH), "unreachable", false},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "nas.lan", IsNotFound: true}}, "unreachable", false},
+		{&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, "connection reset", false},
+		{context.DeadlineExceeded, "timeout", false},
+		{io.ErrUnexpectedEOF, "connection reset", false},
+	}
+	for _, tt := range tests {
+		if got := backendErrorCategory(tt.err); got != tt.want {
+			t.Errorf("backendErrorCategory(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+		if got := isConnRefused(tt.err); got != tt.refused {
+			t.Errorf("isConnRefused(%v) = %t, want %t", tt.err, got, tt.refused)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxRewriteBodySize caps how much of a response body is buffered for
+// rewriting. Larger bodies are passed through untouched.
+const maxRewriteBodySize = 8 << 20
+
+// rewriteResponse returns a ReverseProxy ModifyResponse hook that applies the
+// mapping's rewrite rules to textual bodies, or nil if there are no rules.
+func rewriteResponse(rules []RewriteRule) func(*http.Response) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	pairs := make([]string, 0, len(rules)*2)
+	for _, r := range rules {
+		pairs = append(pairs, r.From, r.To)
+	}
+	replacer := strings.NewReplacer(pairs...)
+
+	return func(resp *http.Response) error {
+		if !rewritable(resp) {
+			return nil
+		}
+		gzipped := strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip")
+
+		raw, err := io.ReadAll(io.LimitReader(resp.Body, maxRewriteBodySize+1))
+		if err != nil {
+			return err
+		}
+		if len(raw) > maxRewriteBodySize {
+			// Too big: put back what we read and stream the rest unchanged
+			resp.Body = readCloser{io.MultiReader(bytes.NewReader(raw), resp.Body), resp.Body}
+			return nil
+		}
+		resp.Body.Close()
+
+		body := raw
+		if gzipped {
+			zr, err := gzip.NewReader(bytes.NewReader(raw))
+			if err != nil {
+				resp.Body = io.NopCloser(bytes.NewReader(raw))
+				return nil
+			}
+			body, err = io.ReadAll(io.LimitReader(zr, maxRewriteBodySize+1))
+			if err != nil || len(body) > maxRewriteBodySize {
+				resp.Body = io.NopCloser(bytes.NewReader(raw))
+				return nil
+			}
+			resp.Header.Del("Content-Encoding")
+		}
+
+		out := []byte(replacer.Replace(string(body)))
+		resp.Body = io.NopCloser(bytes.NewReader(out))
+		resp.ContentLength = int64(len(out))
+		resp.TransferEncoding = nil
+		resp.Header.Set("Content-Length", strconv.Itoa(len(out)))
+		// Offsets into the rewritten body don't match the backend's, so don't
+		// invite range requests against it
+		resp.Header.Del("Accept-Ranges")
+		return nil
+	}
+}
+
+// rewritable reports whether resp has a textual body in an encoding we can
+// decode. Binary types and unknown encodings (br, deflate) are skipped.
+func rewritable(resp *http.Response) bool {
+	if resp.Request != nil && resp.Request.Method == http.MethodHead {
+		return false
+	}
+	if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return false
+	}
+	// Byte-range responses carry a slice of the backend's body at its offsets;
+	// rewriting would shift them and break seeking
+	if resp.StatusCode == http.StatusPartialContent || resp.Header.Get("Content-Range") != "" {
+		return false
+	}
+	if resp.ContentLength > maxRewriteBodySize {
+		return false
+	}
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "", "identity", "gzip":
+	default:
+		return false
+	}
+	mt, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mt, "text/"),
+		strings.HasSuffix(mt, "+json"),
+		strings.HasSuffix(mt, "+xml"):
+		return true
+	}
+	switch mt {
+	case "application/json", "application/javascript", "application/xml", "application/x-javascript":
+		return true
+	}
+	return false
+}
+
+// readCloser pairs a Reader with the Closer of the body it wraps.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// proxyThrough sends req through ProxyHandler with a single mapping "app"
+// pointing at backend.
+func proxyThrough(t *testing.T, backend *httptest.Server, m DomainMapping, req *http.Request) *http.Response {
+	t.Helper()
+	cs := newTestConfigStore(t)
+	m.Domain = "app"
+	m.TargetPort = listenerPort(t, backend)
+	cs.cfg.Mappings = []DomainMapping{m}
+	front := httptest.NewServer(ProxyHandler(NewHub(cs), "127.0.0.1:1"))
+	t.Cleanup(front.Close)
+
+	req.URL.Scheme = "http"
+	req.URL.Host = front.Listener.Addr().String()
+	req.Host = "app.localhost"
+	req.RequestURI = ""
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestResponseRewrite(t *testing.T) {
+	rules := []RewriteRule{{From: "http://127.0.0.1:3000", To: "http://app.localhost"}}
+	const page = `<a href="http://127.0.0.1:3000/login">login</a>`
+	const want = `<a href="http://app.localhost/login">login</a>`
+
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		want     string
+		encoding string
+	}{
+		{"plain html", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			io.WriteString(w, page)
+		}, want, ""},
+		{"gzip html", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", "gzip")
+			zw := gzip.NewWriter(w)
+			io.WriteString(zw, page)
+			zw.Close()
+		}, want, ""},
+		{"chunked json", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"next":"http://127.0.0.1`)
+			w.(http.Flusher).Flush()
+			io.WriteString(w, `:3000/page/2"}`)
+		}, `{"next":"http://app.localhost/page/2"}`, ""},
+		{"binary untouched", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, page)
+		}, page, ""},
+		{"brotli untouched", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", "br")
+			io.WriteString(w, page)
+		}, page, "br"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := httptest.NewServer(tt.handler)
+			defer backend.Close()
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip, br")
+			resp := proxyThrough(t, backend, DomainMapping{ResponseRewrite: rules}, req)
+
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != tt.want {
+				t.Errorf("body = %q, want %q", body, tt.want)
+			}
+			if got := resp.Header.Get("Content-Encoding"); got != tt.encoding {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.encoding)
+			}
+			if tt.want != page && resp.ContentLength != int64(len(tt.want)) {
+				t.Errorf("ContentLength = %d, want %d", resp.ContentLength, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestResponseRewriteSkipsRanges(t *testing.T) {
+	const content = "0123456789 http://127.0.0.1:3000 abcdefghij"
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "page.txt", time.Time{}, strings.NewReader(content))
+	}))
+	defer backend.Close()
+	m := DomainMapping{ResponseRewrite: []RewriteRule{{From: "http://127.0.0.1:3000", To: "http://app.localhost"}}}
+
+	req := httptest.NewRequest(http.MethodGet, "/page.txt", nil)
+	req.Header.Set("Range", "bytes=5-20")
+	resp := proxyThrough(t, backend, m, req)
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("status = %d, want 206", resp.StatusCode)
+	}
+	if string(body) != content[5:21] {
+		t.Errorf("range body = %q, want %q", body, content[5:21])
+	}
+	if got := resp.Header.Get("Content-Range"); got != "bytes 5-20/"+strconv.Itoa(len(content)) {
+		t.Errorf("Content-Range = %q", got)
+	}
+
+	// A full response is rewritten and no longer advertises ranges
+	resp = proxyThrough(t, backend, m, httptest.NewRequest(http.MethodGet, "/page.txt", nil))
+	body, _ = io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "http://app.localhost") {
+		t.Errorf("full body not rewritten: %q", body)
+	}
+	if resp.Header.Get("Accept-Ranges") != "" {
+		t.Errorf("rewritten response still has Accept-Ranges: %q", resp.Header.Get("Accept-Ranges"))
+	}
+}
@@ -1,158 +1,1206 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"maps"
+	"mime"
 	"net"
 	"net/http"
+	"os"
+	"path"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
 )
 
 var titleRe = regexp.MustCompile(`(?i)<title[^>]*>([^<]+)</title>`)
 
+var realmRe = regexp.MustCompile(`(?i)\brealm=(?:"([^"]*)"|([^\s,]+))`)
+
+// ansiRe matches terminal escape sequences: CSI (colors, cursor movement),
+// OSC (window titles, hyperlinks) and two-byte escapes such as ESC c.
+var ansiRe = regexp.MustCompile(`\x1b(?:\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(?:\x07|\x1b\\)|[0-~])`)
+
+// PortProber is how the Scanner talks to ports. The real implementation dials
+// the network; tests substitute a fake to feed synthetic ports through the
+// scanner and hub without opening sockets.
+type PortProber interface {
+	// Dial connects to port and returns nil if something accepts TCP
+	// connections there, or the error saying why nothing did.
+	Dial(port int) error
+	// Probe identifies the service on dp's port as spec describes, filling
+	// in ServiceName, Title and TLS details. It returns the HTTP status, or 0
+	// if the port did not answer HTTP.
+	Probe(dp *DiscoveredPort, spec probeSpec) int
+}
+
+// udpProber is implemented by probers that can check UDP ports. Without it
+// UDP scan ranges find nothing.
+type udpProber interface {
+	// IsOpenUDP reports whether something appears to be bound to UDP port.
+	IsOpenUDP(port int) bool
+}
+
+// netProber probes real ports on the loopback interface.
+type netProber struct{}
+
+func (netProber) Dial(port int) error { return dialPort(port) }
+
+func (netProber) IsOpenUDP(port int) bool { return isOpenUDP(port) }
+
+func (netProber) Probe(dp *DiscoveredPort, spec probeSpec) int { return probeHTTP(dp, spec) }
+
+// probeSpec describes the probe requests sent to a service. A non-empty host
+// overrides the Host header; paths are tried in order and default to "/".
+// Titles are cut to maxTitle characters; zero means no limit. With
+// noRedirects a 3xx is reported rather than followed.
+type probeSpec struct {
+	host        string
+	paths       []string
+	accept      string
+	maxTitle    int
+	noRedirects bool
+	timeout     time.Duration // per request and handshake; zero means probeTimeout
+}
+
 // Scanner scans TCP ports and detects HTTP services.
 type Scanner struct {
-	interval time.Duration
+	interval atomic.Int64 // time.Duration between scans
 	config   *ConfigStore
 	onChange func([]DiscoveredPort)
+	prober   PortProber
+
+	exeMu    sync.Mutex
+	exeCache map[int]exeCacheEntry
+
+	kernelWarn sync.Once // logs once if kernel scan mode falls back to dialing
+
+	statsMu     sync.Mutex
+	lastScanAt  time.Time     // when the last full scan finished
+	lastScanDur time.Duration // how long it took
+
+	control chan struct{} // wakes Run for an immediate rescan
+
+	triggerMu sync.Mutex
+	triggered chan struct{} // closed when the scan serving pending Trigger calls finishes; nil if none are pending
+
+	retimed chan struct{} // wakes Run to reset its ticker to a new interval
+	paused  atomic.Bool   // range scanning is paused; only manual ports are checked
+
+	failMu   sync.Mutex
+	failures map[int]int // consecutive failed checks per manual port
+
+	verbose bool // log every port state transition
+	logMu   sync.Mutex
+	last    map[portKey]DiscoveredPort // state at the previous scan, for verbose logging
+}
+
+// portKey identifies a discovered port. The same number can be open over
+// both TCP and UDP, and those are separate entries.
+type portKey struct {
+	Port     int
+	Protocol string
+}
+
+func (dp DiscoveredPort) key() portKey {
+	return portKey{dp.Port, dp.Protocol}
+}
+
+func comparePortKeys(a, b portKey) int {
+	return cmp.Or(cmp.Compare(a.Port, b.Port), cmp.Compare(a.Protocol, b.Protocol))
+}
+
+// exeCacheEntry is a cached process lookup for one port, valid while the
+// port's preferred listener still has the same owner.
+type exeCacheEntry struct {
+	exe   string
+	name  string
+	addrs []string
+	owner string
 }
 
 // NewScanner creates a scanner with the given interval, config store, and change callback.
 func NewScanner(interval time.Duration, config *ConfigStore, onChange func([]DiscoveredPort)) *Scanner {
-	return &Scanner{interval: interval, config: config, onChange: onChange}
+	s := &Scanner{
+		config:   config,
+		onChange: onChange,
+		prober:   netProber{},
+		exeCache: make(map[int]exeCacheEntry),
+		failures: make(map[int]int),
+		control:  make(chan struct{}, 1),
+		retimed:  make(chan struct{}, 1),
+	}
+	s.interval.Store(int64(interval))
+	s.paused.Store(!config.ScanningEnabled())
+	return s
 }
 
-// Run starts scanning in a loop until ctx is cancelled.
+// SetPaused pauses or resumes range scanning. While paused no range ports
+// are dialed; manual ports are still health-checked. The change takes effect
+// straight away, with a scan in the new mode.
+func (s *Scanner) SetPaused(paused bool) {
+	if s.paused.Swap(paused) != paused {
+		s.Rescan()
+	}
+}
+
+// Rescan asks Run to scan now rather than at the next tick, e.g. because the
+// ranges changed. Requests made while one is pending are merged.
+func (s *Scanner) Rescan() {
+	select {
+	case s.control <- struct{}{}:
+	default:
+	}
+}
+
+// Trigger asks Run to scan now and returns a channel that is closed once a
+// scan started after the call has finished and its ports have been handed to
+// the change callback. Calls made before that scan starts share it, so a
+// burst of triggers costs one scan.
+func (s *Scanner) Trigger() <-chan struct{} {
+	s.triggerMu.Lock()
+	if s.triggered == nil {
+		s.triggered = make(chan struct{})
+	}
+	s.Rescan()
+	done := s.triggered
+	s.triggerMu.Unlock()
+	return done
+}
+
+// Interval returns the time between scans.
+func (s *Scanner) Interval() time.Duration {
+	return time.Duration(s.interval.Load())
+}
+
+// SetInterval changes the time between scans. A running scanner resets its
+// ticker, so the next scan is one new interval from now. Intervals under a
+// second are rejected so the loop can't spin.
+func (s *Scanner) SetInterval(d time.Duration) error {
+	if err := validateScanInterval(d); err != nil {
+		return err
+	}
+	if s.interval.Swap(int64(d)) != int64(d) {
+		select {
+		case s.retimed <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Paused reports whether range scanning is paused.
+func (s *Scanner) Paused() bool {
+	return s.paused.Load()
+}
+
+// SetVerbose turns on logging of every port state transition: found, probe
+// result changes, health changes and drops. Call it before Run.
+func (s *Scanner) SetVerbose(v bool) {
+	s.verbose = v
+}
+
+// logTransitions logs how ports differ from the previous scan when verbose
+// logging is on. Lines carry port and exe fields so they can be grepped
+// alongside the service's own logs.
+func (s *Scanner) logTransitions(ports []DiscoveredPort) {
+	if !s.verbose {
+		return
+	}
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+	seen := make(map[portKey]DiscoveredPort, len(ports))
+	for _, dp := range ports {
+		seen[dp.key()] = dp
+		prev, ok := s.last[dp.key()]
+		switch {
+		case !ok:
+			logEvent(levelInfo, logFields{"port": dp.Port, "protocol": dp.Protocol, "exe": dp.ExePath, "source": dp.Source, "healthy": dp.Healthy},
+				"scanner: found port=%d exe=%q source=%s healthy=%t", dp.Port, dp.ExePath, dp.Source, dp.Healthy)
+		case prev.Healthy != dp.Healthy:
+			state := "unhealthy"
+			if dp.Healthy {
+				state = "healthy"
+			}
+			logEvent(levelInfo, logFields{"port": dp.Port, "protocol": dp.Protocol, "exe": dp.ExePath, "healthy": dp.Healthy, "health_reason": dp.HealthReason},
+				"scanner: marked %s port=%d exe=%q", state, dp.Port, dp.ExePath)
+		}
+		if !ok || prev.ServiceName != dp.ServiceName || prev.Title != dp.Title {
+			logEvent(levelInfo, logFields{"port": dp.Port, "protocol": dp.Protocol, "exe": dp.ExePath, "service": dp.ServiceName, "title": dp.Title},
+				"scanner: probe port=%d exe=%q service=%q title=%q", dp.Port, dp.ExePath, dp.ServiceName, dp.Title)
+		}
+	}
+	for _, k := range slices.SortedFunc(maps.Keys(s.last), comparePortKeys) {
+		if _, ok := seen[k]; !ok {
+			logEvent(levelInfo, logFields{"port": k.Port, "protocol": k.Protocol, "exe": s.last[k].ExePath},
+				"scanner: dropped port=%d exe=%q", k.Port, s.last[k].ExePath)
+		}
+	}
+	s.last = seen
+}
+
+// resolveProcesses looks up the owning process of each open port. The socket
+// table is read once per call (or taken from known when the caller already
+// read it), which is cheap; the process walk that maps sockets to processes
+// is not, so its results are cached per port and reused for as long as the
+// port's socket keeps the same inode (PID on Windows). New ports and ports
+// whose owner changed are resolved together in one walk. It returns nil when
+// resolveExe is disabled.
+func (s *Scanner) resolveProcesses(ports []int, known map[int][]listener) map[int]exeCacheEntry {
+	if !s.config.ResolveExe() || len(ports) == 0 {
+		return nil
+	}
+	byPort := known
+	if byPort == nil {
+		byPort = findListenersByPorts(ports)
+	}
+	out := make(map[int]exeCacheEntry, len(ports))
+	stale := make(map[int][]listener)
+	s.exeMu.Lock()
+	for _, port := range ports {
+		l, ok := preferredListener(byPort[port])
+		if !ok {
+			continue
+		}
+		if e, ok := s.exeCache[port]; ok && e.owner != "" && e.owner == l.owner() {
+			e.addrs = listenAddrs(byPort[port])
+			out[port] = e
+		} else {
+			stale[port] = byPort[port]
+		}
+	}
+	s.exeMu.Unlock()
+	if len(stale) == 0 {
+		return out
+	}
+
+	procs := processesForListeners(stale)
+	s.exeMu.Lock()
+	for port, ls := range stale {
+		l, _ := preferredListener(ls)
+		e := exeCacheEntry{exe: procs[port].Exe, name: procs[port].Name, addrs: listenAddrs(ls), owner: l.owner()}
+		s.exeCache[port] = e
+		out[port] = e
+	}
+	s.exeMu.Unlock()
+	return out
+}
+
+// forgetProcesses drops the cached process lookups of ports that are no
+// longer open, so a process started there later is looked up afresh even if
+// its socket happens to reuse an identifier.
+func (s *Scanner) forgetProcesses(open []int) {
+	keep := make(map[int]bool, len(open))
+	for _, port := range open {
+		keep[port] = true
+	}
+	s.exeMu.Lock()
+	defer s.exeMu.Unlock()
+	for port := range s.exeCache {
+		if !keep[port] {
+			delete(s.exeCache, port)
+		}
+	}
+}
+
+// Run starts scanning in a loop until ctx is cancelled. The first scan runs
+// immediately unless deferInitialScan is set, in which case it waits for the
+// first tick so startup isn't spent scanning large ranges.
 func (s *Scanner) Run(ctx context.Context) {
-	// Initial scan immediately
-	ports := s.scan()
-	if s.onChange != nil {
-		s.onChange(ports)
+	scan := func() {
+		// This scan serves every trigger made so far, including one whose
+		// wake-up is still queued
+		s.triggerMu.Lock()
+		done := s.triggered
+		s.triggered = nil
+		select {
+		case <-s.control:
+		default:
+		}
+		s.triggerMu.Unlock()
+
+		ports := s.scan()
+		if s.onChange != nil {
+			s.onChange(ports)
+		}
+		if done != nil {
+			close(done)
+		}
+	}
+	// A random delay shifts the first scan and the ticker's phase. A trigger
+	// or a new interval ends it early rather than waiting it out.
+	triggered := false
+	if delay := randomDelay(min(s.config.ScanJitter(), s.Interval())); delay > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.control:
+			triggered = true
+		case <-s.retimed:
+		case <-time.After(delay):
+		}
+	}
+	if triggered || !s.config.DeferInitialScan() {
+		scan()
 	}
 
-	ticker := time.NewTicker(s.interval)
+	ticker := time.NewTicker(s.Interval())
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-s.retimed:
+			ticker.Reset(s.Interval())
+		case <-s.control:
+			scan()
 		case <-ticker.C:
-			ports := s.scan()
-			if s.onChange != nil {
-				s.onChange(ports)
-			}
+			scan()
 		}
 	}
 }
 
 func (s *Scanner) scan() []DiscoveredPort {
+	start := time.Now()
+	ports := s.scanStream(nil)
+	s.statsMu.Lock()
+	s.lastScanAt, s.lastScanDur = time.Now(), time.Since(start)
+	metrics.observeScan(s.lastScanDur)
+	s.statsMu.Unlock()
+	s.trackManualFailures(ports)
+	s.logTransitions(ports)
+	return ports
+}
+
+// LastScan returns when the last full scan finished and how long it took.
+// The time is zero before the first scan.
+func (s *Scanner) LastScan() (time.Time, time.Duration) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	return s.lastScanAt, s.lastScanDur
+}
+
+// scanStream scans like scan and, if emit is non-nil, hands each port to it
+// as soon as it has been probed, so callers can output results before the
+// whole scan finishes.
+func (s *Scanner) scanStream(emit func(DiscoveredPort)) []DiscoveredPort {
 	var ports []DiscoveredPort
+	add := func(dp DiscoveredPort) {
+		ports = append(ports, dp)
+		if emit != nil {
+			emit(dp)
+		}
+	}
 	now := time.Now()
 
-	// Track which ports were found by scanning so we can mark manual ports correctly
-	scannedPorts := make(map[int]bool)
-
-	// Scan configurable ranges (deduplicate across overlapping ranges)
+	// Manual ports are always shown, even if their process is excluded
+	manualPorts := s.config.ManualPorts()
+	manual := make(map[int]ManualPort)
+	for _, mp := range manualPorts {
+		manual[mp.Port] = mp
+	}
+	excluded := s.config.ExcludeProcesses()
 	ranges := s.config.ScanRanges()
+	if s.paused.Load() {
+		ranges = nil
+	}
+
+	// In kernel mode, read the listening sockets once instead of dialing
+	// every port; the listeners also identify the owning processes
+	dial := s.prober.Dial
+	var known map[int][]listener
+	if mode, _ := s.config.ScanMode(); mode == scanModeKernel {
+		ls, err := findListenersMatching(func(port int) bool {
+			_, isManual := manual[port]
+			return isManual || inScanRanges(port, ranges)
+		})
+		if err != nil {
+			s.kernelWarn.Do(func() { log.Printf("scanner: kernel scan mode unavailable, dialing ports instead: %v", err) })
+		} else {
+			known = ls
+			dial = func(port int) error {
+				if len(known[port]) == 0 {
+					return errNotListening
+				}
+				return nil
+			}
+		}
+	}
+
+	// Why each closed manual port refused, for its LastError
+	var errMu sync.Mutex
+	dialErrs := make(map[int]error)
+	isOpen := func(port int) bool {
+		err := dial(port)
+		if _, isManual := manual[port]; err != nil && isManual {
+			errMu.Lock()
+			dialErrs[port] = err
+			errMu.Unlock()
+		}
+		return err == nil
+	}
+
+	// Find open ports in the configured ranges (deduplicate across overlapping ranges)
+	var tcpPorts, udpPorts []int
+	checked := make(map[int]bool)
+	checkedUDP := make(map[int]bool)
+	udp, _ := s.prober.(udpProber)
 	for _, r := range ranges {
 		for port := r.Start; port <= r.End; port++ {
-			if scannedPorts[port] {
-				continue
+			if r.scansTCP() && !checked[port] {
+				checked[port] = true
+				tcpPorts = append(tcpPorts, port)
 			}
-			if isOpen(port) {
-				dp := DiscoveredPort{
-					Port:     port,
-					Protocol: "tcp",
-					Healthy:  true,
-					LastSeen: now,
-					Source:   "scan",
-					ExePath:  findExeByPort(port),
-				}
-				s.probeHTTP(&dp)
-				ports = append(ports, dp)
-				scannedPorts[port] = true
+			if r.scansUDP() && udp != nil && !checkedUDP[port] {
+				checkedUDP[port] = true
+				udpPorts = append(udpPorts, port)
 			}
 		}
 	}
+	workers := s.config.ScanConcurrency()
+	open := openPorts(tcpPorts, workers, isOpen)
+	var openUDP []int
+	if udp != nil {
+		openUDP = openPorts(udpPorts, workers, udp.IsOpenUDP)
+	}
+
+	// Health-check manual ports outside the ranges
+	var unranged []int
+	for _, mp := range manualPorts {
+		if !checked[mp.Port] {
+			unranged = append(unranged, mp.Port)
+		}
+	}
+	manualHealthy := make(map[int]bool)
+	resolve := append([]int(nil), open...)
+	for _, port := range openPorts(unranged, workers, isOpen) {
+		manualHealthy[port] = true
+		resolve = append(resolve, port)
+	}
+
+	// Resolve the owning process of every open port in one sweep
+	procs := s.resolveProcesses(resolve, known)
+	s.forgetProcesses(resolve)
+
+	// Track which ports were found by scanning so we can mark manual ports correctly
+	scannedPorts := make(map[int]bool)
+	for _, port := range open {
+		dp := DiscoveredPort{
+			Port:        port,
+			Protocol:    "tcp",
+			Healthy:     true,
+			LastSeen:    now,
+			Source:      "scan",
+			ExePath:     procs[port].exe,
+			ProcessName: procs[port].name,
+			ListenAddrs: procs[port].addrs,
+		}
+		mp, isManual := manual[port]
+		if !isManual && processExcluded(dp.ExePath, excluded) {
+			continue
+		}
+		s.probePort(&dp, mp)
+		// Found by scan — but apply manual path override if set
+		if mp.Path != "" {
+			dp.ExePath = mp.Path
+		}
+		add(dp)
+		scannedPorts[port] = true
+	}
+
+	// UDP ports aren't probed: there's no common protocol to speak, and the
+	// owning process lookup only reads TCP listeners
+	for _, port := range openUDP {
+		add(DiscoveredPort{
+			Port:        port,
+			Protocol:    "udp",
+			ServiceName: "udp",
+			Healthy:     true,
+			LastSeen:    now,
+			Source:      "scan",
+		})
+	}
 
 	// Add manual ports — health-check each one
-	for _, mp := range s.config.ManualPorts() {
+	for _, mp := range manualPorts {
 		if scannedPorts[mp.Port] {
-			// Already found by scan — but apply manual path override if set
-			if mp.Path != "" {
-				for i := range ports {
-					if ports[i].Port == mp.Port {
-						ports[i].ExePath = mp.Path
-						break
-					}
-				}
-			}
 			continue
 		}
 		dp := DiscoveredPort{
 			Port:     mp.Port,
 			Protocol: "tcp",
-			Healthy:  isOpen(mp.Port),
+			Healthy:  manualHealthy[mp.Port],
 			LastSeen: now,
 			Source:   "manual",
 		}
 		if mp.Name != "" {
 			dp.Title = mp.Name
 		}
-		// Use manually-specified path, or try to detect it
+		if !dp.Healthy {
+			dp.HealthReason = healthTCPClosed
+			dp.LastError = dialReason(dialErrs[mp.Port])
+		}
+		// Use manually-specified path, or the detected one
+		if dp.Healthy {
+			dp.ExePath = procs[mp.Port].exe
+			dp.ProcessName = procs[mp.Port].name
+			dp.ListenAddrs = procs[mp.Port].addrs
+		}
 		if mp.Path != "" {
 			dp.ExePath = mp.Path
-		} else if dp.Healthy {
-			dp.ExePath = findExeByPort(mp.Port)
 		}
 		if dp.Healthy {
-			s.probeHTTP(&dp)
-			// Preserve manual name if probeHTTP didn't find a title
+			s.probePort(&dp, mp)
+			// Preserve manual name if the probe didn't find a title
 			if dp.Title == "" && mp.Name != "" {
 				dp.Title = mp.Name
 			}
 		}
-		ports = append(ports, dp)
+		add(dp)
+	}
+
+	return ports
+}
+
+// CheckPort checks one port the way a scan would, whether or not it lies in
+// the scan ranges. Healthy is false if nothing accepts connections on it.
+func (s *Scanner) CheckPort(port int) DiscoveredPort {
+	dp := DiscoveredPort{Port: port, Protocol: "tcp", LastSeen: time.Now(), Source: "scan"}
+	var mp ManualPort
+	for _, m := range s.config.ManualPorts() {
+		if m.Port == port {
+			mp, dp.Source, dp.Title = m, "manual", m.Name
+		}
+	}
+	if mp.Path != "" {
+		dp.ExePath = mp.Path
+	}
+	if err := s.prober.Dial(port); err != nil {
+		dp.HealthReason = healthTCPClosed
+		if dp.Source == "manual" {
+			dp.LastError = dialReason(err)
+		}
+		return dp
+	}
+	dp.Healthy = true
+	if proc, ok := s.resolveProcesses([]int{port}, nil)[port]; ok {
+		dp.ListenAddrs = proc.addrs
+		dp.ProcessName = proc.name
+		if mp.Path == "" {
+			dp.ExePath = proc.exe
+		}
+	}
+	s.probePort(&dp, mp)
+	if dp.Title == "" {
+		dp.Title = mp.Name
+	}
+	return dp
+}
+
+// RecheckKnown re-checks the health of already-discovered ports without
+// scanning the configured ranges. Open ports are re-probed; scanned ports that
+// have closed are dropped and manual ports are kept but marked unhealthy, as a
+// full scan would. Ports are checked concurrently.
+func (s *Scanner) RecheckKnown(known []DiscoveredPort) []DiscoveredPort {
+	return s.recheckKnown(known, true)
+}
+
+// RefreshKnown is RecheckKnown for the health loop. Ports are only dialed:
+// one that was healthy and is still open keeps its last probe result, and
+// only ports whose state may have changed are probed again, so running
+// services don't get an HTTP request every few seconds.
+func (s *Scanner) RefreshKnown(known []DiscoveredPort) []DiscoveredPort {
+	return s.recheckKnown(known, false)
+}
+
+// recheckKnown implements RecheckKnown and RefreshKnown. With reprobe every
+// open port is probed, otherwise only the ones that weren't healthy.
+func (s *Scanner) recheckKnown(known []DiscoveredPort, reprobe bool) []DiscoveredPort {
+	manual := make(map[int]ManualPort)
+	for _, mp := range s.config.ManualPorts() {
+		manual[mp.Port] = mp
+	}
+	now := time.Now()
+	out := make([]DiscoveredPort, len(known))
+	keep := make([]bool, len(known))
+	var wg sync.WaitGroup
+	for i, p := range known {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dp := p
+			dp.Stale = false
+			dp.ConsecutiveFailures, dp.LastError = 0, "" // trackManualFailures refills these
+			if dp.Protocol == "udp" {
+				udp, ok := s.prober.(udpProber)
+				if dp.Healthy = ok && udp.IsOpenUDP(dp.Port); dp.Healthy {
+					dp.LastSeen = now
+				}
+				out[i], keep[i] = dp, dp.Healthy
+				return
+			}
+			mp, isManual := manual[dp.Port]
+			if err := s.prober.Dial(dp.Port); err != nil {
+				dp.Healthy, dp.HealthReason = false, healthTCPClosed
+				dp.LastError = dialReason(err)
+				out[i], keep[i] = dp, isManual || dp.Source == "manual"
+				return
+			}
+			dp.Healthy = true
+			dp.LastSeen = now
+			if !reprobe && p.Healthy {
+				out[i], keep[i] = dp, true
+				return
+			}
+			dp.Title = mp.Name
+			dp.AuthScheme, dp.AuthRealm = "", ""
+			s.probePort(&dp, mp)
+			if dp.Title == "" && mp.Name != "" {
+				dp.Title = mp.Name
+			}
+			out[i], keep[i] = dp, true
+		}()
 	}
+	wg.Wait()
 
+	ports := out[:0]
+	for i, dp := range out {
+		if keep[i] {
+			ports = append(ports, dp)
+		}
+	}
+	s.trackManualFailures(ports)
+	s.logTransitions(ports)
 	return ports
 }
 
-func isOpen(port int) bool {
+// trackManualFailures counts consecutive failed checks of each manual port
+// across scans and rechecks, filling in ConsecutiveFailures on the ones that
+// are down. The checks set LastError themselves, except for a bad status. A
+// successful check resets the count.
+func (s *Scanner) trackManualFailures(ports []DiscoveredPort) {
+	s.failMu.Lock()
+	defer s.failMu.Unlock()
+	seen := make(map[int]bool)
+	for i := range ports {
+		dp := &ports[i]
+		if dp.Source != "manual" || dp.Healthy {
+			continue
+		}
+		seen[dp.Port] = true
+		s.failures[dp.Port]++
+		dp.ConsecutiveFailures = s.failures[dp.Port]
+		// A refused dial or failed health check already said why
+		if dp.HealthReason == healthBadStatus {
+			dp.LastError = fmt.Sprintf("status %d", dp.ProbeStatus)
+		}
+	}
+	maps.DeleteFunc(s.failures, func(port int, _ int) bool { return !seen[port] })
+}
+
+// errNotListening is a closed port's dial error in kernel scan mode, where
+// the socket table is read instead of dialing.
+var errNotListening = errors.New("not listening")
+
+// dialReason describes why a dial failed, e.g. "connection refused", or ""
+// for a nil error.
+func dialReason(err error) string {
+	if err == nil {
+		return ""
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return "timeout"
+	}
+	var se *os.SyscallError
+	if errors.As(err, &se) {
+		return se.Err.Error()
+	}
+	return err.Error()
+}
+
+// inScanRanges reports whether port falls in any of ranges.
+func inScanRanges(port int, ranges []ScanRange) bool {
+	for _, r := range ranges {
+		if port >= r.Start && port <= r.End {
+			return true
+		}
+	}
+	return false
+}
+
+// processExcluded reports whether the exe's basename matches any of the
+// exclusion globs. Matching is case-insensitive and also tried without the
+// extension, so "chrome" excludes chrome.exe.
+func processExcluded(exe string, patterns []string) bool {
+	if exe == "" || len(patterns) == 0 {
+		return false
+	}
+	base := strings.ToLower(filepath.Base(exe))
+	noExt := strings.TrimSuffix(base, filepath.Ext(base))
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, base); ok {
+			return true
+		}
+		if ok, _ := path.Match(p, noExt); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func dialPort(port int) error {
 	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), 500*time.Millisecond)
 	if err != nil {
-		return false
+		return err
 	}
 	conn.Close()
-	return true
+	return nil
 }
 
-func (s *Scanner) probeHTTP(dp *DiscoveredPort) {
-	client := &http.Client{Timeout: 2 * time.Second}
-	resp, err := client.Get(fmt.Sprintf("http://127.0.0.1:%d/", dp.Port))
+// openPorts checks ports with up to workers checks in flight and returns the
+// open ones in ascending order, however the checks finish.
+func openPorts(ports []int, workers int, isOpen func(int) bool) []int {
+	found := make([]bool, len(ports))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(workers, len(ports)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				found[i] = isOpen(ports[i])
+			}
+		}()
+	}
+	for i := range ports {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	var open []int
+	for i, ok := range found {
+		if ok {
+			open = append(open, ports[i])
+		}
+	}
+	slices.Sort(open)
+	return open
+}
+
+// udpProbeTimeout is how long isOpenUDP waits for a closed port's ICMP
+// port-unreachable before assuming something is bound.
+var udpProbeTimeout = 200 * time.Millisecond
+
+// isOpenUDP sends an empty datagram to port and waits briefly. UDP has no
+// handshake, so this is best effort: a closed port answers with ICMP
+// port-unreachable, which surfaces as a read error, while a bound socket
+// either replies or stays silent. Silence counts as open.
+func isOpenUDP(port int) bool {
+	conn, err := net.Dial("udp", fmt.Sprintf("127.0.0.1:%d", port))
 	if err != nil {
-		dp.ServiceName = "tcp"
+		return false
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(udpProbeTimeout))
+	if _, err := conn.Write(nil); err != nil {
+		return false
+	}
+	_, err = conn.Read(make([]byte, 1))
+	var ne net.Error
+	return err == nil || errors.As(err, &ne) && ne.Timeout()
+}
+
+// probeSpec returns how to probe a port: the global probe paths and Accept
+// header, overridden by those of its manual registration mp, if any.
+func (s *Scanner) probeSpec(mp ManualPort) probeSpec {
+	paths, accept := s.config.ProbeDefaults()
+	if len(mp.ProbePaths) > 0 {
+		paths = mp.ProbePaths
+	}
+	if mp.ProbeAccept != "" {
+		accept = mp.ProbeAccept
+	}
+	return probeSpec{host: mp.ProbeHost, paths: paths, accept: accept, maxTitle: s.config.MaxTitleLength()}
+}
+
+// probePort probes dp using the settings of its manual registration mp (the
+// zero value for scanned ports). Ports with a probe host use it as-is; the
+// rest fall back to their mapping's hostname.
+func (s *Scanner) probePort(dp *DiscoveredPort, mp ManualPort) {
+	spec := s.probeSpec(mp)
+	if spec.host != "" {
+		s.prober.Probe(dp, spec)
+	} else {
+		s.probeWithFallback(dp, spec)
+	}
+	codes, _ := s.config.HealthyStatusCodes()
+	applyHealthyStatus(dp, codes)
+	if mp.HealthPath != "" {
+		s.applyHealthCheck(dp, mp)
+	}
+}
+
+// applyHealthCheck probes mp's health path and marks dp unhealthy unless it
+// answers with a status in healthyStatusCodes, or 2xx or 3xx without that
+// option, so an open port whose service isn't ready yet shows as failing
+// rather than up. LastError says what went wrong.
+func (s *Scanner) applyHealthCheck(dp *DiscoveredPort, mp ManualPort) {
+	spec := s.probeSpec(mp)
+	spec.paths, spec.noRedirects = []string{mp.HealthPath}, true
+	check := *dp
+	status := s.prober.Probe(&check, spec)
+	codes, _ := s.config.HealthyStatusCodes()
+	switch {
+	case status <= 0 && check.HealthReason == healthProbeTimeout:
+		dp.LastError = fmt.Sprintf("GET %s: timeout", mp.HealthPath)
+	case status <= 0:
+		dp.LastError = fmt.Sprintf("GET %s: no HTTP response", mp.HealthPath)
+	case len(codes) > 0 && !codes.Contains(status),
+		len(codes) == 0 && (status < 200 || status >= 400):
+		dp.LastError = fmt.Sprintf("GET %s: status %d", mp.HealthPath, status)
+	default:
 		return
 	}
+	dp.Healthy = false
+	dp.HealthReason = healthCheckFailed
+}
+
+// applyHealthyStatus marks a port that answered HTTP with a status outside
+// codes unhealthy. Ports that don't speak HTTP keep their TCP health, and
+// with no codes configured any open port stays healthy.
+func applyHealthyStatus(dp *DiscoveredPort, codes statusCodeSet) {
+	if len(codes) == 0 || dp.ProbeStatus == 0 || dp.HealthReason == healthTCPOnly || dp.HealthReason == healthProbeTimeout {
+		return
+	}
+	if !codes.Contains(dp.ProbeStatus) {
+		dp.Healthy = false
+		dp.HealthReason = healthBadStatus
+	}
+}
+
+// statusCodeSet is a set of HTTP status codes, held as inclusive ranges.
+type statusCodeSet [][2]int
+
+// parseStatusCodes parses entries like "200", "2xx" or "200-204" into a set.
+func parseStatusCodes(entries []string) (statusCodeSet, error) {
+	var set statusCodeSet
+	for _, e := range entries {
+		e = strings.ToLower(strings.TrimSpace(e))
+		lo, hi := e, e
+		if len(e) == 3 && strings.HasSuffix(e, "xx") {
+			lo, hi = e[:1]+"00", e[:1]+"99"
+		} else if a, b, ok := strings.Cut(e, "-"); ok {
+			lo, hi = a, b
+		}
+		start, err1 := strconv.Atoi(lo)
+		end, err2 := strconv.Atoi(hi)
+		if err1 != nil || err2 != nil || start < 100 || end > 599 || start > end {
+			return nil, fmt.Errorf("invalid status code %q (expected e.g. 200, 2xx or 200-204)", e)
+		}
+		set = append(set, [2]int{start, end})
+	}
+	return set, nil
+}
+
+// Contains reports whether code is in the set.
+func (set statusCodeSet) Contains(code int) bool {
+	for _, r := range set {
+		if code >= r[0] && code <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// probeWithFallback probes dp with the default Host and, if the service
+// answers with a non-2xx status and the port is mapped, retries with the
+// mapping's hostname in case the service only serves its expected vhost.
+func (s *Scanner) probeWithFallback(dp *DiscoveredPort, spec probeSpec) {
+	status := s.prober.Probe(dp, spec)
+	if status == 0 || (status >= 200 && status < 300) {
+		return
+	}
+	host := s.config.MappedHost(dp.Port)
+	if host == "" {
+		return
+	}
+	retry := *dp
+	retry.Title = ""
+	retry.AuthScheme, retry.AuthRealm = "", ""
+	spec.host = host
+	if status := s.prober.Probe(&retry, spec); status >= 200 && status < 300 {
+		*dp = retry
+	}
+}
+
+// Health reasons say why a port is in the state it is. Healthy reflects
+// whether the port accepts TCP connections, unless healthyStatusCodes is
+// set or a manual port has a healthPath; the reason tells a crashed service
+// from a hung or failing one.
+const (
+	healthOK           = "ok"            // answered HTTP with a status below 500
+	healthTCPOnly      = "tcp-only"      // accepts connections but doesn't speak HTTP
+	healthProbeTimeout = "probe-timeout" // accepted the probe but sent nothing back in time
+	healthHTTPError    = "http-error"    // answered HTTP 5xx
+	healthBadStatus    = "bad-status"    // answered HTTP outside healthyStatusCodes; unhealthy
+	healthCheckFailed  = "health-check"  // open, but the manual port's healthPath didn't answer 2xx/3xx; unhealthy
+	healthTCPClosed    = "tcp-closed"    // nothing accepts connections; unhealthy
+)
+
+// portLabel names what runs on a port for display: the page title, or the
+// process name when the port served no title.
+func portLabel(dp DiscoveredPort) string {
+	return cmp.Or(dp.Title, dp.ProcessName)
+}
+
+// healthLabel describes a port's health reason for display, with the status
+// for HTTP errors (e.g. "http-error 502"). It is empty when there is nothing
+// worth pointing out.
+func healthLabel(dp DiscoveredPort) string {
+	switch dp.HealthReason {
+	case "", healthOK, healthTCPOnly:
+		return ""
+	case healthHTTPError, healthBadStatus:
+		return fmt.Sprintf("%s %d", dp.HealthReason, dp.ProbeStatus)
+	}
+	return dp.HealthReason
+}
+
+// failureLabel is healthLabel for a manual port that keeps failing its
+// checks, saying for how long and why, e.g. "unreachable for 3 checks:
+// connection refused".
+func failureLabel(dp DiscoveredPort) string {
+	if dp.ConsecutiveFailures == 0 {
+		return healthLabel(dp)
+	}
+	checks := "checks"
+	if dp.ConsecutiveFailures == 1 {
+		checks = "check"
+	}
+	state := "unreachable"
+	switch dp.HealthReason {
+	case healthBadStatus:
+		state = "unhealthy"
+	case healthCheckFailed:
+		state = "health check failing"
+	}
+	label := fmt.Sprintf("%s for %d %s", state, dp.ConsecutiveFailures, checks)
+	if dp.LastError != "" {
+		label += ": " + dp.LastError
+	}
+	return label
+}
+
+// probeTimeout bounds each HTTP probe and TLS handshake unless the probe's
+// spec sets its own.
+const probeTimeout = 2 * time.Second
+
+// probeHTTP checks whether dp speaks HTTP and fills in its title. The probe
+// paths are tried in order and the first 2xx answer wins; if none succeeds
+// the first path's result is kept. A port that doesn't answer HTTP at all
+// isn't tried further. It returns the response status, or 0 if the port did
+// not answer HTTP.
+func probeHTTP(dp *DiscoveredPort, spec probeSpec) int {
+	paths := spec.paths
+	if len(paths) == 0 {
+		paths = defaultProbePaths
+	}
+	dp.ProbePath, dp.ProbeStatus, dp.ContentType = "", 0, ""
+	var first DiscoveredPort
+	firstStatus := -1
+	for _, p := range paths {
+		try := *dp
+		status := probePath(&try, spec, p)
+		if status >= 200 && status < 300 {
+			*dp = try
+			return status
+		}
+		if firstStatus < 0 {
+			first, firstStatus = try, status
+		}
+		if status == 0 {
+			break
+		}
+	}
+	*dp = first
+	return firstStatus
+}
+
+// probePath requests one path on dp's port. Ports that reject plain HTTP are
+// retried over TLS. A port that never answered isn't: a TLS server rejects
+// the plain request at once, so a silent one would only time out again.
+func probePath(dp *DiscoveredPort, spec probeSpec, urlPath string) int {
+	status := probeURL(dp, "http", spec, urlPath)
+	if status != 0 && status != http.StatusBadRequest {
+		return status
+	}
+	if status == 0 && dp.HealthReason == healthProbeTimeout {
+		return status
+	}
+	// Plain HTTP failed or got the "HTTP request to an HTTPS port" 400
+	tlsDP := *dp
+	if !readPeerCert(&tlsDP, spec) {
+		return status
+	}
+	if tlsStatus := probeURL(&tlsDP, "https", spec, urlPath); tlsStatus != 0 {
+		*dp = tlsDP
+		return tlsStatus
+	}
+	return status
+}
+
+// probeTransport is shared by every probe and health check. Certificate
+// verification is skipped, and keep-alives are off so probed services aren't
+// left holding idle connections.
+var probeTransport = &http.Transport{
+	TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+	DisableKeepAlives: true,
+}
+
+// probeURL requests urlPath on dp's port using scheme and records what it
+// learns. The probe only classifies the service.
+func probeURL(dp *DiscoveredPort, scheme string, spec probeSpec, urlPath string) int {
+	client := &http.Client{Timeout: cmp.Or(spec.timeout, probeTimeout), Transport: probeTransport}
+	if spec.noRedirects {
+		client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	}
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s://127.0.0.1:%d%s", scheme, dp.Port, urlPath), nil)
+	if err != nil {
+		dp.ServiceName = "tcp"
+		return 0
+	}
+	if spec.host != "" {
+		req.Host = spec.host
+	}
+	if spec.accept != "" {
+		req.Header.Set("Accept", spec.accept)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		dp.ServiceName = "tcp"
+		dp.HealthReason = healthTCPOnly
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			dp.HealthReason = healthProbeTimeout
+		}
+		return 0
+	}
 	defer resp.Body.Close()
 
-	dp.ServiceName = "http"
+	dp.ServiceName = scheme
+	dp.HealthReason = healthOK
+	if resp.StatusCode >= 500 {
+		dp.HealthReason = healthHTTPError
+	}
+	dp.ProbePath, dp.ProbeStatus = urlPath, resp.StatusCode
+	dp.ContentType, _, _ = mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	// A 401 challenge means the service is up but gated
+	if challenge := resp.Header.Get("WWW-Authenticate"); resp.StatusCode == http.StatusUnauthorized && challenge != "" {
+		dp.ServiceName = scheme + " (auth)"
+		dp.AuthScheme, dp.AuthRealm = parseAuthChallenge(challenge)
+		dp.AuthRealm = sanitizeTitle(dp.AuthRealm, spec.maxTitle)
+	}
 
 	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
 	if err != nil {
-		return
+		return resp.StatusCode
 	}
 
 	if matches := titleRe.FindSubmatch(body); len(matches) > 1 {
-		dp.Title = strings.TrimSpace(string(matches[1]))
+		dp.Title = sanitizeTitle(string(matches[1]), spec.maxTitle)
+	}
+	if dp.Title == "" {
+		dp.Title = dp.AuthRealm
 	}
 
-	serverHeader := resp.Header.Get("Server")
+	serverHeader := sanitizeTitle(resp.Header.Get("Server"), spec.maxTitle)
 	if serverHeader != "" && dp.Title == "" {
 		dp.Title = serverHeader
 	}
+	return resp.StatusCode
+}
+
+// sanitizeTitle makes text taken from a service safe to show in a terminal
+// or the dashboard: escape sequences and control characters are removed,
+// whitespace runs collapse to one space, and anything past max characters is
+// replaced with an ellipsis. A max of zero or less means no limit.
+func sanitizeTitle(s string, max int) string {
+	s = ansiRe.ReplaceAllString(strings.ToValidUTF8(s, ""), "")
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return ' '
+		}
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		return r
+	}, s)
+	s = strings.Join(strings.Fields(s), " ")
+	if runes := []rune(s); max > 0 && len(runes) > max {
+		s = strings.TrimRight(string(runes[:max-1]), " ") + "…"
+	}
+	return s
+}
+
+// parseAuthChallenge returns the auth scheme and realm of the first
+// challenge in a WWW-Authenticate header, e.g. `Basic realm="Router"`.
+func parseAuthChallenge(challenge string) (scheme, realm string) {
+	scheme, params, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	if m := realmRe.FindStringSubmatch(params); m != nil {
+		realm = m[1] + m[2]
+	}
+	return scheme, realm
+}
+
+// readPeerCert performs a TLS handshake with dp's port and records the leaf
+// certificate. Verification errors are ignored; self-signed and mkcert certs
+// are the common case. It reports whether the handshake succeeded.
+func readPeerCert(dp *DiscoveredPort, spec probeSpec) bool {
+	cfg := &tls.Config{InsecureSkipVerify: true}
+	if h, _, err := net.SplitHostPort(spec.host); err == nil {
+		cfg.ServerName = h
+	} else {
+		cfg.ServerName = spec.host
+	}
+	dialer := &net.Dialer{Timeout: cmp.Or(spec.timeout, probeTimeout)}
+	conn, err := tls.DialWithDialer(dialer, "tcp", fmt.Sprintf("127.0.0.1:%d", dp.Port), cfg)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return true
+	}
+	leaf := certs[0]
+	expiry := leaf.NotAfter
+	dp.TLSSubject = leaf.Subject.CommonName
+	if dp.TLSSubject == "" {
+		dp.TLSSubject = leaf.Subject.String()
+	}
+	dp.TLSIssuer = leaf.Issuer.CommonName
+	if dp.TLSIssuer == "" {
+		dp.TLSIssuer = leaf.Issuer.String()
+	}
+	dp.TLSSANs = append([]string(nil), leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		dp.TLSSANs = append(dp.TLSSANs, ip.String())
+	}
+	dp.TLSExpiry = &expiry
+	return true
 }
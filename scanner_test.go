@@ -0,0 +1,1206 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// newTestConfigStore returns a ConfigStore backed by a temp file.
+func newTestConfigStore(t *testing.T) *ConfigStore {
+	t.Helper()
+	cs, err := NewConfigStore(filepath.Join(t.TempDir(), "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cs
+}
+
+// listenerPort returns the port an httptest server is bound to.
+func listenerPort(t *testing.T, srv *httptest.Server) int {
+	t.Helper()
+	_, portStr, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(portStr)
+	return port
+}
+
+func TestScanPopulatesExePath(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("exe resolution test relies on /proc")
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	port := listenerPort(t, srv)
+
+	cs := newTestConfigStore(t)
+	cs.cfg.ScanRanges = []ScanRange{{Start: port, End: port}}
+	s := NewScanner(time.Second, cs, nil)
+
+	ports := s.scan()
+	if len(ports) != 1 {
+		t.Fatalf("scan found %d ports, want 1", len(ports))
+	}
+	want, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ports[0].ExePath != want {
+		t.Errorf("ExePath = %q, want %q", ports[0].ExePath, want)
+	}
+
+	// Disabling resolution leaves ExePath empty
+	off := false
+	cs.cfg.ResolveExe = &off
+	s = NewScanner(time.Second, cs, nil)
+	if ports := s.scan(); len(ports) != 1 || ports[0].ExePath != "" {
+		t.Errorf("with resolveExe off, got %+v", ports)
+	}
+}
+
+func TestProbeVirtualHost(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "shop.localhost" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("<title>Shop</title>"))
+	}))
+	defer srv.Close()
+	port := listenerPort(t, srv)
+
+	cs := newTestConfigStore(t)
+	s := NewScanner(time.Second, cs, nil)
+
+	dp := DiscoveredPort{Port: port}
+	s.probeWithFallback(&dp, probeSpec{})
+	if dp.Title != "" {
+		t.Errorf("unmapped probe got title %q, want none", dp.Title)
+	}
+
+	// A mapping for the port lets the scanner retry with its hostname
+	cs.cfg.Mappings = []DomainMapping{{Domain: "shop", TargetPort: port}}
+	dp = DiscoveredPort{Port: port}
+	s.probeWithFallback(&dp, probeSpec{})
+	if dp.Title != "Shop" {
+		t.Errorf("mapped probe title = %q, want Shop", dp.Title)
+	}
+
+	// An explicit probe host is used as-is
+	dp = DiscoveredPort{Port: port}
+	if status := probeHTTP(&dp, probeSpec{host: "shop.localhost"}); status != http.StatusOK || dp.Title != "Shop" {
+		t.Errorf("probeHTTP with host: status %d title %q", status, dp.Title)
+	}
+}
+
+func TestProbeHTTPSCertificate(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<title>Secure</title>"))
+	}))
+	defer srv.Close()
+
+	dp := DiscoveredPort{Port: listenerPort(t, srv)}
+	if status := probeHTTP(&dp, probeSpec{}); status != http.StatusOK {
+		t.Fatalf("probeHTTP status = %d, want 200", status)
+	}
+	if dp.ServiceName != "https" || dp.Title != "Secure" {
+		t.Errorf("got service %q title %q, want https/Secure", dp.ServiceName, dp.Title)
+	}
+	leaf := srv.Certificate()
+	if dp.TLSExpiry == nil || !dp.TLSExpiry.Equal(leaf.NotAfter) {
+		t.Errorf("TLSExpiry = %v, want %v", dp.TLSExpiry, leaf.NotAfter)
+	}
+	if len(dp.TLSSANs) == 0 || dp.TLSSANs[0] != leaf.DNSNames[0] {
+		t.Errorf("TLSSANs = %v, want to start with %q", dp.TLSSANs, leaf.DNSNames[0])
+	}
+}
+
+func TestProbeAuthRequired(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+		service string
+		title   string
+		scheme  string
+		realm   string
+	}{
+		{
+			name: "basic realm",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("WWW-Authenticate", `Basic realm="Router Admin", charset="UTF-8"`)
+				w.WriteHeader(http.StatusUnauthorized)
+			},
+			status: 401, service: "http (auth)", title: "Router Admin", scheme: "Basic", realm: "Router Admin",
+		},
+		{
+			name: "page title wins over realm",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("WWW-Authenticate", `Digest realm=grafana, qop="auth"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				io.WriteString(w, "<title>Sign in</title>")
+			},
+			status: 401, service: "http (auth)", title: "Sign in", scheme: "Digest", realm: "grafana",
+		},
+		{
+			name: "401 without challenge",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusUnauthorized)
+			},
+			status: 401, service: "http",
+		},
+		{
+			name: "forbidden",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusForbidden)
+				io.WriteString(w, "<title>Forbidden</title>")
+			},
+			status: 403, service: "http", title: "Forbidden",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+			port := listenerPort(t, srv)
+
+			dp := DiscoveredPort{Port: port}
+			if status := probeHTTP(&dp, probeSpec{}); status != tt.status {
+				t.Errorf("status = %d, want %d", status, tt.status)
+			}
+			if dp.ServiceName != tt.service || dp.Title != tt.title || dp.AuthScheme != tt.scheme || dp.AuthRealm != tt.realm {
+				t.Errorf("probe = service %q title %q auth %q/%q, want %q %q %q/%q",
+					dp.ServiceName, dp.Title, dp.AuthScheme, dp.AuthRealm, tt.service, tt.title, tt.scheme, tt.realm)
+			}
+
+			// Gated services are up, not down
+			cs := newTestConfigStore(t)
+			off := false
+			cs.cfg.ResolveExe = &off
+			cs.cfg.ScanRanges = []ScanRange{{Start: port, End: port}}
+			ports := NewScanner(time.Second, cs, nil).scan()
+			if len(ports) != 1 || !ports[0].Healthy {
+				t.Errorf("scan = %+v, want the port healthy", ports)
+			}
+		})
+	}
+}
+
+// fakeProber is a PortProber over a fixed set of synthetic services.
+type fakeProber struct {
+	services map[int]DiscoveredPort // open ports and what probing reports
+	udp      map[int]bool           // open UDP ports
+}
+
+func (f fakeProber) IsOpenUDP(port int) bool { return f.udp[port] }
+
+func (f fakeProber) Dial(port int) error {
+	if _, ok := f.services[port]; ok {
+		return nil
+	}
+	return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+}
+
+func (f fakeProber) Probe(dp *DiscoveredPort, spec probeSpec) int {
+	svc := f.services[dp.Port]
+	dp.ServiceName = svc.ServiceName
+	if svc.Title != "" {
+		dp.Title = svc.Title
+	}
+	if svc.ProbeStatus != 0 {
+		dp.ProbeStatus, dp.HealthReason = svc.ProbeStatus, healthOK
+		return svc.ProbeStatus
+	}
+	if svc.ServiceName == "http" {
+		return http.StatusOK
+	}
+	return 0
+}
+
+func TestScannerWithFakeProber(t *testing.T) {
+	cs := newTestConfigStore(t)
+	off := false
+	cs.cfg.ResolveExe = &off
+	cs.cfg.ScanRanges = []ScanRange{{Start: 3000, End: 3005}}
+	cs.cfg.ManualPorts = []ManualPort{{Port: 9000, Name: "db"}, {Port: 9001, Name: "down"}}
+
+	s := NewScanner(time.Second, cs, nil)
+	s.prober = fakeProber{services: map[int]DiscoveredPort{
+		3001: {ServiceName: "http", Title: "Web"},
+		3004: {ServiceName: "tcp"},
+		9000: {ServiceName: "tcp"},
+	}}
+
+	hub := NewHub(cs)
+	go hub.Run()
+	hub.SetPorts(s.scan())
+
+	rec := httptest.NewRecorder()
+	DashboardHandler(hub, NewSessionStore()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/ports", nil))
+	var ports []DiscoveredPort
+	if err := json.NewDecoder(rec.Body).Decode(&ports); err != nil {
+		t.Fatal(err)
+	}
+
+	type summary struct {
+		port    int
+		source  string
+		title   string
+		healthy bool
+	}
+	var got []summary
+	for _, p := range ports {
+		got = append(got, summary{p.Port, p.Source, p.Title, p.Healthy})
+	}
+	want := []summary{
+		{3001, "scan", "Web", true},
+		{3004, "scan", "", true},
+		{9000, "manual", "db", true},
+		{9001, "manual", "down", false},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("/api/ports = %+v, want %+v", got, want)
+	}
+}
+
+func TestUDPScan(t *testing.T) {
+	cs := newTestConfigStore(t)
+	off := false
+	cs.cfg.ResolveExe = &off
+	cs.cfg.ScanRanges = []ScanRange{
+		{Start: 3000, End: 3002},
+		{Start: 5000, End: 5002, Protocol: scanProtoUDP},
+		{Start: 6000, End: 6001, Protocol: scanProtoBoth},
+	}
+	s := NewScanner(time.Second, cs, nil)
+	s.prober = fakeProber{
+		services: map[int]DiscoveredPort{3001: {ServiceName: "tcp"}, 5001: {ServiceName: "tcp"}, 6000: {ServiceName: "tcp"}},
+		udp:      map[int]bool{3001: true, 5001: true, 6000: true},
+	}
+
+	var got []string
+	for _, p := range s.scan() {
+		got = append(got, fmt.Sprintf("%d/%s", p.Port, p.Protocol))
+	}
+	// 5001 is only scanned over UDP, 3001 only over TCP
+	want := []string{"3001/tcp", "6000/tcp", "5001/udp", "6000/udp"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("scan = %v, want %v", got, want)
+	}
+
+	// A UDP port that stops answering drops out on recheck
+	known := s.scan()
+	s.prober = fakeProber{
+		services: map[int]DiscoveredPort{3001: {ServiceName: "tcp"}, 6000: {ServiceName: "tcp"}},
+		udp:      map[int]bool{6000: true},
+	}
+	got = nil
+	for _, p := range s.RecheckKnown(known) {
+		got = append(got, fmt.Sprintf("%d/%s", p.Port, p.Protocol))
+	}
+	want = []string{"3001/tcp", "6000/tcp", "6000/udp"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("recheck = %v, want %v", got, want)
+	}
+}
+
+func TestOpenPorts(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	isOpen := func(port int) bool {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		// Later ports answer first, so completion order is the reverse of port order
+		time.Sleep(time.Duration(100-port) * 100 * time.Microsecond)
+		return port%3 == 0
+	}
+	var ports []int
+	for p := 99; p >= 1; p-- {
+		ports = append(ports, p)
+	}
+
+	got := openPorts(ports, 8, isOpen)
+	var want []int
+	for p := 3; p < 100; p += 3 {
+		want = append(want, p)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("openPorts = %v, want %v", got, want)
+	}
+	if n := peak.Load(); n > 8 || n < 2 {
+		t.Errorf("peak concurrency = %d, want 2..8", n)
+	}
+	if got := openPorts(nil, 8, isOpen); got != nil {
+		t.Errorf("openPorts(nil) = %v", got)
+	}
+}
+
+func TestRecheckKnown(t *testing.T) {
+	cs := newTestConfigStore(t)
+	off := false
+	cs.cfg.ResolveExe = &off
+	cs.cfg.ScanRanges = []ScanRange{{Start: 3000, End: 3005}}
+	cs.cfg.ManualPorts = []ManualPort{{Port: 9000, Name: "db"}}
+
+	s := NewScanner(time.Second, cs, nil)
+	s.prober = fakeProber{services: map[int]DiscoveredPort{
+		3001: {ServiceName: "http", Title: "Web"},
+		3004: {ServiceName: "tcp"},
+		9000: {ServiceName: "tcp"},
+	}}
+	hub := NewHub(cs)
+	go hub.Run()
+	hub.SetScanner(s)
+	hub.SetPorts(s.scan())
+
+	// 3004 and the manual port go down; a newly opened port is not picked up
+	s.prober = fakeProber{services: map[int]DiscoveredPort{
+		3001: {ServiceName: "http", Title: "Web v2"},
+		3002: {ServiceName: "http"},
+	}}
+	rec := httptest.NewRecorder()
+	DashboardHandler(hub, NewSessionStore()).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/ports/recheck", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var ports []DiscoveredPort
+	if err := json.NewDecoder(rec.Body).Decode(&ports); err != nil {
+		t.Fatal(err)
+	}
+
+	type summary struct {
+		port    int
+		title   string
+		healthy bool
+	}
+	var got []summary
+	for _, p := range ports {
+		got = append(got, summary{p.Port, p.Title, p.Healthy})
+	}
+	want := []summary{{3001, "Web v2", true}, {9000, "db", false}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("recheck = %+v, want %+v", got, want)
+	}
+	if len(hub.GetPorts()) != len(want) {
+		t.Errorf("hub has %d ports after recheck, want %d", len(hub.GetPorts()), len(want))
+	}
+}
+
+func TestScanStream(t *testing.T) {
+	cs := newTestConfigStore(t)
+	off := false
+	cs.cfg.ResolveExe = &off
+	cs.cfg.ScanRanges = []ScanRange{{Start: 3000, End: 3005}}
+	cs.cfg.ManualPorts = []ManualPort{{Port: 3001, Path: "/opt/web"}, {Port: 9000, Name: "db"}}
+
+	s := NewScanner(time.Second, cs, nil)
+	s.prober = fakeProber{services: map[int]DiscoveredPort{
+		3001: {ServiceName: "http", Title: "Web"},
+		3004: {ServiceName: "tcp"},
+		9000: {ServiceName: "tcp"},
+	}}
+
+	var streamed []DiscoveredPort
+	ports := s.scanStream(func(dp DiscoveredPort) { streamed = append(streamed, dp) })
+	if !reflect.DeepEqual(streamed, ports) {
+		t.Errorf("streamed %+v, returned %+v", streamed, ports)
+	}
+	if len(ports) != 3 || ports[0].ExePath != "/opt/web" {
+		t.Errorf("ports = %+v, want 3 with the manual path on 3001", ports)
+	}
+}
+
+func TestDeferInitialScan(t *testing.T) {
+	for _, deferred := range []bool{false, true} {
+		cs := newTestConfigStore(t)
+		cs.cfg.ScanRanges = nil
+		cs.cfg.DeferInitialScan = deferred
+
+		scanned := make(chan time.Time, 1)
+		s := NewScanner(300*time.Millisecond, cs, func([]DiscoveredPort) {
+			select {
+			case scanned <- time.Now():
+			default:
+			}
+		})
+		s.prober = fakeProber{}
+		ctx, cancel := context.WithCancel(context.Background())
+		start := time.Now()
+		go s.Run(ctx)
+		first := (<-scanned).Sub(start)
+		cancel()
+
+		if deferred && first < 250*time.Millisecond {
+			t.Errorf("deferred: first scan after %v, want one interval", first)
+		}
+		if !deferred && first > 200*time.Millisecond {
+			t.Errorf("immediate: first scan after %v", first)
+		}
+	}
+}
+
+func TestScanPauseResume(t *testing.T) {
+	cs := newTestConfigStore(t)
+	off := false
+	cs.cfg.ResolveExe = &off
+	cs.cfg.ScanRanges = []ScanRange{{Start: 3000, End: 3005}}
+	cs.cfg.ManualPorts = []ManualPort{{Port: 9000, Name: "db"}}
+
+	scans := make(chan []DiscoveredPort, 4)
+	s := NewScanner(time.Hour, cs, func(ports []DiscoveredPort) { scans <- ports })
+	s.prober = fakeProber{services: map[int]DiscoveredPort{
+		3001: {ServiceName: "http"},
+		9000: {ServiceName: "tcp"},
+	}}
+	hub := NewHub(cs)
+	go hub.Run()
+	hub.SetScanner(s)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	portsOf := func() []int {
+		select {
+		case ports := <-scans:
+			var got []int
+			for _, p := range ports {
+				got = append(got, p.Port)
+			}
+			return got
+		case <-time.After(5 * time.Second):
+			t.Fatal("no scan")
+			return nil
+		}
+	}
+	if got := portsOf(); !slices.Equal(got, []int{3001, 9000}) {
+		t.Fatalf("initial scan = %v", got)
+	}
+
+	h := DashboardHandler(hub, NewSessionStore())
+	steps := []struct {
+		path    string
+		enabled bool
+		want    []int
+	}{
+		{"/api/scan/pause", false, []int{9000}},
+		{"/api/scan/resume", true, []int{3001, 9000}},
+	}
+	for _, step := range steps {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, step.path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", step.path, rec.Code, rec.Body)
+		}
+		if got := cs.ScanningEnabled(); got != step.enabled {
+			t.Errorf("%s: ScanningEnabled = %v", step.path, got)
+		}
+		if got := portsOf(); !slices.Equal(got, step.want) {
+			t.Errorf("%s: scanned %v, want %v", step.path, got, step.want)
+		}
+		if got := s.Paused(); got == step.enabled {
+			t.Errorf("%s: Paused = %v", step.path, got)
+		}
+	}
+}
+
+func TestScanInterval(t *testing.T) {
+	cs := newTestConfigStore(t)
+	off := false
+	cs.cfg.ResolveExe = &off
+	cs.cfg.ScanRanges = []ScanRange{{Start: 3000, End: 3001}}
+	if err := cs.ForceScanInterval(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	scans := make(chan struct{}, 4)
+	s := NewScanner(cs.ScanInterval(), cs, func([]DiscoveredPort) { scans <- struct{}{} })
+	s.prober = fakeProber{}
+	hub := NewHub(cs)
+	go hub.Run()
+	hub.SetScanner(s)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+	<-scans
+
+	h := DashboardHandler(hub, NewSessionStore())
+	put := func(body string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/api/scan-interval", strings.NewReader(body)))
+		return rec.Code
+	}
+	for _, body := range []string{`{"seconds": 0}`, `{"seconds": -5}`, `{}`} {
+		if code := put(body); code != http.StatusBadRequest {
+			t.Errorf("PUT %s: status = %d, want 400", body, code)
+		}
+	}
+	if got := s.Interval(); got != time.Hour {
+		t.Errorf("rejected interval changed the scanner to %s", got)
+	}
+
+	// The running scanner's ticker picks up the new interval
+	if code := put(`{"seconds": 1}`); code != http.StatusOK {
+		t.Fatalf("PUT 1s: status = %d", code)
+	}
+	if cs.cfg.ScanIntervalSec != 1 || s.Interval() != time.Second {
+		t.Errorf("scanIntervalSec %d, scanner %s; want 1, 1s", cs.cfg.ScanIntervalSec, s.Interval())
+	}
+	select {
+	case <-scans:
+	case <-time.After(3 * time.Second):
+		t.Fatal("no scan after shortening the interval")
+	}
+
+	if err := s.SetInterval(0); err == nil {
+		t.Error("SetInterval(0) succeeded")
+	}
+}
+
+func TestScanProfileSwitch(t *testing.T) {
+	cs := newTestConfigStore(t)
+	off := false
+	cs.cfg.ResolveExe = &off
+	cs.cfg.ScanRanges = []ScanRange{{Start: 3000, End: 3005}}
+
+	scans := make(chan []DiscoveredPort, 4)
+	s := NewScanner(time.Hour, cs, func(ports []DiscoveredPort) { scans <- ports })
+	s.prober = fakeProber{services: map[int]DiscoveredPort{
+		3001: {ServiceName: "http"},
+		8001: {ServiceName: "http"},
+	}}
+	hub := NewHub(cs)
+	go hub.Run()
+	hub.SetScanner(s)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	portsOf := func() []int {
+		select {
+		case ports := <-scans:
+			var got []int
+			for _, p := range ports {
+				got = append(got, p.Port)
+			}
+			return got
+		case <-time.After(5 * time.Second):
+			t.Fatal("no scan")
+			return nil
+		}
+	}
+	if got := portsOf(); !slices.Equal(got, []int{3001}) {
+		t.Fatalf("initial scan = %v", got)
+	}
+
+	h := DashboardHandler(hub, NewSessionStore())
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+	if rec := do(http.MethodPost, "/api/scan-profiles", `{"name": "backend", "ranges": [{"start": 8000, "end": 8005}]}`); rec.Code != http.StatusCreated {
+		t.Fatalf("add profile: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodPut, "/api/scan-profiles/active", `{"name": "nope"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("use unknown profile: status %d", rec.Code)
+	}
+
+	steps := []struct {
+		method, path, body string
+		want               []int
+	}{
+		{http.MethodPut, "/api/scan-profiles/active", `{"name": "backend"}`, []int{8001}},
+		{http.MethodPut, "/api/scan-profiles/active", `{"name": ""}`, []int{3001}},
+		{http.MethodPut, "/api/scan-profiles/active", `{"name": "backend"}`, []int{8001}},
+		{http.MethodDelete, "/api/scan-profiles?name=backend", "", []int{3001}},
+	}
+	for _, step := range steps {
+		if rec := do(step.method, step.path, step.body); rec.Code >= 300 {
+			t.Fatalf("%s %s: status %d: %s", step.method, step.path, rec.Code, rec.Body)
+		}
+		if got := portsOf(); !slices.Equal(got, step.want) {
+			t.Errorf("%s %s %s: scanned %v, want %v", step.method, step.path, step.body, got, step.want)
+		}
+	}
+}
+
+func TestHealthReasons(t *testing.T) {
+	// serve starts a raw listener that handles each connection with fn
+	serve := func(fn func(net.Conn)) int {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { ln.Close() })
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				go fn(conn)
+			}
+		}()
+		return ln.Addr().(*net.TCPAddr).Port
+	}
+	respond := func(status string) func(net.Conn) {
+		return func(conn net.Conn) {
+			defer conn.Close()
+			http.ReadRequest(bufio.NewReader(conn))
+			io.WriteString(conn, "HTTP/1.1 "+status+"\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+		}
+	}
+	hung := make(chan struct{})
+	defer close(hung)
+	var hungConns atomic.Int32
+
+	tests := []struct {
+		name   string
+		handle func(net.Conn)
+		reason string
+		label  string
+	}{
+		{"ok", respond("200 OK"), healthOK, ""},
+		{"client error is ok", respond("404 Not Found"), healthOK, ""},
+		{"server error", respond("502 Bad Gateway"), healthHTTPError, "http-error 502"},
+		{"not http", func(conn net.Conn) {
+			io.WriteString(conn, "-ERR unknown command\r\n")
+			conn.Close()
+		}, healthTCPOnly, ""},
+		{"hung", func(conn net.Conn) {
+			hungConns.Add(1)
+			<-hung
+			conn.Close()
+		}, healthProbeTimeout, "probe-timeout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dp := DiscoveredPort{Port: serve(tt.handle)}
+			probeHTTP(&dp, probeSpec{timeout: 200 * time.Millisecond})
+			if dp.HealthReason != tt.reason {
+				t.Errorf("HealthReason = %q, want %q", dp.HealthReason, tt.reason)
+			}
+			if got := healthLabel(dp); got != tt.label {
+				t.Errorf("healthLabel = %q, want %q", got, tt.label)
+			}
+		})
+	}
+	// A port that timed out isn't tried again over TLS
+	if n := hungConns.Load(); n != 1 {
+		t.Errorf("hung port got %d connections, want 1", n)
+	}
+
+	t.Run("closed", func(t *testing.T) {
+		cs := newTestConfigStore(t)
+		off := false
+		cs.cfg.ResolveExe = &off
+		cs.cfg.ScanRanges = []ScanRange{{Start: 3000, End: 3001}}
+		cs.cfg.ManualPorts = []ManualPort{{Port: 9000, Name: "db"}}
+		s := NewScanner(time.Second, cs, nil)
+		s.prober = fakeProber{services: map[int]DiscoveredPort{3000: {ServiceName: "http"}}}
+		ports := s.scan()
+		if len(ports) != 2 || ports[1].Port != 9000 || ports[1].HealthReason != healthTCPClosed {
+			t.Fatalf("scan = %+v, want manual port 9000 tcp-closed", ports)
+		}
+		s.prober = fakeProber{}
+		for _, dp := range s.RecheckKnown(ports) {
+			if dp.HealthReason != healthTCPClosed || healthLabel(dp) != "tcp-closed" {
+				t.Errorf("recheck :%d reason = %q", dp.Port, dp.HealthReason)
+			}
+		}
+	})
+}
+
+func TestHealthyStatusCodes(t *testing.T) {
+	set, err := parseStatusCodes([]string{"2xx", "301", "401-403"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for code, want := range map[int]bool{200: true, 204: true, 299: true, 300: false, 301: true, 302: false, 402: true, 404: false, 503: false} {
+		if got := set.Contains(code); got != want {
+			t.Errorf("Contains(%d) = %v, want %v", code, got, want)
+		}
+	}
+	for _, bad := range []string{"abc", "2x", "600", "99", "300-200", "6xx"} {
+		if _, err := parseStatusCodes([]string{bad}); err == nil {
+			t.Errorf("parseStatusCodes(%q) succeeded", bad)
+		}
+	}
+
+	cs := newTestConfigStore(t)
+	off := false
+	cs.cfg.ResolveExe = &off
+	cs.cfg.ScanRanges = []ScanRange{{Start: 3000, End: 3003}}
+	cs.cfg.ManualPorts = []ManualPort{{Port: 9000, Name: "api"}}
+	s := NewScanner(time.Second, cs, nil)
+	s.prober = fakeProber{services: map[int]DiscoveredPort{
+		3000: {ServiceName: "http", ProbeStatus: 200},
+		3001: {ServiceName: "http", ProbeStatus: 404},
+		3002: {ServiceName: "http", ProbeStatus: 503},
+		3003: {ServiceName: "tcp"}, // doesn't speak HTTP
+		9000: {ServiceName: "http", ProbeStatus: 404},
+	}}
+
+	// Default: every open port is healthy
+	for _, dp := range s.scan() {
+		if !dp.Healthy {
+			t.Errorf("default: port %d unhealthy (%s)", dp.Port, dp.HealthReason)
+		}
+	}
+
+	cs.cfg.HealthyStatusCodes = []string{"2xx"}
+	want := map[int]bool{3000: true, 3001: false, 3002: false, 3003: true, 9000: false}
+	ports := s.scan()
+	for _, dp := range ports {
+		if dp.Healthy != want[dp.Port] {
+			t.Errorf("2xx: port %d healthy = %v, want %v", dp.Port, dp.Healthy, want[dp.Port])
+		}
+		if !dp.Healthy && healthLabel(dp) != fmt.Sprintf("bad-status %d", dp.ProbeStatus) {
+			t.Errorf("2xx: port %d label %q", dp.Port, healthLabel(dp))
+		}
+	}
+	manual := ports[len(ports)-1]
+	if manual.Port != 9000 || manual.LastError != "status 404" || failureLabel(manual) != "unhealthy for 1 check: status 404" {
+		t.Errorf("manual port = %+v, label %q", manual, failureLabel(manual))
+	}
+	for _, dp := range s.RecheckKnown(ports) {
+		if dp.Healthy != want[dp.Port] {
+			t.Errorf("recheck: port %d healthy = %v, want %v", dp.Port, dp.Healthy, want[dp.Port])
+		}
+	}
+}
+
+func TestHealthPath(t *testing.T) {
+	var ready atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			if !ready.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		case "/moved":
+			http.Redirect(w, r, "/elsewhere", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	port := listenerPort(t, srv)
+
+	cs := newTestConfigStore(t)
+	off := false
+	cs.cfg.ResolveExe = &off
+	check := func(healthPath string) DiscoveredPort {
+		cs.cfg.ManualPorts = []ManualPort{{Port: port, HealthPath: healthPath}}
+		return NewScanner(time.Second, cs, nil).CheckPort(port)
+	}
+
+	// Without a health path a 404 from / is as healthy as before
+	if dp := check(""); !dp.Healthy || dp.HealthReason != healthOK {
+		t.Errorf("no health path: healthy=%v reason=%q", dp.Healthy, dp.HealthReason)
+	}
+	dp := check("/health")
+	if dp.Healthy || dp.HealthReason != healthCheckFailed || dp.LastError != "GET /health: status 503" {
+		t.Errorf("failing check: healthy=%v reason=%q lastError=%q", dp.Healthy, dp.HealthReason, dp.LastError)
+	}
+	dp.ConsecutiveFailures = 2
+	if got, want := failureLabel(dp), "health check failing for 2 checks: GET /health: status 503"; got != want {
+		t.Errorf("failureLabel = %q, want %q", got, want)
+	}
+	ready.Store(true)
+	if dp := check("/health"); !dp.Healthy {
+		t.Errorf("passing check: reason=%q lastError=%q", dp.HealthReason, dp.LastError)
+	}
+	if dp := check("/moved"); !dp.Healthy {
+		t.Errorf("3xx should pass: reason=%q lastError=%q", dp.HealthReason, dp.LastError)
+	}
+	if dp := check("/missing"); dp.Healthy {
+		t.Error("404 passed the health check")
+	}
+
+	// healthyStatusCodes decides what passes, as it does for the probe
+	cs.cfg.HealthyStatusCodes = []string{"2xx", "404"}
+	if dp := check("/moved"); dp.Healthy || dp.LastError != "GET /moved: status 302" {
+		t.Errorf("302 outside healthyStatusCodes: healthy=%v lastError=%q", dp.Healthy, dp.LastError)
+	}
+	if dp := check("/missing"); !dp.Healthy {
+		t.Errorf("404 in healthyStatusCodes: reason=%q lastError=%q", dp.HealthReason, dp.LastError)
+	}
+}
+
+func TestManualFailures(t *testing.T) {
+	cs := newTestConfigStore(t)
+	off := false
+	cs.cfg.ResolveExe = &off
+	cs.cfg.ScanRanges = []ScanRange{{Start: 3000, End: 3001}}
+	cs.cfg.ManualPorts = []ManualPort{{Port: 9000, Name: "db"}}
+	s := NewScanner(time.Second, cs, nil)
+	s.prober = fakeProber{}
+
+	var ports []DiscoveredPort
+	for range 3 {
+		ports = s.scan()
+	}
+	if len(ports) != 1 || ports[0].ConsecutiveFailures != 3 || ports[0].LastError != "connection refused" {
+		t.Fatalf("after 3 scans = %+v, want 3 failures, connection refused", ports)
+	}
+	if got, want := failureLabel(ports[0]), "unreachable for 3 checks: connection refused"; got != want {
+		t.Errorf("failureLabel = %q, want %q", got, want)
+	}
+	if ports = s.RecheckKnown(ports); ports[0].ConsecutiveFailures != 4 || ports[0].LastError != "connection refused" {
+		t.Errorf("recheck failures = %d (%q), want 4", ports[0].ConsecutiveFailures, ports[0].LastError)
+	}
+	s.prober = fakeProber{services: map[int]DiscoveredPort{9000: {ServiceName: "tcp"}}}
+	if rechecked := s.RecheckKnown(ports); rechecked[0].ConsecutiveFailures != 0 || rechecked[0].LastError != "" {
+		t.Errorf("recheck of a recovered port kept %d failures (%q)", rechecked[0].ConsecutiveFailures, rechecked[0].LastError)
+	}
+
+	// Coming back up resets the count, and scanned ports are never counted
+	s.prober = fakeProber{services: map[int]DiscoveredPort{9000: {ServiceName: "tcp"}, 3000: {ServiceName: "tcp"}}}
+	s.scan()
+	s.prober = fakeProber{}
+	for _, dp := range s.scan() {
+		if dp.ConsecutiveFailures != 1 {
+			t.Errorf(":%d failures = %d, want 1", dp.Port, dp.ConsecutiveFailures)
+		}
+	}
+}
+
+func TestKernelScanManualFailure(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("kernel scan mode test relies on /proc")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	cs := newTestConfigStore(t)
+	off := false
+	cs.cfg.ResolveExe = &off
+	cs.cfg.ScanMode = scanModeKernel
+	cs.cfg.ManualPorts = []ManualPort{{Port: port, Name: "db"}}
+	s := NewScanner(time.Second, cs, nil)
+	s.prober = fakeProber{}
+	ports := s.scan()
+	if len(ports) != 1 || ports[0].Healthy || ports[0].LastError != "not listening" {
+		t.Fatalf("scan = %+v, want closed manual port with LastError not listening", ports)
+	}
+	if got, want := failureLabel(ports[0]), "unreachable for 1 check: not listening"; got != want {
+		t.Errorf("failureLabel = %q, want %q", got, want)
+	}
+}
+
+func TestHealthLoop(t *testing.T) {
+	cs := newTestConfigStore(t)
+	off := false
+	cs.cfg.ResolveExe = &off
+	cs.cfg.ScanRanges = []ScanRange{{Start: 3000, End: 3005}}
+	cs.cfg.ManualPorts = []ManualPort{{Port: 9000, Name: "db"}}
+	cs.cfg.HealthIntervalSec = 1
+	s := NewScanner(time.Minute, cs, nil)
+	s.prober = fakeProber{services: map[int]DiscoveredPort{
+		3001: {ServiceName: "http"},
+		3002: {ServiceName: "http"},
+		9000: {ServiceName: "tcp"},
+	}}
+	hub := NewHub(cs)
+	go hub.Run()
+	hub.SetScanner(s)
+	hub.SetPorts(s.scan())
+
+	// 3002 and 9000 go down; a slow full scan is nowhere near done
+	s.prober = fakeProber{services: map[int]DiscoveredPort{3001: {ServiceName: "http"}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hub.RunHealthChecks(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ports := hub.GetPorts()
+		if len(ports) == 2 && ports[0].Port == 3001 && ports[1].Port == 9000 && !ports[1].Healthy {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("health loop never caught up: %+v", ports)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// A full scan that lands while a recheck runs keeps the ports it found
+	checked := hub.GetPorts()
+	hub.SetPorts(append(hub.GetPorts(), DiscoveredPort{Port: 3004, Source: "scan", Healthy: true}))
+	hub.mergeRechecked(checked, s.RecheckKnown(checked))
+	var got []int
+	for _, p := range hub.GetPorts() {
+		got = append(got, p.Port)
+	}
+	if !slices.Equal(got, []int{3001, 9000, 3004}) {
+		t.Errorf("after merge = %v, want [3001 9000 3004]", got)
+	}
+
+	// The loop only dials a healthy port; a port coming back is re-probed
+	s.prober = fakeProber{services: map[int]DiscoveredPort{
+		3001: {ServiceName: "http", Title: "Changed"},
+		9000: {ServiceName: "tcp"},
+	}}
+	refreshed := s.RefreshKnown(checked)
+	if len(refreshed) != 2 || refreshed[0].Title == "Changed" || !refreshed[1].Healthy {
+		t.Errorf("refresh = %+v, want 3001 as it was and 9000 healthy again", refreshed)
+	}
+	if rechecked := s.RecheckKnown(checked); rechecked[0].Title != "Changed" {
+		t.Errorf("recheck didn't re-probe 3001: %+v", rechecked[0])
+	}
+}
+
+func TestSanitizeTitle(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		max  int
+		want string
+	}{
+		{"plain", "My App", 120, "My App"},
+		{"color codes", "\x1b[31mRed\x1b[0m App", 120, "Red App"},
+		{"cursor and clear", "a\x1b[2J\x1b[1;1Hb", 120, "ab"},
+		{"osc title", "\x1b]0;pwned\x07App", 120, "App"},
+		{"osc hyperlink", "\x1b]8;;http://evil\x1b\\click\x1b]8;;\x1b\\", 120, "click"},
+		{"bare escape and bell", "A\x1bcB\x07C", 120, "ABC"},
+		{"c1 control", "A\u009b31mB", 120, "A31mB"},
+		{"bidi override", "abc\u202edcba", 120, "abcdcba"},
+		{"whitespace", "  Dev\n\t  Server \r\n", 120, "Dev Server"},
+		{"invalid utf8", "App\xff\xfe", 120, "App"},
+		{"truncated", strings.Repeat("x", 200), 10, "xxxxxxxxx…"},
+		{"truncated at space", "abcd efgh ijkl", 6, "abcd…"},
+		{"multibyte", "日本語のタイトル", 4, "日本語…"},
+		{"exact length", "abcdef", 6, "abcdef"},
+		{"no limit", strings.Repeat("y", 300), 0, strings.Repeat("y", 300)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeTitle(tt.in, tt.max); got != tt.want {
+				t.Errorf("sanitizeTitle(%q, %d) = %q, want %q", tt.in, tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeSanitizesTitle(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		server string
+		want   string
+	}{
+		{"title", "<title>\x1b[2J\x1b]0;owned\x07" + strings.Repeat("A", 50) + "</title>", "", strings.Repeat("A", 19) + "…"},
+		// Go's client already rejects control bytes in headers, but not
+		// tab runs, bidi overrides or excessive length
+		{"server fallback", "no title here", "evil \t\u202eserver/" + strings.Repeat("9", 40), "evil server/9999999…"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A raw listener, so the bytes reach the probe as a misbehaving
+			// server would send them
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+			go func() {
+				for {
+					conn, err := ln.Accept()
+					if err != nil {
+						return
+					}
+					http.ReadRequest(bufio.NewReader(conn))
+					fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nServer: %s\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
+						tt.server, len(tt.body), tt.body)
+					conn.Close()
+				}
+			}()
+			dp := DiscoveredPort{Port: ln.Addr().(*net.TCPAddr).Port}
+			probeHTTP(&dp, probeSpec{maxTitle: 20})
+			if dp.Title != tt.want {
+				t.Errorf("title = %q, want %q", dp.Title, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerboseTransitions(t *testing.T) {
+	var buf strings.Builder
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	s := NewScanner(0, newTestConfigStore(t), nil)
+	app := DiscoveredPort{Port: 3000, Source: "scan", Healthy: true, ExePath: "/usr/bin/node", ServiceName: "HTTP", Title: "App"}
+	db := DiscoveredPort{Port: 5432, Source: "manual", Healthy: true, ExePath: "/usr/bin/postgres"}
+
+	s.logTransitions([]DiscoveredPort{app, db})
+	if buf.Len() != 0 {
+		t.Fatalf("logged without verbose: %s", buf.String())
+	}
+
+	s.SetVerbose(true)
+	steps := []struct {
+		ports []DiscoveredPort
+		want  []string
+	}{
+		{[]DiscoveredPort{app, db}, []string{
+			`found port=3000 exe="/usr/bin/node"`,
+			`probe port=3000 exe="/usr/bin/node" service="HTTP" title="App"`,
+			`found port=5432`,
+		}},
+		{[]DiscoveredPort{app, db}, nil},
+		{[]DiscoveredPort{app, {Port: 5432, Source: "manual", ExePath: "/usr/bin/postgres"}}, []string{
+			`marked unhealthy port=5432`,
+		}},
+		{[]DiscoveredPort{{Port: 5432, Source: "manual", ExePath: "/usr/bin/postgres"}}, []string{
+			`dropped port=3000 exe="/usr/bin/node"`,
+		}},
+	}
+	for i, step := range steps {
+		buf.Reset()
+		s.logTransitions(step.ports)
+		got := buf.String()
+		for _, w := range step.want {
+			if !strings.Contains(got, w) {
+				t.Errorf("step %d: log missing %q:\n%s", i, w, got)
+			}
+		}
+		if step.want == nil && got != "" {
+			t.Errorf("step %d: unchanged ports logged:\n%s", i, got)
+		}
+	}
+}
+
+func TestProbePaths(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			if r.Header.Get("Accept") != "application/json" {
+				w.WriteHeader(http.StatusNotAcceptable)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			io.WriteString(w, `{"status":"ok"}`)
+		case "/app":
+			io.WriteString(w, "<title>App</title>")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	port := listenerPort(t, srv)
+
+	tests := []struct {
+		name        string
+		spec        probeSpec
+		status      int
+		path        string
+		contentType string
+		title       string
+	}{
+		{"first 2xx wins", probeSpec{paths: []string{"/", "/app", "/health"}, accept: "text/html"}, http.StatusOK, "/app", "text/html", "App"},
+		{"accept header", probeSpec{paths: []string{"/health", "/app"}, accept: "application/json"}, http.StatusOK, "/health", "application/json", ""},
+		{"wrong accept falls through", probeSpec{paths: []string{"/health", "/app"}, accept: "text/html"}, http.StatusOK, "/app", "text/html", "App"},
+		{"no success keeps first", probeSpec{paths: []string{"/missing", "/health"}, accept: "text/html"}, http.StatusNotFound, "/missing", "text/plain", ""},
+		{"default path", probeSpec{}, http.StatusNotFound, "/", "text/plain", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dp := DiscoveredPort{Port: port}
+			if status := probeHTTP(&dp, tt.spec); status != tt.status {
+				t.Errorf("status = %d, want %d", status, tt.status)
+			}
+			if dp.ProbePath != tt.path || dp.ProbeStatus != tt.status || dp.ContentType != tt.contentType || dp.Title != tt.title {
+				t.Errorf("recorded path %q status %d type %q title %q, want %q %d %q %q",
+					dp.ProbePath, dp.ProbeStatus, dp.ContentType, dp.Title, tt.path, tt.status, tt.contentType, tt.title)
+			}
+		})
+	}
+
+	// Manual port settings layer on top of the global ones
+	cs := newTestConfigStore(t)
+	cs.cfg.ProbePaths = []string{"/global"}
+	cs.cfg.ProbeAccept = "application/json"
+	s := NewScanner(0, cs, nil)
+	if spec := s.probeSpec(ManualPort{}); !reflect.DeepEqual(spec.paths, []string{"/global"}) || spec.accept != "application/json" {
+		t.Errorf("global spec = %+v", spec)
+	}
+	if spec := s.probeSpec(ManualPort{ProbePaths: []string{"/own"}, ProbeHost: "app.local"}); !reflect.DeepEqual(spec.paths, []string{"/own"}) || spec.accept != "application/json" || spec.host != "app.local" {
+		t.Errorf("manual spec = %+v", spec)
+	}
+}
+
+func TestScannerTrigger(t *testing.T) {
+	cs := newTestConfigStore(t)
+	off := false
+	cs.cfg.ResolveExe = &off
+	cs.cfg.DeferInitialScan = true
+	cs.cfg.ScanRanges = []ScanRange{{Start: 3000, End: 3001}}
+
+	var scans atomic.Int32
+	release := make(chan struct{})
+	s := NewScanner(time.Hour, cs, func([]DiscoveredPort) {
+		if scans.Add(1) == 1 {
+			<-release
+		}
+	})
+	s.prober = fakeProber{services: map[int]DiscoveredPort{3000: {ServiceName: "tcp"}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	wait := func(done <-chan struct{}) {
+		t.Helper()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("triggered scan never finished")
+		}
+	}
+
+	first := s.Trigger()
+	for scans.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// Triggers while a scan runs wait for a fresh one, and share it
+	second, third := s.Trigger(), s.Trigger()
+	if second == first || second != third {
+		t.Error("triggers during a scan should share one new scan")
+	}
+	close(release)
+	wait(first)
+	wait(second)
+	time.Sleep(50 * time.Millisecond)
+	if n := scans.Load(); n != 2 {
+		t.Errorf("%d scans, want 2", n)
+	}
+}
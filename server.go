@@ -1,12 +1,24 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"crypto/sha256"
 	"embed"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,26 +28,169 @@ import (
 //go:embed static
 var staticFS embed.FS
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+// originAllowed reports whether a browser on the page's origin may open the
+// dashboard WebSocket or reset the config. Allowed are requests without an
+// Origin (non-browser clients), the dashboard's own host, localhost, anything
+// under the domain suffix and the allowedOrigins entries; everything else is
+// refused so a foreign page can't ride the user's session.
+func originAllowed(cs *ConfigStore, r *http.Request) bool {
+	extra, all := cs.AllowedOrigins()
+	origin := r.Header.Get("Origin")
+	if all || origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return true
+	}
+	for _, name := range []string{"localhost", cs.DomainSuffix()} {
+		if host == name || strings.HasSuffix(host, "."+name) {
+			return true
+		}
+	}
+	for _, e := range extra {
+		e = strings.ToLower(e)
+		switch {
+		case strings.Contains(e, "://"):
+			if strings.TrimSuffix(e, "/") == strings.ToLower(u.Scheme+"://"+u.Host) {
+				return true
+			}
+		case strings.HasPrefix(e, "*."):
+			if strings.HasSuffix(host, e[1:]) {
+				return true
+			}
+		case host == e:
+			return true
+		}
+	}
+	return false
 }
 
+// scanNowTimeout is how long POST /api/scan waits for its scan before
+// answering 202 instead.
+const scanNowTimeout = 30 * time.Second
+
+// shutdownReconnectDelay is the reconnect delay suggested to dashboards when
+// the server shuts down cleanly.
+const shutdownReconnectDelay = 3 * time.Second
+
 // NewHub creates a new Hub with the given config store.
 func NewHub(cs *ConfigStore) *Hub {
 	return &Hub{
-		config:     cs,
-		clients:    make(map[*WSClient]bool),
-		register:   make(chan *WSClient),
-		unregister: make(chan *WSClient),
-		broadcast:  make(chan []byte, 256),
+		config:      cs,
+		clients:     make(map[*WSClient]bool),
+		register:    make(chan *WSClient),
+		unregister:  make(chan *WSClient),
+		broadcast:   make(chan []byte, 256),
+		shutdown:    make(chan []byte),
+		epoch:       time.Now().UnixNano(),
+		conns:       make(map[string]int),
+		proxyScheme: "http",
+		proxyPort:   80,
+		scanWait:    scanNowTimeout,
 	}
 }
 
+// SetProxyEndpoint records how clients reach the proxy so mapping URLs can be
+// built. Call it before serving.
+func (h *Hub) SetProxyEndpoint(scheme string, port int) {
+	h.proxyScheme = scheme
+	h.proxyPort = port
+}
+
+// mappingView is a mapping as returned to API and WebSocket clients, with the
+// derived URL it is served at. The URL is never persisted.
+type mappingView struct {
+	DomainMapping
+	URL string `json:"url,omitempty"`
+}
+
+// mappingURL returns the address a browser opens for domain, omitting the
+// port when it is the scheme's default. Wildcard mappings have no single URL.
+func (h *Hub) mappingURL(domain string) string {
+	if isWildcardDomain(domain) {
+		return ""
+	}
+	host := domain + "." + h.config.DomainSuffix()
+	if !(h.proxyScheme == "http" && h.proxyPort == 80 || h.proxyScheme == "https" && h.proxyPort == 443) {
+		host = net.JoinHostPort(host, strconv.Itoa(h.proxyPort))
+	}
+	return h.proxyScheme + "://" + host + "/"
+}
+
+// mappingViews returns the current mappings with their URLs.
+func (h *Hub) mappingViews() []mappingView {
+	ms := h.config.Mappings()
+	out := make([]mappingView, len(ms))
+	for i, m := range ms {
+		out[i] = mappingView{DomainMapping: m, URL: h.mappingURL(m.Domain)}
+	}
+	return out
+}
+
+// sortMappingViews orders views by key: "domain", "created" or "port".
+// An empty key keeps config order. Ties keep config order too.
+func sortMappingViews(views []mappingView, key string) error {
+	var compare func(a, b mappingView) int
+	switch key {
+	case "":
+		return nil
+	case "domain":
+		compare = func(a, b mappingView) int { return strings.Compare(a.Domain, b.Domain) }
+	case "created":
+		compare = func(a, b mappingView) int { return a.CreatedAt.Compare(b.CreatedAt) }
+	case "port":
+		compare = func(a, b mappingView) int { return cmp.Compare(a.TargetPort, b.TargetPort) }
+	default:
+		return fmt.Errorf("sort must be domain, created or port")
+	}
+	slices.SortStableFunc(views, compare)
+	return nil
+}
+
+// acquireConn counts a new proxied connection from ip, refusing it if ip
+// already holds limit connections.
+func (h *Hub) acquireConn(ip string, limit int) bool {
+	h.connMu.Lock()
+	defer h.connMu.Unlock()
+	if h.conns[ip] >= limit {
+		return false
+	}
+	h.conns[ip]++
+	return true
+}
+
+// releaseConn ends a connection counted by acquireConn.
+func (h *Hub) releaseConn(ip string) {
+	h.connMu.Lock()
+	defer h.connMu.Unlock()
+	if h.conns[ip] <= 1 {
+		delete(h.conns, ip)
+		return
+	}
+	h.conns[ip]--
+}
+
 // Run starts the Hub's client management loop.
 func (h *Hub) Run() {
 	for {
 		select {
 		case client := <-h.register:
+			h.mu.RLock()
+			closed := h.closed
+			h.mu.RUnlock()
+			if closed {
+				// Shutdown already said goodbye to everyone else
+				close(client.send)
+				break
+			}
 			h.clients[client] = true
 		case client := <-h.unregister:
 			if _, ok := h.clients[client]; ok {
@@ -51,40 +206,376 @@ func (h *Hub) Run() {
 					delete(h.clients, client)
 				}
 			}
+		case msg := <-h.shutdown:
+			for client := range h.clients {
+				select {
+				case client.send <- msg:
+				default:
+				}
+				close(client.send)
+				delete(h.clients, client)
+			}
 		}
+		h.nclients.Store(int64(len(h.clients)))
+	}
+}
+
+// Shutdown tells every WebSocket client the server is going away, with a
+// suggested reconnect delay, then waits (bounded by ctx) for the notices to
+// be written and the connections closed.
+func (h *Hub) Shutdown(ctx context.Context) {
+	h.mu.Lock()
+	h.closed = true
+	h.mu.Unlock()
+	data, _ := json.Marshal(WSMessage{Type: "server-shutdown", Data: struct {
+		ReconnectAfterMs int64 `json:"reconnect_after_ms"`
+	}{shutdownReconnectDelay.Milliseconds()}})
+	select {
+	case h.shutdown <- data:
+	case <-ctx.Done():
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		h.writers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
 	}
 }
 
+// addWriter counts a new client's writePump towards the ones Shutdown waits
+// for. It reports false once Shutdown has started, so WaitGroup.Add never
+// races with Wait.
+func (h *Hub) addWriter() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return false
+	}
+	h.writers.Add(1)
+	return true
+}
+
 // SetPorts updates the discovered ports and broadcasts to clients.
 func (h *Hub) SetPorts(ports []DiscoveredPort) {
+	grace := h.config.PortGracePeriod()
 	h.mu.Lock()
-	h.ports = ports
+	h.ports = lingerPorts(ports, h.ports, time.Now(), grace)
 	h.mu.Unlock()
 	h.broadcastUpdate()
 }
 
+// SetScanner attaches the scanner used for on-demand health rechecks.
+func (h *Hub) SetScanner(s *Scanner) {
+	h.mu.Lock()
+	h.scanner = s
+	h.mu.Unlock()
+}
+
+// SetReloader attaches the function that re-reads the config file for
+// POST /api/reload.
+func (h *Hub) SetReloader(reload func() error) {
+	h.mu.Lock()
+	h.reload = reload
+	h.mu.Unlock()
+}
+
+// Reload re-reads the config file into the running server. It fails if no
+// reloader is attached or the file is invalid, in which case the current
+// config stays in effect.
+func (h *Hub) Reload() error {
+	h.mu.RLock()
+	reload := h.reload
+	h.mu.RUnlock()
+	if reload == nil {
+		return errors.New("reload is not available")
+	}
+	return reload()
+}
+
+// RecheckPorts re-checks the health of the known ports, stores the result and
+// broadcasts it. It returns false if no scanner is attached.
+func (h *Hub) RecheckPorts() ([]DiscoveredPort, bool) {
+	h.mu.RLock()
+	s := h.scanner
+	h.mu.RUnlock()
+	if s == nil {
+		return nil, false
+	}
+	checked := h.GetPorts()
+	h.mergeRechecked(checked, s.RecheckKnown(checked))
+	return h.GetPorts(), true
+}
+
+// mergeRechecked folds the result of re-checking the ports in checked into
+// the current list and broadcasts it if anything changed. A full scan may
+// have replaced the list meanwhile, so ports that weren't checked are kept
+// and checked ports the recheck dropped are removed.
+func (h *Hub) mergeRechecked(checked, rechecked []DiscoveredPort) {
+	byPort := make(map[portKey]DiscoveredPort, len(rechecked))
+	for _, p := range rechecked {
+		byPort[p.key()] = p
+	}
+	wasChecked := make(map[portKey]bool, len(checked))
+	for _, p := range checked {
+		wasChecked[p.key()] = true
+	}
+	grace := h.config.PortGracePeriod()
+	h.mu.Lock()
+	merged := make([]DiscoveredPort, 0, len(h.ports))
+	for _, p := range h.ports {
+		if r, ok := byPort[p.key()]; ok {
+			merged = append(merged, r)
+		} else if !wasChecked[p.key()] {
+			merged = append(merged, p)
+		}
+	}
+	merged = lingerPorts(merged, h.ports, time.Now(), grace)
+	changed := healthChanged(h.ports, merged)
+	h.ports = merged
+	h.mu.Unlock()
+	if changed {
+		h.broadcastUpdate()
+	}
+}
+
+// healthChanged reports whether b differs from a in more than LastSeen and
+// notes, so a recheck that found nothing new isn't broadcast.
+func healthChanged(a, b []DiscoveredPort) bool {
+	return !slices.EqualFunc(a, b, func(x, y DiscoveredPort) bool {
+		x.LastSeen, y.LastSeen = time.Time{}, time.Time{}
+		x.Note, y.Note = "", ""
+		return reflect.DeepEqual(x, y)
+	})
+}
+
+// RunHealthChecks refreshes the known ports every healthIntervalSec until ctx
+// is done, so health stays fresh while a full scan of large ranges is still
+// running. Healthy ports are only dialed; see Scanner.RefreshKnown. Nothing is
+// checked while the interval isn't shorter than the scan interval, since
+// every scan re-checks the ports anyway.
+func (h *Hub) RunHealthChecks(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(h.config.HealthInterval()):
+		}
+		h.mu.RLock()
+		s := h.scanner
+		h.mu.RUnlock()
+		if s != nil && h.config.HealthInterval() < s.Interval() {
+			checked := h.GetPorts()
+			h.mergeRechecked(checked, s.RefreshKnown(checked))
+		}
+	}
+}
+
+// syncScanning pushes the config's scanningEnabled switch to the scanner.
+func (h *Hub) syncScanning() {
+	h.mu.RLock()
+	s := h.scanner
+	h.mu.RUnlock()
+	if s != nil {
+		s.SetPaused(!h.config.ScanningEnabled())
+	}
+}
+
+// syncScanInterval pushes the configured scan interval to the scanner.
+func (h *Hub) syncScanInterval() {
+	h.mu.RLock()
+	s := h.scanner
+	h.mu.RUnlock()
+	if s == nil {
+		return
+	}
+	if err := s.SetInterval(h.config.ScanInterval()); err != nil {
+		log.Printf("scan interval: %v", err)
+	}
+}
+
+// rescan asks the scanner, if attached, to scan now, e.g. after the ranges
+// to scan changed.
+func (h *Hub) rescan() {
+	h.mu.RLock()
+	s := h.scanner
+	h.mu.RUnlock()
+	if s != nil {
+		s.Rescan()
+	}
+}
+
+// triggerScan asks the scanner to scan now. It returns a channel closed when
+// the scan has finished, or nil if no scanner is attached.
+func (h *Hub) triggerScan() <-chan struct{} {
+	h.mu.RLock()
+	s := h.scanner
+	h.mu.RUnlock()
+	if s == nil {
+		return nil
+	}
+	return s.Trigger()
+}
+
 // GetPorts returns the current discovered ports.
+// Notes from config are merged on, so an edited note shows up without
+// waiting for the next scan.
 func (h *Hub) GetPorts() []DiscoveredPort {
+	notes := h.config.PortNotes()
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 	out := make([]DiscoveredPort, len(h.ports))
 	copy(out, h.ports)
+	for i := range out {
+		out[i].Note = notes[out[i].Port]
+	}
 	return out
 }
 
+// dashboardConfig is what the frontend reads on load instead of hardcoding
+// paths and feature checks.
+type dashboardConfig struct {
+	APIBase        string `json:"apiBase"`  // prefix for REST calls, e.g. "/api"
+	WSURL          string `json:"wsUrl"`    // path or absolute ws(s):// URL of the update socket
+	BasePath       string `json:"basePath"` // prefix the dashboard is served under, "" at the root
+	Version        string `json:"version"`
+	ReadOnly       bool   `json:"readOnly"`
+	ExternalAccess bool   `json:"externalAccess"`
+	TLS            bool   `json:"tls"`
+	PollInterval   int    `json:"pollIntervalSec"` // polling fallback when the WebSocket can't connect
+}
+
+// dashboardConfig returns the runtime settings served at /api/config, with
+// URLs under prefix when the dashboard is reached through an upstream proxy.
+func (h *Hub) dashboardConfig(prefix string) dashboardConfig {
+	h.mu.RLock()
+	scheme := h.proxyScheme
+	h.mu.RUnlock()
+	return dashboardConfig{
+		APIBase:        prefix + "/api",
+		WSURL:          prefix + "/ws",
+		BasePath:       prefix,
+		Version:        currentBuildInfo().Version,
+		ReadOnly:       h.config.ReadOnly(),
+		ExternalAccess: h.config.ExternalAccess(),
+		TLS:            scheme == "https",
+		PollInterval:   int(h.config.PollInterval().Seconds()),
+	}
+}
+
+// hubStats is the aggregate overview served at /api/stats.
+type hubStats struct {
+	Ports      portStats      `json:"ports"`
+	Mappings   mappingStats   `json:"mappings"`
+	ScanRanges scanRangeStats `json:"scanRanges"`
+	Clients    int64          `json:"clients"` // connected dashboard WebSockets
+	UptimeSec  int64          `json:"uptimeSec"`
+	LastScan   *time.Time     `json:"lastScan,omitempty"` // when the last full scan finished
+	LastScanMs int64          `json:"lastScanMs"`         // how long it took
+	Version    string         `json:"version"`
+}
+
+type portStats struct {
+	Total     int `json:"total"`
+	Healthy   int `json:"healthy"`
+	Unhealthy int `json:"unhealthy"`
+	Manual    int `json:"manual"`
+	Scan      int `json:"scan"`
+}
+
+type mappingStats struct {
+	Total       int `json:"total"`
+	Active      int `json:"active"`
+	Maintenance int `json:"maintenance"`
+	System      int `json:"system"`
+}
+
+type scanRangeStats struct {
+	Count int `json:"count"`
+	Ports int `json:"ports"` // distinct ports covered, overlaps counted once
+}
+
+// stats aggregates the current state without copying the port list.
+func (h *Hub) stats() hubStats {
+	st := hubStats{
+		Clients:   h.nclients.Load(),
+		UptimeSec: int64(time.Since(time.Unix(0, h.epoch)).Seconds()),
+		Version:   currentBuildInfo().Version,
+	}
+	h.mu.RLock()
+	for _, p := range h.ports {
+		if p.Healthy {
+			st.Ports.Healthy++
+		}
+		if p.Source == "manual" {
+			st.Ports.Manual++
+		}
+	}
+	st.Ports.Total = len(h.ports)
+	s := h.scanner
+	h.mu.RUnlock()
+	st.Ports.Unhealthy = st.Ports.Total - st.Ports.Healthy
+	st.Ports.Scan = st.Ports.Total - st.Ports.Manual
+
+	st.Mappings.Total, st.Mappings.Maintenance, st.Mappings.System = h.config.MappingCounts()
+	st.Mappings.Active = st.Mappings.Total - st.Mappings.Maintenance
+
+	ranges := h.config.ScanRanges()
+	st.ScanRanges = scanRangeStats{Count: len(ranges), Ports: rangeCoverage(ranges)}
+
+	if s != nil {
+		if at, took := s.LastScan(); !at.IsZero() {
+			st.LastScan = &at
+			st.LastScanMs = took.Milliseconds()
+		}
+	}
+	return st
+}
+
+// rangeCoverage counts the distinct ports in ranges. It sorts ranges in place.
+func rangeCoverage(ranges []ScanRange) int {
+	slices.SortFunc(ranges, func(a, b ScanRange) int { return cmp.Compare(a.Start, b.Start) })
+	total, next := 0, 0 // next is the first port not yet counted
+	for _, r := range ranges {
+		start := max(r.Start, next)
+		if r.End >= start {
+			total += r.End - start + 1
+			next = r.End + 1
+		}
+	}
+	return total
+}
+
+// hubState is the payload of "update" WebSocket messages.
+type hubState struct {
+	Ports          []DiscoveredPort `json:"ports"`
+	Mappings       []mappingView    `json:"mappings"`
+	ScanRanges     []ScanRange      `json:"scan_ranges"`
+	DomainSuffix   string           `json:"domain_suffix"`
+	Epoch          int64            `json:"epoch"`
+	ScanningPaused bool             `json:"scanning_paused"`        // ports holds only manual ports
+	ScanProfile    string           `json:"scan_profile,omitempty"` // profile whose ranges scan_ranges holds
+}
+
+// state returns the current state as sent to WebSocket clients.
+func (h *Hub) state() hubState {
+	st := hubState{
+		Ports:          h.GetPorts(),
+		Mappings:       h.mappingViews(),
+		ScanRanges:     h.config.ScanRanges(),
+		DomainSuffix:   h.config.DomainSuffix(),
+		Epoch:          h.epoch,
+		ScanningPaused: !h.config.ScanningEnabled(),
+	}
+	_, st.ScanProfile = h.config.ScanProfiles()
+	return st
+}
+
 func (h *Hub) broadcastUpdate() {
-	msg := struct {
-		Ports        []DiscoveredPort `json:"ports"`
-		Mappings     []DomainMapping  `json:"mappings"`
-		ScanRanges   []ScanRange      `json:"scan_ranges"`
-		DomainSuffix string           `json:"domain_suffix"`
-	}{
-		Ports:        h.GetPorts(),
-		Mappings:     h.config.Mappings(),
-		ScanRanges:   h.config.ScanRanges(),
-		DomainSuffix: h.config.DomainSuffix(),
-	}
-	data, err := json.Marshal(WSMessage{Type: "update", Data: msg})
+	data, err := json.Marshal(WSMessage{Type: "update", Data: h.state()})
 	if err != nil {
 		return
 	}
@@ -94,6 +585,9 @@ func (h *Hub) broadcastUpdate() {
 // DashboardHandler returns the HTTP mux for the dashboard + API.
 func DashboardHandler(hub *Hub, sessions *SessionStore) http.Handler {
 	mux := http.NewServeMux()
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool { return originAllowed(hub.config, r) },
+	}
 
 	// Login page (GET) and login handler (POST)
 	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
@@ -109,7 +603,7 @@ func DashboardHandler(hub *Hub, sessions *SessionStore) http.Handler {
 			w.Write(f)
 		case http.MethodPost:
 			if err := r.ParseForm(); err != nil {
-				http.Error(w, "bad request", http.StatusBadRequest)
+				badBody(w, err)
 				return
 			}
 			password := r.FormValue("password")
@@ -133,34 +627,111 @@ func DashboardHandler(hub *Hub, sessions *SessionStore) http.Handler {
 				SameSite: http.SameSiteLaxMode,
 				MaxAge:   int(hub.config.SessionExpiry().Seconds()),
 			})
-			http.Redirect(w, r, "/", http.StatusSeeOther)
+			http.Redirect(w, r, requestPrefix(r, hub.config)+"/", http.StatusSeeOther)
 		default:
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		}
 	})
 
+	mux.HandleFunc("/api/config", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(hub.dashboardConfig(requestPrefix(r, hub.config)))
+	})
+
+	// Reset the config to defaults, keeping a backup. Without a password
+	// only direct localhost connections may do this. A JSON content type
+	// can't be sent cross-origin without a preflight, so together with the
+	// Origin check a foreign page can't reset the config through the
+	// user's browser.
+	mux.HandleFunc("/api/config/reset", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+			http.Error(w, "config reset requires Content-Type: application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+		if !originAllowed(hub.config, r) {
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+		if !hub.config.DashboardProtected() && (!isLocalRequest(r) || r.Header.Get("X-Forwarded-For") != "") {
+			http.Error(w, "config reset requires auth or a direct localhost connection", http.StatusForbidden)
+			return
+		}
+		keep := r.URL.Query().Get("keepMappings")
+		backup, err := hub.config.Reset(keep == "1" || keep == "true")
+		if err != nil {
+			http.Error(w, "reset failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := applyRuntimeConfig(hub.config); err != nil {
+			log.Printf("config reset: %v", err)
+		}
+		hub.syncScanInterval()
+		hub.broadcastUpdate()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"backup": backup})
+	})
+
+	// Re-read the config file, as SIGHUP does. Servers, WebSocket clients
+	// and in-flight proxy requests are left alone.
+	mux.HandleFunc("/api/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := hub.Reload(); err != nil {
+			http.Error(w, "reload failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"path": hub.config.Path(), "mappings": len(hub.config.Mappings())})
+	})
+
+	mux.HandleFunc("/api/config-path", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"path": hub.config.Path()})
+	})
+
 	mux.HandleFunc("/api/version", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"version": version})
+		json.NewEncoder(w).Encode(currentBuildInfo())
 	})
 
 	mux.HandleFunc("/api/ports", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(hub.GetPorts())
+			writeJSONWithETag(w, r, hub.GetPorts())
 
 		case http.MethodPost:
 			var req PortRequest
-			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-				http.Error(w, "bad request", http.StatusBadRequest)
+			if !decodeBody(w, r, &req) {
 				return
 			}
 			if req.Port < 1 || req.Port > 65535 {
 				http.Error(w, "port must be 1-65535", http.StatusBadRequest)
 				return
 			}
-			mp := ManualPort{Port: req.Port, Name: req.Name, Path: req.Path}
+			if err := validateProbePaths(req.ProbePaths); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := validateHealthPath(req.HealthPath); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			mp := ManualPort{
+				Port:        req.Port,
+				Name:        req.Name,
+				Path:        req.Path,
+				ProbeHost:   req.ProbeHost,
+				ProbePaths:  req.ProbePaths,
+				ProbeAccept: req.ProbeAccept,
+				HealthPath:  req.HealthPath,
+			}
 			if err := hub.config.AddManualPort(mp); err != nil {
 				http.Error(w, "save failed", http.StatusInternalServerError)
 				return
@@ -185,14 +756,170 @@ func DashboardHandler(hub *Hub, sessions *SessionStore) http.Handler {
 				http.Error(w, "save failed", http.StatusInternalServerError)
 				return
 			}
+			// Mappings to the port outlive it unless asked to go too
+			var res PortRemoval
+			if r.URL.Query().Get("removeMappings") == "1" {
+				removed, err := hub.config.RemoveMappingsToPort(port)
+				if err != nil {
+					http.Error(w, "save failed", http.StatusInternalServerError)
+					return
+				}
+				res.RemovedMappings = removed
+			} else {
+				res.Warnings = danglingMappingWarnings(port, hub.config.MappingsToPort(port))
+			}
 			hub.broadcastUpdate()
-			w.WriteHeader(http.StatusNoContent)
+			if res.Warnings == nil && res.RemovedMappings == nil {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(res)
 
 		default:
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		}
 	})
 
+	// Pause or resume range scanning without a restart; manual ports keep
+	// being health-checked while paused
+	setScanning := func(enabled bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if err := hub.config.SetScanningEnabled(enabled); err != nil {
+				http.Error(w, "save failed", http.StatusInternalServerError)
+				return
+			}
+			hub.syncScanning()
+			hub.broadcastUpdate()
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]bool{"scanningEnabled": enabled})
+		}
+	}
+	mux.HandleFunc("/api/scan/pause", setScanning(false))
+	mux.HandleFunc("/api/scan/resume", setScanning(true))
+
+	// Scan now and answer with the fresh ports. A scan that outlasts
+	// hub.scanWait gets 202; its results still reach dashboards over the
+	// WebSocket.
+	mux.HandleFunc("/api/scan", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		done := hub.triggerScan()
+		if done == nil {
+			http.Error(w, "scanner not running", http.StatusServiceUnavailable)
+			return
+		}
+		select {
+		case <-done:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(hub.GetPorts())
+		case <-time.After(hub.scanWait):
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(map[string]string{"status": "scanning"})
+		case <-r.Context().Done():
+		}
+	})
+
+	// Change the time between scans; the running scanner picks it up
+	// without a restart
+	mux.HandleFunc("/api/scan-interval", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]int{"seconds": int(hub.config.ScanInterval() / time.Second)})
+
+		case http.MethodPut:
+			var req struct {
+				Seconds int `json:"seconds"`
+			}
+			if !decodeBody(w, r, &req) {
+				return
+			}
+			if err := validateScanInterval(time.Duration(req.Seconds) * time.Second); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := hub.config.SetScanInterval(req.Seconds); err != nil {
+				http.Error(w, "save failed", http.StatusInternalServerError)
+				return
+			}
+			hub.syncScanInterval()
+			hub.broadcastUpdate()
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]int{"seconds": req.Seconds})
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Prometheus scrape target
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		hub.writeMetrics(w)
+	})
+
+	mux.HandleFunc("/api/stats", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hub.stats())
+	})
+
+	// Re-check health of the known ports now, without a full range scan
+	mux.HandleFunc("/api/ports/recheck", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		ports, ok := hub.RecheckPorts()
+		if !ok {
+			http.Error(w, "scanner not running", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ports)
+	})
+
+	// Per-port actions: PUT /api/ports/<port>/note
+	mux.HandleFunc("/api/ports/", func(w http.ResponseWriter, r *http.Request) {
+		portStr, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/ports/"), "/note")
+		port, err := strconv.Atoi(portStr)
+		if !ok || err != nil {
+			apiNotFound(w)
+			return
+		}
+		if r.Method != http.MethodPut {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if port < 1 || port > 65535 {
+			http.Error(w, "invalid port", http.StatusBadRequest)
+			return
+		}
+		var req PortNoteRequest
+		if !decodeBody(w, r, &req) {
+			return
+		}
+		note := strings.TrimSpace(req.Note)
+		if err := hub.config.SetPortNote(port, note); err != nil {
+			http.Error(w, "save failed", http.StatusInternalServerError)
+			return
+		}
+		hub.broadcastUpdate()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"port": port, "note": note})
+	})
+
 	mux.HandleFunc("/api/scan-ranges", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
@@ -201,15 +928,19 @@ func DashboardHandler(hub *Hub, sessions *SessionStore) http.Handler {
 
 		case http.MethodPost:
 			var req ScanRangeRequest
-			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-				http.Error(w, "bad request", http.StatusBadRequest)
+			if !decodeBody(w, r, &req) {
 				return
 			}
 			if req.Start < 1 || req.End > 65535 || req.Start > req.End {
 				http.Error(w, "invalid range", http.StatusBadRequest)
 				return
 			}
-			sr := ScanRange{Start: req.Start, End: req.End}
+			proto, err := normalizeScanProtocol(req.Protocol)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			sr := ScanRange{Start: req.Start, End: req.End, Protocol: proto}
 			if err := hub.config.AddScanRange(sr); err != nil {
 				http.Error(w, "save failed", http.StatusInternalServerError)
 				return
@@ -235,7 +966,12 @@ func DashboardHandler(hub *Hub, sessions *SessionStore) http.Handler {
 				http.Error(w, "invalid end", http.StatusBadRequest)
 				return
 			}
-			sr := ScanRange{Start: start, End: end}
+			proto, err := normalizeScanProtocol(r.URL.Query().Get("protocol"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			sr := ScanRange{Start: start, End: end, Protocol: proto}
 			if err := hub.config.RemoveScanRange(sr); err != nil {
 				http.Error(w, "save failed", http.StatusInternalServerError)
 				return
@@ -248,41 +984,214 @@ func DashboardHandler(hub *Hub, sessions *SessionStore) http.Handler {
 		}
 	})
 
-	mux.HandleFunc("/api/mappings", func(w http.ResponseWriter, r *http.Request) {
+	// Snapshot export (GET) and import (POST). Imports merge into the config;
+	// existing entries that differ are only replaced with ?overwrite=1, and
+	// ?dryRun=1 reports the changes without making them.
+	mux.HandleFunc("/api/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			json.NewEncoder(w).Encode(hub.Snapshot())
+
+		case http.MethodPost:
+			var req SnapshotImport
+			if !decodeBody(w, r, &req) {
+				return
+			}
+			q := r.URL.Query()
+			dryRun := q.Get("dryRun") == "1"
+			res, err := hub.config.ImportSnapshot(req, q.Get("overwrite") == "1", dryRun)
+			if err != nil {
+				if errors.Is(err, errInvalidSnapshot) {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+				} else {
+					http.Error(w, "save failed", http.StatusInternalServerError)
+				}
+				return
+			}
+			if !dryRun && (len(res.Added) > 0 || len(res.Replaced) > 0) {
+				hub.broadcastUpdate()
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(res)
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Named scan range sets; switching the active one rescans straight away
+	mux.HandleFunc("/api/scan-profiles", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
+			profiles, active := hub.config.ScanProfiles()
 			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(hub.config.Mappings())
+			json.NewEncoder(w).Encode(map[string]any{"active": active, "profiles": profiles})
+
+		case http.MethodPost:
+			var req ScanProfileRequest
+			if !decodeBody(w, r, &req) {
+				return
+			}
+			name := normalizeGroup(req.Name)
+			if err := validateScanProfile(name, req.Ranges); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := hub.config.SetScanProfile(name, req.Ranges); err != nil {
+				http.Error(w, "save failed", http.StatusInternalServerError)
+				return
+			}
+			if _, active := hub.config.ScanProfiles(); active == name {
+				hub.rescan()
+			}
+			hub.broadcastUpdate()
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(ScanProfileRequest{Name: name, Ranges: req.Ranges})
+
+		case http.MethodDelete:
+			_, active := hub.config.ScanProfiles()
+			if err := hub.config.RemoveScanProfile(r.URL.Query().Get("name")); err != nil {
+				if errors.Is(err, errUnknownScanProfile) {
+					http.Error(w, err.Error(), http.StatusNotFound)
+				} else {
+					http.Error(w, "save failed", http.StatusInternalServerError)
+				}
+				return
+			}
+			if _, now := hub.config.ScanProfiles(); now != active {
+				hub.rescan()
+			}
+			hub.broadcastUpdate()
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc("/api/scan-profiles/active", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Name string `json:"name"`
+		}
+		if !decodeBody(w, r, &req) {
+			return
+		}
+		if err := hub.config.UseScanProfile(req.Name); err != nil {
+			if errors.Is(err, errUnknownScanProfile) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+			} else {
+				http.Error(w, "save failed", http.StatusInternalServerError)
+			}
+			return
+		}
+		hub.rescan()
+		hub.broadcastUpdate()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"active": normalizeGroup(req.Name), "ranges": hub.config.ScanRanges()})
+	})
+
+	mux.HandleFunc("/api/mappings", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			views := hub.mappingViews()
+			if group := normalizeGroup(r.URL.Query().Get("group")); group != "" {
+				filtered := views[:0]
+				for _, v := range views {
+					if v.Group == group {
+						filtered = append(filtered, v)
+					}
+				}
+				views = filtered
+			}
+			if err := sortMappingViews(views, r.URL.Query().Get("sort")); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSONWithETag(w, r, views)
 
 		case http.MethodPost:
 			var req MappingRequest
-			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-				http.Error(w, "bad request", http.StatusBadRequest)
+			if !decodeBody(w, r, &req) {
 				return
 			}
+			var host string
+			if req.Target != "" {
+				if req.Port != 0 {
+					http.Error(w, "give either port or target, not both", http.StatusBadRequest)
+					return
+				}
+				var err error
+				if host, req.Port, err = parseTarget(req.Target); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			if req.Host != "" {
+				if host != "" {
+					http.Error(w, "give the host in either host or target, not both", http.StatusBadRequest)
+					return
+				}
+				var err error
+				if host, err = normalizeTargetHost(strings.TrimSpace(req.Host)); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
 			if req.Domain == "" || req.Port == 0 {
 				http.Error(w, "domain and port required", http.StatusBadRequest)
 				return
 			}
+			if req.Port < 1 || req.Port > 65535 {
+				http.Error(w, "port must be 1-65535", http.StatusBadRequest)
+				return
+			}
 			domain := strings.ToLower(strings.TrimSpace(req.Domain))
 			domain = strings.TrimSuffix(domain, "."+hub.config.DomainSuffix())
-			if domain == "portgate" || domain == "" {
-				http.Error(w, "reserved domain", http.StatusBadRequest)
+			if req.Mode == mappingModeBoth {
+				req.Mode = ""
+			}
+			stripPrefix, err := cleanStripPrefix(req.StripPrefix)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
 			m := DomainMapping{
-				Domain:     domain,
-				TargetPort: req.Port,
-				CreatedAt:  time.Now(),
+				Domain:                  domain,
+				TargetPort:              req.Port,
+				TargetHost:              host,
+				CreatedAt:               time.Now(),
+				ResponseRewrite:         req.ResponseRewrite,
+				StartupGracePeriodSec:   req.StartupGracePeriodSec,
+				Group:                   normalizeGroup(req.Group),
+				WebSocketIdleTimeoutSec: req.WebSocketIdleTimeoutSec,
+				Mode:                    req.Mode,
+				BackendHTTP2:            req.BackendHTTP2,
+				StripPrefix:             stripPrefix,
+			}
+			if err := validateMapping(m); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
 			}
 			if err := hub.config.AddMapping(m); err != nil {
 				http.Error(w, "save failed", http.StatusInternalServerError)
 				return
 			}
+			logEvent(levelInfo, logFields{"subdomain": m.Domain, "port": m.TargetPort, "target": mappingTarget(m), "remote_addr": r.RemoteAddr},
+				"mapping set: %s → %s", m.Domain, mappingTarget(m))
 			hub.broadcastUpdate()
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusCreated)
-			json.NewEncoder(w).Encode(m)
+			json.NewEncoder(w).Encode(struct {
+				DomainMapping
+				Warnings []string `json:"warnings,omitempty"`
+			}{m, unwatchedTargetWarnings(hub, m)})
 
 		case http.MethodDelete:
 			domain := r.URL.Query().Get("domain")
@@ -300,6 +1209,7 @@ func DashboardHandler(hub *Hub, sessions *SessionStore) http.Handler {
 				http.Error(w, "save failed", http.StatusInternalServerError)
 				return
 			}
+			logEvent(levelInfo, logFields{"subdomain": domain, "remote_addr": r.RemoteAddr}, "mapping removed: %s", domain)
 			hub.broadcastUpdate()
 			w.WriteHeader(http.StatusNoContent)
 
@@ -308,6 +1218,63 @@ func DashboardHandler(hub *Hub, sessions *SessionStore) http.Handler {
 		}
 	})
 
+	// A single mapping at /api/mappings/<domain>, and its test at .../test
+	mux.HandleFunc("/api/mappings/", func(w http.ResponseWriter, r *http.Request) {
+		domain, test := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/mappings/"), "/test")
+		if domain == "" || strings.Contains(domain, "/") {
+			apiNotFound(w)
+			return
+		}
+		method := http.MethodGet
+		if test {
+			method = http.MethodPost
+		}
+		if r.Method != method {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		domain = strings.TrimSuffix(strings.ToLower(domain), "."+hub.config.DomainSuffix())
+		m, found := hub.config.LookupMapping(domain)
+		if !found {
+			http.Error(w, "mapping not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !test {
+			json.NewEncoder(w).Encode(mappingView{DomainMapping: m, URL: hub.mappingURL(m.Domain)})
+			return
+		}
+		json.NewEncoder(w).Encode(testMapping(r.Context(), hub.config, m))
+	})
+
+	mux.HandleFunc("/api/maintenance", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req MaintenanceRequest
+		if !decodeBody(w, r, &req) {
+			return
+		}
+		m, ok := hub.config.LookupMapping(req.Domain)
+		if !ok {
+			http.Error(w, "mapping not found", http.StatusNotFound)
+			return
+		}
+		if m.System {
+			http.Error(w, "cannot put system mapping in maintenance", http.StatusForbidden)
+			return
+		}
+		if err := hub.config.SetMaintenance(req.Domain, req.Enabled); err != nil {
+			http.Error(w, "save failed", http.StatusInternalServerError)
+			return
+		}
+		hub.broadcastUpdate()
+		m.Maintenance = req.Enabled
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m)
+	})
+
 	mux.HandleFunc("/api/domain-suffix", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
@@ -318,13 +1285,12 @@ func DashboardHandler(hub *Hub, sessions *SessionStore) http.Handler {
 			var req struct {
 				Suffix string `json:"suffix"`
 			}
-			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-				http.Error(w, "bad request", http.StatusBadRequest)
+			if !decodeBody(w, r, &req) {
 				return
 			}
-			suffix := strings.ToLower(strings.TrimSpace(req.Suffix))
-			if suffix == "" {
-				http.Error(w, "suffix required", http.StatusBadRequest)
+			suffix := normalizeDomainSuffix(req.Suffix)
+			if err := validateDomainSuffix(suffix); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
 			if err := hub.config.SetDomainSuffix(suffix); err != nil {
@@ -341,37 +1307,278 @@ func DashboardHandler(hub *Hub, sessions *SessionStore) http.Handler {
 	})
 
 	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		if !hub.addWriter() {
+			http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
-			log.Printf("ws upgrade error: %v", err)
+			hub.writers.Done()
+			logEvent(levelWarn, logFields{"remote_addr": r.RemoteAddr, "error": err.Error()}, "ws upgrade error: %v", err)
 			return
 		}
 		client := &WSClient{hub: hub, conn: conn, send: make(chan []byte, 256)}
+		// Queue initial state before registering so a concurrent shutdown
+		// can't close send underneath us.
+		data, _ := json.Marshal(WSMessage{Type: "update", Data: hub.state()})
+		client.send <- data
 		hub.register <- client
 
 		go client.writePump()
 		go client.readPump()
-
-		// Send initial state
-		msg := struct {
-			Ports        []DiscoveredPort `json:"ports"`
-			Mappings     []DomainMapping  `json:"mappings"`
-			ScanRanges   []ScanRange      `json:"scan_ranges"`
-			DomainSuffix string           `json:"domain_suffix"`
-		}{
-			Ports:        hub.GetPorts(),
-			Mappings:     hub.config.Mappings(),
-			ScanRanges:   hub.config.ScanRanges(),
-			DomainSuffix: hub.config.DomainSuffix(),
-		}
-		data, _ := json.Marshal(WSMessage{Type: "update", Data: msg})
-		client.send <- data
 	})
 
 	staticSub, _ := fs.Sub(staticFS, "static")
-	mux.Handle("/", http.FileServer(http.FS(staticSub)))
+	// Unknown API routes get a JSON 404 rather than falling through to the
+	// static files, so clients never receive HTML where they expect JSON
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		apiNotFound(w)
+	})
+
+	mux.Handle("/", staticHandler(staticSub))
+
+	return limitRequest(readOnlyGuard(hub.config, mux))
+}
 
-	return mux
+// staticHandler serves the dashboard assets. A build that shipped without
+// them would otherwise serve a blank page, so it falls back to a bare page
+// that lists ports and mappings from the JSON API.
+func staticHandler(assets fs.FS) http.Handler {
+	if _, err := fs.Stat(assets, "index.html"); err == nil {
+		return http.FileServer(http.FS(assets))
+	}
+	log.Printf("warning: dashboard assets are missing from this build; serving a minimal fallback page")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" && r.URL.Path != "/index.html" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		io.WriteString(w, fallbackDashboardHTML)
+	})
+}
+
+// fallbackDashboardHTML is written inline rather than embedded, since it is
+// what gets served when the embedded assets are missing.
+const fallbackDashboardHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<title>Portgate (minimal dashboard)</title>
+<style>
+body { font-family: sans-serif; margin: 2rem; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2rem; }
+th, td { text-align: left; padding: 0.25rem 0.75rem; border-bottom: 1px solid #ddd; }
+.warn { background: #fff3cd; padding: 0.5rem 1rem; border: 1px solid #f0ad4e; }
+</style>
+</head>
+<body>
+<h1>Portgate</h1>
+<p class="warn">The dashboard assets are missing from this build, so this is a minimal fallback. The proxy and API work normally.</p>
+<h2>Mappings</h2>
+<table><thead><tr><th>Domain</th><th>Target</th></tr></thead><tbody id="mappings"></tbody></table>
+<h2>Ports</h2>
+<table><thead><tr><th>Port</th><th>Status</th><th>Service</th><th>Source</th></tr></thead><tbody id="ports"></tbody></table>
+<script>
+function cell(text) {
+  var td = document.createElement('td');
+  td.textContent = text;
+  return td;
+}
+function fill(id, rows) {
+  var body = document.getElementById(id);
+  body.textContent = '';
+  rows.forEach(function(cols) {
+    var tr = document.createElement('tr');
+    cols.forEach(function(c) { tr.appendChild(cell(c)); });
+    body.appendChild(tr);
+  });
+}
+function refresh() {
+  fetch('api/mappings').then(function(r) { return r.json(); }).then(function(ms) {
+    fill('mappings', (ms || []).map(function(m) { return [m.domain, ':' + m.targetPort]; }));
+  });
+  fetch('api/ports').then(function(r) { return r.json(); }).then(function(ps) {
+    fill('ports', (ps || []).map(function(p) {
+      return [p.port, p.healthy ? 'up' : 'down', [p.serviceName, p.title].filter(Boolean).join(' - '), p.source];
+    }));
+  });
+}
+refresh();
+setInterval(refresh, 5000);
+</script>
+</body>
+</html>
+`
+
+// apiNotFound answers an unknown API route with a JSON 404.
+func apiNotFound(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(map[string]string{"error": "unknown endpoint"})
+}
+
+// writeJSONWithETag writes v as JSON with an ETag derived from its content,
+// answering 304 Not Modified when the client already has that version. This
+// keeps polling clients cheap when nothing has changed.
+func writeJSONWithETag(w http.ResponseWriter, r *http.Request, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "encoding failed", http.StatusInternalServerError)
+		return
+	}
+	data = append(data, '\n')
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, using the
+// weak comparison RFC 9110 prescribes for it.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// stripBasePath serves next under the configured basePath as well as at the
+// root, for upstream proxies that forward the prefix rather than strip it.
+func stripBasePath(config *ConfigStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		base, _ := config.BasePath()
+		if base != "" {
+			// Relative asset URLs need the trailing slash
+			if r.URL.Path == base {
+				http.Redirect(w, r, base+"/", http.StatusMovedPermanently)
+				return
+			}
+			if rest, ok := strings.CutPrefix(r.URL.Path, base+"/"); ok {
+				r2 := new(http.Request)
+				*r2 = *r
+				u := *r.URL
+				u.Path = "/" + rest
+				u.RawPath = ""
+				r2.URL = &u
+				r = r2
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// danglingMappingWarnings warns that domains still route to a manual port
+// that was just removed.
+func danglingMappingWarnings(port int, domains []string) []string {
+	var warnings []string
+	for _, d := range domains {
+		warnings = append(warnings, fmt.Sprintf("mapping %s still routes to port %d, which is no longer health-checked", d, port))
+	}
+	return warnings
+}
+
+// unwatchedTargetWarnings warns when a new mapping routes to a local port
+// that is neither registered as a manual port nor currently discovered, since
+// nothing will then report whether its backend is up.
+func unwatchedTargetWarnings(hub *Hub, m DomainMapping) []string {
+	if m.TargetHost != "" {
+		return nil
+	}
+	for _, mp := range hub.config.ManualPorts() {
+		if mp.Port == m.TargetPort {
+			return nil
+		}
+	}
+	for _, p := range hub.GetPorts() {
+		if p.Port == m.TargetPort {
+			return nil
+		}
+	}
+	return []string{fmt.Sprintf("nothing was found listening on port %d; register it with `portgate add-port %d` to track its health", m.TargetPort, m.TargetPort)}
+}
+
+// readOnlySafe reports whether a non-GET API path is allowed in read-only
+// mode because it only inspects state.
+func readOnlySafe(path string) bool {
+	return path == "/api/ports/recheck" || path == "/api/reload" || path == "/api/scan" ||
+		strings.HasPrefix(path, "/api/mappings/") && strings.HasSuffix(path, "/test")
+}
+
+// readOnlyGuard rejects mutating API requests with 403 when read-only mode is
+// on. Reads, the WebSocket stream, and login keep working.
+func readOnlyGuard(config *ConfigStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Health rechecks, scans and mapping tests change no
+		// configuration, and a reload only applies what is already in the
+		// file, so they stay available
+		if config.ReadOnly() && strings.HasPrefix(r.URL.Path, "/api/") && !readOnlySafe(r.URL.Path) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+			default:
+				http.Error(w, "read-only mode", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// maxAPIBodyBytes caps request bodies sent to the dashboard. Mapping, port
+// and range payloads are a few hundred bytes at most.
+const maxAPIBodyBytes = 64 << 10
+
+// maxRequestHeaders caps the number of header fields on a dashboard request.
+const maxRequestHeaders = 100
+
+// limitRequest rejects requests with too many header fields with 431 and
+// caps the body of every request at maxAPIBodyBytes. The size of the header
+// block itself is limited by the server's MaxHeaderBytes.
+func limitRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fields := 0
+		for _, v := range r.Header {
+			fields += len(v)
+		}
+		if fields > maxRequestHeaders {
+			http.Error(w, "too many request headers", http.StatusRequestHeaderFieldsTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxAPIBodyBytes)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// decodeBody decodes the JSON request body into v. On failure it answers the
+// request and returns false.
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		badBody(w, err)
+		return false
+	}
+	return true
+}
+
+// badBody answers a request whose body could not be read or parsed: 413 if
+// it was over the size cap, 400 otherwise.
+func badBody(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, "bad request", http.StatusBadRequest)
 }
 
 func (c *WSClient) readPump() {
@@ -388,10 +1595,15 @@ func (c *WSClient) readPump() {
 }
 
 func (c *WSClient) writePump() {
+	defer c.hub.writers.Done()
 	defer c.conn.Close()
 	for msg := range c.send {
 		if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
-			break
+			return
 		}
 	}
+	// send was closed by the hub: say goodbye properly
+	c.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseGoingAway, ""),
+		time.Now().Add(time.Second))
 }
@@ -0,0 +1,976 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestMappingURLs(t *testing.T) {
+	cs := newTestConfigStore(t)
+	cs.cfg.DomainSuffix = "test"
+	cs.cfg.Mappings = []DomainMapping{{Domain: "app", TargetPort: 3000}, {Domain: "*.tenants", TargetPort: 3001}}
+	hub := NewHub(cs)
+
+	tests := []struct {
+		scheme string
+		port   int
+		want   string
+	}{
+		{"http", 80, "http://app.test/"},
+		{"http", 8000, "http://app.test:8000/"},
+		{"https", 443, "https://app.test/"},
+		{"https", 80, "https://app.test:80/"},
+	}
+	for _, tt := range tests {
+		hub.SetProxyEndpoint(tt.scheme, tt.port)
+		if got := hub.mappingURL("app"); got != tt.want {
+			t.Errorf("%s on %d: url = %q, want %q", tt.scheme, tt.port, got, tt.want)
+		}
+	}
+
+	hub.SetProxyEndpoint("http", 80)
+	rec := httptest.NewRecorder()
+	DashboardHandler(hub, NewSessionStore()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/mappings", nil))
+	var got []map[string]any
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0]["url"] != "http://app.test/" {
+		t.Errorf("GET /api/mappings = %v, want url on the first mapping", got)
+	}
+	if _, ok := got[1]["url"]; ok {
+		t.Errorf("wildcard mapping has a url: %v", got[1])
+	}
+}
+
+func TestMappingGroups(t *testing.T) {
+	cs := newTestConfigStore(t)
+	hub := NewHub(cs)
+	handler := DashboardHandler(hub, NewSessionStore())
+
+	for _, body := range []string{
+		`{"domain":"web","port":3000,"group":" Frontend "}`,
+		`{"domain":"api","port":3001,"group":"backend"}`,
+		`{"domain":"docs","port":3002}`,
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/mappings", strings.NewReader(body)))
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("POST %s: status %d: %s", body, rec.Code, rec.Body)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"web", "api", "docs"}},
+		{"?group=frontend", []string{"web"}},
+		{"?group=BACKEND", []string{"api"}},
+		{"?group=none", nil},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/mappings"+tt.query, nil))
+		var got []DomainMapping
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		var domains []string
+		for _, m := range got {
+			if !m.System {
+				domains = append(domains, m.Domain)
+			}
+		}
+		if strings.Join(domains, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("GET /api/mappings%s = %v, want %v", tt.query, domains, tt.want)
+		}
+	}
+	if m, _ := cs.LookupMapping("web"); m.Group != "frontend" {
+		t.Errorf("stored group = %q, want frontend", m.Group)
+	}
+
+	// A hand-edited group is normalized when the file is read back
+	if err := os.WriteFile(cs.path, []byte(`{"mappings":[{"domain":"web","targetPort":3000,"group":" FrontEnd"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := cs.LookupMapping("web"); m.Group != "frontend" {
+		t.Errorf("reloaded group = %q, want frontend", m.Group)
+	}
+}
+
+func TestDashboardConfig(t *testing.T) {
+	cs := newTestConfigStore(t)
+	cs.cfg.ReadOnly = true
+	hub := NewHub(cs)
+	hub.SetProxyEndpoint("https", 443)
+
+	rec := httptest.NewRecorder()
+	DashboardHandler(hub, NewSessionStore()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/config", nil))
+	var got dashboardConfig
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := dashboardConfig{APIBase: "/api", WSURL: "/ws", Version: version, ReadOnly: true, TLS: true, PollInterval: 5}
+	if got != want {
+		t.Errorf("GET /api/config = %+v, want %+v", got, want)
+	}
+}
+
+func TestGetMapping(t *testing.T) {
+	cs := newTestConfigStore(t)
+	cs.cfg.Mappings = []DomainMapping{{Domain: "app", TargetPort: 3000, Group: "web"}}
+	hub := NewHub(cs)
+	hub.SetProxyEndpoint("http", 80)
+	handler := DashboardHandler(hub, NewSessionStore())
+
+	tests := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{http.MethodGet, "/api/mappings/app", http.StatusOK},
+		{http.MethodGet, "/api/mappings/APP.localhost", http.StatusOK},
+		{http.MethodGet, "/api/mappings/nope", http.StatusNotFound},
+		{http.MethodDelete, "/api/mappings/app", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/mappings/app/test", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.code {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.path, rec.Code, tt.code)
+			continue
+		}
+		if rec.Code != http.StatusOK {
+			continue
+		}
+		var got mappingView
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Domain != "app" || got.TargetPort != 3000 || got.Group != "web" || got.URL != "http://app.localhost/" {
+			t.Errorf("%s %s = %+v", tt.method, tt.path, got)
+		}
+	}
+}
+
+func TestUnknownAPIRoute(t *testing.T) {
+	handler := DashboardHandler(NewHub(newTestConfigStore(t)), NewSessionStore())
+	for _, path := range []string{"/api/mapping", "/api/", "/api/ports/nope"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", path, rec.Code)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: Content-Type %q, want application/json", path, ct)
+		}
+		if body := strings.TrimSpace(rec.Body.String()); body != `{"error":"unknown endpoint"}` {
+			t.Errorf("%s: body %s", path, body)
+		}
+	}
+}
+
+func TestMappingOrder(t *testing.T) {
+	cs := newTestConfigStore(t)
+	hub := NewHub(cs)
+	handler := DashboardHandler(hub, NewSessionStore())
+	post := func(body string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/mappings", strings.NewReader(body)))
+		if rec.Code >= 300 {
+			t.Fatalf("POST %s: status %d: %s", body, rec.Code, rec.Body)
+		}
+	}
+	list := func(query string) []string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/mappings"+query, nil))
+		var ms []DomainMapping
+		json.NewDecoder(rec.Body).Decode(&ms)
+		var out []string
+		for _, m := range ms {
+			if !m.System {
+				out = append(out, m.Domain)
+			}
+		}
+		return out
+	}
+
+	post(`{"domain":"web","port":3002}`)
+	post(`{"domain":"api","port":3000}`)
+	post(`{"domain":"docs","port":3001}`)
+	created, _ := cs.LookupMapping("web")
+
+	// Editing a mapping keeps its position and creation time
+	post(`{"domain":"web","port":3005}`)
+	if got := strings.Join(list(""), ","); got != "web,api,docs" {
+		t.Errorf("order after edit = %s, want web,api,docs", got)
+	}
+	if m, _ := cs.LookupMapping("web"); m.TargetPort != 3005 || !m.CreatedAt.Equal(created.CreatedAt) {
+		t.Errorf("edited mapping = %+v, want port 3005 and createdAt %v", m, created.CreatedAt)
+	}
+
+	tests := []struct{ sort, want string }{
+		{"domain", "api,docs,web"},
+		{"port", "api,docs,web"},
+		{"created", "web,api,docs"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(list("?sort="+tt.sort), ","); got != tt.want {
+			t.Errorf("sort=%s: %s, want %s", tt.sort, got, tt.want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/mappings?sort=size", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("sort=size: status %d, want 400", rec.Code)
+	}
+}
+
+func TestMappingTest(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "app.localhost" {
+			t.Errorf("backend saw Host %q", r.Host)
+		}
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer backend.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	cs := newTestConfigStore(t)
+	cs.cfg.Mappings = []DomainMapping{
+		{Domain: "app", TargetPort: listenerPort(t, backend)},
+		{Domain: "down", TargetPort: closedPort},
+		{Domain: "paused", TargetPort: listenerPort(t, backend), Maintenance: true},
+	}
+	handler := DashboardHandler(NewHub(cs), NewSessionStore())
+
+	tests := []struct {
+		domain string
+		ok     bool
+		status int
+		errPfx string
+	}{
+		{"app", true, http.StatusTeapot, ""},
+		{"down", false, http.StatusBadGateway, "port closed"},
+		{"paused", false, http.StatusServiceUnavailable, "mapping is in maintenance"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/mappings/"+tt.domain+"/test", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", tt.domain, rec.Code, rec.Body)
+		}
+		var res MappingTestResult
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.OK != tt.ok || res.Status != tt.status || !strings.HasPrefix(res.Error, tt.errPfx) {
+			t.Errorf("%s: got %+v", tt.domain, res)
+		}
+	}
+
+	for path, want := range map[string]int{
+		"/api/mappings/nope/test": http.StatusNotFound,
+		"/api/mappings/app/ping":  http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		if rec.Code != want {
+			t.Errorf("%s: status %d, want %d", path, rec.Code, want)
+		}
+	}
+
+	// Testing changes nothing, so read-only mode allows it
+	cs.ForceReadOnly()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/mappings/app/test", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("read-only test: status %d", rec.Code)
+	}
+}
+
+func TestPortNotes(t *testing.T) {
+	cs := newTestConfigStore(t)
+	hub := NewHub(cs)
+	go hub.Run()
+	hub.ports = []DiscoveredPort{
+		{Port: 3000, Source: "scan"},
+		{Port: 9000, Source: "manual"},
+	}
+	handler := DashboardHandler(hub, NewSessionStore())
+
+	tests := []struct {
+		path string
+		body string
+		code int
+	}{
+		{"/api/ports/3000/note", `{"note": " charts experiment "}`, http.StatusOK},
+		{"/api/ports/9000/note", `{"note": "db"}`, http.StatusOK},
+		{"/api/ports/5555/note", `{"note": "not running yet"}`, http.StatusOK},
+		{"/api/ports/9000/note", `{"note": ""}`, http.StatusOK},
+		{"/api/ports/0/note", `{"note": "x"}`, http.StatusBadRequest},
+		{"/api/ports/abc/note", `{"note": "x"}`, http.StatusNotFound},
+		{"/api/ports/3000", `{"note": "x"}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, tt.path, strings.NewReader(tt.body)))
+		if rec.Code != tt.code {
+			t.Errorf("PUT %s: status %d, want %d: %s", tt.path, rec.Code, tt.code, rec.Body)
+		}
+	}
+
+	notes := map[int]string{}
+	for _, p := range hub.GetPorts() {
+		notes[p.Port] = p.Note
+	}
+	if want := map[int]string{3000: "charts experiment", 9000: ""}; !reflect.DeepEqual(notes, want) {
+		t.Errorf("port notes = %v, want %v", notes, want)
+	}
+
+	// Notes persist, including for ports that aren't up
+	reloaded, err := NewConfigStore(cs.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int]string{3000: "charts experiment", 5555: "not running yet"}; !reflect.DeepEqual(reloaded.PortNotes(), want) {
+		t.Errorf("saved notes = %v, want %v", reloaded.PortNotes(), want)
+	}
+}
+
+func TestStaticFallback(t *testing.T) {
+	staticSub, err := fs.Sub(staticFS, "static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		assets fs.FS
+		path   string
+		code   int
+		want   string
+	}{
+		{"embedded index", staticSub, "/", http.StatusOK, `class="list"`},
+		{"embedded asset", staticSub, "/client.js", http.StatusOK, "connect"},
+		{"missing assets", fstest.MapFS{}, "/", http.StatusOK, "assets are missing"},
+		{"missing asset file", fstest.MapFS{}, "/client.js", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			staticHandler(tt.assets).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.code {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("body missing %q", tt.want)
+			}
+		})
+	}
+}
+
+func TestStats(t *testing.T) {
+	cs := newTestConfigStore(t)
+	cs.cfg.ScanRanges = []ScanRange{{Start: 3000, End: 3009}, {Start: 3005, End: 3014}, {Start: 8000, End: 8000}}
+	cs.cfg.Mappings = []DomainMapping{
+		{Domain: "portgate", TargetPort: 8080, System: true},
+		{Domain: "app", TargetPort: 3000},
+		{Domain: "old", TargetPort: 3001, Maintenance: true},
+	}
+	hub := NewHub(cs)
+	hub.ports = []DiscoveredPort{
+		{Port: 3000, Healthy: true, Source: "scan"},
+		{Port: 3001, Healthy: true, Source: "scan"},
+		{Port: 9000, Healthy: false, Source: "manual"},
+	}
+	handler := DashboardHandler(hub, NewSessionStore())
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/stats", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var st hubStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+		t.Fatal(err)
+	}
+	if want := (portStats{Total: 3, Healthy: 2, Unhealthy: 1, Manual: 1, Scan: 2}); st.Ports != want {
+		t.Errorf("ports = %+v, want %+v", st.Ports, want)
+	}
+	if want := (mappingStats{Total: 3, Active: 2, Maintenance: 1, System: 1}); st.Mappings != want {
+		t.Errorf("mappings = %+v, want %+v", st.Mappings, want)
+	}
+	if want := (scanRangeStats{Count: 3, Ports: 16}); st.ScanRanges != want {
+		t.Errorf("scan ranges = %+v, want %+v", st.ScanRanges, want)
+	}
+	if st.LastScan != nil || st.Version == "" {
+		t.Errorf("lastScan %v version %q", st.LastScan, st.Version)
+	}
+}
+
+func TestPollingETags(t *testing.T) {
+	cs := newTestConfigStore(t)
+	cs.cfg.PollIntervalSec = 7
+	cs.cfg.Mappings = []DomainMapping{{Domain: "app", TargetPort: 3000}}
+	hub := NewHub(cs)
+	hub.ports = []DiscoveredPort{{Port: 3000, Healthy: true}}
+	handler := DashboardHandler(hub, NewSessionStore())
+	get := func(path, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, path := range []string{"/api/ports", "/api/mappings"} {
+		first := get(path, "")
+		etag := first.Header().Get("ETag")
+		if first.Code != http.StatusOK || etag == "" {
+			t.Fatalf("%s: status %d, ETag %q", path, first.Code, etag)
+		}
+		if rec := get(path, etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+			t.Errorf("%s: unchanged poll got %d with %d bytes, want empty 304", path, rec.Code, rec.Body.Len())
+		}
+		if rec := get(path, `"other", W/`+etag); rec.Code != http.StatusNotModified {
+			t.Errorf("%s: weak ETag in a list got %d, want 304", path, rec.Code)
+		}
+		if rec := get(path, `"stale"`); rec.Code != http.StatusOK || rec.Body.String() != first.Body.String() {
+			t.Errorf("%s: stale ETag got %d", path, rec.Code)
+		}
+	}
+
+	// A state change yields a new ETag
+	before := get("/api/ports", "").Header().Get("ETag")
+	hub.SetPorts([]DiscoveredPort{{Port: 3000, Healthy: false}})
+	if rec := get("/api/ports", before); rec.Code != http.StatusOK || rec.Header().Get("ETag") == before {
+		t.Errorf("changed ports: status %d, ETag %q (was %q)", rec.Code, rec.Header().Get("ETag"), before)
+	}
+
+	if got := hub.dashboardConfig("").PollInterval; got != 7 {
+		t.Errorf("pollIntervalSec = %d, want 7", got)
+	}
+}
+
+func TestBasePathWebSocket(t *testing.T) {
+	cs := newTestConfigStore(t)
+	hub := NewHub(cs)
+	go hub.Run()
+	sessions := NewSessionStore()
+	srv := httptest.NewServer(stripBasePath(cs, AuthMiddleware(cs, sessions, DashboardHandler(hub, sessions))))
+	defer srv.Close()
+	wsBase := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	config := func(path string, header http.Header) dashboardConfig {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		req.Header = header
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var got dashboardConfig
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		return got
+	}
+	upgrade := func(path string, header http.Header) {
+		t.Helper()
+		conn, resp, err := websocket.DefaultDialer.Dial(wsBase+path, header)
+		if err != nil {
+			status := 0
+			if resp != nil {
+				status = resp.StatusCode
+			}
+			t.Fatalf("upgrade %s: %v (status %d)", path, err, status)
+		}
+		conn.Close()
+	}
+
+	// An upstream that strips /portgate and says so
+	stripped := http.Header{"X-Forwarded-Prefix": {"/portgate/"}}
+	if got := config("/api/config", stripped); got.WSURL != "/portgate/ws" || got.APIBase != "/portgate/api" || got.BasePath != "/portgate" {
+		t.Errorf("stripped prefix: config = %+v", got)
+	}
+	upgrade("/ws", stripped)
+
+	// An upstream that forwards the prefix untouched, with basePath set
+	cs.cfg.BasePath = "/portgate"
+	if got := config("/portgate/api/config", nil); got.WSURL != "/portgate/ws" {
+		t.Errorf("basePath: wsUrl = %q, want /portgate/ws", got.WSURL)
+	}
+	upgrade("/portgate/ws", nil)
+
+	// X-Forwarded-Prefix from an untrusted peer is ignored
+	req := httptest.NewRequest(http.MethodGet, "/api/config", nil)
+	req.RemoteAddr = "203.0.113.9:4000"
+	req.Header.Set("X-Forwarded-Prefix", "/evil")
+	if got := requestPrefix(req, cs); got != "/portgate" {
+		t.Errorf("untrusted peer: prefix = %q, want /portgate", got)
+	}
+}
+
+func TestManualPortMappingLinks(t *testing.T) {
+	cs := newTestConfigStore(t)
+	cs.cfg.ManualPorts = []ManualPort{{Port: 9000, Name: "db"}}
+	hub := NewHub(cs)
+	go hub.Run()
+	hub.ports = []DiscoveredPort{{Port: 3000, Source: "scan"}}
+	handler := DashboardHandler(hub, NewSessionStore())
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+	warnings := func(rec *httptest.ResponseRecorder) []string {
+		var res PortRemoval
+		json.NewDecoder(rec.Body).Decode(&res)
+		return res.Warnings
+	}
+
+	// Mapping to a watched port is quiet; to an unknown one it warns
+	for _, tt := range []struct {
+		body string
+		warn bool
+	}{
+		{`{"domain": "db", "port": 9000}`, false},
+		{`{"domain": "web", "port": 3000}`, false},
+		{`{"domain": "typo", "port": 9999}`, true},
+		{`{"domain": "remote", "target": "10.0.0.5:9999"}`, false},
+	} {
+		rec := do(http.MethodPost, "/api/mappings", tt.body)
+		if got := warnings(rec); (len(got) > 0) != tt.warn {
+			t.Errorf("POST %s: warnings %q, want warning %v", tt.body, got, tt.warn)
+		}
+	}
+
+	// Removing the manual port warns about the mapping left behind
+	rec := do(http.MethodDelete, "/api/ports?port=9000", "")
+	if got := warnings(rec); rec.Code != http.StatusOK || len(got) != 1 || !strings.Contains(got[0], "db") {
+		t.Errorf("DELETE port 9000: %d, warnings %q", rec.Code, got)
+	}
+	if _, ok := cs.LookupMapping("db"); !ok {
+		t.Error("mapping db was removed without removeMappings")
+	}
+
+	// removeMappings takes the mappings with it
+	cs.AddManualPort(ManualPort{Port: 9999})
+	rec = do(http.MethodDelete, "/api/ports?port=9999&removeMappings=1", "")
+	var res PortRemoval
+	json.NewDecoder(rec.Body).Decode(&res)
+	if !reflect.DeepEqual(res.RemovedMappings, []string{"typo"}) {
+		t.Errorf("removedMappings = %q, want [typo]", res.RemovedMappings)
+	}
+	if _, ok := cs.LookupMapping("typo"); ok {
+		t.Error("mapping typo survived removeMappings")
+	}
+	if _, ok := cs.LookupMapping("remote"); !ok {
+		t.Error("mapping to another host on the same port was removed")
+	}
+	if rec := do(http.MethodDelete, "/api/ports?port=9000", ""); rec.Code != http.StatusOK {
+		t.Errorf("repeat DELETE with a mapping left: %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/api/ports?port=4000", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE unlinked port: %d, want 204", rec.Code)
+	}
+}
+
+func TestRequestLimits(t *testing.T) {
+	cs := newTestConfigStore(t)
+	cs.cfg.DashboardMaxHeaderBytes = 4 << 10
+	srv := httptest.NewUnstartedServer(DashboardHandler(NewHub(cs), NewSessionStore()))
+	srv.Config.MaxHeaderBytes = cs.DashboardMaxHeaderBytes()
+	srv.Start()
+	defer srv.Close()
+
+	huge := `{"domain": "app", "port": 3000, "group": "` + strings.Repeat("x", maxAPIBodyBytes) + `"}`
+	manyHeaders := make(http.Header)
+	for i := range maxRequestHeaders + 1 {
+		manyHeaders.Set(fmt.Sprintf("X-H%d", i), "1")
+	}
+	tests := []struct {
+		name   string
+		body   string
+		header http.Header
+		code   int
+	}{
+		{"small body", `{"domain": "app", "port": 3000}`, nil, http.StatusCreated},
+		{"oversized body", huge, nil, http.StatusRequestEntityTooLarge},
+		{"too many headers", `{"domain": "b", "port": 3000}`, manyHeaders, http.StatusRequestHeaderFieldsTooLarge},
+		{"oversized headers", `{"domain": "c", "port": 3000}`, http.Header{"X-Big": {strings.Repeat("x", 16<<10)}}, http.StatusRequestHeaderFieldsTooLarge},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/mappings", strings.NewReader(tt.body))
+		for k, v := range tt.header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.code {
+			t.Errorf("%s: status %d, want %d", tt.name, resp.StatusCode, tt.code)
+		}
+	}
+}
+
+func TestReloadAPI(t *testing.T) {
+	cs := newTestConfigStore(t)
+	hub := NewHub(cs)
+	handler := DashboardHandler(hub, NewSessionStore())
+	post := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/reload", nil))
+		return rec
+	}
+
+	// Without a reloader, as in tests and one-shot commands, it refuses
+	if rec := post(); rec.Code != http.StatusInternalServerError {
+		t.Errorf("no reloader: status %d, want 500", rec.Code)
+	}
+
+	hub.SetReloader(cs.Reload)
+	if err := os.WriteFile(cs.Path(), []byte(`{"configVersion": 1, "mappings": [{"domain": "edited", "targetPort": 4000}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rec := post()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reload: status %d: %s", rec.Code, rec.Body)
+	}
+	if _, ok := cs.LookupMapping("edited"); !ok {
+		t.Errorf("edited mapping not loaded: %+v", cs.Mappings())
+	}
+	if body := strings.TrimSpace(rec.Body.String()); !strings.Contains(body, `"mappings":1`) {
+		t.Errorf("body = %s", body)
+	}
+
+	// A broken file is reported and the current config stays
+	os.WriteFile(cs.Path(), []byte(`{"mappings": [`), 0644)
+	if rec := post(); rec.Code != http.StatusInternalServerError {
+		t.Errorf("broken file: status %d, want 500", rec.Code)
+	}
+	if _, ok := cs.LookupMapping("edited"); !ok {
+		t.Error("failed reload dropped the current config")
+	}
+
+	// Reloading only applies the file, so read-only mode allows it
+	cs.ForceReadOnly()
+	os.WriteFile(cs.Path(), []byte(`{"configVersion": 1}`), 0644)
+	if rec := post(); rec.Code != http.StatusOK {
+		t.Errorf("read-only: status %d, want 200", rec.Code)
+	}
+}
+
+func TestAPIToken(t *testing.T) {
+	cs := newTestConfigStore(t)
+	hub := NewHub(cs)
+	go hub.Run()
+	sessions := NewSessionStore()
+	srv := httptest.NewServer(TokenMiddleware(cs, AuthMiddleware(cs, sessions, DashboardHandler(hub, sessions))))
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+	get := func(path string, header http.Header) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		if header != nil {
+			req.Header = header
+		}
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	// Off by default
+	if resp := get("/api/mappings", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("no token configured: status %d", resp.StatusCode)
+	}
+
+	cs.cfg.APIToken = "s3cret"
+	for _, tt := range []struct {
+		path   string
+		header http.Header
+		want   int
+	}{
+		{"/api/mappings", nil, http.StatusUnauthorized},
+		{"/", nil, http.StatusUnauthorized},
+		{"/api/mappings", http.Header{"Authorization": {"Bearer wrong"}}, http.StatusUnauthorized},
+		{"/api/mappings", http.Header{"Authorization": {"Bearer s3cret"}}, http.StatusOK},
+		{"/api/mappings?token=s3cret", nil, http.StatusOK},
+		{"/api/mappings", http.Header{"Cookie": {tokenCookieName + "=s3cret"}}, http.StatusOK},
+	} {
+		if resp := get(tt.path, tt.header); resp.StatusCode != tt.want {
+			t.Errorf("GET %s %v: status %d, want %d", tt.path, tt.header, resp.StatusCode, tt.want)
+		}
+	}
+	// The query parameter leaves a cookie behind for the browser, and the
+	// page is reloaded without the token
+	resp := get("/?tab=ports&token=s3cret", nil)
+	if c := resp.Cookies(); len(c) != 1 || c[0].Name != tokenCookieName || c[0].Secure {
+		t.Errorf("cookies = %v", c)
+	}
+	if loc := resp.Header.Get("Location"); resp.StatusCode != http.StatusFound || loc != "/?tab=ports" {
+		t.Errorf("GET /?token=: status %d, Location %q; want 302 to /?tab=ports", resp.StatusCode, loc)
+	}
+
+	// The WebSocket needs it too
+	if _, resp, err := websocket.DefaultDialer.Dial(wsURL, nil); err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("WebSocket without token: err %v", err)
+	}
+	for _, dial := range []func() (*websocket.Conn, *http.Response, error){
+		func() (*websocket.Conn, *http.Response, error) {
+			return websocket.DefaultDialer.Dial(wsURL+"?token=s3cret", nil)
+		},
+		func() (*websocket.Conn, *http.Response, error) {
+			return websocket.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer s3cret"}})
+		},
+	} {
+		conn, _, err := dial()
+		if err != nil {
+			t.Fatalf("WebSocket with token: %v", err)
+		}
+		conn.Close()
+	}
+}
+
+func TestTokenTransport(t *testing.T) {
+	var got []string
+	rt := &tokenTransport{token: "s3cret", next: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		got = append(got, r.Host+"="+r.Header.Get("Authorization"))
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})}
+	client := &http.Client{Transport: rt}
+	client.Get("http://" + cliServerHost + "/api/mappings")
+	client.Get("https://api.github.com/repos/x/y/releases/latest")
+	want := []string{cliServerHost + "=Bearer s3cret", "api.github.com="}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("requests = %q, want %q", got, want)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestWebSocketOrigin(t *testing.T) {
+	cs := newTestConfigStore(t)
+	cs.cfg.DomainSuffix = "test"
+	cs.cfg.AllowedOrigins = []string{"https://dash.example.com", "*.corp.example"}
+	hub := NewHub(cs)
+	go hub.Run()
+	srv := httptest.NewServer(DashboardHandler(hub, NewSessionStore()))
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	dial := func(origin string) int {
+		t.Helper()
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial(wsURL, header)
+		if err != nil {
+			if resp == nil {
+				t.Fatalf("origin %q: %v", origin, err)
+			}
+			return resp.StatusCode
+		}
+		conn.Close()
+		return http.StatusSwitchingProtocols
+	}
+	for origin, want := range map[string]int{
+		"":                          http.StatusSwitchingProtocols,
+		srv.URL:                     http.StatusSwitchingProtocols,
+		"http://localhost:8080":     http.StatusSwitchingProtocols,
+		"http://127.0.0.1:9000":     http.StatusSwitchingProtocols,
+		"http://portgate.test":      http.StatusSwitchingProtocols,
+		"https://app.test:8443":     http.StatusSwitchingProtocols,
+		"https://dash.example.com":  http.StatusSwitchingProtocols,
+		"http://dash.example.com":   http.StatusForbidden,
+		"https://a.corp.example":    http.StatusSwitchingProtocols,
+		"https://evil.example":      http.StatusForbidden,
+		"https://localhost.evil.io": http.StatusForbidden,
+		"null":                      http.StatusForbidden,
+	} {
+		if got := dial(origin); got != want {
+			t.Errorf("origin %q: status %d, want %d", origin, got, want)
+		}
+	}
+
+	cs.AllowAllOrigins()
+	if got := dial("https://evil.example"); got != http.StatusSwitchingProtocols {
+		t.Errorf("--unsafe-allow-all-origins: status %d, want 101", got)
+	}
+}
+
+func TestScanAPI(t *testing.T) {
+	cs := newTestConfigStore(t)
+	off := false
+	cs.cfg.ResolveExe = &off
+	cs.cfg.DeferInitialScan = true
+	cs.cfg.ScanJitterMs = 60_000 // a scan-now doesn't wait out the start-up jitter
+	cs.cfg.ScanRanges = []ScanRange{{Start: 3000, End: 3001}}
+	hub := NewHub(cs)
+	go hub.Run()
+	dash := DashboardHandler(hub, NewSessionStore())
+	post := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		dash.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/scan", nil))
+		return rec
+	}
+
+	if rec := post(); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("without a scanner: status %d, want 503", rec.Code)
+	}
+
+	s := NewScanner(time.Hour, cs, hub.SetPorts)
+	s.prober = fakeProber{services: map[int]DiscoveredPort{3000: {ServiceName: "tcp"}}}
+	hub.SetScanner(s)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	rec := post()
+	var ports []DiscoveredPort
+	json.Unmarshal(rec.Body.Bytes(), &ports)
+	if rec.Code != http.StatusOK || len(ports) != 1 || ports[0].Port != 3000 {
+		t.Errorf("status %d, ports %+v; want 200 with port 3000", rec.Code, ports)
+	}
+
+	// A scan that outlasts the wait is reported as started
+	hub.scanWait = 10 * time.Millisecond
+	cancel()
+	if rec := post(); rec.Code != http.StatusAccepted {
+		t.Errorf("slow scan: status %d, want 202", rec.Code)
+	}
+}
+
+func TestConfigResetCSRF(t *testing.T) {
+	cs := newTestConfigStore(t)
+	cs.cfg.Mappings = []DomainMapping{{Domain: "app", TargetPort: 3000}}
+	if err := cs.Save(); err != nil {
+		t.Fatal(err)
+	}
+	hub := NewHub(cs)
+	go hub.Run()
+	srv := httptest.NewServer(DashboardHandler(hub, NewSessionStore()))
+	defer srv.Close()
+
+	forwardedFor := ""
+	reset := func(contentType, origin string) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/config/reset", nil)
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	// What a foreign page can send without a preflight
+	for _, ct := range []string{"", "text/plain", "application/x-www-form-urlencoded"} {
+		if got := reset(ct, "https://evil.example"); got != http.StatusUnsupportedMediaType {
+			t.Errorf("Content-Type %q: status %d, want 415", ct, got)
+		}
+	}
+	if got := reset("application/json", "https://evil.example"); got != http.StatusForbidden {
+		t.Errorf("foreign origin: status %d, want 403", got)
+	}
+	if _, ok := cs.LookupMapping("app"); !ok {
+		t.Fatal("a refused reset changed the config")
+	}
+	if got := reset("application/json; charset=utf-8", ""); got != http.StatusOK {
+		t.Errorf("CLI reset: status %d, want 200", got)
+	}
+	if _, ok := cs.LookupMapping("app"); ok {
+		t.Error("reset kept the mappings")
+	}
+
+	// A remote reset needs the dashboard to be protected, by the password or
+	// by the API token
+	forwardedFor = "203.0.113.7"
+	if got := reset("application/json", ""); got != http.StatusForbidden {
+		t.Errorf("remote reset without auth: status %d, want 403", got)
+	}
+	cs.cfg.APIToken = "s3cret"
+	if got := reset("application/json", ""); got != http.StatusOK {
+		t.Errorf("remote reset with an API token: status %d, want 200", got)
+	}
+}
+
+func TestHubShutdown(t *testing.T) {
+	cs := newTestConfigStore(t)
+	hub := NewHub(cs)
+	go hub.Run()
+	srv := httptest.NewServer(DashboardHandler(hub, NewSessionStore()))
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var msg WSMessage
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != "update" {
+		t.Fatalf("initial message = %+v, %v", msg, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	hub.Shutdown(ctx)
+	if ctx.Err() != nil {
+		t.Fatal("Shutdown didn't drain the writer")
+	}
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != "server-shutdown" {
+		t.Errorf("after shutdown = %+v, %v", msg, err)
+	}
+
+	// Nothing new is accepted once Shutdown has started
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("dial after shutdown: %v, %v", resp, err)
+	}
+}
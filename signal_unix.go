@@ -8,3 +8,6 @@ import (
 )
 
 var shutdownSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+
+// reloadSignals trigger a config reload without restarting.
+var reloadSignals = []os.Signal{syscall.SIGHUP}
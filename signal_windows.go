@@ -7,3 +7,6 @@ import (
 )
 
 var shutdownSignals = []os.Signal{os.Interrupt}
+
+// reloadSignals is empty: Windows has no SIGHUP.
+var reloadSignals []os.Signal
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// snapshotFormat is bumped when the snapshot layout changes incompatibly.
+const snapshotFormat = 1
+
+// errInvalidSnapshot wraps validation failures of an imported snapshot.
+var errInvalidSnapshot = errors.New("invalid snapshot")
+
+// redactedValue replaces secrets in exported snapshots.
+const redactedValue = "[redacted]"
+
+// Snapshot is a point-in-time dump of a running portgate, for bug reports and
+// for sharing a setup: build info, the effective config with secrets
+// redacted, and the discovered ports.
+type Snapshot struct {
+	Format    int              `json:"snapshotFormat"`
+	CreatedAt time.Time        `json:"createdAt"`
+	Build     BuildInfo        `json:"build"`
+	Config    Config           `json:"config"`
+	Ports     []DiscoveredPort `json:"ports"`
+}
+
+// SnapshotImport is the part of a config that can be applied to another
+// portgate: the domain suffix, user mappings, scan ranges and manual ports.
+// It is what POST /api/snapshot takes and what portgate export writes.
+type SnapshotImport struct {
+	DomainSuffix string          `json:"domainSuffix,omitempty"`
+	Mappings     []DomainMapping `json:"mappings,omitempty"`
+	ScanRanges   []ScanRange     `json:"scanRanges,omitempty"`
+	ManualPorts  []ManualPort    `json:"manualPorts,omitempty"`
+}
+
+// SnapshotImportResult describes what an import changed, or would change on
+// a dry run. Entries are labeled like "mapping app" or "port 3000".
+type SnapshotImportResult struct {
+	Added     []string `json:"added,omitempty"`
+	Replaced  []string `json:"replaced,omitempty"`  // existing entries overwritten
+	Conflicts []string `json:"conflicts,omitempty"` // existing entries that differ and were kept
+	Skipped   []string `json:"skipped,omitempty"`   // system mappings, never imported
+	Unchanged int      `json:"unchanged"`
+}
+
+// Snapshot captures the hub's current state.
+func (h *Hub) Snapshot() Snapshot {
+	return Snapshot{
+		Format:    snapshotFormat,
+		CreatedAt: time.Now().UTC(),
+		Build:     currentBuildInfo(),
+		Config:    h.config.ExportConfig(),
+		Ports:     h.GetPorts(),
+	}
+}
+
+// ExportConfig returns a deep copy of the config with the scan ranges in
+// effect filled in and secrets redacted.
+func (cs *ConfigStore) ExportConfig() Config {
+	ranges := cs.ScanRanges()
+	cs.mu.RLock()
+	data, err := json.Marshal(cs.cfg)
+	cs.mu.RUnlock()
+	var cfg Config
+	if err == nil {
+		json.Unmarshal(data, &cfg)
+	}
+	cfg.ScanRanges = ranges
+	redactConfig(&cfg)
+	return cfg
+}
+
+// redactConfig blanks out everything in cfg that grants access: the master
+// password hash, the API token and any credentials embedded in URLs.
+func redactConfig(cfg *Config) {
+	if cfg.MasterPasswordHash != "" {
+		cfg.MasterPasswordHash = redactedValue
+	}
+	if cfg.APIToken != "" {
+		cfg.APIToken = redactedValue
+	}
+	if u, err := url.Parse(cfg.UpdateAPIBase); err == nil && u.User != nil {
+		u.User = url.User(redactedValue)
+		cfg.UpdateAPIBase = u.String()
+	}
+}
+
+// Import returns the importable part of the snapshot.
+func (s Snapshot) Import() SnapshotImport {
+	return SnapshotImport{
+		Mappings:    s.Config.Mappings,
+		ScanRanges:  s.Config.ScanRanges,
+		ManualPorts: s.Config.ManualPorts,
+	}
+}
+
+// validateSnapshotImport checks every entry before anything is applied, so an
+// import is all or nothing. System mappings are allowed through; they are
+// skipped when applying.
+func validateSnapshotImport(in SnapshotImport) error {
+	if in.DomainSuffix != "" {
+		if err := validateDomainSuffix(normalizeDomainSuffix(in.DomainSuffix)); err != nil {
+			return err
+		}
+	}
+	seen := make(map[string]bool)
+	for _, m := range in.Mappings {
+		if m.System {
+			continue
+		}
+		if seen[m.Domain] {
+			return fmt.Errorf("duplicate mapping %q", m.Domain)
+		}
+		seen[m.Domain] = true
+		if err := validateMapping(m); err != nil {
+			return fmt.Errorf("mapping %q: %w", m.Domain, err)
+		}
+		if m.TargetHost != "" {
+			if _, err := normalizeTargetHost(m.TargetHost); err != nil {
+				return fmt.Errorf("mapping %q: %w", m.Domain, err)
+			}
+		}
+	}
+	for _, r := range in.ScanRanges {
+		if err := validateScanRange(r); err != nil {
+			return fmt.Errorf("scan range: %w", err)
+		}
+	}
+	ports := make(map[int]bool)
+	for _, mp := range in.ManualPorts {
+		if mp.Port < 1 || mp.Port > 65535 {
+			return fmt.Errorf("manual port %d: port must be 1-65535", mp.Port)
+		}
+		if ports[mp.Port] {
+			return fmt.Errorf("duplicate manual port %d", mp.Port)
+		}
+		ports[mp.Port] = true
+		if err := validateProbePaths(mp.ProbePaths); err != nil {
+			return fmt.Errorf("manual port %d: %w", mp.Port, err)
+		}
+		if err := validateHealthPath(mp.HealthPath); err != nil {
+			return fmt.Errorf("manual port %d: %w", mp.Port, err)
+		}
+	}
+	return nil
+}
+
+// ImportSnapshot merges the snapshot's domain suffix, mappings, scan ranges
+// and manual ports into the config. New entries are added; entries that exist
+// with different settings are reported as conflicts and kept unless overwrite
+// is set. System mappings are never touched. With dryRun nothing is changed,
+// but the result still describes what would happen.
+func (cs *ConfigStore) ImportSnapshot(in SnapshotImport, overwrite, dryRun bool) (SnapshotImportResult, error) {
+	var res SnapshotImportResult
+	if err := validateSnapshotImport(in); err != nil {
+		return res, fmt.Errorf("%w: %w", errInvalidSnapshot, err)
+	}
+
+	cs.mu.Lock()
+	name := cs.cfg.ActiveScanProfile
+	if _, ok := cs.cfg.ScanProfiles[name]; name != "" && !ok {
+		cs.mu.Unlock()
+		return res, fmt.Errorf("activeScanProfile: %w %q", errUnknownScanProfile, name)
+	}
+	suffix := cs.cfg.DomainSuffix
+	if s := normalizeDomainSuffix(in.DomainSuffix); s != "" {
+		label := "domain suffix " + s
+		switch {
+		case suffix == "":
+			suffix = s
+			res.Added = append(res.Added, label)
+		case suffix == s:
+			res.Unchanged++
+		case overwrite:
+			suffix = s
+			res.Replaced = append(res.Replaced, label)
+		default:
+			res.Conflicts = append(res.Conflicts, label)
+		}
+	}
+	mappings := slices.Clone(cs.cfg.Mappings)
+	manual := slices.Clone(cs.cfg.ManualPorts)
+	for _, m := range in.Mappings {
+		label := "mapping " + m.Domain
+		i := slices.IndexFunc(mappings, func(e DomainMapping) bool { return e.Domain == m.Domain })
+		switch {
+		case m.System || i >= 0 && mappings[i].System:
+			res.Skipped = append(res.Skipped, label)
+		case i < 0:
+			m.CreatedAt = time.Now()
+			m.Maintenance = false
+			mappings = append(mappings, m)
+			res.Added = append(res.Added, label)
+		default:
+			m.CreatedAt = mappings[i].CreatedAt
+			m.Maintenance = mappings[i].Maintenance
+			if reflect.DeepEqual(m, mappings[i]) {
+				res.Unchanged++
+			} else if overwrite {
+				mappings[i] = m
+				res.Replaced = append(res.Replaced, label)
+			} else {
+				res.Conflicts = append(res.Conflicts, label)
+			}
+		}
+	}
+	for _, mp := range in.ManualPorts {
+		label := fmt.Sprintf("port %d", mp.Port)
+		i := slices.IndexFunc(manual, func(e ManualPort) bool { return e.Port == mp.Port })
+		switch {
+		case i < 0:
+			manual = append(manual, mp)
+			res.Added = append(res.Added, label)
+		case reflect.DeepEqual(mp, manual[i]):
+			res.Unchanged++
+		case overwrite:
+			manual[i] = mp
+			res.Replaced = append(res.Replaced, label)
+		default:
+			res.Conflicts = append(res.Conflicts, label)
+		}
+	}
+
+	// Ranges only ever add coverage, so they can't conflict. Like
+	// AddScanRange, they go to the active profile when there is one.
+	ranges := slices.Clone(cs.cfg.ScanRanges)
+	if name != "" {
+		ranges = slices.Clone(cs.cfg.ScanProfiles[name])
+	} else if len(ranges) == 0 {
+		ranges = slices.Clone(DefaultScanRanges)
+	}
+	rangesAdded := false
+	for _, r := range in.ScanRanges {
+		if slices.Contains(ranges, r) {
+			res.Unchanged++
+			continue
+		}
+		ranges = append(ranges, r)
+		res.Added = append(res.Added, "range "+r.String())
+		rangesAdded = true
+	}
+
+	changed := len(res.Added) > 0 || len(res.Replaced) > 0
+	if dryRun || !changed {
+		cs.mu.Unlock()
+		return res, nil
+	}
+	now := time.Now()
+	for _, m := range mappings {
+		if _, ok := cs.firstSeen[m.Domain]; !ok {
+			cs.firstSeen[m.Domain] = now
+		}
+	}
+	cs.cfg.DomainSuffix = suffix
+	cs.cfg.Mappings = mappings
+	cs.cfg.ManualPorts = manual
+	if rangesAdded {
+		if name != "" {
+			cs.cfg.ScanProfiles[name] = ranges
+		} else {
+			cs.cfg.ScanRanges = ranges
+		}
+	}
+	cs.mu.Unlock()
+	return res, cs.Save()
+}
+
+// Export writes the portable part of the config to w as an indented
+// SnapshotImport, so it can be read back by Import or POSTed to /api/snapshot.
+// The scan ranges are the ones in effect, so the defaults are written out too.
+func (cs *ConfigStore) Export(w io.Writer) error {
+	ranges := cs.ScanRanges()
+	cs.mu.RLock()
+	out := SnapshotImport{
+		DomainSuffix: cs.cfg.DomainSuffix,
+		ScanRanges:   ranges,
+		ManualPorts:  slices.Clone(cs.cfg.ManualPorts),
+	}
+	for _, m := range cs.cfg.Mappings {
+		if !m.System {
+			out.Mappings = append(out.Mappings, m)
+		}
+	}
+	cs.mu.RUnlock()
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// Import reads an export written by Export and applies it. With merge it is
+// a snapshot import without overwrite: new entries are added and existing
+// ones are kept. Otherwise the user mappings, scan ranges, manual ports and
+// domain suffix are all replaced. Either way every entry is validated before
+// anything changes, and system mappings are skipped.
+func (cs *ConfigStore) Import(r io.Reader, merge bool) (SnapshotImportResult, error) {
+	var res SnapshotImportResult
+	var in SnapshotImport
+	if err := json.NewDecoder(r).Decode(&in); err != nil {
+		return res, fmt.Errorf("%w: %w", errInvalidSnapshot, err)
+	}
+	if merge {
+		return cs.ImportSnapshot(in, false, false)
+	}
+	if err := validateSnapshotImport(in); err != nil {
+		return res, fmt.Errorf("%w: %w", errInvalidSnapshot, err)
+	}
+
+	cs.mu.Lock()
+	name := cs.cfg.ActiveScanProfile
+	if _, ok := cs.cfg.ScanProfiles[name]; name != "" && !ok {
+		cs.mu.Unlock()
+		return res, fmt.Errorf("activeScanProfile: %w %q", errUnknownScanProfile, name)
+	}
+	var mappings []DomainMapping
+	for _, m := range cs.cfg.Mappings {
+		if m.System {
+			mappings = append(mappings, m)
+		}
+	}
+	now := time.Now()
+	for _, m := range in.Mappings {
+		label := "mapping " + m.Domain
+		if m.System {
+			res.Skipped = append(res.Skipped, label)
+			continue
+		}
+		if m.CreatedAt.IsZero() {
+			m.CreatedAt = now
+		}
+		mappings = append(mappings, m)
+		res.Added = append(res.Added, label)
+		if _, ok := cs.firstSeen[m.Domain]; !ok {
+			cs.firstSeen[m.Domain] = now
+		}
+	}
+	for _, mp := range in.ManualPorts {
+		res.Added = append(res.Added, fmt.Sprintf("port %d", mp.Port))
+	}
+	for _, r := range in.ScanRanges {
+		res.Added = append(res.Added, "range "+r.String())
+	}
+	cs.cfg.Mappings = mappings
+	cs.cfg.ManualPorts = in.ManualPorts
+	// Like AddScanRange, ranges go to the active profile when there is one
+	if name != "" {
+		cs.cfg.ScanProfiles[name] = in.ScanRanges
+	} else {
+		cs.cfg.ScanRanges = in.ScanRanges
+	}
+	cs.cfg.DomainSuffix = normalizeDomainSuffix(in.DomainSuffix)
+	cs.mu.Unlock()
+	return res, cs.Save()
+}
+
+// cmdSnapshot exports the running server's state to a file, or imports the
+// mappings, scan ranges and manual ports of one. An import that would
+// overwrite existing entries asks first.
+func cmdSnapshot(args []string) {
+	const base = "http://localhost:8080/api/snapshot"
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	yes := fs.Bool("yes", false, "overwrite conflicting entries without asking")
+	dryRun := fs.Bool("dry-run", false, "show what an import would change without changing it")
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: portgate snapshot <export|import> <file> [--dry-run] [--yes]")
+		os.Exit(1)
+	}
+	fs.Parse(args[2:])
+	file := args[1]
+
+	switch args[0] {
+	case "export":
+		resp, err := http.Get(base)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v (is portgate running?)\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+		var snap Snapshot
+		if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&snap) != nil {
+			fmt.Fprintf(os.Stderr, "error: snapshot request failed (%s)\n", resp.Status)
+			os.Exit(1)
+		}
+		data, _ := json.MarshalIndent(snap, "", "  ")
+		data = append(data, '\n')
+		if file == "-" {
+			os.Stdout.Write(data)
+			return
+		}
+		if err := os.WriteFile(file, data, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote snapshot of %d mappings and %d ports to %s\n", len(snap.Config.Mappings), len(snap.Ports), file)
+
+	case "import":
+		data, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		var snap Snapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s is not a snapshot: %v\n", file, err)
+			os.Exit(1)
+		}
+		if snap.Format != snapshotFormat {
+			fmt.Fprintf(os.Stderr, "error: unsupported snapshot format %d (expected %d)\n", snap.Format, snapshotFormat)
+			os.Exit(1)
+		}
+		body, _ := json.Marshal(snap.Import())
+
+		// Preview first, so conflicts can be confirmed before anything changes
+		plan, err := postSnapshotImport(base+"?dryRun=1", body)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		printSnapshotPlan(os.Stdout, plan)
+		if *dryRun {
+			return
+		}
+		if len(plan.Added) == 0 && len(plan.Conflicts) == 0 {
+			fmt.Println("Nothing to import")
+			return
+		}
+		target := base
+		if len(plan.Conflicts) > 0 {
+			if !*yes && !confirm(fmt.Sprintf("Overwrite %d existing entries?", len(plan.Conflicts))) {
+				fmt.Println("Aborted; nothing was imported")
+				os.Exit(1)
+			}
+			target += "?overwrite=1"
+		}
+		res, err := postSnapshotImport(target, body)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Imported %d new and %d replaced entries\n", len(res.Added), len(res.Replaced))
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown snapshot subcommand: %s\nsubcommands: export, import\n", args[0])
+		os.Exit(1)
+	}
+}
+
+// postSnapshotImport sends an import to the running server.
+func postSnapshotImport(url string, body []byte) (SnapshotImportResult, error) {
+	var res SnapshotImportResult
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return res, fmt.Errorf("%v (is portgate running?)", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return res, errors.New(strings.TrimSpace(string(msg)))
+	}
+	return res, json.NewDecoder(resp.Body).Decode(&res)
+}
+
+// printSnapshotPlan lists what an import changes.
+func printSnapshotPlan(w io.Writer, res SnapshotImportResult) {
+	section := func(title string, entries []string) {
+		if len(entries) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "%s:\n", title)
+		for _, e := range entries {
+			fmt.Fprintf(w, "  %s\n", e)
+		}
+	}
+	section("New", res.Added)
+	section("Replaced", res.Replaced)
+	section("Existing with different settings", res.Conflicts)
+	section("Skipped (system)", res.Skipped)
+	if res.Unchanged > 0 {
+		fmt.Fprintf(w, "%d entries already match\n", res.Unchanged)
+	}
+}
+
+// confirm asks a yes/no question on stdin; anything but y or yes is no.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
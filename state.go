@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stateFileName is the file next to the config that holds the ports known at
+// the last shutdown.
+const stateFileName = "state.json"
+
+// hubSavedState is the persisted form of the hub's ports.
+type hubSavedState struct {
+	SavedAt time.Time        `json:"savedAt"`
+	Ports   []DiscoveredPort `json:"ports"`
+}
+
+// statePath returns where the hub's state is saved.
+func (h *Hub) statePath() string {
+	return filepath.Join(filepath.Dir(h.config.Path()), stateFileName)
+}
+
+// saveState writes the known ports to the state file, so the next start can
+// show them before its first scan.
+func (h *Hub) saveState() error {
+	h.mu.RLock()
+	data, err := json.MarshalIndent(hubSavedState{SavedAt: time.Now(), Ports: h.ports}, "", "  ")
+	h.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	path := h.statePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadState restores the ports saved by saveState, marked stale and unhealthy
+// until a scan sees them again. A missing state file is not an error.
+func (h *Hub) loadState() error {
+	data, err := os.ReadFile(h.statePath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var st hubSavedState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+	for i := range st.Ports {
+		st.Ports[i].Stale, st.Ports[i].Healthy = true, false
+		st.Ports[i].ConsecutiveFailures, st.Ports[i].LastError = 0, ""
+	}
+	h.mu.Lock()
+	h.ports = st.Ports
+	h.mu.Unlock()
+	return nil
+}
+
+// lingerPorts returns fresh plus the ports of prev it no longer has that were
+// last seen within grace. Those are kept as stale and unhealthy, so a service
+// that stopped stays visible for a while instead of vanishing at once.
+func lingerPorts(fresh, prev []DiscoveredPort, now time.Time, grace time.Duration) []DiscoveredPort {
+	if grace <= 0 {
+		return fresh
+	}
+	have := make(map[portKey]bool, len(fresh))
+	for _, p := range fresh {
+		have[p.key()] = true
+	}
+	for _, p := range prev {
+		if have[p.key()] || now.Sub(p.LastSeen) >= grace {
+			continue
+		}
+		p.Stale, p.Healthy, p.HealthReason = true, false, healthTCPClosed
+		fresh = append(fresh, p)
+	}
+	return fresh
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestHubState(t *testing.T) {
+	cs := newTestConfigStore(t)
+	now := time.Now()
+	hub := NewHub(cs)
+	hub.SetPorts([]DiscoveredPort{
+		{Port: 3000, Protocol: "tcp", Healthy: true, LastSeen: now, Source: "scan"},
+		{Port: 9000, Protocol: "tcp", Healthy: true, LastSeen: now, Source: "manual", ConsecutiveFailures: 2, LastError: "timeout"},
+	})
+	if err := hub.saveState(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new hub shows the saved ports as stale until a scan sees them
+	restored := NewHub(cs)
+	if err := restored.loadState(); err != nil {
+		t.Fatal(err)
+	}
+	ports := restored.GetPorts()
+	if len(ports) != 2 || !ports[0].Stale || !ports[1].Stale || ports[0].Healthy || ports[1].ConsecutiveFailures != 0 {
+		t.Fatalf("restored = %+v", ports)
+	}
+	restored.SetPorts([]DiscoveredPort{{Port: 9000, Protocol: "tcp", Healthy: true, LastSeen: now, Source: "manual"}})
+	if ports := restored.GetPorts(); len(ports) != 1 || ports[0].Stale {
+		t.Errorf("after scan without grace = %+v", ports)
+	}
+
+	// No state file is not an error
+	if err := NewHub(newTestConfigStore(t)).loadState(); err != nil {
+		t.Errorf("missing state file: %v", err)
+	}
+}
+
+func TestLingerPorts(t *testing.T) {
+	now := time.Now()
+	prev := []DiscoveredPort{
+		{Port: 3000, Protocol: "tcp", Healthy: true, LastSeen: now.Add(-10 * time.Second)},
+		{Port: 3001, Protocol: "tcp", Healthy: true, LastSeen: now.Add(-2 * time.Minute)},
+		{Port: 3002, Protocol: "tcp", Healthy: true, LastSeen: now},
+	}
+	fresh := []DiscoveredPort{{Port: 3002, Protocol: "tcp", Healthy: true, LastSeen: now}}
+
+	got := lingerPorts(slices.Clone(fresh), prev, now, time.Minute)
+	if len(got) != 2 || got[1].Port != 3000 || !got[1].Stale || got[1].Healthy || got[1].HealthReason != healthTCPClosed {
+		t.Errorf("lingerPorts = %+v, want 3002 and a stale 3000", got)
+	}
+	if got := lingerPorts(slices.Clone(fresh), prev, now, 0); len(got) != 1 {
+		t.Errorf("no grace: %+v", got)
+	}
+}
@@ -15,44 +15,161 @@
     return JSON.parse(JSON.stringify(defaultFilters));
   })();
 
+  var groupFilter = (function() {
+    try { return localStorage.getItem('portgate-group') || ''; } catch(e) { return ''; }
+  })();
+
+  // Latest test result per mapping domain, shown until the page reloads
+  var testResults = {};
+
+  // config comes from /api/config; these defaults are used if it can't be read
+  var config = { apiBase: '/api', wsUrl: '/ws', basePath: '', readOnly: false, pollIntervalSec: 5 };
+
+  function api(path) {
+    return config.apiBase + path;
+  }
+
+  var serverEpoch = null;
+  var reconnectDelay = 1000;
+  var nextReconnectDelay = null;
+
   function connect() {
-    const proto = location.protocol === 'https:' ? 'wss:' : 'ws:';
-    ws = new WebSocket(proto + '//' + location.host + '/ws');
+    var url = config.wsUrl;
+    if (!/^wss?:/.test(url)) {
+      const proto = location.protocol === 'https:' ? 'wss:' : 'ws:';
+      url = proto + '//' + location.host + url;
+    }
+    ws = new WebSocket(url);
+    var opened = false;
 
     ws.onopen = function() {
       console.log('Portgate WS connected');
+      opened = true;
+      reconnectDelay = 1000;
+      stopPolling();
     };
 
     ws.onmessage = function(e) {
       const msg = JSON.parse(e.data);
+      if (msg.type === 'server-shutdown') {
+        nextReconnectDelay = (msg.data && msg.data.reconnect_after_ms) || null;
+        return;
+      }
       if (msg.type === 'update') {
+        // A new epoch means the server restarted, possibly with a new version
+        if (serverEpoch !== null && msg.data.epoch !== serverEpoch) {
+          location.reload();
+          return;
+        }
+        serverEpoch = msg.data.epoch;
         state.ports = msg.data.ports || [];
         state.mappings = msg.data.mappings || [];
         state.scanRanges = msg.data.scan_ranges || [];
         state.domainSuffix = msg.data.domain_suffix || 'localhost';
+        document.getElementById('scan-paused').hidden = !msg.data.scanning_paused;
+        var profileEl = document.getElementById('scan-profile');
+        profileEl.textContent = msg.data.scan_profile ? 'profile: ' + msg.data.scan_profile : '';
+        profileEl.hidden = !msg.data.scan_profile;
         render();
+        refreshStats();
       }
     };
 
     ws.onclose = function() {
-      console.log('Portgate WS disconnected, reconnecting...');
-      setTimeout(connect, 2000);
+      // A socket that never opened is likely blocked by a proxy or firewall;
+      // poll the REST API meanwhile so the page still updates
+      if (!opened) startPolling();
+      // Honor the server's suggested delay on clean shutdown, otherwise back
+      // off exponentially with jitter so restarts don't cause reconnect storms
+      var delay = nextReconnectDelay || reconnectDelay;
+      nextReconnectDelay = null;
+      reconnectDelay = Math.min(reconnectDelay * 2, 30000);
+      delay += Math.random() * 1000;
+      console.log('Portgate WS disconnected, reconnecting in ' + Math.round(delay) + 'ms...');
+      setTimeout(connect, delay);
     };
   }
 
+  // Polling fallback for when the WebSocket can't connect. ETags make an
+  // unchanged poll a cheap 304.
+  var pollTimer = null;
+  var etags = {};
+
+  function startPolling() {
+    if (pollTimer) return;
+    console.log('Portgate WS unavailable, polling every ' + config.pollIntervalSec + 's');
+    poll();
+    pollTimer = setInterval(poll, config.pollIntervalSec * 1000);
+  }
+
+  function stopPolling() {
+    if (!pollTimer) return;
+    clearInterval(pollTimer);
+    pollTimer = null;
+  }
+
+  // pollJSON fetches path and returns its body, or null when it hasn't
+  // changed since the last poll.
+  function pollJSON(path) {
+    var headers = etags[path] ? { 'If-None-Match': etags[path] } : {};
+    return fetch(api(path), { headers: headers, cache: 'no-store' }).then(checkAuth).then(function(r) {
+      if (!r || r.status === 304 || !r.ok) return null;
+      etags[path] = r.headers.get('ETag');
+      return r.json();
+    });
+  }
+
+  function poll() {
+    Promise.all([pollJSON('/ports'), pollJSON('/mappings')]).then(function(res) {
+      if (!res[0] && !res[1]) return;
+      if (res[0]) state.ports = res[0];
+      if (res[1]) state.mappings = res[1];
+      render();
+      refreshStats();
+    }).catch(function() {});
+  }
+
+  // refreshStats updates the summary line under the title. Updates can come
+  // in bursts, so it fetches at most once a second.
+  var statsPending = false;
+  function refreshStats() {
+    if (statsPending) return;
+    statsPending = true;
+    setTimeout(function() {
+      fetch(api('/stats')).then(function(r) { return r.ok ? r.json() : null; }).then(function(st) {
+        if (!st) return;
+        var parts = [
+          st.ports.total + ' ports',
+          st.ports.healthy + ' healthy',
+          st.mappings.total + ' mappings',
+          st.scanRanges.ports + ' ports scanned'
+        ];
+        if (st.lastScan) parts.push('last scan ' + st.lastScanMs + 'ms');
+        document.getElementById('stats-summary').textContent = parts.join(' · ');
+      }).catch(function() {}).finally(function() {
+        statsPending = false;
+      });
+    }, 1000);
+  }
+
   function checkAuth(r) {
     if (r.status === 401) {
-      window.location.href = '/login';
+      window.location.href = config.basePath + '/login';
       return null;
     }
     return r;
   }
 
-  fetch('/api/version').then(checkAuth).then(function(r) { return r && r.json(); }).then(function(d) {
-    if (!d) return;
+  // applyConfig reflects the server's runtime settings in the page.
+  function applyConfig() {
     var el = document.getElementById('version-tag');
-    if (el && d.version) el.textContent = d.version;
-  }).catch(function() {});
+    if (el && config.version) el.textContent = config.version;
+    if (config.readOnly) {
+      document.body.classList.add('read-only');
+      var input = document.getElementById('domain-suffix');
+      if (input) input.readOnly = true;
+    }
+  }
 
   function render() {
     renderPortFilters();
@@ -62,13 +179,50 @@
     renderSuffix();
   }
 
+  // mappedPorts returns the local ports some mapping targets. Mappings with
+  // their own target host point at another machine and don't count.
+  function mappedPorts() {
+    return new Set(state.mappings.filter(function(m) { return !m.targetHost; })
+      .map(function(m) { return m.targetPort; }));
+  }
+
+  // mappingTarget formats a mapping's backend like the CLI: ":3000", or
+  // host and port with IPv6 literals bracketed.
+  function mappingTarget(m) {
+    if (!m.targetHost) return ':' + m.targetPort;
+    var host = m.targetHost.indexOf(':') !== -1 ? '[' + m.targetHost + ']' : m.targetHost;
+    return host + ':' + m.targetPort;
+  }
+
+  // healthLabel explains a port's dot, e.g. "http-error 502".
+  function healthLabel(p) {
+    if (p.consecutiveFailures) {
+      var state = p.healthReason === 'bad-status' ? 'unhealthy'
+        : p.healthReason === 'health-check' ? 'health check failing' : 'unreachable';
+      var label = state + ' for ' + p.consecutiveFailures + (p.consecutiveFailures === 1 ? ' check' : ' checks');
+      return p.lastError ? label + ': ' + p.lastError : label;
+    }
+    if (!p.healthReason) return p.healthy ? 'ok' : 'tcp-closed';
+    var withStatus = p.healthReason === 'http-error' || p.healthReason === 'bad-status';
+    return withStatus ? p.healthReason + ' ' + p.probeStatus : p.healthReason;
+  }
+
+  // portDotClass is offline when the port is closed, failing when it is open
+  // but its health check isn't passing, and degraded when it is open but
+  // erroring or hung.
+  function portDotClass(p) {
+    if (p.healthReason === 'health-check') return 'failing';
+    if (!p.healthy) return 'offline';
+    return p.healthReason === 'http-error' || p.healthReason === 'probe-timeout' ? 'degraded' : 'online';
+  }
+
   function renderPortFilters() {
     var el = document.getElementById('port-filters');
     if (!el) return;
-    var mappedSet = new Set(state.mappings.map(function(m) { return m.targetPort; }));
+    var mappedSet = mappedPorts();
     var counts = { http: 0, tcp: 0, mapped: 0, unmapped: 0 };
     state.ports.forEach(function(p) {
-      if (p.serviceName === 'http') counts.http++;
+      if (isHttpService(p)) counts.http++;
       else counts.tcp++;
       if (mappedSet.has(p.port)) counts.mapped++;
       else counts.unmapped++;
@@ -96,13 +250,31 @@
     renderPorts();
   };
 
+  function isHttpService(p) {
+    return /^https?( \(auth\))?$/.test(p.serviceName || '');
+  }
+
+  // tlsDetails renders the certificate summary line for HTTPS ports.
+  function tlsDetails(p) {
+    if (!p.tlsExpiry) return '';
+    var expiry = new Date(p.tlsExpiry);
+    var expired = expiry < new Date();
+    var parts = ['CN=' + (p.tlsSubject || '?')];
+    if (p.tlsSans && p.tlsSans.length) parts.push('SANs: ' + p.tlsSans.join(', '));
+    if (p.tlsIssuer) parts.push('issuer: ' + p.tlsIssuer);
+    parts.push((expired ? 'expired ' : 'expires ') + expiry.toISOString().slice(0, 10));
+    var text = parts.join(' · ');
+    return '<div class="exe-path tls-cert' + (expired ? ' expired' : '') + '" title="' + escapeHtml(text) + '">' +
+      escapeHtml(text) + '</div>';
+  }
+
   function renderPorts() {
     var el = document.getElementById('ports');
-    var mappedSet = new Set(state.mappings.map(function(m) { return m.targetPort; }));
+    var mappedSet = mappedPorts();
     var filtered = state.ports.filter(function(p) {
       var isMapped = mappedSet.has(p.port);
       var mappingOk = (isMapped && filters.mapped) || (!isMapped && filters.unmapped);
-      var isHttp = p.serviceName === 'http';
+      var isHttp = isHttpService(p);
       var typeOk = (isHttp && filters.http) || (!isHttp && filters.tcp);
       return mappingOk && typeOk;
     });
@@ -112,27 +284,45 @@
     }
 
     el.innerHTML = filtered.map(function(p) {
-      var isMapped = mappedSet.has(p.port);
-      var detail = [p.serviceName, p.title].filter(Boolean).join(' — ');
+      // Mappings proxy HTTP over TCP, so UDP ports can't be mapped
+      var isUDP = p.protocol === 'udp';
+      var isMapped = !isUDP && mappedSet.has(p.port);
+      // Fall back to the process name for ports that served no page title
+      var detail = [p.serviceName, p.title || p.processName].filter(Boolean).join(' — ');
       var sourceBadge = p.source === 'manual'
         ? '<span class="source-badge manual">manual</span>'
         : '<span class="source-badge scan">scan</span>';
       var mappedBadge = isMapped
         ? '<span class="source-badge mapped">mapped</span>'
         : '';
+      var staleBadge = p.stale
+        ? '<span class="source-badge stale" title="Not seen by the latest scan; last seen ' + escapeHtml(new Date(p.lastSeen).toLocaleString()) + '">stale</span>'
+        : '';
       var exePathHtml = p.exePath
         ? '<div class="exe-path" title="' + escapeHtml(p.exePath) + '">' + escapeHtml(p.exePath) + '</div>'
         : '';
-      return '<div class="port-item">' +
+      var noteHtml = p.note
+        ? '<div class="port-note">' + escapeHtml(p.note) + '</div>'
+        : '';
+      var tlsHtml = tlsDetails(p);
+      var authHint = 'Requires ' + p.authScheme + ' authentication' + (p.authRealm ? ' (' + p.authRealm + ')' : '');
+      var authLock = p.authScheme
+        ? '<span class="auth-lock" title="' + escapeHtml(authHint) + '">&#128274;</span>'
+        : '';
+      return '<div class="port-item' + (p.stale ? ' stale' : '') + '">' +
         '<div class="port-info">' +
-          '<span class="status-dot ' + (p.healthy ? 'online' : 'offline') + '"></span>' +
-          '<span class="port-number">:' + p.port + '</span>' +
+          '<span class="status-dot ' + portDotClass(p) + '" title="' + escapeHtml(healthLabel(p)) + '"></span>' +
+          '<span class="port-number">:' + p.port + '<span class="port-protocol">/' + (p.protocol || 'tcp') + '</span></span>' +
           sourceBadge +
           mappedBadge +
+          staleBadge +
+          authLock +
           '<span class="port-detail">' + escapeHtml(detail) + '</span>' +
         '</div>' +
         exePathHtml +
-        (!isMapped
+        noteHtml +
+        tlsHtml +
+        (!isMapped && !isUDP
           ? '<button class="btn btn-primary btn-sm" onclick="openMapModal(' + p.port + ')">Map</button>'
           : ''
         ) +
@@ -144,29 +334,93 @@
     }).join('');
   }
 
+  // renderGroupFilter shows a group selector once any mapping has a group.
+  function renderGroupFilter() {
+    var el = document.getElementById('group-filter');
+    if (!el) return;
+    var groups = [];
+    state.mappings.forEach(function(m) {
+      if (m.group && groups.indexOf(m.group) === -1) groups.push(m.group);
+    });
+    groups.sort();
+    if (groupFilter && groups.indexOf(groupFilter) === -1) groupFilter = '';
+    if (!groups.length) {
+      el.innerHTML = '';
+      return;
+    }
+    el.innerHTML = '<select onchange="setGroupFilter(this.value)">' +
+      '<option value="">All groups</option>' +
+      groups.map(function(g) {
+        return '<option value="' + escapeHtml(g) + '"' + (g === groupFilter ? ' selected' : '') + '>' + escapeHtml(g) + '</option>';
+      }).join('') +
+    '</select>';
+  }
+
+  window.setGroupFilter = function(group) {
+    groupFilter = group;
+    try { localStorage.setItem('portgate-group', group); } catch(e) {}
+    renderMappings();
+  };
+
   function renderMappings() {
     const el = document.getElementById('mappings');
+    renderGroupFilter();
     if (!state.mappings.length) {
       el.innerHTML = '<div class="empty">No domain mappings configured</div>';
       return;
     }
+    var visible = state.mappings.filter(function(m) {
+      return !groupFilter || m.group === groupFilter;
+    });
+    if (!visible.length) {
+      el.innerHTML = '<div class="empty">No mappings in this group</div>';
+      return;
+    }
 
-    el.innerHTML = state.mappings.map(function(m) {
-      const port = state.ports.find(function(p) { return p.port === m.targetPort; });
+    el.innerHTML = visible.map(function(m) {
+      const port = !m.targetHost && state.ports.find(function(p) { return p.port === m.targetPort; });
       const online = port && port.healthy;
       const systemBadge = m.system
         ? '<span class="source-badge system">system</span>'
         : '';
+      const groupBadge = m.group
+        ? '<span class="source-badge group">' + escapeHtml(m.group) + '</span>'
+        : '';
+      const maintenanceBadge = m.maintenance
+        ? '<span class="source-badge maintenance">maintenance</span>'
+        : '';
+      const modeBadge = m.mode
+        ? '<span class="source-badge group">' + escapeHtml(m.mode) + '</span>'
+        : '';
+      const h2Badge = m.backendHTTP2
+        ? '<span class="source-badge group" title="HTTP/2 (h2c) to the backend">h2</span>'
+        : '';
+      const result = testResults[m.domain];
+      const testBadge = result
+        ? '<span class="source-badge ' + (result.ok ? 'test-ok' : 'test-fail') + '" title="' + escapeHtml(result.error || result.target) + '">' +
+            (result.ok ? '&#10003; ' + result.status + ' &middot; ' + result.latencyMs + 'ms' : '&#10007; ' + escapeHtml(result.error ? result.error.split(':')[0] : String(result.status))) +
+          '</span>'
+        : '';
       return '<div class="mapping-item">' +
         '<div class="mapping-info">' +
           '<span class="status-dot ' + (online ? 'online' : 'offline') + '"></span>' +
-          '<a class="mapping-domain" href="http://' + escapeHtml(m.domain) + '.' + escapeHtml(state.domainSuffix) + '" target="_blank">' + escapeHtml(m.domain) + '.' + escapeHtml(state.domainSuffix) + '</a>' +
+          (m.url
+            ? '<a class="mapping-domain" href="' + escapeHtml(m.url) + '" target="_blank">' + escapeHtml(m.domain) + '.' + escapeHtml(state.domainSuffix) + '</a>'
+            : '<span class="mapping-domain">' + escapeHtml(m.domain) + '.' + escapeHtml(state.domainSuffix) + '</span>') +
           systemBadge +
-          '<span class="mapping-target">→ :' + m.targetPort + '</span>' +
+          groupBadge +
+          maintenanceBadge +
+          modeBadge +
+          h2Badge +
+          testBadge +
+          '<span class="mapping-target">→ ' + escapeHtml(mappingTarget(m)) + '</span>' +
         '</div>' +
+        '<button class="btn btn-sm" onclick="testMapping(\'' + escapeHtml(m.domain) + '\', this)">Test</button>' +
         (m.system
           ? ''
-          : '<button class="btn btn-danger" onclick="removeMapping(\'' + escapeHtml(m.domain) + '\')">Remove</button>'
+          : '<button class="btn btn-sm" onclick="setMaintenance(\'' + escapeHtml(m.domain) + '\', ' + !m.maintenance + ')">' +
+              (m.maintenance ? 'End Maintenance' : 'Maintenance') + '</button>' +
+            '<button class="btn btn-danger" onclick="removeMapping(\'' + escapeHtml(m.domain) + '\')">Remove</button>'
         ) +
       '</div>';
     }).join('');
@@ -181,8 +435,8 @@
 
     el.innerHTML = state.scanRanges.map(function(r) {
       return '<div class="range-item">' +
-        '<span class="range-label">' + r.start + ' – ' + r.end + '</span>' +
-        '<button class="btn btn-danger btn-sm" onclick="removeScanRange(' + r.start + ',' + r.end + ')">Remove</button>' +
+        '<span class="range-label">' + r.start + ' – ' + r.end + (r.protocol ? ' / ' + r.protocol : '') + '</span>' +
+        '<button class="btn btn-danger btn-sm" onclick="removeScanRange(' + r.start + ',' + r.end + ',\'' + (r.protocol || '') + '\')">Remove</button>' +
       '</div>';
     }).join('');
   }
@@ -225,7 +479,7 @@
       alert('Domain suffix cannot be empty');
       return;
     }
-    fetch('/api/domain-suffix', {
+    fetch(api('/domain-suffix'), {
       method: 'PUT',
       headers: { 'Content-Type': 'application/json' },
       body: JSON.stringify({ suffix: suffix })
@@ -237,28 +491,41 @@
   window.addScanRange = function() {
     var startEl = document.getElementById('add-range-start');
     var endEl = document.getElementById('add-range-end');
+    var protocol = document.getElementById('add-range-protocol').value;
     var start = parseInt(startEl.value, 10);
     var end = parseInt(endEl.value, 10);
     if (!start || !end || start < 1 || end > 65535 || start > end) {
       alert('Enter a valid range (1-65535, start <= end)');
       return;
     }
-    fetch('/api/scan-ranges', {
+    fetch(api('/scan-ranges'), {
       method: 'POST',
       headers: { 'Content-Type': 'application/json' },
-      body: JSON.stringify({ start: start, end: end })
+      body: JSON.stringify({ start: start, end: end, protocol: protocol })
     }).then(function(r) {
       if (r.ok) { startEl.value = ''; endEl.value = ''; }
       else r.text().then(function(t) { alert('Error: ' + t); });
     });
   };
 
-  window.removeScanRange = function(start, end) {
-    fetch('/api/scan-ranges?start=' + start + '&end=' + end, {
+  window.removeScanRange = function(start, end, protocol) {
+    fetch(api('/scan-ranges?start=') + start + '&end=' + end + '&protocol=' + protocol, {
       method: 'DELETE'
     });
   };
 
+  // recheckPorts refreshes health of the known ports; the result arrives
+  // over the WebSocket like any other update.
+  window.recheckPorts = function() {
+    var btn = document.getElementById('recheck-btn');
+    btn.disabled = true;
+    fetch(api('/ports/recheck'), { method: 'POST' }).then(function(r) {
+      if (!r.ok) r.text().then(function(t) { alert('Error: ' + t); });
+    }).finally(function() {
+      btn.disabled = false;
+    });
+  };
+
   window.addPort = function() {
     var portEl = document.getElementById('add-port-number');
     var nameEl = document.getElementById('add-port-name');
@@ -268,7 +535,7 @@
       alert('Enter a valid port number (1-65535)');
       return;
     }
-    fetch('/api/ports', {
+    fetch(api('/ports'), {
       method: 'POST',
       headers: { 'Content-Type': 'application/json' },
       body: JSON.stringify({ port: port, name: nameEl.value.trim(), path: pathEl.value.trim() })
@@ -292,6 +559,9 @@
           '<input type="text" id="map-modal-input" placeholder="subdomain" autofocus>' +
           '<span class="suffix-label">.' + escapeHtml(state.domainSuffix) + '</span>' +
         '</div>' +
+        '<div class="modal-input-row">' +
+          '<input type="text" id="map-modal-group" placeholder="group (optional)" value="' + escapeHtml(groupFilter) + '">' +
+        '</div>' +
         '<div class="modal-actions">' +
           '<button class="btn" onclick="closeMapModal()">Cancel</button>' +
           '<button class="btn btn-primary" onclick="submitMapModal(' + port + ')">Map</button>' +
@@ -320,11 +590,12 @@
     var input = document.getElementById('map-modal-input');
     var domain = input.value.trim().toLowerCase();
     if (!domain) return;
+    var group = document.getElementById('map-modal-group').value.trim().toLowerCase();
 
-    fetch('/api/mappings', {
+    fetch(api('/mappings'), {
       method: 'POST',
       headers: { 'Content-Type': 'application/json' },
-      body: JSON.stringify({ domain: domain, port: port })
+      body: JSON.stringify({ domain: domain, port: port, group: group })
     }).then(function(r) {
       if (!r.ok) r.text().then(function(t) { alert('Error: ' + t); });
       else closeMapModal();
@@ -332,14 +603,46 @@
   };
 
   window.removeMapping = function(domain) {
-    fetch('/api/mappings?domain=' + encodeURIComponent(domain), {
+    fetch(api('/mappings?domain=') + encodeURIComponent(domain), {
       method: 'DELETE'
     });
   };
 
+  // testMapping sends a request through the mapping and shows the outcome
+  // next to it.
+  window.testMapping = function(domain, btn) {
+    btn.disabled = true;
+    fetch(api('/mappings/') + encodeURIComponent(domain) + '/test', { method: 'POST' }).then(function(r) {
+      if (!r.ok) return r.text().then(function(t) { alert('Error: ' + t); });
+      return r.json().then(function(result) {
+        testResults[domain] = result;
+        renderMappings();
+      });
+    }).finally(function() {
+      btn.disabled = false;
+    });
+  };
+
+  window.setMaintenance = function(domain, enabled) {
+    fetch(api('/maintenance'), {
+      method: 'PUT',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ domain: domain, enabled: enabled })
+    }).then(function(r) {
+      if (!r.ok) r.text().then(function(t) { alert('Error: ' + t); });
+    });
+  };
+
   window.removePort = function(port) {
-    fetch('/api/ports?port=' + port, {
+    fetch(api('/ports?port=') + port, {
       method: 'DELETE'
+    }).then(function(r) {
+      if (r.status !== 200) return;
+      r.json().then(function(res) {
+        if (res.warnings && confirm(res.warnings.join('\n') + '\n\nRemove those mappings too?')) {
+          fetch(api('/ports?port=') + port + '&removeMappings=1', { method: 'DELETE' });
+        }
+      });
     });
   };
 
@@ -348,5 +651,14 @@
     return str.replace(/&/g,'&amp;').replace(/</g,'&lt;').replace(/>/g,'&gt;').replace(/"/g,'&quot;');
   }
 
-  connect();
+  // Load runtime config before connecting so paths come from the server
+  fetch('api/config').then(checkAuth).then(function(r) { return r && r.json(); }).then(function(d) {
+    if (!d) return false;
+    for (var k in d) config[k] = d[k];
+    return true;
+  }).catch(function() { return true; }).then(function(ok) {
+    if (!ok) return;
+    applyConfig();
+    connect();
+  });
 })();
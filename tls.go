@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Self-signed certificates are valid for selfSignedValidity and replaced once
+// less than selfSignedRenewBefore remains.
+const (
+	selfSignedValidity    = 825 * 24 * time.Hour
+	selfSignedRenewBefore = 30 * 24 * time.Hour
+)
+
+// certPaths returns where the certificate and key for suffix are cached.
+func certPaths(dir, suffix string) (certFile, keyFile string) {
+	base := filepath.Join(dir, "tls", suffix)
+	return base + ".crt", base + ".key"
+}
+
+// ensureSelfSignedCert returns the cached self-signed certificate for
+// *.suffix under dir, generating and saving a new one if none exists, it is
+// about to expire, or it doesn't cover the suffix. The certificate names both
+// the wildcard and the bare suffix, so every mapped subdomain and the suffix
+// itself validate once it is trusted.
+func ensureSelfSignedCert(dir, suffix string) (tls.Certificate, error) {
+	certFile, keyFile := certPaths(dir, suffix)
+	if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil && certUsable(cert.Leaf, suffix) {
+		return cert, nil
+	}
+
+	certPEM, keyPEM, err := generateSelfSignedCert(suffix, time.Now())
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(certFile), 0700); err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		return tls.Certificate{}, err
+	}
+	log.Printf("tls: generated a self-signed certificate for *.%s at %s; trust it once to avoid browser warnings", suffix, certFile)
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// certUsable reports whether a cached certificate still serves suffix.
+func certUsable(leaf *x509.Certificate, suffix string) bool {
+	return leaf != nil &&
+		time.Until(leaf.NotAfter) > selfSignedRenewBefore &&
+		slices.Contains(leaf.DNSNames, "*."+suffix) &&
+		slices.Contains(leaf.DNSNames, suffix)
+}
+
+// generateSelfSignedCert creates a PEM-encoded ECDSA certificate and key for
+// *.suffix and suffix, valid from now.
+func generateSelfSignedCert(suffix string, now time.Time) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "*." + suffix, Organization: []string{"Portgate"}},
+		DNSNames:              []string{"*." + suffix, suffix},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// selfSignedCerts serves the certificate for the current domain suffix,
+// so changing the suffix at runtime switches to a matching certificate.
+type selfSignedCerts struct {
+	dir    string
+	config *ConfigStore
+
+	mu    sync.Mutex
+	certs map[string]*tls.Certificate
+}
+
+func newSelfSignedCerts(dir string, config *ConfigStore) *selfSignedCerts {
+	return &selfSignedCerts{dir: dir, config: config, certs: make(map[string]*tls.Certificate)}
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (c *selfSignedCerts) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	suffix := c.config.DomainSuffix()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cert := c.certs[suffix]; cert != nil && certUsable(cert.Leaf, suffix) {
+		return cert, nil
+	}
+	cert, err := ensureSelfSignedCert(c.dir, suffix)
+	if err != nil {
+		return nil, fmt.Errorf("certificate for %s: %w", suffix, err)
+	}
+	c.certs[suffix] = &cert
+	return &cert, nil
+}
+
+// httpsRedirect sends plain HTTP requests to the same URL over HTTPS on
+// tlsPort.
+func httpsRedirect(tlsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host == "" {
+			http.Error(w, "missing Host header", http.StatusBadRequest)
+			return
+		}
+		if tlsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(tlsPort))
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestEnsureSelfSignedCert(t *testing.T) {
+	dir := t.TempDir()
+	cert, err := ensureSelfSignedCert(dir, "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"*.localhost", "localhost"}; !slices.Equal(cert.Leaf.DNSNames, want) {
+		t.Errorf("SANs = %v, want %v", cert.Leaf.DNSNames, want)
+	}
+	if err := cert.Leaf.VerifyHostname("app.localhost"); err != nil {
+		t.Errorf("subdomain: %v", err)
+	}
+
+	// The cached certificate is reused
+	again, err := ensureSelfSignedCert(dir, "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Leaf.SerialNumber.Cmp(cert.Leaf.SerialNumber) != 0 {
+		t.Error("certificate was regenerated instead of loaded from the cache")
+	}
+
+	// A different suffix gets its own certificate
+	other, err := ensureSelfSignedCert(dir, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Leaf.VerifyHostname("app.test"); err != nil {
+		t.Errorf("new suffix: %v", err)
+	}
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	tests := []struct {
+		port int
+		host string
+		path string
+		want string
+	}{
+		{443, "app.localhost", "/a?b=1", "https://app.localhost/a?b=1"},
+		{443, "app.localhost:80", "/", "https://app.localhost/"},
+		{8443, "app.localhost:8080", "/x", "https://app.localhost:8443/x"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Host = tt.host
+		rec := httptest.NewRecorder()
+		httpsRedirect(tt.port).ServeHTTP(rec, req)
+		if rec.Code != http.StatusPermanentRedirect || rec.Header().Get("Location") != tt.want {
+			t.Errorf("%s%s: %d %q, want 308 %q", tt.host, tt.path, rec.Code, rec.Header().Get("Location"), tt.want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -15,55 +16,171 @@ type DiscoveredPort struct {
 	Title       string    `json:"title"`
 	Healthy     bool      `json:"healthy"`
 	LastSeen    time.Time `json:"lastSeen"`
-	Source      string    `json:"source"`  // "scan" or "manual"
-	ExePath     string    `json:"exePath"` // filesystem path of the listening process
+	Source      string    `json:"source"`                // "scan" or "manual"
+	ExePath     string    `json:"exePath"`               // filesystem path of the listening process
+	ProcessName string    `json:"processName,omitempty"` // short name of the listening process, e.g. "node"
+	ListenAddrs []string  `json:"listenAddrs,omitempty"` // bound addresses, the one the proxy reaches first
+	Note        string    `json:"note,omitempty"`        // user annotation from config portNotes
+	Stale       bool      `json:"stale,omitempty"`       // not seen by the latest scan: restored at startup, or lingering after it stopped
+
+	// Why Healthy is what it is: ok, tcp-only, probe-timeout, http-error or
+	// tcp-closed
+	HealthReason string `json:"healthReason,omitempty"`
+
+	// Manual ports only: how many checks in a row have found the port
+	// closed, and why the last one failed
+	ConsecutiveFailures int    `json:"consecutiveFailures,omitempty"`
+	LastError           string `json:"lastError,omitempty"`
+
+	// The probe request that identified the service
+	ProbePath   string `json:"probePath,omitempty"`
+	ProbeStatus int    `json:"probeStatus,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+
+	// HTTP authentication the service challenged the probe with (401)
+	AuthScheme string `json:"authScheme,omitempty"` // e.g. "Basic", "Digest", "Bearer"
+	AuthRealm  string `json:"authRealm,omitempty"`
+
+	// Peer certificate details, set when the port serves HTTPS
+	TLSSubject string     `json:"tlsSubject,omitempty"`
+	TLSIssuer  string     `json:"tlsIssuer,omitempty"`
+	TLSSANs    []string   `json:"tlsSans,omitempty"`
+	TLSExpiry  *time.Time `json:"tlsExpiry,omitempty"`
 }
 
 // ManualPort is a user-registered port persisted in config.
 type ManualPort struct {
-	Port int    `json:"port"`
-	Name string `json:"name,omitempty"`
-	Path string `json:"path,omitempty"` // optional user-specified install path
+	Port        int      `json:"port"`
+	Name        string   `json:"name,omitempty"`
+	Path        string   `json:"path,omitempty"`        // optional user-specified install path
+	ProbeHost   string   `json:"probeHost,omitempty"`   // Host header sent when probing, for vhost-gated services
+	ProbePaths  []string `json:"probePaths,omitempty"`  // overrides the global probe paths
+	ProbeAccept string   `json:"probeAccept,omitempty"` // overrides the global probe Accept header
+	HealthPath  string   `json:"healthPath,omitempty"`  // healthy only while this path answers 2xx/3xx
 }
 
 // ScanRange defines a range of ports to scan.
 type ScanRange struct {
-	Start int `json:"start"`
-	End   int `json:"end"`
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+	Protocol string `json:"protocol,omitempty"` // tcp (default), udp or both
 }
 
 // DomainMapping maps a subdomain to a target port.
 type DomainMapping struct {
-	Domain     string    `json:"domain"`
-	TargetPort int       `json:"targetPort"`
-	CreatedAt  time.Time `json:"createdAt"`
-	System     bool      `json:"system,omitempty"`
+	Domain                  string        `json:"domain"`
+	TargetPort              int           `json:"targetPort"`
+	TargetHost              string        `json:"targetHost,omitempty"` // overrides proxyBackendHost; IP literal or hostname
+	CreatedAt               time.Time     `json:"createdAt"`
+	System                  bool          `json:"system,omitempty"`
+	Maintenance             bool          `json:"maintenance,omitempty"`             // serve a 503 maintenance page instead of proxying
+	ResponseRewrite         []RewriteRule `json:"responseRewrite,omitempty"`         // text replacements applied to textual response bodies
+	StartupGracePeriodSec   int           `json:"startupGracePeriodSec,omitempty"`   // wait for a booting backend instead of 502ing
+	Group                   string        `json:"group,omitempty"`                   // free-form project label, lowercase; empty = ungrouped
+	WebSocketIdleTimeoutSec *int          `json:"webSocketIdleTimeoutSec,omitempty"` // overrides the global WebSocket idle timeout; 0 = none
+	Mode                    string        `json:"mode,omitempty"`                    // both (default), http-only or ws-only
+	BackendHTTP2            bool          `json:"backendHTTP2,omitempty"`            // speak HTTP/2 cleartext (h2c) to the backend
+	StripPrefix             string        `json:"stripPrefix,omitempty"`             // path prefix removed before proxying, e.g. /v1
+}
+
+// RewriteRule replaces every occurrence of From with To in a response body.
+type RewriteRule struct {
+	From string `json:"from"`
+	To   string `json:"to"`
 }
 
 // Config is the persisted configuration.
 type Config struct {
-	Mappings              []DomainMapping `json:"mappings"`
-	ScanIntervalSec       int             `json:"scanIntervalSec"`
-	ScanRanges            []ScanRange     `json:"scanRanges,omitempty"`
-	ManualPorts           []ManualPort    `json:"manualPorts,omitempty"`
-	DomainSuffix          string          `json:"domainSuffix,omitempty"`
-	ExternalAccess        bool            `json:"externalAccess,omitempty"`
-	MasterPasswordHash    string          `json:"masterPasswordHash,omitempty"`
-	SessionExpirySec      int             `json:"sessionExpirySec,omitempty"`
-	BypassAuthForLocalhost bool           `json:"bypassAuthForLocalhost,omitempty"`
+	ConfigVersion            int                    `json:"configVersion"` // schema version, see configMigrations
+	Mappings                 []DomainMapping        `json:"mappings"`
+	ScanIntervalSec          int                    `json:"scanIntervalSec"`
+	ScanRanges               []ScanRange            `json:"scanRanges,omitempty"`
+	ManualPorts              []ManualPort           `json:"manualPorts,omitempty"`
+	PortNotes                map[int]string         `json:"portNotes,omitempty"` // freeform notes keyed by port number
+	DomainSuffix             string                 `json:"domainSuffix,omitempty"`
+	ExternalAccess           bool                   `json:"externalAccess,omitempty"`
+	MasterPasswordHash       string                 `json:"masterPasswordHash,omitempty"`
+	SessionExpirySec         int                    `json:"sessionExpirySec,omitempty"`
+	BypassAuthForLocalhost   bool                   `json:"bypassAuthForLocalhost,omitempty"`
+	APIToken                 string                 `json:"apiToken,omitempty"` // bearer token required on every dashboard and API request (default none)
+	MaintenanceRetryAfterSec int                    `json:"maintenanceRetryAfterSec,omitempty"`
+	ExcludeProcesses         []string               `json:"excludeProcesses,omitempty"`        // exe basename globs hidden from discovery
+	ResolveExe               *bool                  `json:"resolveExe,omitempty"`              // look up the owning process of each port (default true)
+	ReadOnly                 bool                   `json:"readOnly,omitempty"`                // reject mutating API requests
+	TrustedProxies           []string               `json:"trustedProxies,omitempty"`          // CIDRs whose X-Forwarded-For is honored
+	AllowedOrigins           []string               `json:"allowedOrigins,omitempty"`          // extra origins or hostnames allowed to open the dashboard WebSocket
+	MaxConnsPerIP            int                    `json:"maxConnsPerIP,omitempty"`           // concurrent proxied connections per client IP (0 = unlimited)
+	ProxyBackendHost         string                 `json:"proxyBackendHost,omitempty"`        // host mapping backends are reached on (default 127.0.0.1)
+	ProxyDialNetwork         string                 `json:"proxyDialNetwork,omitempty"`        // tcp, tcp4 or tcp6 (default tcp)
+	BackendMaxHeaderBytes    int                    `json:"backendMaxHeaderBytes,omitempty"`   // largest response header block accepted from a backend (default 1 MiB)
+	BackendHeaderTimeoutSec  int                    `json:"backendHeaderTimeoutSec,omitempty"` // wait for a backend's response headers before 504 (default 60)
+	WebSocketIdleTimeoutSec  *int                   `json:"webSocketIdleTimeoutSec,omitempty"` // close proxied WebSockets idle this long (default 300, 0 = never)
+	DeferInitialScan         bool                   `json:"deferInitialScan,omitempty"`        // skip the scan at startup; the first scan runs after one interval
+	ScanMode                 string                 `json:"scanMode,omitempty"`                // dial (connect to every port) or kernel (read listening sockets); default dial
+	UpdateRepo               string                 `json:"updateRepo,omitempty"`              // owner/name of the GitHub repo releases come from
+	UpdateAPIBase            string                 `json:"updateApiBase,omitempty"`           // GitHub API root, for GitHub Enterprise (default https://api.github.com)
+	AccessLogSampleRate      int                    `json:"accessLogSampleRate,omitempty"`     // log 1 in N successful requests (0 or 1 = all)
+	AccessLogSlowMs          int                    `json:"accessLogSlowMs,omitempty"`         // always log requests at least this slow when sampling (default 1000)
+	ProbePaths               []string               `json:"probePaths,omitempty"`              // paths tried in order when identifying a service (default ["/"])
+	ProbeAccept              string                 `json:"probeAccept,omitempty"`             // Accept header sent when probing (default text/html)
+	NormalizeTrailingSlash   string                 `json:"normalizeTrailingSlash,omitempty"`  // add or remove: redirect mapped paths to one trailing-slash form (default off)
+	PollIntervalSec          int                    `json:"pollIntervalSec,omitempty"`         // dashboard polling interval when WebSockets are blocked (default 5)
+	IdentifyProxy            bool                   `json:"identifyProxy,omitempty"`           // add Via to proxied traffic and set Server: portgate on responses
+	ScanningEnabled          *bool                  `json:"scanningEnabled,omitempty"`         // scan the port ranges (default true); when false only manual ports are checked
+	MaxTitleLength           int                    `json:"maxTitleLength,omitempty"`          // probed titles longer than this are truncated (default 120)
+	ErrorPageTemplate        string                 `json:"errorPageTemplate,omitempty"`       // html/template file rendered when a backend is unreachable (default embedded page)
+	NotFoundPageTemplate     string                 `json:"notFoundPageTemplate,omitempty"`    // html/template file rendered for a subdomain with no mapping (default embedded page)
+	BasePath                 string                 `json:"basePath,omitempty"`                // path prefix the dashboard is reached under through an upstream proxy, e.g. /portgate
+	HealthIntervalSec        int                    `json:"healthIntervalSec,omitempty"`       // seconds between health checks of known ports between full scans (default 3)
+	Compression              bool                   `json:"compression,omitempty"`             // gzip proxied responses for clients that accept it
+	CompressionExcludeTypes  []string               `json:"compressionExcludeTypes,omitempty"` // content-type globs never compressed (default: images, video, archives, ...)
+	DashboardMaxHeaderBytes  int                    `json:"dashboardMaxHeaderBytes,omitempty"` // largest request header block the dashboard accepts (default 64 KiB)
+	HealthyStatusCodes       []string               `json:"healthyStatusCodes,omitempty"`      // probe statuses that count as healthy, e.g. ["2xx", "301"] (default: any open port)
+	ScanJitterMs             int                    `json:"scanJitterMs,omitempty"`            // random delay of up to this long before the first scan (default 0)
+	UpdateCheckJitterSec     *int                   `json:"updateCheckJitterSec,omitempty"`    // random delay of up to this long before the startup update check (default 30, 0 = none)
+	ScanConcurrency          int                    `json:"scanConcurrency,omitempty"`         // ports checked at once during a scan (default 128, max 1024)
+	PortGraceSec             int                    `json:"portGraceSec,omitempty"`            // keep ports a scan no longer finds listed as stale for this long (default 0)
+	ProxyDialTimeoutMs       int                    `json:"proxyDialTimeoutMs,omitempty"`      // give up on one backend connection attempt after this long (default 5000)
+	ProxyRetries             int                    `json:"proxyRetries,omitempty"`            // extra connection attempts when a backend refuses, for restarting dev servers (default 0, max 20)
+	ScanProfiles             map[string][]ScanRange `json:"scanProfiles,omitempty"`            // named range sets to switch between
+	ActiveScanProfile        string                 `json:"activeScanProfile,omitempty"`       // profile scanned instead of scanRanges; "" = none
 }
 
 // PortRequest is the POST body for registering a manual port.
 type PortRequest struct {
-	Port int    `json:"port"`
-	Name string `json:"name,omitempty"`
-	Path string `json:"path,omitempty"`
+	Port        int      `json:"port"`
+	Name        string   `json:"name,omitempty"`
+	Path        string   `json:"path,omitempty"`
+	ProbeHost   string   `json:"probeHost,omitempty"`
+	ProbePaths  []string `json:"probePaths,omitempty"`
+	ProbeAccept string   `json:"probeAccept,omitempty"`
+	HealthPath  string   `json:"healthPath,omitempty"`
+}
+
+// PortRemoval reports what else a manual port's removal touched: the
+// mappings that still route to the port, or that were removed along with it.
+type PortRemoval struct {
+	Warnings        []string `json:"warnings,omitempty"`
+	RemovedMappings []string `json:"removedMappings,omitempty"`
+}
+
+// PortNoteRequest is the PUT body for annotating a port. An empty note
+// removes it.
+type PortNoteRequest struct {
+	Note string `json:"note"`
+}
+
+// ScanProfileRequest is the POST body for creating or replacing a scan profile.
+type ScanProfileRequest struct {
+	Name   string      `json:"name"`
+	Ranges []ScanRange `json:"ranges"`
 }
 
 // ScanRangeRequest is the POST body for adding/removing a scan range.
 type ScanRangeRequest struct {
-	Start int `json:"start"`
-	End   int `json:"end"`
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+	Protocol string `json:"protocol,omitempty"`
 }
 
 // Hub coordinates scanner, proxy, config, and WebSocket clients.
@@ -72,9 +189,24 @@ type Hub struct {
 	ports      []DiscoveredPort
 	config     *ConfigStore
 	clients    map[*WSClient]bool
+	nclients   atomic.Int64 // len(clients), readable outside Run
 	register   chan *WSClient
 	unregister chan *WSClient
 	broadcast  chan []byte
+	shutdown   chan []byte    // final message sent to every client before closing
+	writers    sync.WaitGroup // running writePumps, drained on shutdown; Add only through addWriter
+	closed     bool           // set by Shutdown under mu; no WebSocket clients are accepted after it
+	epoch      int64          // identifies this server instance; changes on restart
+
+	proxyScheme string // scheme and port clients use to reach the proxy, for mapping URLs
+	proxyPort   int
+
+	scanner  *Scanner      // for on-demand health rechecks; nil until SetScanner
+	reload   func() error  // re-reads the config file; nil until SetReloader
+	scanWait time.Duration // how long POST /api/scan waits for its scan; scanNowTimeout
+
+	connMu sync.Mutex
+	conns  map[string]int // active proxied connections per client IP
 }
 
 // WSClient represents a connected WebSocket client.
@@ -90,8 +222,33 @@ type WSMessage struct {
 	Data interface{} `json:"data"`
 }
 
+// MaintenanceRequest is the PUT body for toggling maintenance mode on a mapping.
+type MaintenanceRequest struct {
+	Domain  string `json:"domain"`
+	Enabled bool   `json:"enabled"`
+}
+
+// MappingTestResult reports a test request sent to a mapping's backend.
+type MappingTestResult struct {
+	Domain    string `json:"domain"`
+	Target    string `json:"target"` // host:port that was dialed
+	OK        bool   `json:"ok"`     // the backend answered with a status below 500
+	Status    int    `json:"status"` // backend status, or the 502/503/504 a client would get
+	LatencyMs int64  `json:"latencyMs"`
+	Error     string `json:"error,omitempty"`
+}
+
 // MappingRequest is the POST body for creating a mapping.
 type MappingRequest struct {
-	Domain string `json:"domain"`
-	Port   int    `json:"port"`
+	Domain                  string        `json:"domain"`
+	Port                    int           `json:"port"`
+	Target                  string        `json:"target,omitempty"` // "host:port", "[::1]:port" or a bare port; instead of port
+	Host                    string        `json:"host,omitempty"`   // backend host or IP for port; the same as a host in target
+	ResponseRewrite         []RewriteRule `json:"responseRewrite,omitempty"`
+	StartupGracePeriodSec   int           `json:"startupGracePeriodSec,omitempty"`
+	Group                   string        `json:"group,omitempty"`
+	WebSocketIdleTimeoutSec *int          `json:"webSocketIdleTimeoutSec,omitempty"`
+	Mode                    string        `json:"mode,omitempty"`
+	BackendHTTP2            bool          `json:"backendHTTP2,omitempty"`
+	StripPrefix             string        `json:"stripPrefix,omitempty"`
 }
@@ -2,18 +2,89 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"runtime/debug"
 	"strconv"
 	"strings"
+	"time"
 )
 
-const releaseURL = "https://api.github.com/repos/erkantaylan/portgate/releases/latest"
+// Release lookup defaults; forks and GitHub Enterprise override them with
+// updateRepo/updateApiBase in config or the environment variables below.
+const (
+	defaultUpdateRepo    = "erkantaylan/portgate"
+	defaultUpdateAPIBase = "https://api.github.com"
+	updateRepoEnvVar     = "PORTGATE_UPDATE_REPO"
+	updateAPIEnvVar      = "PORTGATE_UPDATE_API"
+)
+
+var updateRepoRe = regexp.MustCompile(`^[A-Za-z0-9_.-]+/[A-Za-z0-9_.-]+$`)
+
+// releaseSource is the GitHub (or GitHub Enterprise) repo releases come from.
+type releaseSource struct {
+	apiBase string // e.g. https://api.github.com or https://ghe.example.com/api/v3
+	repo    string // owner/name
+}
+
+// updateSource resolves where to look for releases: environment first, then
+// config, then the upstream repo on github.com. cs may be nil.
+func updateSource(cs *ConfigStore) (releaseSource, error) {
+	src := releaseSource{apiBase: defaultUpdateAPIBase, repo: defaultUpdateRepo}
+	if cs != nil {
+		repo, base := cs.UpdateSource()
+		if repo != "" {
+			src.repo = repo
+		}
+		if base != "" {
+			src.apiBase = base
+		}
+	}
+	if v := os.Getenv(updateRepoEnvVar); v != "" {
+		src.repo = v
+	}
+	if v := os.Getenv(updateAPIEnvVar); v != "" {
+		src.apiBase = v
+	}
+	if err := validateUpdateSource(src.repo, src.apiBase); err != nil {
+		return releaseSource{}, err
+	}
+	src.apiBase = strings.TrimSuffix(src.apiBase, "/")
+	return src, nil
+}
+
+// validateUpdateSource checks an owner/name repo and an http(s) API base URL.
+func validateUpdateSource(repo, apiBase string) error {
+	if repo != "" && !updateRepoRe.MatchString(repo) {
+		return fmt.Errorf("updateRepo %q must be owner/name", repo)
+	}
+	if apiBase != "" {
+		u, err := url.Parse(apiBase)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("updateApiBase %q must be an http(s) URL", apiBase)
+		}
+	}
+	return nil
+}
+
+// latestURL returns the API URL of the newest release.
+func (s releaseSource) latestURL() string {
+	return s.apiBase + "/repos/" + s.repo + "/releases/latest"
+}
+
+// tagURL returns the API URL of the release tagged tag.
+func (s releaseSource) tagURL(tag string) string {
+	return s.apiBase + "/repos/" + s.repo + "/releases/tags/" + url.PathEscape(tag)
+}
 
 type githubRelease struct {
 	TagName string        `json:"tag_name"`
@@ -80,14 +151,19 @@ func binaryAssetName() string {
 }
 
 // checkLatestRelease fetches the latest GitHub release info.
-func checkLatestRelease() (*githubRelease, error) {
+func checkLatestRelease(src releaseSource) (*githubRelease, error) {
+	return fetchRelease(src.latestURL())
+}
+
+// fetchRelease fetches the release info at a GitHub API release URL.
+func fetchRelease(releaseURL string) (*githubRelease, error) {
 	req, err := http.NewRequest("GET", releaseURL, nil)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Accept", "application/vnd.github+json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := releaseClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to check for updates: %w", err)
 	}
@@ -115,23 +191,93 @@ func (r *githubRelease) downloadURL() string {
 	return ""
 }
 
-func cmdVersion() {
+// BuildInfo describes the running binary.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"buildDate"`
+	GoVersion string `json:"goVersion"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+}
+
+// currentBuildInfo returns the build metadata, falling back to the VCS stamp
+// embedded by the Go toolchain when commit/buildDate weren't set via ldflags.
+func currentBuildInfo() BuildInfo {
+	bi := BuildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && bi.Commit == "":
+				bi.Commit = s.Value
+			case s.Key == "vcs.time" && bi.BuildDate == "":
+				bi.BuildDate = s.Value
+			}
+		}
+	}
+	return bi
+}
+
+func cmdVersion(args []string) {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print build info as JSON")
+	fs.Parse(args)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(currentBuildInfo())
+		return
+	}
 	fmt.Printf("portgate %s\n", version)
 }
 
-func cmdUpdate() {
-	fmt.Printf("Current version: %s\n", version)
-	fmt.Println("Checking for updates...")
+func cmdUpdate(args []string) {
+	fs := flag.NewFlagSet("update", flag.ExitOnError)
+	tag := fs.String("tag", "", "install this release tag instead of the latest")
+	force := fs.Bool("force", false, "with --tag, install the release even if it isn't newer than this one")
+	fs.Parse(args)
 
-	rel, err := checkLatestRelease()
+	var cs *ConfigStore
+	if c, err := NewConfigStore(""); err == nil {
+		cs = c
+	}
+	src, err := updateSource(cs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Current version: %s\n", version)
+	var rel *githubRelease
+	if *tag != "" {
+		fmt.Printf("Fetching release %s from %s...\n", *tag, src.repo)
+		rel, err = fetchRelease(src.tagURL(*tag))
+	} else {
+		fmt.Printf("Checking %s for updates...\n", src.repo)
+		rel, err = checkLatestRelease(src)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 
 	if !isNewer(version, rel.TagName) {
-		fmt.Printf("Already up to date (%s)\n", version)
-		return
+		switch {
+		case *tag == "":
+			fmt.Printf("Already up to date (%s)\n", version)
+			return
+		case !*force:
+			fmt.Fprintf(os.Stderr, "Release %s is not newer than %s; use --force to install it anyway\n", rel.TagName, version)
+			os.Exit(1)
+		}
 	}
 
 	dlURL := rel.downloadURL()
@@ -154,18 +300,6 @@ func cmdUpdate() {
 		os.Exit(1)
 	}
 
-	resp, err := http.Get(dlURL)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Download failed: %v\n", err)
-		os.Exit(1)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		fmt.Fprintf(os.Stderr, "Download failed: HTTP %d\n", resp.StatusCode)
-		os.Exit(1)
-	}
-
 	dir := filepath.Dir(exe)
 	tmp, err := os.CreateTemp(dir, "portgate-update-*")
 	if err != nil {
@@ -174,7 +308,7 @@ func cmdUpdate() {
 	}
 	tmpPath := tmp.Name()
 
-	if _, err := io.Copy(tmp, resp.Body); err != nil {
+	if err := downloadWithRetry(downloadClient, dlURL, tmp, downloadAttempts, downloadRetryBackoff); err != nil {
 		tmp.Close()
 		os.Remove(tmpPath)
 		fmt.Fprintf(os.Stderr, "Download failed: %v\n", err)
@@ -191,12 +325,119 @@ func cmdUpdate() {
 	fmt.Printf("Updated to %s\n", rel.TagName)
 }
 
+// Download retry policy: total attempts, and the wait before the first retry,
+// doubled after each failure.
+const (
+	downloadAttempts     = 5
+	downloadRetryBackoff = time.Second
+)
+
+// Clients for the release API and the download. Both are bounded so a stalled
+// connection can't hang the update; a download attempt that runs out of time
+// is resumed by the next one.
+var (
+	releaseClient  = &http.Client{Timeout: 15 * time.Second}
+	downloadClient = &http.Client{Timeout: 5 * time.Minute}
+)
+
+// downloadStatusError is an unexpected HTTP status from the download server.
+type downloadStatusError struct {
+	code int
+}
+
+func (e *downloadStatusError) Error() string {
+	return fmt.Sprintf("HTTP %d", e.code)
+}
+
+// retryable reports whether the status may succeed on a later attempt.
+func (e *downloadStatusError) retryable() bool {
+	return e.code >= 500 || e.code == http.StatusRequestTimeout || e.code == http.StatusTooManyRequests
+}
+
+// downloadWithRetry downloads url into f, retrying failed attempts with
+// exponential backoff. After an interrupted attempt it asks only for the
+// missing bytes with a Range header, falling back to a full download when the
+// server doesn't honor it. Client errors such as 404 are not retried.
+func downloadWithRetry(client *http.Client, url string, f *os.File, attempts int, backoff time.Duration) error {
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			fmt.Printf("Download interrupted (%v), retrying in %s...\n", err, backoff)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = downloadOnce(client, url, f); err == nil {
+			return nil
+		}
+		var se *downloadStatusError
+		if errors.As(err, &se) && !se.retryable() {
+			return err
+		}
+	}
+	return fmt.Errorf("%w (gave up after %d attempts)", err, attempts)
+}
+
+// downloadOnce makes one download attempt, resuming from the end of f.
+func downloadOnce(client *http.Client, url string, f *os.File) error {
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			// Resuming at the wrong place would corrupt the file; start over
+			if err := restartFile(f); err != nil {
+				return err
+			}
+			return fmt.Errorf("server resumed at an unexpected offset (%s)", resp.Header.Get("Content-Range"))
+		}
+	case resp.StatusCode == http.StatusOK:
+		// A full body, either the first attempt or a server without ranges
+		if err := restartFile(f); err != nil {
+			return err
+		}
+	default:
+		return &downloadStatusError{code: resp.StatusCode}
+	}
+	_, err = io.Copy(f, resp.Body)
+	return err
+}
+
+// restartFile empties f and rewinds it.
+func restartFile(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := f.Seek(0, io.SeekStart)
+	return err
+}
+
 // backgroundUpdateCheck logs if a newer version is available (non-blocking).
-func backgroundUpdateCheck() {
+func backgroundUpdateCheck(cs *ConfigStore) {
 	if version == "dev" {
 		return
 	}
-	rel, err := checkLatestRelease()
+	src, err := updateSource(cs)
+	if err != nil {
+		log.Printf("update check: %v", err)
+		return
+	}
+	time.Sleep(randomDelay(cs.UpdateCheckJitter()))
+	rel, err := checkLatestRelease(src)
 	if err != nil {
 		return
 	}
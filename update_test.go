@@ -1,6 +1,16 @@
 package main
 
-import "testing"
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
 
 func TestIsNewer(t *testing.T) {
 	tests := []struct {
@@ -45,3 +55,117 @@ func TestIsNewer(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdateSource(t *testing.T) {
+	tests := []struct {
+		name            string
+		repo, base      string // config
+		envRepo, envAPI string
+		latest          string
+		wantErr         bool
+	}{
+		{name: "default", latest: "https://api.github.com/repos/erkantaylan/portgate/releases/latest"},
+		{name: "fork", repo: "myorg/portgate", latest: "https://api.github.com/repos/myorg/portgate/releases/latest"},
+		{name: "enterprise", repo: "tools/portgate", base: "https://ghe.example.com/api/v3/", latest: "https://ghe.example.com/api/v3/repos/tools/portgate/releases/latest"},
+		{name: "env wins", repo: "myorg/portgate", envRepo: "other/pg", latest: "https://api.github.com/repos/other/pg/releases/latest"},
+		{name: "env api", envAPI: "http://mirror.local", latest: "http://mirror.local/repos/erkantaylan/portgate/releases/latest"},
+		{name: "bad repo", repo: "portgate", wantErr: true},
+		{name: "bad base", base: "ftp://example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(updateRepoEnvVar, tt.envRepo)
+			t.Setenv(updateAPIEnvVar, tt.envAPI)
+			cs := newTestConfigStore(t)
+			cs.cfg.UpdateRepo = tt.repo
+			cs.cfg.UpdateAPIBase = tt.base
+			src, err := updateSource(cs)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("updateSource = %+v, want error", src)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := src.latestURL(); got != tt.latest {
+				t.Errorf("latestURL = %q, want %q", got, tt.latest)
+			}
+		})
+	}
+
+	src := releaseSource{apiBase: defaultUpdateAPIBase, repo: defaultUpdateRepo}
+	if got, want := src.tagURL("v1.2.0"), "https://api.github.com/repos/erkantaylan/portgate/releases/tags/v1.2.0"; got != want {
+		t.Errorf("tagURL = %q, want %q", got, want)
+	}
+}
+
+func TestDownloadWithRetry(t *testing.T) {
+	body := bytes.Repeat([]byte("portgate-binary "), 4096)
+	half := len(body) / 2
+
+	tests := []struct {
+		name      string
+		ranges    bool // server honors Range
+		status    int  // fixed status instead of the body, if non-zero
+		wantErr   bool
+		wantCalls int32
+	}{
+		{"resume with range", true, 0, false, 2},
+		{"restart without range", false, 0, false, 2},
+		{"not found is not retried", true, http.StatusNotFound, true, 1},
+		{"server errors are retried", true, http.StatusBadGateway, true, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			var resumedFrom string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := calls.Add(1)
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+					return
+				}
+				if n == 1 {
+					// Promise the whole file, send half, then drop the connection
+					w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+					w.Write(body[:half])
+					w.(http.Flusher).Flush()
+					conn, _, _ := w.(http.Hijacker).Hijack()
+					conn.Close()
+					return
+				}
+				resumedFrom = r.Header.Get("Range")
+				if !tt.ranges {
+					r.Header.Del("Range")
+				}
+				http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(body))
+			}))
+			defer srv.Close()
+
+			f, err := os.Create(filepath.Join(t.TempDir(), "download"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			err = downloadWithRetry(srv.Client(), srv.URL, f, 3, time.Millisecond)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("%d requests, want %d", got, tt.wantCalls)
+			}
+			if tt.wantErr {
+				return
+			}
+			if want := "bytes=" + strconv.Itoa(half) + "-"; resumedFrom != want {
+				t.Errorf("retry sent Range %q, want %q", resumedFrom, want)
+			}
+			got, _ := os.ReadFile(f.Name())
+			if !bytes.Equal(got, body) {
+				t.Errorf("downloaded %d bytes, want %d identical bytes", len(got), len(body))
+			}
+		})
+	}
+}